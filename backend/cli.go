@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"tapspot/services"
+)
+
+// runCommand 执行命令行管理命令，返回进程退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "set-role":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "用法: tapspot set-role <用户名> <user|moderator|admin>")
+			return 2
+		}
+		if err := services.SetUserRole(args[1], args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, "设置角色失败:", err)
+			return 1
+		}
+		fmt.Printf("已将 %s 的角色设置为 %s\n", args[1], args[2])
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, "未知命令:", args[0])
+	fmt.Fprintln(os.Stderr, "可用命令: set-role <用户名> <user|moderator|admin>")
+	return 2
+}
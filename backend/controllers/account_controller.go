@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"tapspot/dto"
+	"tapspot/services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccountController 账号数据控制器（导出与注销）
+type AccountController struct {
+	accountService *services.AccountService
+}
+
+// NewAccountController 创建账号数据控制器实例
+func NewAccountController() *AccountController {
+	return &AccountController{
+		accountService: services.NewAccountService(services.NewMailerFromEnv()),
+	}
+}
+
+// ExportData 导出个人数据（默认 ZIP，format=json 时返回单个 JSON 文件）
+func (ac *AccountController) ExportData(c *gin.Context) {
+	userID := GetUserID(c)
+
+	export, err := ac.accountService.Export(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("tapspot-export-%d-%s", userID, time.Now().Format("20060102"))
+
+	if c.Query("format") == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	c.Status(http.StatusOK)
+	if err := ac.accountService.WriteExportZip(c.Writer, export); err != nil {
+		c.Error(err)
+	}
+}
+
+// DeleteAccount 申请注销账号
+func (ac *AccountController) DeleteAccount(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	scheduledAt, err := ac.accountService.RequestDeletion(userID, &req)
+	if errors.Is(err, services.ErrDeletionCodeSent) {
+		c.JSON(http.StatusAccepted, dto.AuthResponse{
+			Success: true,
+			Message: err.Error(),
+			Data:    gin.H{"code_sent": true},
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已申请注销，宽限期内重新登录即可撤销",
+		Data:    gin.H{"scheduled_at": scheduledAt},
+	})
+}
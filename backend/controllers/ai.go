@@ -1,14 +1,16 @@
 package controllers
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
-	"os"
 	"strings"
-	"time"
+	"unicode/utf8"
+
+	"tapspot/llm"
+	"tapspot/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,46 +26,110 @@ type AIAnalyzeRequest struct {
 type AIAnalyzeResponse struct {
 	Analysis string `json:"analysis"`
 	Success  bool   `json:"success"`
+	Cached   bool   `json:"cached"` // 结果来自缓存或同一地点的其他请求
 }
 
-// AnalyzeLocation AI 分析位置
-func AnalyzeLocation(c *gin.Context) {
+// maxAnalysisRunes 分析结果的最大字符数（提示词要求 200-800 字）
+const maxAnalysisRunes = 1000
+
+// bindAnalyzeRequest 解析并校验分析请求，失败时已写入响应
+func bindAnalyzeRequest(c *gin.Context) (*AIAnalyzeRequest, bool) {
 	var req AIAnalyzeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
-		return
+		return nil, false
 	}
 
 	if req.LocationName == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "位置名称不能为空"})
+		return nil, false
+	}
+	return &req, true
+}
+
+// AnalyzeLocation AI 分析位置（相同地点的分析会被缓存）
+func AnalyzeLocation(c *gin.Context) {
+	req, ok := bindAnalyzeRequest(c)
+	if !ok {
 		return
 	}
 
-	// 调用 AI API 分析
-	analysis, err := callAI(req.LocationName)
+	key := services.NewAnalysisKey(req.LocationName, req.Latitude, req.Longitude)
+	analysis, cached, err := services.DefaultAnalysisCache.GetOrCompute(c.Request.Context(), key, func(ctx context.Context) (string, string, error) {
+		return callAI(ctx, GetUserID(c), key)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI 分析失败：" + err.Error()})
+		log.Printf("⚠️ AI 分析失败: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "AI 服务暂时不可用，请稍后再试"})
 		return
 	}
 
 	c.JSON(http.StatusOK, AIAnalyzeResponse{
 		Analysis: analysis,
 		Success:  true,
+		Cached:   cached,
 	})
 }
 
-// callAI 调用 AI API（阿里云百炼 Qwen3-Coder-Plus）
-func callAI(locationName string) (string, error) {
-	// 获取 API Key（从环境变量）
-	apiKey := os.Getenv("AI_API_KEY")
-	if apiKey == "" {
-		// 如果没有配置 API Key，返回模拟数据
-		return generateMockAnalysis(locationName), nil
+// AnalyzeLocationStream 以 SSE 流式返回 AI 分析
+// 事件：delta（增量文本）、done（完整结果）、error（失败原因）；命中缓存时一次性返回全部内容
+func AnalyzeLocationStream(c *gin.Context) {
+	req, ok := bindAnalyzeRequest(c)
+	if !ok {
+		return
+	}
+
+	startSSE(c)
+	key := services.NewAnalysisKey(req.LocationName, req.Latitude, req.Longitude)
+	streamed := false
+	analysis, cached, err := services.DefaultAnalysisCache.GetOrCompute(c.Request.Context(), key, func(ctx context.Context) (string, string, error) {
+		streamed = true
+		return streamAnalysis(ctx, c, key)
+	})
+
+	switch {
+	case errors.Is(err, errClientGone) || c.Request.Context().Err() != nil:
+		return
+	case err != nil:
+		log.Printf("⚠️ AI 流式分析失败: %v", err)
+		writeSSE(c, "error", gin.H{"error": "AI 服务暂时不可用，请稍后再试"})
+		return
+	}
+	if !streamed {
+		writeSSE(c, "delta", gin.H{"content": analysis})
 	}
 
-	// API 端点（阿里云百炼）
-	apiURL := "https://coding.dashscope.aliyuncs.com/v1/chat/completions"
+	writeSSE(c, "done", AIAnalyzeResponse{Analysis: analysis, Success: true, Cached: cached})
+}
 
+// streamAnalysis 流式生成分析并推送增量，返回完整的分析和使用的模型
+func streamAnalysis(ctx context.Context, c *gin.Context, key services.AnalysisKey) (string, string, error) {
+	limiter := newRuneLimiter(maxAnalysisRunes)
+	resp, err := llm.Default.Stream(ctx, analysisRequest(GetUserID(c), key), func(delta string) error {
+		delta, err := limiter.Take(delta)
+		if delta != "" && !writeSSE(c, "delta", gin.H{"content": delta}) {
+			return errClientGone
+		}
+		return err
+	})
+
+	switch {
+	case errors.Is(err, llm.ErrNotConfigured):
+		// 没有配置 API Key，返回模拟数据
+		analysis := generateMockAnalysis(key.Name)
+		writeSSE(c, "delta", gin.H{"content": analysis})
+		return analysis, "", nil
+	case errors.Is(err, errLimitReached):
+		return services.TruncateRunes(resp.Content, maxAnalysisRunes) + "...", resp.Model, nil
+	case err != nil:
+		return "", "", err
+	}
+	return resp.Content, resp.Model, nil
+}
+
+// analysisRequest 构造分析地点或文字描述的请求，提供坐标时在提示词中注明大致位置
+func analysisRequest(userID uint, key services.AnalysisKey) *llm.Request {
+	locationName := key.Name
 	// 判断是地点名称还是文字描述
 	isTextAnalysis := len(locationName) > 30 || strings.ContainsAny(locationName, "。！？，、；：")
 	
@@ -88,6 +154,9 @@ func callAI(locationName string) (string, error) {
 		maxTokens = 1500
 	} else {
 		// 地点名称模式 - 分析地方特色（详细版）
+		if key.HasLocation() {
+			locationName += fmt.Sprintf("（大致坐标：纬度 %.1f，经度 %.1f）", key.Latitude, key.Longitude)
+		}
 		prompt = fmt.Sprintf(`你是一名专业的旅游博主，请为游客详细介绍这个地方：%s
 
 请按照以下结构详细描写（必须写满 200 字以上）：
@@ -107,68 +176,34 @@ func callAI(locationName string) (string, error) {
 		maxTokens = 800
 	}
 
-	// 构建请求体（Qwen-Turbo 快速版）
-	requestBody := map[string]interface{}{
-		"model": "qwen-turbo",
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
-		"max_tokens": maxTokens,
-		"temperature": 0.7,
-		"top_p": 0.9,
-		"stream": false,
+	return &llm.Request{
+		Model:       llm.EnvModel("LLM_ANALYZE_MODEL", "qwen-turbo"),
+		Messages:    []llm.Message{{Role: llm.RoleUser, Content: prompt}},
+		MaxTokens:   maxTokens,
+		Temperature: 0.7,
+		TopP:        0.9,
+		Purpose:     "analyze",
+		UserID:      userID,
 	}
+}
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
-	}
-
-	// 创建请求
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	// 发送请求
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+// callAI 调用大模型分析地点或文字描述，返回分析和使用的模型（模拟数据的模型为空）
+func callAI(ctx context.Context, userID uint, key services.AnalysisKey) (string, string, error) {
+	resp, err := llm.Default.Complete(ctx, analysisRequest(userID, key))
+	if errors.Is(err, llm.ErrNotConfigured) {
+		// 没有配置 API Key，返回模拟数据
+		return generateMockAnalysis(key.Name), "", nil
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	// 解析响应
-	var aiResp map[string]interface{}
-	if err := json.Unmarshal(body, &aiResp); err != nil {
-		return "", err
+	// 按字符限制长度，避免截断半个汉字
+	analysis := resp.Content
+	if utf8.RuneCountInString(analysis) > maxAnalysisRunes {
+		analysis = services.TruncateRunes(analysis, maxAnalysisRunes) + "..."
 	}
-
-	// 提取分析结果
-	if choices, ok := aiResp["choices"].([]interface{}); ok && len(choices) > 0 {
-		if choice, ok := choices[0].(map[string]interface{}); ok {
-			if message, ok := choice["message"].(map[string]interface{}); ok {
-				if content, ok := message["content"].(string); ok {
-					// 清理文本，限制长度
-					analysis := strings.TrimSpace(content)
-					if len(analysis) > 80 {
-						analysis = analysis[:80] + "..."
-					}
-					return analysis, nil
-				}
-			}
-		}
-	}
-
-	return generateMockAnalysis(locationName), nil
+	return analysis, resp.Model, nil
 }
 
 // generateMockAnalysis 生成模拟分析（当 API 未配置时）
@@ -11,13 +11,15 @@ import (
 
 // AuthController 认证控制器
 type AuthController struct {
-	authService *services.AuthService
+	authService     *services.AuthService
+	passwordService *services.PasswordService
 }
 
 // NewAuthController 创建认证控制器实例
 func NewAuthController() *AuthController {
 	return &AuthController{
-		authService: services.NewAuthService(),
+		authService:     services.NewAuthService(),
+		passwordService: services.NewPasswordService(services.NewMailerFromEnv()),
 	}
 }
 
@@ -60,10 +62,10 @@ func (ac *AuthController) Register(c *gin.Context) {
 // Login 用户登录
 func (ac *AuthController) Login(c *gin.Context) {
 	var req dto.LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		errMsg := "请输入用户名和密码"
-		if req.Username == "" {
-			errMsg = "用户名不能为空"
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Account == "" && req.Username == "") {
+		errMsg := "请输入账号和密码"
+		if req.Account == "" && req.Username == "" {
+			errMsg = "账号不能为空"
 		} else if req.Password == "" {
 			errMsg = "密码不能为空"
 		}
@@ -83,6 +85,15 @@ func (ac *AuthController) Login(c *gin.Context) {
 		return
 	}
 
+	if resp.TwoFactorRequired {
+		c.JSON(http.StatusOK, dto.AuthResponse{
+			Success: true,
+			Message: "请输入两步验证码",
+			Data:    resp,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, dto.AuthResponse{
 		Success: true,
 		Message: "登录成功",
@@ -128,7 +139,7 @@ func (ac *AuthController) GetUserProfile(c *gin.Context) {
 		return
 	}
 
-	profile, err := ac.authService.GetUserProfile(userID)
+	profile, err := ac.authService.GetUserProfile(GetUserID(c), userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, dto.ErrorResponse{
 			Success: false,
@@ -197,7 +208,8 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	if err := ac.authService.ChangePassword(userID, &req); err != nil {
+	token, err := ac.authService.ChangePassword(userID, &req)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
 			Success: false,
 			Message: err.Error(),
@@ -208,6 +220,52 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
 	c.JSON(http.StatusOK, dto.AuthResponse{
 		Success: true,
 		Message: "密码修改成功",
+		Data:    gin.H{"token": token},
+	})
+}
+
+// ForgotPassword 申请重置密码（发送重置邮件）
+func (ac *AuthController) ForgotPassword(c *gin.Context) {
+	var req dto.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "请输入有效的邮箱地址",
+		})
+		return
+	}
+
+	ac.passwordService.ForgotPassword(&req)
+
+	// 不论邮箱是否注册都返回相同结果
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "如果该邮箱已注册，重置链接已发送，请查收邮件",
+	})
+}
+
+// ResetPassword 通过重置令牌设置新密码
+func (ac *AuthController) ResetPassword(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	if err := ac.passwordService.ResetPassword(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "密码已重置，请重新登录",
 	})
 }
 
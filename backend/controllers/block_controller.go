@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"net/http"
+	"tapspot/dto"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BlockController 用户屏蔽控制器
+type BlockController struct {
+	blockService *services.BlockService
+}
+
+// NewBlockController 创建屏蔽控制器实例
+func NewBlockController() *BlockController {
+	return &BlockController{
+		blockService: services.NewBlockService(),
+	}
+}
+
+// BlockUser 屏蔽用户
+func (bc *BlockController) BlockUser(c *gin.Context) {
+	userID := GetUserID(c)
+	targetID := parseUint(c.Param("id"))
+	if targetID == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "无效的用户 ID",
+		})
+		return
+	}
+
+	if err := bc.blockService.Block(userID, targetID); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已屏蔽该用户",
+	})
+}
+
+// UnblockUser 取消屏蔽
+func (bc *BlockController) UnblockUser(c *gin.Context) {
+	userID := GetUserID(c)
+	targetID := parseUint(c.Param("id"))
+	if targetID == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "无效的用户 ID",
+		})
+		return
+	}
+
+	if err := bc.blockService.Unblock(userID, targetID); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已取消屏蔽",
+	})
+}
+
+// ListBlocked 获取我屏蔽的用户列表
+func (bc *BlockController) ListBlocked(c *gin.Context) {
+	userID := GetUserID(c)
+
+	users, err := bc.blockService.ListBlocked(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    gin.H{"users": users},
+	})
+}
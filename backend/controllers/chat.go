@@ -1,32 +1,33 @@
 package controllers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"tapspot/dto"
+	"tapspot/filter"
+	"tapspot/llm"
 	"tapspot/models"
+	"tapspot/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-// ChatMessage 聊天消息
-type ChatMessage struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	UserID    uint      `json:"user_id" gorm:"not null;index"`
-	Role      string    `json:"role" gorm:"size:20;not null"` // user/assistant
-	Content   string    `json:"content" gorm:"type:text;not null"`
-	CreatedAt time.Time `json:"created_at"`
-}
-
 // ChatRequest 聊天请求
 type ChatRequest struct {
-	Message  string  `json:"message"`
-	UserID   uint    `json:"user_id"`
-	Latitude float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
+	Message    string  `json:"message"`
+	SessionID  uint    `json:"session_id"`  // 为空时继续最近的会话
+	NewSession bool    `json:"new_session"` // 开始新会话
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
 }
 
 // ChatResponse 聊天响应
@@ -34,11 +35,53 @@ type ChatResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	Data    struct {
-		Reply      string      `json:"reply"`
-		Recommendations []Recommendation `json:"recommendations,omitempty"`
+		Reply           string           `json:"reply"`
+		SessionID       uint             `json:"session_id,omitempty"`
+		Cards           []dto.ChatCard   `json:"cards,omitempty"`           // 工具调用返回的帖子、打卡点和位置
+		Recommendations []Recommendation `json:"recommendations,omitempty"` // 卡片中的打卡点（兼容旧版前端）
 	} `json:"data"`
 }
 
+// ChatController 阿尼亚聊天控制器
+type ChatController struct {
+	sessionService   *services.ChatSessionService
+	recommendService *services.RecommendService
+	toolsEnabled     bool // 是否允许模型调用工具查询 TapSpot 数据
+}
+
+// NewChatController 创建聊天控制器实例
+// CHAT_TOOLS_ENABLED=false 可关闭工具调用（模型不支持 function calling 时）
+func NewChatController() *ChatController {
+	toolsEnabled := true
+	if v, err := strconv.ParseBool(os.Getenv("CHAT_TOOLS_ENABLED")); err == nil {
+		toolsEnabled = v
+	}
+	return &ChatController{
+		sessionService:   services.NewChatSessionService(),
+		recommendService: services.NewRecommendService(),
+		toolsEnabled:     toolsEnabled,
+	}
+}
+
+// anonymousChatCookie 未登录访客的聊天标识 cookie（带签名，30 天有效）
+const (
+	anonymousChatCookie = "tapspot_anya"
+	anonymousChatMaxAge = 30 * 24 * time.Hour
+)
+
+// maxChatMessageRunes 单条聊天消息的最大字数，消息会保存在会话历史中并随之后的每轮对话发送
+const maxChatMessageRunes = 2000
+
+// chatTurn 一轮对话的上下文
+type chatTurn struct {
+	req     *ChatRequest
+	session *models.ChatSession
+	llmReq  *llm.Request
+	toolbox *services.AnyaToolbox
+	content *filter.Result // 用户消息的过滤结果，这一轮保存后才计入重复检测
+	offline bool           // 未配置模型，回复为本地生成
+}
+
 // Recommendation 推荐打卡点
 type Recommendation struct {
 	ID          uint    `json:"id"`
@@ -53,161 +96,271 @@ type Recommendation struct {
 	Distance    float64 `json:"distance,omitempty"` // 距离（公里）
 }
 
-// ChatWithAnya 与阿尼亚聊天
-func ChatWithAnya(c *gin.Context) {
+// prepareChat 解析、校验并过滤聊天请求，加载会话历史，失败时已写入响应
+func (cc *ChatController) prepareChat(c *gin.Context) (*chatTurn, bool) {
 	var req ChatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
-		return
+		return nil, false
 	}
 
-	if req.Message == "" {
+	if strings.TrimSpace(req.Message) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "消息内容不能为空"})
-		return
+		return nil, false
+	}
+	if utf8.RuneCountInString(req.Message) > maxChatMessageRunes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("消息不能超过 %d 个字", maxChatMessageRunes)})
+		return nil, false
 	}
 
-	// 调用 AI 生成回复
-	reply, err := callAnyaAI(req.Message, req.Latitude, req.Longitude)
+	owner, err := cc.chatOwner(c, true)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI 回复失败：" + err.Error()})
-		return
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建会话失败"})
+		return nil, false
 	}
 
-	// 解析推荐结果
-	var recommendations []Recommendation
-	if strings.Contains(reply, "【推荐】") {
-		recommendations = getHotSpots(req.Latitude, req.Longitude)
+	// 内容过滤
+	result := checkContent(c, filter.SurfaceAnya, owner.UserID, req.Message)
+	if result == nil {
+		return nil, false
 	}
+	req.Message = result.Text
 
-	// 保存聊天记录
-	if req.UserID > 0 {
-		saveChatMessage(req.UserID, "user", req.Message)
-		saveChatMessage(req.UserID, "assistant", reply)
+	session, err := cc.sessionService.Resolve(owner, req.SessionID, req.NewSession, req.Message)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
 	}
 
-	var response ChatResponse
-	response.Success = true
-	response.Data.Reply = reply
-	response.Data.Recommendations = recommendations
-
-	c.JSON(http.StatusOK, response)
+	turn := &chatTurn{req: &req, session: session, content: result}
+	history := cc.sessionService.ContextMessages(session)
+	turn.toolbox = services.NewAnyaToolbox(owner.UserID, req.Latitude, req.Longitude)
+	if cc.toolsEnabled {
+		history = append([]llm.Message{toolPrompt(&req)}, history...)
+	}
+	turn.llmReq = anyaRequest(owner.UserID, history, req.Message)
+	if cc.toolsEnabled {
+		turn.llmReq.Tools = turn.toolbox.Tools()
+	}
+	return turn, true
 }
 
-// getHotSpots 获取热门打卡点
-func getHotSpots(lat, lng float64) []Recommendation {
-	var spots []models.Spot
-	var recommendations []Recommendation
-
-	// 查询热门打卡点（按评分和评论数排序）
-	models.DB.Where("latitude != 0 AND longitude != 0").
-		Order("rating DESC, review_count DESC").
-		Limit(5).
-		Find(&spots)
-
-	for _, spot := range spots {
-		rec := Recommendation{
-			ID:          spot.ID,
-			Name:        spot.Name,
-			Description: spot.Description,
-			Latitude:    spot.Latitude,
-			Longitude:   spot.Longitude,
-			Address:     spot.Address,
-			Category:    spot.Category,
-			Rating:      spot.Rating,
-			LikeCount:   spot.ReviewCount,
-		}
+// chatOwner 当前请求的聊天身份
+// 登录用户以 token 为准（同时把之前的匿名会话转到名下）；未登录时使用签名 cookie，create 为 true 且没有 cookie 时签发新的
+func (cc *ChatController) chatOwner(c *gin.Context, create bool) (services.ChatOwner, error) {
+	anonymousID := ""
+	if value, err := c.Cookie(anonymousChatCookie); err == nil {
+		anonymousID, _ = services.VerifyAnonymousID(value)
+	}
 
-		// 计算距离
-		if lat != 0 && lng != 0 {
-			rec.Distance = calculateDistance(lat, lng, spot.Latitude, spot.Longitude)
+	if userID := GetUserID(c); userID > 0 {
+		if anonymousID != "" {
+			if err := cc.sessionService.ClaimAnonymous(anonymousID, userID); err != nil {
+				log.Printf("⚠️ 合并匿名聊天会话失败: %v", err)
+			} else {
+				setAnonymousChatCookie(c, "", -1)
+			}
 		}
-
-		recommendations = append(recommendations, rec)
+		return services.ChatOwner{UserID: userID}, nil
 	}
 
-	return recommendations
+	if anonymousID == "" && create {
+		id, value, err := services.NewAnonymousID()
+		if err != nil {
+			return services.ChatOwner{}, err
+		}
+		setAnonymousChatCookie(c, value, int(anonymousChatMaxAge.Seconds()))
+		anonymousID = id
+	}
+	return services.ChatOwner{AnonymousID: anonymousID}, nil
 }
 
-// calculateDistance 计算两点间距离（Haversine 公式）
-func calculateDistance(lat1, lng1, lat2, lng2 float64) float64 {
-	const R = 6371 // 地球半径（公里）
+// setAnonymousChatCookie 写入（maxAge < 0 时删除）匿名聊天 cookie
+func setAnonymousChatCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(anonymousChatCookie, value, maxAge, "/api/chat", "", secure, true)
+}
 
-	dLat := (lat2 - lat1) * 3.14159265359 / 180
-	dLng := (lng2 - lng1) * 3.14159265359 / 180
+// ChatWithAnya 与阿尼亚聊天
+func (cc *ChatController) ChatWithAnya(c *gin.Context) {
+	turn, ok := cc.prepareChat(c)
+	if !ok {
+		return
+	}
 
-	a := (1 - cos(dLat))/2 + cos(lat1*3.14159265359/180)*cos(lat2*3.14159265359/180)*(1-cos(dLng))/2
-	c := 2 * atan2(sqrt(a), sqrt(1-a))
+	// 调用 AI 生成回复，模型可以先调用工具查询数据
+	resp, err := llm.CompleteWithTools(c.Request.Context(), llm.Default, turn.llmReq, turn.toolbox, llm.DefaultMaxToolRounds)
+	var reply string
+	switch {
+	case errors.Is(err, llm.ErrNotConfigured):
+		reply = generateAnyaReply(turn.req.Message)
+		turn.offline = true
+	case err != nil:
+		log.Printf("⚠️ 阿尼亚回复失败: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "阿尼亚暂时联系不上，请稍后再试"})
+		return
+	default:
+		reply = resp.Content
+	}
 
-	return R * c
+	c.JSON(http.StatusOK, cc.finishChat(c.Request.Context(), turn, reply))
 }
 
-func sqrt(x float64) float64 {
-	if x <= 0 {
-		return 0
+// ChatWithAnyaStream 以 SSE 流式返回阿尼亚的回复
+// 事件：delta（增量文本）、tool（开始调用工具）、card（工具返回的卡片）、done（完整回复和卡片）、error（失败原因）
+// 客户端中途断开时停止生成，不保存这一轮对话
+func (cc *ChatController) ChatWithAnyaStream(c *gin.Context) {
+	turn, ok := cc.prepareChat(c)
+	if !ok {
+		return
 	}
-	z := x
-	for i := 0; i < 10; i++ {
-		z = (z + x/z) / 2
+
+	startSSE(c)
+	turn.toolbox.OnCall = func(call llm.ToolCall) {
+		writeSSE(c, "tool", gin.H{"name": call.Function.Name})
+	}
+	turn.toolbox.OnCard = func(card dto.ChatCard) {
+		writeSSE(c, "card", card)
+	}
+	resp, err := llm.StreamWithTools(c.Request.Context(), llm.Default, turn.llmReq, turn.toolbox, llm.DefaultMaxToolRounds, func(delta string) error {
+		if !writeSSE(c, "delta", gin.H{"content": delta}) {
+			return errClientGone
+		}
+		return nil
+	})
+
+	var reply string
+	switch {
+	case errors.Is(err, llm.ErrNotConfigured):
+		reply = generateAnyaReply(turn.req.Message)
+		turn.offline = true
+		writeSSE(c, "delta", gin.H{"content": reply})
+	case errors.Is(err, errClientGone) || c.Request.Context().Err() != nil:
+		return
+	case err != nil:
+		log.Printf("⚠️ 阿尼亚流式回复失败: %v", err)
+		writeSSE(c, "error", gin.H{"error": "阿尼亚暂时联系不上，请稍后再试"})
+		return
+	default:
+		reply = resp.Content
 	}
-	return z
-}
 
-func cos(x float64) float64 {
-	// 简化版 cos 函数
-	return 1 - (x*x)/2 + (x*x*x*x)/24
+	writeSSE(c, "done", cc.finishChat(c.Request.Context(), turn, reply))
 }
 
-func atan2(y, x float64) float64 {
-	// 简化版 atan2 函数
-	if x > 0 {
-		return y / (x + 0.0001)
+// finishChat 整理卡片、保存聊天记录并组装响应
+func (cc *ChatController) finishChat(ctx context.Context, turn *chatTurn, reply string) ChatResponse {
+	var response ChatResponse
+	response.Success = true
+	response.Data.Reply = reply
+	response.Data.Cards = turn.toolbox.Cards()
+	for _, card := range response.Data.Cards {
+		if card.Type == "spot" {
+			response.Data.Recommendations = append(response.Data.Recommendations, Recommendation{
+				ID:          card.ID,
+				Name:        card.Title,
+				Description: card.Description,
+				Latitude:    card.Latitude,
+				Longitude:   card.Longitude,
+				Address:     card.Address,
+				Category:    card.Category,
+				Rating:      card.Rating,
+				Distance:    card.Distance,
+			})
+		}
+	}
+	// 未配置模型时按关键词给出本地推荐
+	if len(response.Data.Recommendations) == 0 && turn.offline && wantsRecommendation(turn.req.Message) {
+		response.Data.Recommendations = cc.localRecommendations(ctx, turn)
+	}
+
+	// 保存聊天记录，历史过长时在后台压缩成摘要
+	if err := cc.sessionService.Append(turn.session, turn.req.Message, reply); err != nil {
+		log.Printf("⚠️ 保存聊天记录失败: %v", err)
+	} else {
+		turn.content.Accept()
+		go cc.sessionService.SummarizeIfNeeded(turn.session.ID)
 	}
-	return 1.5708
+	response.Data.SessionID = turn.session.ID
+	return response
 }
 
-// saveChatMessage 保存聊天消息
-func saveChatMessage(userID uint, role, content string) {
-	message := ChatMessage{
-		UserID:  userID,
-		Role:    role,
-		Content: content,
+// localRecommendations 按消息内容和用户位置推荐打卡点
+func (cc *ChatController) localRecommendations(ctx context.Context, turn *chatTurn) []Recommendation {
+	items, _ := cc.recommendService.Recommend(ctx, services.RecommendQuery{
+		ViewerID:    turn.session.UserID,
+		Query:       turn.req.Message,
+		Latitude:    turn.req.Latitude,
+		Longitude:   turn.req.Longitude,
+		HasLocation: turn.req.Latitude != 0 || turn.req.Longitude != 0,
+		Type:        services.EmbeddingSpot,
+		Limit:       5,
+	})
+
+	recommendations := make([]Recommendation, 0, len(items))
+	for _, item := range items {
+		recommendations = append(recommendations, Recommendation{
+			ID:          item.ID,
+			Name:        item.Title,
+			Description: item.Description,
+			Latitude:    item.Latitude,
+			Longitude:   item.Longitude,
+			Address:     item.Address,
+			Category:    item.Category,
+			Rating:      item.Rating,
+			LikeCount:   item.ReviewCount,
+			Distance:    item.Distance,
+		})
 	}
-	models.DB.Create(&message)
+	return recommendations
 }
 
-// GetChatHistory 获取聊天历史
-func GetChatHistory(c *gin.Context) {
-	userID := c.Param("user_id")
-	if userID == "" {
+// GetChatHistory 获取聊天历史（按会话分页，未指定 session_id 时取最近的会话），只能查看自己的记录
+func (cc *ChatController) GetChatHistory(c *gin.Context) {
+	userID := parseUint(c.Param("user_id"))
+	if userID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "用户 ID 不能为空"})
 		return
 	}
+	if userID != GetUserID(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权查看他人的聊天记录"})
+		return
+	}
+	owner := services.ChatOwner{UserID: userID}
+
+	sessionID := parseUint(c.Query("session_id"))
+	if sessionID == 0 {
+		sessions, _, err := cc.sessionService.List(owner, 1, 1)
+		if err != nil || len(sessions) == 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success":  true,
+				"messages": []models.ChatMessage{},
+			})
+			return
+		}
+		sessionID = sessions[0].ID
+	}
 
-	var messages []ChatMessage
-	models.DB.Where("user_id = ?", userID).
-		Order("created_at ASC").
-		Limit(50).
-		Find(&messages)
+	page, pageSize := parsePagination(c)
+	messages, total, err := cc.sessionService.Messages(owner, sessionID, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success":  true,
-		"messages": messages,
+		"success":    true,
+		"session_id": sessionID,
+		"messages":   messages,
+		"total":      total,
+		"page":       page,
+		"page_size":  pageSize,
 	})
 }
 
-// callAnyaAI 调用 AI 生成阿尼亚风格的回复
-func callAnyaAI(message string, lat, lng float64) (string, error) {
-	apiKey := os.Getenv("AI_API_KEY")
-	if apiKey == "" {
-		return generateAnyaReply(message), nil
-	}
-
-	apiURL := "https://coding.dashscope.aliyuncs.com/v1/chat/completions"
-
-	requestBody := map[string]interface{}{
-		"model": "qwen3-coder-plus",
-		"messages": []map[string]string{
-			{"role": "system", "content": `你是阿尼亚·福杰，来自《间谍过家家》的小女孩，但你是全能的 AI 助手。
+// anyaSystemPrompt 阿尼亚的人设
+const anyaSystemPrompt = `你是阿尼亚·福杰，来自《间谍过家家》的小女孩，但你是全能的 AI 助手。
 
 【核心设定】
 - 用第三人称"阿尼亚"称呼自己
@@ -226,42 +379,35 @@ func callAnyaAI(message string, lat, lng float64) (string, error) {
 【示例】
 - 问："今天天气怎么样" → "阿尼亚看了天气预报，今天晴天哦~ 适合出去玩！"
 - 问："Python 怎么读取文件" → "用 open() 函数就可以啦！比如：f = open('file.txt', 'r')，然后用 f.read() 读取内容。记得用完要 f.close() 哦~"
-- 问："心情不好" → "阿尼亚明白这种感觉...有时候休息一下，吃点好吃的会好一些。想和阿尼亚聊聊吗？"`},
-			{"role": "user", "content": message},
-		},
-		"max_tokens": 500,
-		"temperature": 0.8,
-		"top_p": 0.9,
-		"stream": false,
+- 问："心情不好" → "阿尼亚明白这种感觉...有时候休息一下，吃点好吃的会好一些。想和阿尼亚聊聊吗？"`
+
+// anyaRequest 构造阿尼亚对话请求，history 为会话摘要和最近几轮对话
+func anyaRequest(userID uint, history []llm.Message, message string) *llm.Request {
+	messages := []llm.Message{{Role: llm.RoleSystem, Content: anyaSystemPrompt}}
+	messages = append(messages, history...)
+	messages = append(messages, llm.Message{Role: llm.RoleUser, Content: message})
+
+	return &llm.Request{
+		Model:       llm.EnvModel("LLM_CHAT_MODEL", "qwen3-coder-plus"),
+		Messages:    messages,
+		MaxTokens:   500,
+		Temperature: 0.8,
+		TopP:        0.9,
+		Purpose:     "chat",
+		UserID:      userID,
 	}
+}
 
-	jsonData, _ := json.Marshal(requestBody)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	req, _ := http.NewRequest("POST", apiURL, strings.NewReader(string(jsonData)))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return generateAnyaReply(message), nil
-	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-
-	if choices, ok := result["choices"].([]interface{}); ok && len(choices) > 0 {
-		if choice, ok := choices[0].(map[string]interface{}); ok {
-			if message, ok := choice["message"].(map[string]interface{}); ok {
-				if content, ok := message["content"].(string); ok {
-					return strings.TrimSpace(content), nil
-				}
-			}
-		}
+// toolPrompt 告诉模型可以使用的工具和用户当前位置
+func toolPrompt(req *ChatRequest) llm.Message {
+	prompt := "【工具】用户询问推荐地点、附近好玩的、某个地方的详情或者自己在哪里时，先调用工具查询 TapSpot 的真实数据再回答，" +
+		"只推荐工具返回的地点和帖子，不要编造。工具返回的地点会以卡片形式展示给用户，回复里不用重复坐标和 ID。"
+	if req.Latitude != 0 || req.Longitude != 0 {
+		prompt += fmt.Sprintf("\n用户当前位置：纬度 %.6f，经度 %.6f。", req.Latitude, req.Longitude)
+	} else {
+		prompt += "\n不知道用户当前位置，需要位置时先询问用户在哪里。"
 	}
-
-	return generateAnyaReply(message), nil
+	return llm.Message{Role: llm.RoleSystem, Content: prompt}
 }
 
 // generateAnyaReply 生成阿尼亚风格的回复（备用）
@@ -278,8 +424,8 @@ func generateAnyaReply(message string) string {
 	}
 
 	// 简单关键词匹配
-	if strings.Contains(message, "推荐") || strings.Contains(message, "打卡") || strings.Contains(message, "好玩") {
-		return "哇库哇库~ 阿尼亚知道很多好玩的地方哦！【推荐】✨"
+	if wantsRecommendation(message) {
+		return "哇库哇库~ 阿尼亚知道很多好玩的地方哦！✨"
 	}
 
 	if strings.Contains(message, "你好") || strings.Contains(message, "嗨") {
@@ -292,3 +438,8 @@ func generateAnyaReply(message string) string {
 
 	return replies[time.Now().Second()%len(replies)]
 }
+
+// wantsRecommendation 消息是否在请求推荐地点
+func wantsRecommendation(message string) bool {
+	return strings.Contains(message, "推荐") || strings.Contains(message, "打卡") || strings.Contains(message, "好玩")
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"net/http"
+	"tapspot/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSessions 列出当前用户（或匿名访客）与阿尼亚的会话
+func (cc *ChatController) ListSessions(c *gin.Context) {
+	owner, _ := cc.chatOwner(c, false)
+	page, pageSize := parsePagination(c)
+
+	sessions, total, err := cc.sessionService.List(owner, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data: gin.H{
+			"sessions":  sessions,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetSessionMessages 分页获取会话消息（第 1 页为最新的消息）
+func (cc *ChatController) GetSessionMessages(c *gin.Context) {
+	owner, _ := cc.chatOwner(c, false)
+	page, pageSize := parsePagination(c)
+
+	messages, total, err := cc.sessionService.Messages(owner, parseUint(c.Param("id")), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data: gin.H{
+			"messages":  messages,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// RenameSession 修改会话标题
+func (cc *ChatController) RenameSession(c *gin.Context) {
+	owner, _ := cc.chatOwner(c, false)
+
+	var req dto.RenameChatSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	session, err := cc.sessionService.Rename(owner, parseUint(c.Param("id")), req.Title)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "标题已修改",
+		Data:    session,
+	})
+}
+
+// DeleteSession 删除会话及其聊天记录
+func (cc *ChatController) DeleteSession(c *gin.Context) {
+	owner, _ := cc.chatOwner(c, false)
+
+	if err := cc.sessionService.Delete(owner, parseUint(c.Param("id"))); err != nil {
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "会话已删除",
+	})
+}
@@ -3,7 +3,9 @@ package controllers
 import (
 	"net/http"
 	"sort"
+	"tapspot/filter"
 	"tapspot/models"
+	"tapspot/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,13 +13,24 @@ import (
 // GetComments 获取帖子评论
 func GetComments(c *gin.Context) {
 	postID := c.Param("id")
+	viewerID := c.GetUint("userID")
 
-	// 获取评论及其点赞数
+	// 与帖子作者存在屏蔽关系时看不到帖子，也看不到评论
+	var post models.Post
+	if err := models.DB.Select("id", "user_id").First(&post, postID).Error; err != nil || services.IsBlocked(viewerID, post.UserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+		return
+	}
+
+	// 获取评论及其点赞数，过滤与自己存在屏蔽关系的用户的评论
+	query := models.DB.Preload("User").
+		Where("post_id = ? AND hidden = ?", post.ID, false).
+		Scopes(services.ShadowBanScope("user_id", viewerID))
+	if hidden := services.HiddenUserIDs(viewerID); len(hidden) > 0 {
+		query = query.Where("user_id NOT IN ?", hidden)
+	}
 	var comments []models.Comment
-	if err := models.DB.Preload("User").
-		Where("post_id = ?", postID).
-		Order("created_at ASC").
-		Find(&comments).Error; err != nil {
+	if err := query.Order("created_at ASC").Find(&comments).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取评论失败"})
 		return
 	}
@@ -87,18 +100,45 @@ func CreateComment(c *gin.Context) {
 		return
 	}
 
+	// 回复评论时检查与被回复者之间的屏蔽关系
+	if req.ReplyToID != nil {
+		var target models.Comment
+		if err := models.DB.Where("id = ? AND post_id = ?", *req.ReplyToID, post.ID).First(&target).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "回复的评论不存在"})
+			return
+		}
+		if services.IsBlocked(userID, target.UserID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "对方已设置屏蔽，无法回复"})
+			return
+		}
+	}
+
+	// 内容过滤
+	result := checkContent(c, filter.SurfaceComment, userID, req.Content)
+	if result == nil {
+		return
+	}
+	held := needsReview(result)
+
 	comment := models.Comment{
 		PostID:      post.ID,
 		UserID:      userID,
-		Content:     req.Content,
+		Content:     result.Text,
 		ReplyToID:   req.ReplyToID,
 		ReplyToUser: req.ReplyToUser,
+		Hidden:      held,
 	}
 
 	if err := models.DB.Create(&comment).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "评论失败"})
 		return
 	}
+	result.Accept()
+
+	// 命中待审核策略的评论先隐藏，审核通过后公开
+	if held {
+		flagForReview("comment", comment.ID, userID, result)
+	}
 
 	// 获取用户信息
 	var user models.User
@@ -110,7 +150,8 @@ func CreateComment(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
+		"success":        true,
+		"pending_review": held,
 		"comment": gin.H{
 			"id":          comment.ID,
 			"content":     comment.Content,
@@ -166,12 +207,18 @@ func GetCommentCounts(c *gin.Context) {
 		Count  int  `json:"count"`
 	}
 
-	var results []CountResult
-	models.DB.Model(&models.Comment{}).
+	// 与 GetComments 一致：不计与自己存在屏蔽关系的用户的评论，屏蔽关系中的用户的帖子计为 0
+	viewerID := c.GetUint("userID")
+	query := models.DB.Model(&models.Comment{}).
 		Select("post_id, count(*) as count").
-		Where("post_id IN ?", ids).
-		Group("post_id").
-		Scan(&results)
+		Where("post_id IN ? AND hidden = ?", ids, false).
+		Scopes(services.ShadowBanScope("user_id", viewerID))
+	if hidden := services.HiddenUserIDs(viewerID); len(hidden) > 0 {
+		query = query.Where("user_id NOT IN ?", hidden).
+			Where("post_id NOT IN (?)", models.DB.Model(&models.Post{}).Select("id").Where("user_id IN ?", hidden))
+	}
+	var results []CountResult
+	query.Group("post_id").Scan(&results)
 
 	counts := make(map[uint]int)
 	for _, r := range results {
@@ -285,15 +332,28 @@ func GetCommentLikeCounts(c *gin.Context) {
 // GetBestComment 获取最佳评论（PK逻辑）
 func GetBestComment(c *gin.Context) {
 	postID := c.Param("id")
+	postIDUint := parseUint(postID)
+	viewerID := c.GetUint("userID")
+
+	// 获取帖子信息，与帖子作者存在屏蔽关系时视为不存在
+	var post models.Post
+	if err := models.DB.Preload("User").Where("hidden = ?", false).First(&post, postIDUint).Error; err != nil || services.IsBlocked(viewerID, post.UserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+		return
+	}
 
 	// 获取帖子点赞数
 	var postLikeCount int64
-	postIDUint := parseUint(postID)
 	models.DB.Model(&models.Like{}).Where("post_id = ?", postIDUint).Count(&postLikeCount)
 
-	// 获取该帖子下点赞最高的评论
+	// 获取该帖子下点赞最高的评论，过滤与自己存在屏蔽关系的用户的评论
+	query := models.DB.Preload("User").Where("post_id = ? AND hidden = ?", postIDUint, false).
+		Scopes(services.ShadowBanScope("user_id", viewerID))
+	if hidden := services.HiddenUserIDs(viewerID); len(hidden) > 0 {
+		query = query.Where("user_id NOT IN ?", hidden)
+	}
 	var comments []models.Comment
-	models.DB.Preload("User").Where("post_id = ?", postIDUint).Find(&comments)
+	query.Find(&comments)
 
 	var topComment *models.Comment
 	var topCommentLikeCount int
@@ -307,13 +367,6 @@ func GetBestComment(c *gin.Context) {
 		}
 	}
 
-	// 获取帖子信息
-	var post models.Post
-	if err := models.DB.Preload("User").First(&post, postIDUint).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
-		return
-	}
-
 	postAuthor := post.User.Nickname
 	if postAuthor == "" {
 		postAuthor = post.User.Username
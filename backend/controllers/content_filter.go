@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"tapspot/filter"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkContent 按场景过滤用户提交的文本
+// 被拒绝时直接写入 400 响应并返回 nil，调用方应立即返回
+func checkContent(c *gin.Context, surface filter.Surface, userID uint, text string) *filter.Result {
+	result := filter.Check(surface, userID, text)
+	if result.Action == filter.ActionReject {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "内容未通过审核：" + result.Reason()})
+		return nil
+	}
+	return result
+}
+
+// needsReview 任一结果要求人工审核
+func needsReview(results ...*filter.Result) bool {
+	for _, r := range results {
+		if r.Action == filter.ActionReview {
+			return true
+		}
+	}
+	return false
+}
+
+// flagForReview 把被过滤器拦下的内容送入审核队列
+func flagForReview(targetType string, targetID, userID uint, results ...*filter.Result) {
+	var reasons []string
+	for _, r := range results {
+		if r.Action != filter.ActionReview {
+			continue
+		}
+		reason := r.Reason()
+		if len(r.Words) > 0 {
+			reason += "（" + strings.Join(r.Words, "、") + "）"
+		}
+		reasons = append(reasons, reason)
+	}
+
+	detail := "内容过滤：" + strings.Join(reasons, "；")
+	if err := services.NewModerationService().FlagForReview(targetType, targetID, userID, detail); err != nil {
+		log.Printf("送审失败 %s#%d: %v", targetType, targetID, err)
+	}
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"net/http"
+	"tapspot/dto"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FollowController 关注控制器
+type FollowController struct {
+	followService *services.FollowService
+}
+
+// NewFollowController 创建关注控制器实例
+func NewFollowController() *FollowController {
+	return &FollowController{
+		followService: services.NewFollowService(),
+	}
+}
+
+// Follow 关注用户
+func (fc *FollowController) Follow(c *gin.Context) {
+	userID := GetUserID(c)
+	targetID := parseUint(c.Param("id"))
+	if targetID == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "无效的用户 ID",
+		})
+		return
+	}
+
+	if err := fc.followService.Follow(userID, targetID); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "关注成功",
+	})
+}
+
+// Unfollow 取消关注
+func (fc *FollowController) Unfollow(c *gin.Context) {
+	userID := GetUserID(c)
+	targetID := parseUint(c.Param("id"))
+	if targetID == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "无效的用户 ID",
+		})
+		return
+	}
+
+	if err := fc.followService.Unfollow(userID, targetID); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已取消关注",
+	})
+}
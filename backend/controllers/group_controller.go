@@ -0,0 +1,362 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"tapspot/dto"
+	"tapspot/services"
+	"tapspot/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GroupController 群聊控制器
+type GroupController struct {
+	groupService   *services.GroupService
+	messageService *services.MessageService
+}
+
+// NewGroupController 创建群聊控制器实例
+func NewGroupController() *GroupController {
+	return &GroupController{
+		groupService:   services.NewGroupService(),
+		messageService: services.NewMessageService(),
+	}
+}
+
+// CreateGroup 创建群聊
+func (gc *GroupController) CreateGroup(c *gin.Context) {
+	userID := GetUserID(c)
+	var req dto.CreateGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	detail, added, err := gc.groupService.Create(userID, req.Name, req.MemberIDs)
+	if err != nil {
+		groupError(c, err)
+		return
+	}
+	if websocket.GlobalHub != nil {
+		websocket.GlobalHub.NotifyGroup(added, detail.ID, userID, websocket.GroupJoined)
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "群聊已创建",
+		Data:    detail,
+	})
+}
+
+// ListGroups 当前用户加入的群聊
+func (gc *GroupController) ListGroups(c *gin.Context) {
+	groups, err := gc.groupService.List(GetUserID(c))
+	if err != nil {
+		groupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    groups,
+	})
+}
+
+// GetGroup 群聊详情
+func (gc *GroupController) GetGroup(c *gin.Context) {
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+	detail, err := gc.groupService.Detail(GetUserID(c), groupID)
+	if err != nil {
+		groupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    detail,
+	})
+}
+
+// InviteMembers 邀请成员（群主和管理员）
+func (gc *GroupController) InviteMembers(c *gin.Context) {
+	userID := GetUserID(c)
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+	var req dto.InviteGroupMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	added, err := gc.groupService.Invite(userID, groupID, req.UserIDs)
+	if err != nil {
+		groupError(c, err)
+		return
+	}
+	gc.notifyMembers(groupID, userID, added)
+	if websocket.GlobalHub != nil {
+		websocket.GlobalHub.NotifyGroup(added, groupID, userID, websocket.GroupJoined)
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已邀请 " + strconv.Itoa(len(added)) + " 人加入群聊",
+		Data:    gin.H{"user_ids": added},
+	})
+}
+
+// RemoveMember 移出成员
+func (gc *GroupController) RemoveMember(c *gin.Context) {
+	userID := GetUserID(c)
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+	targetID := parseUint(c.Param("userId"))
+	if targetID == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "无效的用户 ID",
+		})
+		return
+	}
+
+	if err := gc.groupService.Kick(userID, groupID, targetID); err != nil {
+		groupError(c, err)
+		return
+	}
+	gc.notifyMembers(groupID, userID, nil)
+	if websocket.GlobalHub != nil {
+		websocket.GlobalHub.NotifyGroup([]uint{targetID}, groupID, userID, websocket.GroupRemoved)
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已移出群聊",
+	})
+}
+
+// UpdateMemberRole 设置成员角色（群主）
+func (gc *GroupController) UpdateMemberRole(c *gin.Context) {
+	userID := GetUserID(c)
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+	targetID := parseUint(c.Param("userId"))
+	if targetID == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "无效的用户 ID",
+		})
+		return
+	}
+	var req dto.UpdateGroupMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	if err := gc.groupService.SetRole(userID, groupID, targetID, req.Role); err != nil {
+		groupError(c, err)
+		return
+	}
+	gc.notifyMembers(groupID, userID, nil)
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "角色已更新",
+	})
+}
+
+// LeaveGroup 退出群聊，群主退出时自动转让
+func (gc *GroupController) LeaveGroup(c *gin.Context) {
+	userID := GetUserID(c)
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+
+	newOwnerID, err := gc.groupService.Leave(userID, groupID)
+	if err != nil {
+		groupError(c, err)
+		return
+	}
+	gc.notifyMembers(groupID, userID, nil)
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已退出群聊",
+		Data:    gin.H{"new_owner_id": newOwnerID},
+	})
+}
+
+// GetGroupMessages 群消息历史，同时标记为已读
+func (gc *GroupController) GetGroupMessages(c *gin.Context) {
+	userID := GetUserID(c)
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 50
+	}
+	// after_id 参数用于轮询新消息
+	afterID := parseUint(c.Query("after_id"))
+
+	messages, err := gc.groupService.Messages(userID, groupID, page, pageSize, afterID)
+	if err != nil {
+		groupError(c, err)
+		return
+	}
+	gc.markRead(userID, groupID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages": messages,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// SendGroupMessage 发送群消息（HTTP备用接口）
+func (gc *GroupController) SendGroupMessage(c *gin.Context) {
+	userID := GetUserID(c)
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+	var req dto.SendGroupMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	sent, err := gc.messageService.Send(services.SendMessageInput{
+		SenderID:    userID,
+		GroupID:     groupID,
+		Content:     req.Content,
+		ClientMsgID: req.ClientMsgID,
+	})
+	if err != nil {
+		groupError(c, err)
+		return
+	}
+	// 通过 WebSocket 推送给在线成员和发送者的其他设备（重发的消息已经推送过）
+	if !sent.Duplicate && websocket.GlobalHub != nil {
+		websocket.GlobalHub.DeliverMessage(sent, req.ClientMsgID)
+	}
+
+	message := sent.Message
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data: gin.H{
+			"id":            message.ID,
+			"group_id":      groupID,
+			"sender_id":     message.SenderID,
+			"sender_name":   sent.SenderName,
+			"content":       message.Content,
+			"client_msg_id": req.ClientMsgID,
+			"created_at":    message.CreatedAt.Format("2006-01-02 15:04:05"),
+		},
+	})
+}
+
+// MarkGroupRead 标记群消息为已读
+func (gc *GroupController) MarkGroupRead(c *gin.Context) {
+	userID := GetUserID(c)
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+	if !services.IsGroupMember(groupID, userID) {
+		groupError(c, services.ErrGroupNotFound)
+		return
+	}
+	gc.markRead(userID, groupID)
+
+	c.JSON(http.StatusOK, dto.AuthResponse{Success: true})
+}
+
+// markRead 标记群消息为已读，已读位置前进时通过 WebSocket 推送给群成员
+func (gc *GroupController) markRead(userID, groupID uint) {
+	lastReadID, advanced, err := gc.groupService.MarkRead(userID, groupID)
+	if err != nil {
+		log.Printf("标记群消息已读失败: %v", err)
+		return
+	}
+	if advanced && websocket.GlobalHub != nil {
+		websocket.GlobalHub.NotifyGroupRead(userID, groupID, lastReadID)
+	}
+}
+
+// notifyMembers 通知群内其他成员成员列表有变化，exclude 中的用户会单独收到通知
+func (gc *GroupController) notifyMembers(groupID, actorID uint, exclude []uint) {
+	if websocket.GlobalHub == nil {
+		return
+	}
+	skip := map[uint]bool{actorID: true}
+	for _, id := range exclude {
+		skip[id] = true
+	}
+	var members []uint
+	for _, id := range services.GroupMemberIDs(groupID) {
+		if !skip[id] {
+			members = append(members, id)
+		}
+	}
+	websocket.GlobalHub.NotifyGroup(members, groupID, actorID, websocket.GroupUpdated)
+}
+
+// groupIDParam 解析路径中的群聊 ID，无效时直接返回 400
+func groupIDParam(c *gin.Context) (uint, bool) {
+	groupID := parseUint(c.Param("id"))
+	if groupID == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "无效的群聊 ID",
+		})
+		return 0, false
+	}
+	return groupID, true
+}
+
+// groupError 按错误类型返回对应的状态码
+func groupError(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, services.ErrGroupNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrMessageForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, services.ErrMessageSaveFailed):
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, dto.ErrorResponse{
+		Success: false,
+		Message: err.Error(),
+	})
+}
@@ -1,20 +1,23 @@
 package controllers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"tapspot/models"
+	"tapspot/services"
 	"tapspot/websocket"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SendMessageRequest 发送消息请求
 type SendMessageRequest struct {
-	ReceiverID uint   `json:"receiver_id" binding:"required"`
-	Content    string `json:"content" binding:"required"`
-	PostID     *uint  `json:"post_id,omitempty"`
+	ReceiverID  uint   `json:"receiver_id" binding:"required"`
+	Content     string `json:"content" binding:"required"`
+	PostID      *uint  `json:"post_id,omitempty"`
+	ClientMsgID string `json:"client_msg_id,omitempty"` // 客户端生成的消息 ID，重发时不会重复保存
 }
 
 // SendMessage 发送消息（HTTP备用接口）
@@ -27,78 +30,46 @@ func SendMessage(c *gin.Context) {
 		return
 	}
 
-	// 验证内容长度
-	if len(req.Content) == 0 || len(req.Content) > 1000 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "消息内容长度必须在1-1000字符之间"})
-		return
-	}
-
-	// 不能给自己发消息
-	if req.ReceiverID == userID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不能给自己发送消息"})
-		return
-	}
-
-	// 验证接收者是否存在
-	var receiver models.User
-	if err := models.DB.First(&receiver, req.ReceiverID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "接收者不存在"})
-		return
-	}
-
-	// 创建消息
-	message := models.Message{
-		SenderID:   userID,
-		ReceiverID: req.ReceiverID,
-		Content:    req.Content,
-		PostID:     req.PostID,
-		IsRead:     false,
-	}
-
-	if err := models.DB.Create(&message).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送失败"})
+	// 校验、保存消息并更新双方会话（与 WebSocket 发送共用）
+	sent, err := services.NewMessageService().Send(services.SendMessageInput{
+		SenderID:    userID,
+		ReceiverID:  req.ReceiverID,
+		Content:     req.Content,
+		PostID:      req.PostID,
+		ClientMsgID: req.ClientMsgID,
+	})
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, services.ErrReceiverNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrMessageForbidden):
+			status = http.StatusForbidden
+		case errors.Is(err, services.ErrMessageSaveFailed):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 更新会话
-	updateConversationHTTP(userID, req.ReceiverID, req.Content)
-
-	// 通过 WebSocket 广播消息（如果接收者在线）
-	if websocket.GlobalHub != nil && websocket.GlobalHub.IsUserOnline(req.ReceiverID) {
-		wsMsg := &websocket.Message{
-			Type:       "chat",
-			SenderID:   userID,
-			ReceiverID: req.ReceiverID,
-			Content:    req.Content,
-			CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
-		}
-		// 获取发送者昵称
-		var sender models.User
-		if err := models.DB.First(&sender, userID).Error; err == nil {
-			if sender.Nickname != "" {
-				wsMsg.SenderName = sender.Nickname
-			} else {
-				wsMsg.SenderName = sender.Username
-			}
-		}
-		// 获取会话 ID
-		var conv models.Conversation
-		if err := models.DB.Where("user_id = ? AND peer_id = ?", userID, req.ReceiverID).First(&conv).Error; err == nil {
-			wsMsg.ConversationID = conv.ID
-		}
-		websocket.GlobalHub.Broadcast <- wsMsg
+	// 通过 WebSocket 推送给接收者和发送者的其他设备（重发的消息已经推送过）
+	if !sent.Duplicate && websocket.GlobalHub != nil {
+		websocket.GlobalHub.DeliverMessage(sent, req.ClientMsgID)
 	}
 
+	message := sent.Message
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": gin.H{
-			"id":          message.ID,
-			"sender_id":   message.SenderID,
-			"receiver_id": message.ReceiverID,
-			"content":     message.Content,
-			"post_id":     message.PostID,
-			"is_read":     message.IsRead,
-			"created_at":  message.CreatedAt.Format("2006-01-02 15:04:05"),
+			"id":              message.ID,
+			"conversation_id": sent.ConversationID,
+			"sender_id":       message.SenderID,
+			"receiver_id":     message.ReceiverID,
+			"content":         message.Content,
+			"post_id":         message.PostID,
+			"is_read":         message.IsRead,
+			"client_msg_id":   req.ClientMsgID,
+			"created_at":      message.CreatedAt.Format("2006-01-02 15:04:05"),
 		},
 	})
 }
@@ -120,14 +91,17 @@ func GetConversations(c *gin.Context) {
 	}
 	
 	type ConversationResponse struct {
-		ID          uint      `json:"id"`
-		PeerID      uint      `json:"peer_id"`
-		PeerName    string    `json:"peer_name"`
-		PeerAvatar  string    `json:"peer_avatar"`
-		LastMessage string    `json:"last_message"`
-		LastMsgTime string    `json:"last_msg_time"`
-		UnreadCount int       `json:"unread_count"`
-		OtherUser   OtherUser `json:"other_user"`
+		ID             uint      `json:"id"`
+		PeerID         uint      `json:"peer_id"`
+		PeerName       string    `json:"peer_name"`
+		PeerAvatar     string    `json:"peer_avatar"`
+		LastMessage    string    `json:"last_message"`
+		LastMsgTime    string    `json:"last_msg_time"`
+		UnreadCount    int       `json:"unread_count"`
+		OtherUser      OtherUser `json:"other_user"`
+		PeerOnline     bool      `json:"peer_online"`
+		PeerLastSeen   string    `json:"peer_last_seen,omitempty"` // 对方离线时最后在线的时间
+		PeerLastReadID uint      `json:"peer_last_read_id"`        // 对方已读到的我发出的最后一条消息 ID
 	}
 
 	if conversations == nil {
@@ -144,6 +118,17 @@ func GetConversations(c *gin.Context) {
 			peerName = peer.Username
 		}
 
+		// 存在屏蔽关系时不展示在线状态
+		online, lastSeen := false, ""
+		if !services.IsBlocked(userID, conv.PeerID) {
+			online = websocket.GlobalHub != nil && websocket.GlobalHub.IsUserOnline(conv.PeerID)
+			if !online && peer.LastSeenAt != nil {
+				lastSeen = peer.LastSeenAt.Format("2006-01-02 15:04:05")
+			}
+		}
+		var peerConv models.Conversation
+		models.DB.Select("last_read_id").Where("user_id = ? AND peer_id = ?", conv.PeerID, userID).Limit(1).Find(&peerConv)
+
 		result = append(result, ConversationResponse{
 			ID:          conv.ID,
 			PeerID:      conv.PeerID,
@@ -157,6 +142,9 @@ func GetConversations(c *gin.Context) {
 				Nickname: peerName,
 				Avatar:   peer.Avatar,
 			},
+			PeerOnline:     online,
+			PeerLastSeen:   lastSeen,
+			PeerLastReadID: peerConv.LastReadID,
 		})
 	}
 
@@ -205,7 +193,9 @@ func GetMessages(c *gin.Context) {
 
 	query := models.DB.Preload("Sender").Preload("Receiver").
 		Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)",
-			userID, peerID, peerID, userID)
+			userID, peerID, peerID, userID).
+		Where("group_id IS NULL").
+		Where("(shadowed = ? OR sender_id = ?)", false, userID)
 	
 	// 如果有 after_id 参数，只获取新消息
 	if afterID != "" {
@@ -220,15 +210,8 @@ func GetMessages(c *gin.Context) {
 		Offset(offset).
 		Find(&messages)
 
-	// 标记消息为已读
-	models.DB.Model(&models.Message{}).
-		Where("sender_id = ? AND receiver_id = ? AND is_read = ?", peerID, userID, false).
-		Update("is_read", true)
-
-	// 重置会话未读数
-	models.DB.Model(&models.Conversation{}).
-		Where("user_id = ? AND peer_id = ?", userID, peerID).
-		Update("unread_count", 0)
+	// 标记消息为已读并重置会话未读数
+	markRead(userID, peerID)
 
 	// 确保 messages 不是 nil
 	if messages == nil {
@@ -287,8 +270,10 @@ func GetUnreadCount(c *gin.Context) {
 
 	var count int64
 	models.DB.Model(&models.Message{}).
-		Where("receiver_id = ? AND is_read = ?", userID, false).
+		Where("receiver_id = ? AND is_read = ? AND shadowed = ?", userID, false, false).
 		Count(&count)
+	// 加上群聊的未读数
+	count += services.GroupUnreadCount(userID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"unread_count": count,
@@ -369,61 +354,20 @@ func MarkConversationAsRead(c *gin.Context) {
 		return
 	}
 	
-	// 重置未读数
-	models.DB.Model(&conversation).Update("unread_count", 0)
-	
-	// 标记消息为已读
-	models.DB.Model(&models.Message{}).
-		Where("sender_id = ? AND receiver_id = ? AND is_read = ?", conversation.PeerID, userID, false).
-		Update("is_read", true)
+	// 标记消息为已读并重置未读数
+	markRead(userID, conversation.PeerID)
 	
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-// updateConversationHTTP HTTP方式更新会话
-func updateConversationHTTP(userID, peerID uint, lastMessage string) {
-	now := time.Now()
-	
-	// 更新发送方的会话
-	var conv models.Conversation
-	err := models.DB.Where("user_id = ? AND peer_id = ?", userID, peerID).First(&conv).Error
+// markRead 标记与对方的消息为已读，已读位置前进时通过 WebSocket 推送已读回执
+func markRead(userID, peerID uint) {
+	lastReadID, advanced, err := services.MarkConversationRead(userID, peerID)
 	if err != nil {
-		// 创建新会话
-		conv = models.Conversation{
-			UserID:      userID,
-			PeerID:      peerID,
-			LastMessage: lastMessage,
-			LastMsgTime: now,
-			UnreadCount: 0,
-		}
-		models.DB.Create(&conv)
-	} else {
-		// 更新会话
-		models.DB.Model(&conv).Updates(map[string]interface{}{
-			"last_message":  lastMessage,
-			"last_msg_time": now,
-		})
+		log.Printf("标记已读失败: %v", err)
+		return
 	}
-	
-	// 更新接收方的会话（增加未读数）
-	var peerConv models.Conversation
-	err = models.DB.Where("user_id = ? AND peer_id = ?", peerID, userID).First(&peerConv).Error
-	if err != nil {
-		// 为接收方创建会话
-		peerConv = models.Conversation{
-			UserID:      peerID,
-			PeerID:      userID,
-			LastMessage: lastMessage,
-			LastMsgTime: now,
-			UnreadCount: 1,
-		}
-		models.DB.Create(&peerConv)
-	} else {
-		// 更新接收方会话，增加未读数
-		models.DB.Model(&peerConv).Updates(map[string]interface{}{
-			"last_message":  lastMessage,
-			"last_msg_time": now,
-			"unread_count":  peerConv.UnreadCount + 1,
-		})
+	if advanced && websocket.GlobalHub != nil {
+		websocket.GlobalHub.NotifyRead(userID, peerID, lastReadID)
 	}
 }
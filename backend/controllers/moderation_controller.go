@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"tapspot/dto"
+	"tapspot/services"
+	"tapspot/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModerationController 举报与审核控制器
+type ModerationController struct {
+	moderationService *services.ModerationService
+}
+
+// NewModerationController 创建审核控制器实例
+func NewModerationController() *ModerationController {
+	return &ModerationController{
+		moderationService: services.NewModerationService(),
+	}
+}
+
+// CreateReport 举报帖子、评论、私信或用户
+func (mc *ModerationController) CreateReport(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.CreateReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	report, err := mc.moderationService.CreateReport(userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "举报已提交，我们会尽快处理",
+		Data:    gin.H{"id": report.ID},
+	})
+}
+
+// ListReports 审核队列
+func (mc *ModerationController) ListReports(c *gin.Context) {
+	page, pageSize := parsePagination(c)
+
+	reports, total, err := mc.moderationService.ListReports(c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data: gin.H{
+			"reports":   reports,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// ClaimReport 认领举报
+func (mc *ModerationController) ClaimReport(c *gin.Context) {
+	userID := GetUserID(c)
+
+	if err := mc.moderationService.ClaimReport(userID, parseUint(c.Param("id"))); err != nil {
+		c.JSON(http.StatusConflict, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "认领成功",
+	})
+}
+
+// ResolveReport 处理举报
+func (mc *ModerationController) ResolveReport(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.ResolveReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	action, err := mc.moderationService.ResolveReport(userID, parseUint(c.Param("id")), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 警告实时推送给被处理的用户
+	if action.Action == "warn" && websocket.GlobalHub != nil {
+		content := "你发布的内容违反了社区规范，请注意言行"
+		if req.Note != "" {
+			content += "：" + req.Note
+		}
+		data, _ := json.Marshal(&websocket.Message{
+			Type:       "warning",
+			ReceiverID: action.TargetUserID,
+			Content:    content,
+			CreatedAt:  time.Now().Format("2006-01-02 15:04:05"),
+		})
+		websocket.GlobalHub.SendToUser(action.TargetUserID, data)
+	}
+	if action.Action == "ban" && websocket.GlobalHub != nil {
+		websocket.GlobalHub.DisconnectUser(action.TargetUserID)
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "处理完成",
+		Data:    action,
+	})
+}
+
+// ListActions 审核操作日志
+func (mc *ModerationController) ListActions(c *gin.Context) {
+	page, pageSize := parsePagination(c)
+
+	actions, total, err := mc.moderationService.ListActions(parseUint(c.Query("user_id")), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data: gin.H{
+			"actions":   actions,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// parsePagination 解析分页参数（page 从 1 开始，page_size 最大 100）
+func parsePagination(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return page, pageSize
+}
+
+// SuspendUser 封禁用户（临时或永久）
+func (mc *ModerationController) SuspendUser(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.SuspendUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "请填写封禁原因",
+		})
+		return
+	}
+
+	targetID := parseUint(c.Param("id"))
+	action, err := mc.moderationService.SuspendUser(userID, targetID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 断开被封禁用户的实时连接
+	if websocket.GlobalHub != nil {
+		websocket.GlobalHub.DisconnectUser(targetID)
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已封禁该用户",
+		Data:    action,
+	})
+}
+
+// LiftSuspension 解除封禁
+func (mc *ModerationController) LiftSuspension(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.ModerationNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	action, err := mc.moderationService.LiftSuspension(userID, parseUint(c.Param("id")), req.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "已解除封禁",
+		Data:    action,
+	})
+}
+
+// SetShadowBan 开启或关闭隐身封禁
+func (mc *ModerationController) SetShadowBan(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.ShadowBanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	action, err := mc.moderationService.SetShadowBan(userID, parseUint(c.Param("id")), req.Enabled, req.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	message := "已开启隐身封禁"
+	if !req.Enabled {
+		message = "已关闭隐身封禁"
+	}
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: message,
+		Data:    action,
+	})
+}
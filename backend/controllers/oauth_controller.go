@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"tapspot/dto"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oauthBindingCookie 把授权请求绑定到发起它的浏览器的 cookie
+const oauthBindingCookie = "tapspot_oauth_binding"
+
+// OAuthController 第三方登录控制器
+type OAuthController struct {
+	oidcService *services.OIDCService
+	// frontendRedirect 回调完成后跳转回前端的地址，为空时直接返回 JSON
+	frontendRedirect string
+}
+
+// NewOAuthController 创建第三方登录控制器实例
+func NewOAuthController() *OAuthController {
+	return &OAuthController{
+		oidcService:      services.NewOIDCService(services.LoadOIDCProviderConfigsFromEnv()),
+		frontendRedirect: os.Getenv("OIDC_FRONTEND_REDIRECT"),
+	}
+}
+
+// ListProviders 获取可用的第三方登录方式
+func (oc *OAuthController) ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    gin.H{"providers": oc.oidcService.ProviderNames()},
+	})
+}
+
+// Login 跳转到第三方授权页面
+func (oc *OAuthController) Login(c *gin.Context) {
+	authURL, binding, err := oc.oidcService.AuthCodeURL(c.Param("provider"), 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	setOAuthBindingCookie(c, binding, int(services.OAuthStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback 第三方授权回调
+func (oc *OAuthController) Callback(c *gin.Context) {
+	if errCode := c.Query("error"); errCode != "" {
+		oc.respondCallbackError(c, "第三方授权被拒绝："+errCode)
+		return
+	}
+
+	binding, _ := c.Cookie(oauthBindingCookie)
+	setOAuthBindingCookie(c, "", -1)
+	result, err := oc.oidcService.HandleCallback(c.Param("provider"), c.Query("state"), c.Query("code"), binding)
+	if err != nil {
+		oc.respondCallbackError(c, err.Error())
+		return
+	}
+
+	if oc.frontendRedirect != "" {
+		// 通过 URL fragment 传给前端，避免 token 出现在服务器日志和 Referer 中
+		fragment := url.Values{}
+		fragment.Set("action", result.Action)
+		if result.Login != nil && result.Login.TwoFactorRequired {
+			fragment.Set("challenge_token", result.Login.ChallengeToken)
+		} else if result.Login != nil {
+			fragment.Set("token", result.Login.Token)
+		}
+		if result.Identity != nil {
+			fragment.Set("provider", result.Identity.Provider)
+		}
+		c.Redirect(http.StatusFound, oc.frontendRedirect+"#"+fragment.Encode())
+		return
+	}
+
+	message := "登录成功"
+	if result.Action == "link" {
+		message = "绑定成功"
+	}
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: message,
+		Data:    result,
+	})
+}
+
+// ListIdentities 获取当前用户已绑定的第三方账号
+func (oc *OAuthController) ListIdentities(c *gin.Context) {
+	userID := GetUserID(c)
+
+	identities, err := oc.oidcService.ListIdentities(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    gin.H{"identities": identities},
+	})
+}
+
+// LinkIdentity 发起绑定第三方账号（返回授权地址，由前端跳转）
+// 请求需要带上 credentials，浏览器才会保存回调时校验的 cookie
+func (oc *OAuthController) LinkIdentity(c *gin.Context) {
+	userID := GetUserID(c)
+
+	authURL, binding, err := oc.oidcService.AuthCodeURL(c.Param("provider"), userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	setOAuthBindingCookie(c, binding, int(services.OAuthStateTTL.Seconds()))
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    gin.H{"url": authURL},
+	})
+}
+
+// UnlinkIdentity 解绑第三方账号
+func (oc *OAuthController) UnlinkIdentity(c *gin.Context) {
+	userID := GetUserID(c)
+
+	if err := oc.oidcService.UnlinkIdentity(userID, c.Param("provider")); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "解绑成功",
+	})
+}
+
+// setOAuthBindingCookie 写入（maxAge < 0 时删除）授权绑定 cookie，只在回调路径上发送
+// SameSite=Lax 保证从提供方跳转回来的顶层导航会带上 cookie
+func setOAuthBindingCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthBindingCookie, value, maxAge, "/api/oauth", "", secure, true)
+}
+
+// respondCallbackError 回调失败时的响应
+func (oc *OAuthController) respondCallbackError(c *gin.Context, message string) {
+	if oc.frontendRedirect != "" {
+		fragment := url.Values{}
+		fragment.Set("error", message)
+		c.Redirect(http.StatusFound, oc.frontendRedirect+"#"+fragment.Encode())
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+		Success: false,
+		Message: message,
+	})
+}
@@ -2,7 +2,10 @@ package controllers
 
 import (
 	"net/http"
+	"strings"
+	"tapspot/filter"
 	"tapspot/models"
+	"tapspot/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -30,10 +33,12 @@ type PostResponse struct {
 	Author       string  `json:"author"`
 	AuthorID     uint    `json:"authorId"`
 	CreatedAt    string  `json:"createdAt"`
+	Analysis     string  `json:"analysis,omitempty"` // 已缓存的 AI 地点分析（详情接口带 ?include=analysis 时返回）
 }
 
 // formatPost 格式化帖子为响应格式
-func formatPost(post models.Post) PostResponse {
+// viewerID 为当前访问者（未登录为 0），非作者看到的坐标按作者的隐私设置模糊处理
+func formatPost(post models.Post, viewerID uint) PostResponse {
 	var likeCount int64
 	models.DB.Model(&models.Like{}).Where("post_id = ?", post.ID).Count(&likeCount)
 
@@ -42,14 +47,16 @@ func formatPost(post models.Post) PostResponse {
 		author = post.User.Username
 	}
 
+	latitude, longitude := services.PublicCoordinates(&post, viewerID)
+
 	return PostResponse{
 		ID:           post.ID,
 		Title:        post.Title,
 		Content:      post.Content,
 		Type:         post.Type,
 		LocationName: post.LocationName,
-		Latitude:     post.Latitude,
-		Longitude:    post.Longitude,
+		Latitude:     latitude,
+		Longitude:    longitude,
 		Likes:        int(likeCount),
 		Author:       author,
 		AuthorID:     post.UserID,
@@ -63,14 +70,24 @@ func GetPosts(c *gin.Context) {
 	userID := c.Query("userId")
 	search := c.Query("search")
 
-	query := models.DB.Preload("User")
+	// 被审核隐藏的帖子不出现在列表中
+	// 隐身封禁用户的帖子只有本人能看到
+	query := models.DB.Preload("User").Where("hidden = ?", false).
+		Scopes(services.ShadowBanScope("user_id", c.GetUint("userID")))
 
 	if postType != "" && postType != "all" {
 		query = query.Where("type = ?", postType)
 	}
 
 	if userID != "" {
-		query = query.Where("user_id = ?", parseUint(userID))
+		// 按作者筛选时与作者主页遵守相同的可见范围
+		var author models.User
+		if err := models.DB.First(&author, parseUint(userID)).Error; err != nil ||
+			!services.CanViewProfile(c.GetUint("userID"), &author) {
+			c.JSON(http.StatusOK, gin.H{"posts": []PostResponse{}})
+			return
+		}
+		query = query.Where("user_id = ?", author.ID)
 	}
 
 	if search != "" {
@@ -78,6 +95,11 @@ func GetPosts(c *gin.Context) {
 		query = query.Where("title LIKE ? OR content LIKE ? OR location_name LIKE ?", searchTerm, searchTerm, searchTerm)
 	}
 
+	// 登录用户看不到与自己存在屏蔽关系的用户的帖子
+	if hidden := services.HiddenUserIDs(c.GetUint("userID")); len(hidden) > 0 {
+		query = query.Where("user_id NOT IN ?", hidden)
+	}
+
 	var posts []models.Post
 	if err := query.Order("created_at DESC").Find(&posts).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取帖子失败"})
@@ -86,7 +108,7 @@ func GetPosts(c *gin.Context) {
 
 	result := []PostResponse{}
 	for _, post := range posts {
-		result = append(result, formatPost(post))
+		result = append(result, formatPost(post, c.GetUint("userID")))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"posts": result})
@@ -101,8 +123,43 @@ func GetPost(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
 		return
 	}
+	// 被隐藏或作者处于隐身封禁的帖子只有作者本人能看到
+	if (post.Hidden || post.User.ShadowBanned) && post.UserID != c.GetUint("userID") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+		return
+	}
+	// 与作者存在屏蔽关系时按不存在处理
+	if services.IsBlocked(c.GetUint("userID"), post.UserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "帖子不存在"})
+		return
+	}
+
+	response := formatPost(post, c.GetUint("userID"))
+	if includes(c, "analysis") && post.LocationName != "" {
+		response.Analysis = cachedAnalysis(post.LocationName, response.Latitude, response.Longitude)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"post": response})
+}
+
+// cachedAnalysis 查询地点已缓存的 AI 分析，不会调用模型
+// 先按坐标查询，再查询不带坐标的分析（前端按地点名称请求分析时不带坐标）
+func cachedAnalysis(locationName string, latitude, longitude float64) string {
+	if analysis, ok := services.DefaultAnalysisCache.Get(services.NewAnalysisKey(locationName, latitude, longitude)); ok {
+		return analysis
+	}
+	analysis, _ := services.DefaultAnalysisCache.Get(services.NewAnalysisKey(locationName, 0, 0))
+	return analysis
+}
 
-	c.JSON(http.StatusOK, gin.H{"post": formatPost(post)})
+// includes 查询参数 include（逗号分隔）中是否包含 name
+func includes(c *gin.Context, name string) bool {
+	for _, part := range strings.Split(c.Query("include"), ",") {
+		if strings.TrimSpace(part) == name {
+			return true
+		}
+	}
+	return false
 }
 
 // GetMyPosts 获取当前用户的帖子
@@ -117,7 +174,7 @@ func GetMyPosts(c *gin.Context) {
 
 	result := []PostResponse{}
 	for _, post := range posts {
-		result = append(result, formatPost(post))
+		result = append(result, formatPost(post, c.GetUint("userID")))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"posts": result})
@@ -140,30 +197,58 @@ func CreatePost(c *gin.Context) {
 
 	postType := req.Type
 	if postType == "" {
-		postType = "post"
+		postType = services.DefaultPostType
+	}
+	if !services.IsValidPostType(postType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "帖子分类不正确"})
+		return
+	}
+
+	// 内容过滤（重复内容只按正文统计）
+	titleResult := checkContent(c, filter.SurfacePost, 0, req.Title)
+	if titleResult == nil {
+		return
+	}
+	contentResult := checkContent(c, filter.SurfacePost, userID, req.Content)
+	if contentResult == nil {
+		return
 	}
+	// 地点名称也会公开展示，同样需要过滤
+	locationResult := checkContent(c, filter.SurfacePost, 0, req.LocationName)
+	if locationResult == nil {
+		return
+	}
+	held := needsReview(titleResult, contentResult, locationResult)
 
 	post := models.Post{
 		UserID:       userID,
-		Title:        req.Title,
-		Content:      req.Content,
+		Title:        titleResult.Text,
+		Content:      contentResult.Text,
 		Type:         postType,
-		LocationName: req.LocationName,
+		LocationName: locationResult.Text,
 		Latitude:     req.Latitude,
 		Longitude:    req.Longitude,
+		Hidden:       held,
 	}
 
 	if err := models.DB.Create(&post).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "发布失败，请稍后重试"})
 		return
 	}
+	contentResult.Accept()
+
+	// 命中待审核策略的帖子先隐藏，审核通过后公开
+	if held {
+		flagForReview("post", post.ID, userID, titleResult, contentResult, locationResult)
+	}
 
 	// 加载用户信息
 	models.DB.Preload("User").First(&post, post.ID)
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"post":    formatPost(post),
+		"success":        true,
+		"post":           formatPost(post, userID),
+		"pending_review": held,
 	})
 }
 
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+
+	"tapspot/dto"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PostDraftController 帖子草稿辅助控制器
+type PostDraftController struct {
+	draftService *services.PostDraftService
+}
+
+// NewPostDraftController 创建帖子草稿辅助控制器实例
+func NewPostDraftController() *PostDraftController {
+	return &PostDraftController{
+		draftService: services.NewPostDraftService(),
+	}
+}
+
+// Suggest 根据草稿正文和位置建议标题、分类、话题标签和地点名称
+func (pc *PostDraftController) Suggest(c *gin.Context) {
+	var req dto.PostDraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    pc.draftService.Suggest(c.Request.Context(), GetUserID(c), &req),
+	})
+}
+
+// GetPostCategories 帖子分类表
+func (pc *PostDraftController) GetPostCategories(c *gin.Context) {
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    services.PostCategories,
+	})
+}
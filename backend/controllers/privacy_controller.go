@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"net/http"
+	"tapspot/dto"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PrivacyController 隐私设置控制器
+type PrivacyController struct {
+	privacyService *services.PrivacyService
+}
+
+// NewPrivacyController 创建隐私设置控制器实例
+func NewPrivacyController() *PrivacyController {
+	return &PrivacyController{
+		privacyService: services.NewPrivacyService(),
+	}
+}
+
+// GetSettings 获取当前用户的隐私设置
+func (pc *PrivacyController) GetSettings(c *gin.Context) {
+	userID := GetUserID(c)
+
+	settings, err := pc.privacyService.GetSettings(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    settings,
+	})
+}
+
+// UpdateSettings 更新当前用户的隐私设置
+func (pc *PrivacyController) UpdateSettings(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.UpdatePrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	settings, err := pc.privacyService.UpdateSettings(userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "隐私设置已更新",
+		Data:    settings,
+	})
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"tapspot/dto"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecommendController 推荐控制器
+type RecommendController struct {
+	recommendService *services.RecommendService
+}
+
+// NewRecommendController 创建推荐控制器实例
+func NewRecommendController() *RecommendController {
+	return &RecommendController{
+		recommendService: services.NewRecommendService(),
+	}
+}
+
+// Recommend 推荐帖子和打卡点
+// 提供 q 时按与搜索词的语义相似度推荐，否则登录用户按点赞过的帖子推荐；提供 lat、lng 时结合距离排序
+func (rc *RecommendController) Recommend(c *gin.Context) {
+	query := services.RecommendQuery{
+		ViewerID: GetUserID(c),
+		Query:    c.Query("q"),
+		Type:     c.Query("type"),
+		Category: c.Query("category"),
+	}
+	if query.Type != "" && query.Type != services.EmbeddingPost && query.Type != services.EmbeddingSpot {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "type 只能是 post 或 spot",
+		})
+		return
+	}
+	if len([]rune(query.Query)) > 200 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "搜索词不能超过 200 个字",
+		})
+		return
+	}
+
+	if c.Query("lat") != "" || c.Query("lng") != "" {
+		lat, latErr := strconv.ParseFloat(c.Query("lat"), 64)
+		lng, lngErr := strconv.ParseFloat(c.Query("lng"), 64)
+		if latErr != nil || lngErr != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Success: false,
+				Message: "经纬度格式不正确",
+			})
+			return
+		}
+		query.Latitude, query.Longitude, query.HasLocation = lat, lng, true
+	}
+	query.RadiusKm, _ = strconv.ParseFloat(c.Query("radius_km"), 64)
+	query.Limit, _ = strconv.Atoi(c.Query("limit"))
+
+	items, basis := rc.recommendService.Recommend(c.Request.Context(), query)
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data: gin.H{
+			"items": items,
+			"basis": basis,
+		},
+	})
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"unicode/utf8"
+
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errClientGone 客户端已断开，停止生成
+var errClientGone = errors.New("客户端已断开")
+
+// errLimitReached 输出已达到长度上限，停止生成
+var errLimitReached = errors.New("输出已达到长度上限")
+
+// startSSE 写入 SSE 响应头
+func startSSE(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 关闭 nginx 缓冲，否则内容会攒到最后才下发
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+}
+
+// writeSSE 发送一个 SSE 事件并立即刷新，客户端已断开时返回 false
+func writeSSE(c *gin.Context, event string, data interface{}) bool {
+	if c.Request.Context().Err() != nil {
+		return false
+	}
+	c.SSEvent(event, data)
+	c.Writer.Flush()
+	return c.Request.Context().Err() == nil
+}
+
+// runeLimiter 按字符数限制流式输出的总长度
+type runeLimiter struct {
+	remaining int
+}
+
+// newRuneLimiter 创建长度限制器
+func newRuneLimiter(max int) *runeLimiter {
+	return &runeLimiter{remaining: max}
+}
+
+// Take 返回本段中仍可输出的部分，超出上限时返回 errLimitReached
+func (l *runeLimiter) Take(delta string) (string, error) {
+	n := utf8.RuneCountInString(delta)
+	if n <= l.remaining {
+		l.remaining -= n
+		return delta, nil
+	}
+	delta = services.TruncateRunes(delta, l.remaining)
+	l.remaining = 0
+	return delta, errLimitReached
+}
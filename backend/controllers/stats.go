@@ -2,10 +2,12 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"tapspot/models"
+	"tapspot/services"
 )
 
 // GetVisitStats 获取访客统计信息
@@ -106,3 +108,22 @@ func GetRealTimeVisitors(c *gin.Context) {
 		"count":    len(visitors),
 	})
 }
+
+// GetAIUsageStats 获取大模型调用用量（默认最近 7 天，days 最大 90）
+func GetAIUsageStats(c *gin.Context) {
+	days := 7
+	if d, err := strconv.Atoi(c.Query("days")); err == nil && d > 0 && d <= 90 {
+		days = d
+	}
+
+	summary, err := services.LLMUsageSummary(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取 AI 用量失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":  days,
+		"usage": summary,
+	})
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"net/http"
+	"tapspot/dto"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TwoFactorController 两步验证控制器
+type TwoFactorController struct {
+	twoFactorService *services.TwoFactorService
+}
+
+// NewTwoFactorController 创建两步验证控制器实例
+func NewTwoFactorController() *TwoFactorController {
+	return &TwoFactorController{
+		twoFactorService: services.NewTwoFactorService(),
+	}
+}
+
+// Enroll 获取验证器绑定密钥和 otpauth 地址
+func (tc *TwoFactorController) Enroll(c *gin.Context) {
+	userID := GetUserID(c)
+
+	resp, err := tc.twoFactorService.Enroll(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Data:    resp,
+	})
+}
+
+// Enable 提交验证码开启两步验证
+func (tc *TwoFactorController) Enable(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "请输入验证码",
+		})
+		return
+	}
+
+	resp, err := tc.twoFactorService.Enable(userID, req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "两步验证已开启，请妥善保存恢复码",
+		Data:    resp,
+	})
+}
+
+// Disable 关闭两步验证
+func (tc *TwoFactorController) Disable(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.TwoFactorPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	if err := tc.twoFactorService.Disable(userID, &req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "两步验证已关闭",
+	})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码
+func (tc *TwoFactorController) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.TwoFactorPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	resp, err := tc.twoFactorService.RegenerateRecoveryCodes(userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "恢复码已重新生成",
+		Data:    resp,
+	})
+}
+
+// VerifyLogin 登录第二步：提交验证码或恢复码
+func (tc *TwoFactorController) VerifyLogin(c *gin.Context) {
+	var req dto.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: "请输入验证码",
+		})
+		return
+	}
+
+	resp, err := tc.twoFactorService.VerifyLogin(&req)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "登录成功",
+		Data:    resp,
+	})
+}
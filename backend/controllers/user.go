@@ -3,6 +3,7 @@ package controllers
 import (
 	"net/http"
 	"tapspot/models"
+	"tapspot/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,8 +34,12 @@ func SearchUsers(c *gin.Context) {
 
 	var users []models.User
 	// 只搜索昵称，不搜索用户名
-	models.DB.Where("nickname LIKE ?", "%"+query+"%").
-		Select("id, username, nickname, avatar, bio, created_at").
+	db := models.DB.Where("nickname LIKE ?", "%"+query+"%")
+	// 登录用户搜索不到与自己存在屏蔽关系的用户
+	if hidden := services.HiddenUserIDs(c.GetUint("userID")); len(hidden) > 0 {
+		db = db.Where("id NOT IN ?", hidden)
+	}
+	db.Select("id, username, nickname, avatar, bio, created_at").
 		Limit(20).
 		Find(&users)
 
@@ -213,6 +218,20 @@ func GetUserByID(c *gin.Context) {
 		return
 	}
 
+	// 资料不公开时只返回基本信息
+	if !services.CanViewProfile(c.GetUint("userID"), &user) {
+		c.JSON(http.StatusOK, gin.H{
+			"user": gin.H{
+				"id":         user.ID,
+				"username":   user.Username,
+				"nickname":   user.Nickname,
+				"avatar":     user.Avatar,
+				"restricted": true,
+			},
+		})
+		return
+	}
+
 	// 获取用户帖子数
 	var postCount int64
 	models.DB.Model(&models.Post{}).Where("user_id = ?", user.ID).Count(&postCount)
@@ -248,9 +267,20 @@ func GetUserPosts(c *gin.Context) {
 	userID := c.Param("id")
 
 	userIDUint := parseUint(userID)
+	viewerID := c.GetUint("userID")
 
 	var posts []models.Post
-	models.DB.Preload("User").Where("user_id = ?", userIDUint).Order("created_at DESC").Find(&posts)
+	// 资料不公开或存在屏蔽关系时不展示帖子列表
+	var owner models.User
+	// 隐身封禁用户的帖子只有本人能看到
+	if err := models.DB.First(&owner, userIDUint).Error; err == nil && services.CanViewProfile(viewerID, &owner) &&
+		(!owner.ShadowBanned || viewerID == owner.ID) {
+		query := models.DB.Preload("User").Where("user_id = ?", userIDUint)
+		if viewerID != userIDUint {
+			query = query.Where("hidden = ?", false)
+		}
+		query.Order("created_at DESC").Find(&posts)
+	}
 
 	type PostWithLikes struct {
 		ID           uint    `json:"id"`
@@ -279,14 +309,16 @@ func GetUserPosts(c *gin.Context) {
 			author = post.User.Username
 		}
 
+		latitude, longitude := services.PublicCoordinates(&post, viewerID)
+
 		result = append(result, PostWithLikes{
 			ID:           post.ID,
 			Title:        post.Title,
 			Content:      post.Content,
 			Type:         post.Type,
 			LocationName: post.LocationName,
-			Latitude:     post.Latitude,
-			Longitude:    post.Longitude,
+			Latitude:     latitude,
+			Longitude:    longitude,
 			Likes:        int(likeCount),
 			Author:       author,
 			AuthorID:     post.UserID,
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+	"tapspot/dto"
+	"tapspot/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerificationController 邮箱/手机号验证控制器
+type VerificationController struct {
+	verificationService *services.VerificationService
+}
+
+// NewVerificationController 创建验证控制器实例
+func NewVerificationController() *VerificationController {
+	return &VerificationController{
+		verificationService: services.NewVerificationService(services.NewMailerFromEnv(), services.NewSMSSenderFromEnv()),
+	}
+}
+
+// SendCode 向当前账号的邮箱或手机号发送验证码
+func (vc *VerificationController) SendCode(c *gin.Context) {
+	userID := GetUserID(c)
+
+	if err := vc.verificationService.SendCode(userID, c.Param("channel")); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "验证码已发送",
+	})
+}
+
+// Confirm 提交验证码完成验证
+func (vc *VerificationController) Confirm(c *gin.Context) {
+	userID := GetUserID(c)
+
+	var req dto.ConfirmVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: getValidationErrorMessage(err),
+		})
+		return
+	}
+
+	if err := vc.verificationService.Confirm(userID, c.Param("channel"), req.Code); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.AuthResponse{
+		Success: true,
+		Message: "验证成功",
+	})
+}
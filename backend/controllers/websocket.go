@@ -2,15 +2,23 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"tapspot/services"
 	"tapspot/websocket"
 
 	"github.com/gin-gonic/gin"
 )
 
 // WebSocketHandler 处理 WebSocket 连接
+// 浏览器无法为 WebSocket 设置请求头，token 通过 ?token= 传递，也兼容 Authorization 头
+// 重连时带上 ?since=<已收到的最后一条消息 ID> 补收断线期间的消息
 func WebSocketHandler(c *gin.Context) {
-	userID := GetUserID(c)
-	if userID == 0 {
+	token := c.Query("token")
+	if token == "" {
+		token = c.GetHeader("Authorization")
+	}
+	if strings.TrimSpace(token) == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
 			"message": "请先登录",
@@ -18,8 +26,21 @@ func WebSocketHandler(c *gin.Context) {
 		return
 	}
 
+	// ParseToken 同时校验 token 版本和封禁状态
+	userID, _, err := services.ParseToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// since 为客户端已收到的最后一条消息 ID，重连时补发之后的消息
+	since, _ := strconv.ParseUint(c.Query("since"), 10, 32)
+
 	// 使用 websocket 包中的 HandleWebSocket
 	w := c.Writer
 	r := c.Request
-	websocket.HandleWebSocket(w, r, userID)
+	websocket.HandleWebSocket(w, r, userID, uint(since))
 }
@@ -25,15 +25,20 @@ type RegisterResponse struct {
 // ========== 登录相关 ==========
 
 // LoginRequest 登录请求
+// Account 可以是用户名、邮箱或手机号，Username 为兼容旧版客户端保留
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
+	Account  string `json:"account"`
+	Username string `json:"username"`
 	Password string `json:"password" binding:"required"`
 }
 
 // LoginResponse 登录响应
+// 开启两步验证时 Token 为空，需携带 ChallengeToken 调用 /api/login/2fa
 type LoginResponse struct {
-	User  UserInfo `json:"user"`
-	Token string   `json:"token"`
+	User              UserInfo `json:"user"`
+	Token             string   `json:"token"`
+	TwoFactorRequired bool     `json:"two_factor_required,omitempty"`
+	ChallengeToken    string   `json:"challenge_token,omitempty"`
 }
 
 // ========== 用户信息相关 ==========
@@ -46,8 +51,10 @@ type UserInfo struct {
 	Avatar    string    `json:"avatar"`
 	Gender    string    `json:"gender"`
 	Bio       string    `json:"bio"`
-	Email     string    `json:"email"`
-	Phone     string    `json:"phone"`
+	Email     string    `json:"email,omitempty"`
+	Phone     string    `json:"phone,omitempty"`
+	EmailVerified bool  `json:"email_verified"`
+	PhoneVerified bool  `json:"phone_verified"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -59,13 +66,15 @@ type UserProfile struct {
 	Avatar       string    `json:"avatar"`
 	Gender       string    `json:"gender"`
 	Bio          string    `json:"bio"`
-	Email        string    `json:"email"`
-	Phone        string    `json:"phone"`
+	Email        string    `json:"email,omitempty"` // 未公开时不返回
+	Phone        string    `json:"phone,omitempty"` // 未公开时不返回
 	CreatedAt    time.Time `json:"created_at"`
 	PostCount    int64     `json:"post_count"`    // 发帖数
 	LikeCount    int64     `json:"like_count"`    // 获得的总点赞数
-	FollowerCount int64    `json:"follower_count"` // 粉丝数（预留）
-	FollowingCount int64   `json:"following_count"` // 关注数（预留）
+	FollowerCount int64    `json:"follower_count"` // 粉丝数
+	FollowingCount int64   `json:"following_count"` // 关注数
+	IsFollowing  bool      `json:"is_following"`  // 当前用户是否已关注
+	Restricted   bool      `json:"restricted,omitempty"` // 资料不公开，仅返回基本信息
 }
 
 // ========== 更新资料相关 ==========
@@ -87,6 +96,288 @@ type ChangePasswordRequest struct {
 	NewPasswordConf string `json:"new_password_conf" binding:"required,eqfield=NewPassword"`
 }
 
+// ========== 找回密码相关 ==========
+
+// ConfirmVerificationRequest 提交邮箱/手机号验证码请求
+type ConfirmVerificationRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+// ForgotPasswordRequest 申请重置密码请求
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest 重置密码请求
+type ResetPasswordRequest struct {
+	Token           string `json:"token" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6,max=100"`
+	NewPasswordConf string `json:"new_password_conf" binding:"required,eqfield=NewPassword"`
+}
+
+// ========== 两步验证相关 ==========
+
+// TwoFactorEnrollResponse 开始绑定验证器的响应
+type TwoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TwoFactorCodeRequest 提交验证码
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorPasswordRequest 需要重新验证密码的操作（关闭两步验证、重新生成恢复码）
+// 没有设置密码的第三方登录用户使用当前验证码代替
+type TwoFactorPasswordRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// TwoFactorLoginRequest 登录第二步：验证码或恢复码
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// RecoveryCodesResponse 恢复码（只在生成时返回一次）
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// ========== 账号注销相关 ==========
+
+// DeleteAccountRequest 注销账号请求（需要重新验证密码）
+// 没有设置密码的第三方登录用户如开启了两步验证，使用验证码代替；
+// 两者都没有时先不带 code 提交，收到邮件确认码后再带上 code 提交
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// ========== 第三方登录相关 ==========
+
+// IdentityInfo 已绑定的第三方账号
+type IdentityInfo struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OAuthCallbackResponse 第三方登录回调结果
+// Action 为 login 时返回登录信息，为 link 时返回绑定的账号
+type OAuthCallbackResponse struct {
+	Action   string         `json:"action"`
+	Login    *LoginResponse `json:"login,omitempty"`
+	Identity *IdentityInfo  `json:"identity,omitempty"`
+}
+
+// ========== 屏蔽相关 ==========
+
+// BlockedUser 被屏蔽的用户
+type BlockedUser struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Nickname  string    `json:"nickname"`
+	Avatar    string    `json:"avatar"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+// ========== 隐私设置相关 ==========
+
+// PrivacySettings 隐私设置
+type PrivacySettings struct {
+	MessagePermission string `json:"message_permission"` // everyone, followers（互相关注）, nobody
+	ProfileVisibility string `json:"profile_visibility"` // public, followers（互相关注）, private
+	ShowEmail         bool   `json:"show_email"`
+	ShowPhone         bool   `json:"show_phone"`
+	LocationPrecision int    `json:"location_precision"` // 帖子公开坐标保留的小数位数，-1 表示精确坐标
+}
+
+// UpdatePrivacyRequest 更新隐私设置请求（未传的字段保持不变）
+type UpdatePrivacyRequest struct {
+	MessagePermission string `json:"message_permission" binding:"omitempty,oneof=everyone followers nobody"`
+	ProfileVisibility string `json:"profile_visibility" binding:"omitempty,oneof=public followers private"`
+	ShowEmail         *bool  `json:"show_email"`
+	ShowPhone         *bool  `json:"show_phone"`
+	LocationPrecision *int   `json:"location_precision"` // 0-4，-1 表示不模糊
+}
+
+// ========== 举报与审核相关 ==========
+
+// CreateReportRequest 举报请求
+type CreateReportRequest struct {
+	TargetType string `json:"target_type" binding:"required,oneof=post comment message user"`
+	TargetID   uint   `json:"target_id" binding:"required"`
+	Reason     string `json:"reason" binding:"required,oneof=spam abuse porn illegal other"`
+	Detail     string `json:"detail" binding:"max=500"`
+}
+
+// ResolveReportRequest 处理举报请求
+type ResolveReportRequest struct {
+	Action string `json:"action" binding:"required,oneof=hide delete warn ban dismiss"`
+	Note   string `json:"note" binding:"max=500"`
+}
+
+// SuspendUserRequest 封禁用户请求
+type SuspendUserRequest struct {
+	DurationHours int    `json:"duration_hours" binding:"min=0"` // 0 表示永久封禁
+	Reason        string `json:"reason" binding:"required,max=255"`
+}
+
+// ShadowBanRequest 隐身封禁请求
+type ShadowBanRequest struct {
+	Enabled bool   `json:"enabled"`
+	Note    string `json:"note" binding:"max=500"`
+}
+
+// ModerationNoteRequest 只需填写备注的审核操作请求
+type ModerationNoteRequest struct {
+	Note string `json:"note" binding:"max=500"`
+}
+
+// ReportInfo 审核队列中的举报
+type ReportInfo struct {
+	ID            uint       `json:"id"`
+	ReporterID    uint       `json:"reporter_id"`
+	TargetType    string     `json:"target_type"`
+	TargetID      uint       `json:"target_id"`
+	TargetUserID  uint       `json:"target_user_id"`
+	TargetContent string     `json:"target_content"` // 被举报内容的快照，内容已删除时为空
+	ReportCount   int64      `json:"report_count"`   // 同一内容的待处理举报数
+	Reason        string     `json:"reason"`
+	Detail        string     `json:"detail"`
+	Status        string     `json:"status"`
+	ModeratorID   *uint      `json:"moderator_id"`
+	Resolution    string     `json:"resolution"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ClaimedAt     *time.Time `json:"claimed_at"`
+	ResolvedAt    *time.Time `json:"resolved_at"`
+}
+
+// RenameChatSessionRequest 修改聊天会话标题请求
+type RenameChatSessionRequest struct {
+	Title string `json:"title" binding:"required,max=100"`
+}
+
+// ChatCard 阿尼亚回复中附带的结构化卡片（来自工具调用的结果）
+type ChatCard struct {
+	Type        string  `json:"type"` // post, spot, location
+	ID          uint    `json:"id,omitempty"`
+	Title       string  `json:"title"`
+	Description string  `json:"description,omitempty"`
+	Category    string  `json:"category,omitempty"`
+	Address     string  `json:"address,omitempty"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	Rating      float64 `json:"rating,omitempty"`
+	Distance    float64 `json:"distance,omitempty"` // 距离（公里）
+}
+
+// RecommendItem 推荐结果，Similarity 为语义相似度，Score 为结合距离和热度后的综合得分
+type RecommendItem struct {
+	ChatCard
+	LikeCount   int     `json:"like_count,omitempty"`   // 帖子的点赞数
+	ReviewCount int     `json:"review_count,omitempty"` // 打卡点的评价数
+	Similarity  float64 `json:"similarity"`
+	Score       float64 `json:"score"`
+}
+
+// PostDraftRequest 帖子草稿建议请求
+type PostDraftRequest struct {
+	Title     string  `json:"title" binding:"max=255"`
+	Content   string  `json:"content" binding:"required,max=5000"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// PostDraftSuggestion 帖子草稿建议
+type PostDraftSuggestion struct {
+	Title        string   `json:"title"`
+	Type         string   `json:"type"` // 帖子分类表中的分类
+	TypeLabel    string   `json:"type_label"`
+	Hashtags     []string `json:"hashtags"` // 不带 # 号
+	LocationName string   `json:"location_name"`
+	Source       string   `json:"source"` // ai 模型生成，rules 本地规则
+}
+
+// CreateGroupRequest 创建群聊请求，创建者自动成为群主
+type CreateGroupRequest struct {
+	Name      string `json:"name" binding:"required,max=50"`
+	MemberIDs []uint `json:"member_ids" binding:"max=100"`
+}
+
+// InviteGroupMembersRequest 邀请成员请求
+type InviteGroupMembersRequest struct {
+	UserIDs []uint `json:"user_ids" binding:"required,min=1,max=100"`
+}
+
+// UpdateGroupMemberRoleRequest 设置成员角色请求（仅群主）
+type UpdateGroupMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin member"`
+}
+
+// SendGroupMessageRequest 发送群消息请求
+type SendGroupMessageRequest struct {
+	Content     string `json:"content" binding:"required"`
+	ClientMsgID string `json:"client_msg_id"` // 客户端生成的消息 ID，重发时不会重复保存
+}
+
+// GroupSummary 群聊列表项
+type GroupSummary struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	OwnerID     uint   `json:"owner_id"`
+	Role        string `json:"role"` // 当前用户在群中的角色
+	MemberCount int    `json:"member_count"`
+	UnreadCount int    `json:"unread_count"`
+	LastReadID  uint   `json:"last_read_id"`
+	LastMessage string `json:"last_message"`
+	LastMsgTime string `json:"last_msg_time"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// GroupMemberInfo 群成员信息
+type GroupMemberInfo struct {
+	UserID     uint   `json:"user_id"`
+	Nickname   string `json:"nickname"`
+	Avatar     string `json:"avatar"`
+	Role       string `json:"role"`
+	LastReadID uint   `json:"last_read_id"` // 已读到的最后一条群消息 ID
+	JoinedAt   string `json:"joined_at"`
+}
+
+// GroupDetail 群聊详情
+type GroupDetail struct {
+	GroupSummary
+	Members []GroupMemberInfo `json:"members"`
+}
+
+// GroupMessage 群消息
+type GroupMessage struct {
+	ID           uint   `json:"id"`
+	GroupID      uint   `json:"group_id"`
+	SenderID     uint   `json:"sender_id"`
+	SenderName   string `json:"sender_name"`
+	SenderAvatar string `json:"sender_avatar"`
+	Content      string `json:"content"`
+	IsMe         bool   `json:"is_me"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// LLMUsageSummary 大模型用量汇总（按场景和模型分组）
+type LLMUsageSummary struct {
+	Purpose          string `json:"purpose"`
+	Model            string `json:"model"`
+	Calls            int64  `json:"calls"`
+	Failures         int64  `json:"failures"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+	AvgLatencyMs     int64  `json:"avg_latency_ms"`
+}
+
 // ========== 通用响应 ==========
 
 // AuthResponse 认证通用响应
@@ -0,0 +1,419 @@
+package filter
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Surface 内容出现的场景
+type Surface string
+
+const (
+	SurfacePost    Surface = "post"
+	SurfaceComment Surface = "comment"
+	SurfaceMessage Surface = "message"
+	SurfaceAnya    Surface = "anya"
+)
+
+// Action 命中后的处理方式
+type Action string
+
+const (
+	ActionAllow  Action = "allow"  // 放行
+	ActionMask   Action = "mask"   // 敏感词替换为 ***
+	ActionReject Action = "reject" // 拒绝提交
+	ActionReview Action = "review" // 先隐藏，等待人工审核
+)
+
+// maskText 敏感词替换成的文本
+const maskText = "***"
+
+// linkPattern 匹配网址和常见的裸域名
+var linkPattern = regexp.MustCompile(`(?i)(https?://\S+|www\.\S+|\b[a-z0-9][a-z0-9-]*\.(com|cn|net|org|io|xyz|top|vip|cc|me)\b)`)
+
+// Config 过滤器配置
+type Config struct {
+	WordsFile       string             // 词表文件，每行一个词，# 开头为注释
+	ReloadInterval  time.Duration      // 检查词表文件变化的间隔，0 表示不热加载
+	MaxLinks        int                // 单条内容允许的最大链接数
+	DuplicateLimit  int                // 窗口期内允许重复发送相同内容的次数
+	DuplicateWindow time.Duration      // 重复内容检测窗口
+	MaxRepeatRun    int                // 同一字符连续出现的最大次数
+	Policies        map[Surface]Action // 各场景命中后的处理方式
+}
+
+// DefaultPolicies 默认处理策略：帖子先审后发，评论和私信打码，AI 对话直接拒绝
+var DefaultPolicies = map[Surface]Action{
+	SurfacePost:    ActionReview,
+	SurfaceComment: ActionMask,
+	SurfaceMessage: ActionMask,
+	SurfaceAnya:    ActionReject,
+}
+
+// ConfigFromEnv 从环境变量读取配置
+func ConfigFromEnv() Config {
+	cfg := Config{
+		WordsFile:       os.Getenv("FILTER_WORDS_FILE"),
+		ReloadInterval:  time.Duration(envInt("FILTER_RELOAD_SECONDS", 30)) * time.Second,
+		MaxLinks:        envInt("FILTER_MAX_LINKS", 2),
+		DuplicateLimit:  envInt("FILTER_DUPLICATE_LIMIT", 3),
+		DuplicateWindow: time.Duration(envInt("FILTER_DUPLICATE_WINDOW_MINUTES", 10)) * time.Minute,
+		MaxRepeatRun:    envInt("FILTER_MAX_REPEAT_RUN", 20),
+		Policies:        map[Surface]Action{},
+	}
+	if cfg.WordsFile == "" {
+		cfg.WordsFile = "config/banned_words.txt"
+	}
+
+	for surface, action := range DefaultPolicies {
+		cfg.Policies[surface] = action
+		// 例如 FILTER_POLICY_COMMENT=reject
+		switch v := Action(strings.ToLower(os.Getenv("FILTER_POLICY_" + strings.ToUpper(string(surface))))); v {
+		case ActionMask, ActionReject, ActionReview:
+			cfg.Policies[surface] = v
+		}
+	}
+	// 私信和 AI 对话没有可以隐藏待审的内容，待审核按拒绝处理
+	for _, surface := range []Surface{SurfaceMessage, SurfaceAnya} {
+		if cfg.Policies[surface] == ActionReview {
+			cfg.Policies[surface] = ActionReject
+		}
+	}
+	return cfg
+}
+
+// Result 过滤结果
+type Result struct {
+	Action Action   // 最终处理方式
+	Text   string   // 处理后的文本（打码时为打码后的内容）
+	Words  []string // 命中的敏感词
+	Spam   string   // 命中的垃圾内容规则，为空表示未命中
+
+	filter    *Filter // 检查所用的过滤器，Accept 时记录重复内容
+	dupKey    string  // 重复内容统计的键（场景+用户），为空表示不统计
+	dupDigest string  // 内容摘要
+}
+
+// Accept 内容被接受（保存或发送成功）后调用，计入重复发送统计
+// 被拒绝或保存失败的提交不调用，不会让用户因为重试而被判定为重复发送
+func (r *Result) Accept() {
+	if r == nil || r.filter == nil || r.dupKey == "" {
+		return
+	}
+	r.filter.recordDuplicate(r.dupKey, r.dupDigest)
+}
+
+// Reason 命中原因，用于提示用户和审核记录
+func (r *Result) Reason() string {
+	if r.Spam != "" {
+		return r.Spam
+	}
+	if len(r.Words) > 0 {
+		return "包含敏感词"
+	}
+	return ""
+}
+
+// Filter 内容过滤器，可并发使用
+type Filter struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	matcher  *Matcher
+	modTime  time.Time
+	wordSize int
+
+	recentMu sync.Mutex
+	recent   map[string][]recentEntry // userID+场景 -> 最近发送的内容摘要
+}
+
+// recentEntry 最近发送内容的摘要
+type recentEntry struct {
+	digest string
+	at     time.Time
+}
+
+// New 创建过滤器并加载词表
+func New(cfg Config) *Filter {
+	f := &Filter{
+		cfg:    cfg,
+		recent: make(map[string][]recentEntry),
+	}
+	if err := f.Reload(); err != nil {
+		log.Printf("⚠️ 加载敏感词表失败: %v", err)
+	}
+	return f
+}
+
+// Global 全局过滤器实例
+var Global *Filter
+
+// InitFilter 按环境变量初始化全局过滤器，并启动词表热加载
+func InitFilter() {
+	Global = New(ConfigFromEnv())
+	Global.Watch()
+}
+
+// Check 使用全局过滤器检查内容，未初始化时直接放行
+func Check(surface Surface, userID uint, text string) *Result {
+	if Global == nil {
+		return &Result{Action: ActionAllow, Text: text}
+	}
+	return Global.Check(surface, userID, text)
+}
+
+// Reload 重新读取词表文件
+func (f *Filter) Reload() error {
+	info, err := os.Stat(f.cfg.WordsFile)
+	if err != nil {
+		return err
+	}
+	words, err := readWords(f.cfg.WordsFile)
+	if err != nil {
+		return err
+	}
+	f.SetWords(words)
+
+	f.mu.Lock()
+	f.modTime = info.ModTime()
+	f.mu.Unlock()
+	return nil
+}
+
+// SetWords 直接替换词表
+func (f *Filter) SetWords(words []string) {
+	normalizedWords := make([]string, 0, len(words))
+	for _, w := range words {
+		if nw := normalizeWord(w); nw != "" {
+			normalizedWords = append(normalizedWords, nw)
+		}
+	}
+	matcher := NewMatcher(normalizedWords)
+
+	f.mu.Lock()
+	f.matcher = matcher
+	f.wordSize = len(normalizedWords)
+	f.mu.Unlock()
+}
+
+// Watch 定期检查词表文件的修改时间，变化时自动重新加载
+func (f *Filter) Watch() {
+	if f.cfg.ReloadInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(f.cfg.ReloadInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			info, err := os.Stat(f.cfg.WordsFile)
+			if err != nil {
+				continue
+			}
+			f.mu.RLock()
+			changed := !info.ModTime().Equal(f.modTime)
+			f.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := f.Reload(); err != nil {
+				log.Printf("⚠️ 重新加载敏感词表失败: %v", err)
+				continue
+			}
+			f.mu.RLock()
+			log.Printf("🔄 敏感词表已重新加载，共 %d 个词", f.wordSize)
+			f.mu.RUnlock()
+		}
+	}()
+}
+
+// Check 按场景策略检查内容
+// 敏感词在打码策略下替换为 ***；垃圾内容无法打码，打码策略下直接拒绝
+func (f *Filter) Check(surface Surface, userID uint, text string) *Result {
+	result := &Result{Action: ActionAllow, Text: text}
+	policy, ok := f.cfg.Policies[surface]
+	if !ok {
+		policy = ActionReject
+	}
+
+	original := []rune(text)
+	norm := normalizeText(original)
+
+	f.mu.RLock()
+	matches := f.matcher.FindAll(norm.runes)
+	f.mu.RUnlock()
+
+	if len(matches) > 0 {
+		result.Words = matchedWords(norm, matches)
+		result.Action = policy
+		if policy == ActionMask {
+			result.Text = maskMatches(original, norm, matches)
+		}
+	}
+
+	if userID != 0 && f.cfg.DuplicateLimit > 0 && len(norm.runes) > 0 {
+		sum := sha1.Sum([]byte(string(norm.runes)))
+		result.filter = f
+		result.dupKey = string(surface) + ":" + strconv.FormatUint(uint64(userID), 10)
+		result.dupDigest = hex.EncodeToString(sum[:])
+	}
+
+	if spam := f.detectSpam(result, original, norm); spam != "" {
+		result.Spam = spam
+		result.Text = text
+		if policy == ActionMask {
+			result.Action = ActionReject
+		} else {
+			result.Action = policy
+		}
+	}
+
+	return result
+}
+
+// detectSpam 链接数量、重复字符和重复发送检测
+func (f *Filter) detectSpam(result *Result, original []rune, norm normalized) string {
+	if f.cfg.MaxLinks >= 0 && len(linkPattern.FindAllString(string(original), -1)) > f.cfg.MaxLinks {
+		return "包含过多链接"
+	}
+
+	if f.cfg.MaxRepeatRun > 0 {
+		run := 1
+		for i := 1; i < len(norm.runes); i++ {
+			if norm.runes[i] == norm.runes[i-1] {
+				run++
+				if run > f.cfg.MaxRepeatRun {
+					return "包含大量重复字符"
+				}
+			} else {
+				run = 1
+			}
+		}
+	}
+
+	if result.dupKey != "" && f.countDuplicate(result.dupKey, result.dupDigest) >= f.cfg.DuplicateLimit {
+		return "短时间内重复发送相同内容"
+	}
+	return ""
+}
+
+// countDuplicate 窗口期内已被接受的相同内容的次数（不含本次）
+func (f *Filter) countDuplicate(key, digest string) int {
+	cutoff := time.Now().Add(-f.cfg.DuplicateWindow)
+
+	f.recentMu.Lock()
+	defer f.recentMu.Unlock()
+
+	count := 0
+	for _, e := range f.recent[key] {
+		if e.digest == digest && !e.at.Before(cutoff) {
+			count++
+		}
+	}
+	return count
+}
+
+// recordDuplicate 记录一次被接受的发送，同时丢弃窗口期之外的记录
+func (f *Filter) recordDuplicate(key, digest string) {
+	now := time.Now()
+	cutoff := now.Add(-f.cfg.DuplicateWindow)
+
+	f.recentMu.Lock()
+	defer f.recentMu.Unlock()
+
+	entries := f.recent[key][:0]
+	for _, e := range f.recent[key] {
+		if !e.at.Before(cutoff) {
+			entries = append(entries, e)
+		}
+	}
+	f.recent[key] = append(entries, recentEntry{digest: digest, at: now})
+
+	// 定期清理不活跃用户，避免内存持续增长
+	if len(f.recent) > 10000 {
+		for k, list := range f.recent {
+			if len(list) == 0 || list[len(list)-1].at.Before(cutoff) {
+				delete(f.recent, k)
+			}
+		}
+	}
+}
+
+// matchedWords 命中的敏感词（去重）
+func matchedWords(norm normalized, matches []Match) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, m := range matches {
+		w := string(norm.runes[m.Start:m.End])
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// maskMatches 在原文上把命中的区间替换为 ***，重叠或相邻的区间合并
+func maskMatches(original []rune, norm normalized, matches []Match) string {
+	spans := make([][2]int, 0, len(matches))
+	for _, m := range matches {
+		spans = append(spans, [2]int{norm.positions[m.Start], norm.positions[m.End-1] + 1})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(spans); i++ {
+		start, end := spans[i][0], spans[i][1]
+		for i+1 < len(spans) && spans[i+1][0] <= end {
+			if spans[i+1][1] > end {
+				end = spans[i+1][1]
+			}
+			i++
+		}
+		if start < last {
+			start = last
+		}
+		b.WriteString(string(original[last:start]))
+		b.WriteString(maskText)
+		last = end
+	}
+	b.WriteString(string(original[last:]))
+	return b.String()
+}
+
+// readWords 读取词表文件
+func readWords(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// envInt 读取整数环境变量
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,82 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFilter(t *testing.T) *Filter {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("坏词\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return New(Config{
+		WordsFile:       path,
+		MaxLinks:        1,
+		DuplicateLimit:  2,
+		DuplicateWindow: time.Minute,
+		Policies:        DefaultPolicies,
+	})
+}
+
+func TestDuplicateCountsOnlyAcceptedContent(t *testing.T) {
+	f := newTestFilter(t)
+
+	// 未被接受的提交（例如保存失败后重试）不计数
+	for i := 0; i < 5; i++ {
+		if r := f.Check(SurfaceComment, 1, "同样的内容"); r.Action != ActionAllow {
+			t.Fatalf("attempt %d: got %s", i, r.Action)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		r := f.Check(SurfaceComment, 1, "同样的内容")
+		if r.Action != ActionAllow {
+			t.Fatalf("accepted %d: got %s", i, r.Action)
+		}
+		r.Accept()
+	}
+
+	r := f.Check(SurfaceComment, 1, "同样的内容")
+	if r.Action != ActionReject || r.Spam == "" {
+		t.Fatalf("expected duplicate to be rejected, got %s", r.Action)
+	}
+
+	// 其他用户和其他场景不受影响
+	if r := f.Check(SurfaceComment, 2, "同样的内容"); r.Action != ActionAllow {
+		t.Fatalf("other user: got %s", r.Action)
+	}
+	if r := f.Check(SurfacePost, 1, "同样的内容"); r.Action != ActionAllow {
+		t.Fatalf("other surface: got %s", r.Action)
+	}
+}
+
+func TestRejectedContentIsNotCounted(t *testing.T) {
+	f := newTestFilter(t)
+
+	spam := "看 https://a.example.com 和 https://b.example.com"
+	for i := 0; i < 3; i++ {
+		r := f.Check(SurfaceComment, 1, spam)
+		if r.Action != ActionReject {
+			t.Fatalf("expected link spam to be rejected, got %s", r.Action)
+		}
+	}
+	if n := f.countDuplicate("comment:1", f.Check(SurfaceComment, 1, spam).dupDigest); n != 0 {
+		t.Fatalf("rejected submissions were counted: %d", n)
+	}
+}
+
+func TestMaskKeepsDuplicateTracking(t *testing.T) {
+	f := newTestFilter(t)
+
+	r := f.Check(SurfaceComment, 1, "这是坏词")
+	if r.Action != ActionMask || r.Text != "这是***" {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	r.Accept()
+	if n := f.countDuplicate(r.dupKey, r.dupDigest); n != 1 {
+		t.Fatalf("expected masked content to be counted once, got %d", n)
+	}
+}
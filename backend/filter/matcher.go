@@ -0,0 +1,103 @@
+package filter
+
+// Matcher 基于 Aho-Corasick 自动机的多关键词匹配器
+// 一次扫描即可找出文本中所有命中的关键词，耗时与词表大小无关
+type Matcher struct {
+	nodes []acNode
+}
+
+// acNode 自动机节点
+type acNode struct {
+	children map[rune]int
+	fail     int
+	// outputs 以该节点结尾的关键词长度（按 rune 计），包含经 fail 链继承的
+	outputs []int
+}
+
+// Match 一次命中，Start/End 为规范化后文本中的 rune 下标（左闭右开）
+type Match struct {
+	Start int
+	End   int
+}
+
+// NewMatcher 根据关键词列表构建匹配器，关键词需已规范化
+func NewMatcher(words []string) *Matcher {
+	m := &Matcher{nodes: []acNode{{children: map[rune]int{}}}}
+
+	for _, word := range words {
+		runes := []rune(word)
+		if len(runes) == 0 {
+			continue
+		}
+		cur := 0
+		for _, r := range runes {
+			next, ok := m.nodes[cur].children[r]
+			if !ok {
+				next = len(m.nodes)
+				m.nodes = append(m.nodes, acNode{children: map[rune]int{}})
+				m.nodes[cur].children[r] = next
+			}
+			cur = next
+		}
+		m.nodes[cur].outputs = append(m.nodes[cur].outputs, len(runes))
+	}
+
+	m.buildFailLinks()
+	return m
+}
+
+// buildFailLinks 按层序构建失败指针
+func (m *Matcher) buildFailLinks() {
+	queue := make([]int, 0, len(m.nodes))
+	for _, child := range m.nodes[0].children {
+		m.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for r, child := range m.nodes[cur].children {
+			fail := m.nodes[cur].fail
+			for fail != 0 {
+				if _, ok := m.nodes[fail].children[r]; ok {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			if next, ok := m.nodes[fail].children[r]; ok && next != child {
+				m.nodes[child].fail = next
+			} else {
+				m.nodes[child].fail = 0
+			}
+			m.nodes[child].outputs = append(m.nodes[child].outputs, m.nodes[m.nodes[child].fail].outputs...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+// FindAll 返回文本中所有命中的位置
+func (m *Matcher) FindAll(text []rune) []Match {
+	if m == nil || len(m.nodes) <= 1 {
+		return nil
+	}
+
+	var matches []Match
+	cur := 0
+	for i, r := range text {
+		for cur != 0 {
+			if _, ok := m.nodes[cur].children[r]; ok {
+				break
+			}
+			cur = m.nodes[cur].fail
+		}
+		if next, ok := m.nodes[cur].children[r]; ok {
+			cur = next
+		}
+		for _, length := range m.nodes[cur].outputs {
+			matches = append(matches, Match{Start: i - length + 1, End: i + 1})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,47 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+)
+
+// normalized 规范化后的文本，positions 记录每个 rune 在原文中的下标
+// 用于在原文上打码
+type normalized struct {
+	runes     []rune
+	positions []int
+}
+
+// normalizeText 统一大小写和全角字符，并去掉空白和标点
+// 这样 "加 微 信"、"加-微-信" 之类的变体也能命中 "加微信"
+func normalizeText(original []rune) normalized {
+	n := normalized{
+		runes:     make([]rune, 0, len(original)),
+		positions: make([]int, 0, len(original)),
+	}
+	for i, r := range original {
+		r = foldRune(r)
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		n.runes = append(n.runes, r)
+		n.positions = append(n.positions, i)
+	}
+	return n
+}
+
+// normalizeWord 规范化词表中的关键词（规则与 normalizeText 一致）
+func normalizeWord(word string) string {
+	return string(normalizeText([]rune(strings.TrimSpace(word))).runes)
+}
+
+// foldRune 全角转半角并转小写
+func foldRune(r rune) rune {
+	switch {
+	case r == '　':
+		r = ' '
+	case r >= '！' && r <= '～':
+		r -= 0xFEE0
+	}
+	return unicode.ToLower(r)
+}
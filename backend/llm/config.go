@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultBaseURL 默认的 OpenAI 兼容接口地址（阿里云百炼）
+const DefaultBaseURL = "https://coding.dashscope.aliyuncs.com/v1"
+
+// Config 客户端配置
+type Config struct {
+	BaseURL      string        // 接口地址，不含 /chat/completions
+	APIKey       string        // 为空时所有调用返回 ErrNotConfigured
+	Model        string        // 默认模型
+	Timeout      time.Duration // 单次请求超时
+	MaxRetries   int           // 限流、服务端错误和网络错误的最大重试次数
+	RetryBackoff time.Duration // 首次重试前的等待时间，之后每次翻倍
+}
+
+// ConfigFromEnv 从环境变量读取配置
+func ConfigFromEnv() Config {
+	cfg := Config{
+		BaseURL:      os.Getenv("LLM_BASE_URL"),
+		APIKey:       os.Getenv("LLM_API_KEY"),
+		Model:        EnvModel("LLM_MODEL", "qwen-turbo"),
+		Timeout:      time.Duration(envInt("LLM_TIMEOUT_SECONDS", 30)) * time.Second,
+		MaxRetries:   envInt("LLM_MAX_RETRIES", 2),
+		RetryBackoff: 500 * time.Millisecond,
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("AI_API_KEY") // 兼容旧配置
+	}
+	return cfg
+}
+
+// EnvModel 读取某个场景使用的模型名
+func EnvModel(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// envInt 读取整数环境变量
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
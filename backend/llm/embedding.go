@@ -0,0 +1,244 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Embedder 把文本转换为向量
+// 不同 Model 生成的向量不能互相比较，存储向量时应同时记录 Model
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Model() string
+}
+
+// DefaultEmbedder 全局向量化服务，启动时按环境变量替换
+var DefaultEmbedder Embedder = NewHashEmbedder(defaultHashDimensions)
+
+// defaultHashDimensions 本地哈希向量的默认维度
+const defaultHashDimensions = 256
+
+// EmbedderFromEnv 按环境变量选择向量化服务
+// EMBEDDING_PROVIDER=openai 使用 OpenAI 兼容的 /embeddings 接口，local 使用本地哈希向量，
+// 默认 auto：配置了 API Key 时使用接口，否则使用本地哈希向量
+func EmbedderFromEnv() Embedder {
+	cfg := ConfigFromEnv()
+	if baseURL := os.Getenv("EMBEDDING_BASE_URL"); baseURL != "" {
+		cfg.BaseURL = baseURL
+	}
+
+	switch EnvModel("EMBEDDING_PROVIDER", "auto") {
+	case "local":
+		return NewHashEmbedder(envInt("EMBEDDING_DIMENSIONS", defaultHashDimensions))
+	case "auto":
+		if cfg.APIKey == "" {
+			return NewHashEmbedder(defaultHashDimensions)
+		}
+	}
+	return NewEmbedder(cfg, EnvModel("EMBEDDING_MODEL", "text-embedding-v3"), envInt("EMBEDDING_DIMENSIONS", 512))
+}
+
+// openAIEmbedder OpenAI 兼容的 /embeddings 接口
+type openAIEmbedder struct {
+	client     *openAIClient
+	model      string
+	dimensions int // 0 表示使用模型默认维度
+	batchSize  int
+}
+
+// NewEmbedder 创建调用 OpenAI 兼容接口的向量化服务
+func NewEmbedder(cfg Config, model string, dimensions int) Embedder {
+	return &openAIEmbedder{
+		client:     newOpenAIClient(cfg),
+		model:      model,
+		dimensions: dimensions,
+		batchSize:  envInt("EMBEDDING_BATCH_SIZE", 10),
+	}
+}
+
+// embeddingRequest 接口请求体
+type embeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+// embeddingResponse 接口响应体
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Model 模型名和维度，维度不同的向量同样不能比较
+func (e *openAIEmbedder) Model() string {
+	if e.dimensions > 0 {
+		return fmt.Sprintf("%s@%d", e.model, e.dimensions)
+	}
+	return e.model
+}
+
+// Embed 分批请求接口，返回的向量与 texts 一一对应
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.client.cfg.APIKey == "" {
+		return nil, ErrNotConfigured
+	}
+
+	batchSize := e.batchSize
+	if batchSize <= 0 {
+		batchSize = len(texts)
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		end := start + batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := e.embedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// embedBatch 请求一批文本的向量
+func (e *openAIEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	payload, err := json.Marshal(embeddingRequest{
+		Model:          e.model,
+		Input:          texts,
+		Dimensions:     e.dimensions,
+		EncodingFormat: "float",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	err = e.client.withRetry(ctx, func() error {
+		attemptCtx, cancel := context.WithTimeout(ctx, e.client.cfg.Timeout)
+		defer cancel()
+
+		httpResp, err := e.client.send(attemptCtx, "/embeddings", payload)
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(httpResp.Body, 32<<20))
+		if err != nil {
+			return &networkError{msg: "读取向量服务响应失败", err: err}
+		}
+		var parsed embeddingResponse
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return fmt.Errorf("解析向量服务响应失败：%w", err)
+		}
+		for _, item := range parsed.Data {
+			if item.Index >= 0 && item.Index < len(vectors) {
+				vectors[item.Index] = item.Embedding
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, vector := range vectors {
+		if len(vector) == 0 {
+			return nil, ErrEmptyResponse
+		}
+	}
+	return vectors, nil
+}
+
+// hashEmbedder 本地哈希向量：把词和汉字二元组哈希到固定维度
+// 不需要调用外部服务，只能体现字面上的相似，作为未配置向量接口时的兜底
+type hashEmbedder struct {
+	dimensions int
+}
+
+// NewHashEmbedder 创建本地哈希向量化服务
+func NewHashEmbedder(dimensions int) Embedder {
+	if dimensions <= 0 {
+		dimensions = defaultHashDimensions
+	}
+	return &hashEmbedder{dimensions: dimensions}
+}
+
+// Model 模型标识
+func (e *hashEmbedder) Model() string {
+	return fmt.Sprintf("local-hash@%d", e.dimensions)
+}
+
+// Embed 计算向量，没有可用特征的文本返回零向量
+func (e *hashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+// embed 英文和数字按词、汉字按单字和相邻二字组合提取特征
+func (e *hashEmbedder) embed(text string) []float32 {
+	vector := make([]float32, e.dimensions)
+	add := func(feature string, weight float32) {
+		h := fnv.New32a()
+		h.Write([]byte(feature))
+		sum := h.Sum32()
+		// 最高位决定符号，减少哈希冲突带来的偏差
+		if sum>>31 == 1 {
+			weight = -weight
+		}
+		vector[sum%uint32(e.dimensions)] += weight
+	}
+
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			add(string(word), 1)
+			word = word[:0]
+		}
+	}
+	var prevHan rune
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			add(string(r), 0.5)
+			if prevHan != 0 {
+				add(string([]rune{prevHan, r}), 1)
+			}
+			prevHan = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+		prevHan = 0
+	}
+	flush()
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vector {
+			vector[i] *= scale
+		}
+	}
+	return vector
+}
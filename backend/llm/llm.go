@@ -0,0 +1,135 @@
+// Package llm 封装 OpenAI 兼容的大模型对话接口（默认阿里云百炼 DashScope）
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 消息角色
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// ErrNotConfigured 未配置 API Key，调用方可以据此使用本地兜底文案
+var ErrNotConfigured = errors.New("AI 服务未配置")
+
+// ErrEmptyResponse 模型没有返回任何内容
+var ErrEmptyResponse = errors.New("AI 服务返回了空结果")
+
+// Message 对话消息
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // 助手要求调用的工具
+	ToolCallID string     `json:"tool_call_id,omitempty"` // 工具结果对应的调用
+}
+
+// Tool 提供给模型调用的函数
+type Tool struct {
+	Type     string       `json:"type"` // 固定为 function
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 函数定义，Parameters 为 JSON Schema
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolCall 模型发起的一次函数调用
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 调用的函数名和 JSON 参数
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// NewTool 创建函数工具
+func NewTool(name, description, parameters string) Tool {
+	return Tool{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  json.RawMessage(parameters),
+		},
+	}
+}
+
+// Request 对话补全请求
+type Request struct {
+	Model       string // 为空时使用客户端默认模型
+	Messages    []Message
+	MaxTokens   int
+	Temperature float64
+	TopP        float64
+	Tools       []Tool // 为空时不启用函数调用
+
+	// 以下字段只用于用量统计，不会发送给模型
+	Purpose string // 调用场景，例如 analyze、chat
+	UserID  uint   // 发起调用的用户，未登录为 0
+}
+
+// Usage token 用量
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Response 对话补全结果
+type Response struct {
+	Content      string
+	ToolCalls    []ToolCall // 不为空时需要执行工具并把结果发回模型
+	Model        string
+	FinishReason string
+	Usage        Usage
+}
+
+// StreamHandler 流式输出回调，每收到一段增量文本调用一次，返回错误会中止生成
+type StreamHandler func(delta string) error
+
+// Client 大模型客户端
+type Client interface {
+	// Complete 等待完整结果后返回
+	Complete(ctx context.Context, req *Request) (*Response, error)
+	// Stream 边生成边回调，结束后返回拼接好的完整结果
+	// ctx 取消（例如客户端断开）时立即停止读取上游
+	Stream(ctx context.Context, req *Request, handler StreamHandler) (*Response, error)
+}
+
+// APIError 上游接口返回的错误
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+	RetryAfter time.Duration // 上游通过 Retry-After 建议的等待时间
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("AI 服务返回错误（%d）：%s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("AI 服务返回错误（%d）", e.StatusCode)
+}
+
+// Temporary 限流和服务端错误可以重试
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// Default 全局客户端，由 main 初始化
+var Default Client = NewClient(Config{})
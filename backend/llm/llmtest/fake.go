@@ -0,0 +1,239 @@
+// Package llmtest 提供测试用的假大模型服务
+package llmtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"tapspot/llm"
+)
+
+// FakeReply 假服务的一次响应
+type FakeReply struct {
+	Content    string
+	Usage      llm.Usage
+	StatusCode int    // 非 0 且不是 200 时返回错误响应
+	Error      string // 错误响应的 message
+	RetryAfter int    // 错误响应的 Retry-After 秒数
+	ChunkRunes int    // 流式响应每个分片的字符数，默认 1
+	ToolCalls  []llm.ToolCall
+}
+
+// FakeServer OpenAI 兼容的假 HTTP 服务，按顺序返回预设的响应并记录收到的请求
+type FakeServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	replies  []FakeReply
+	fallback FakeReply
+	requests []json.RawMessage
+}
+
+// NewFakeServer 启动假服务，预设响应用完后一直返回最后一个
+func NewFakeServer(replies ...FakeReply) *FakeServer {
+	f := &FakeServer{replies: replies, fallback: FakeReply{Content: "ok"}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+// Client 返回指向假服务的客户端（不重试以便断言）
+func (f *FakeServer) Client() llm.Client {
+	return llm.NewClient(llm.Config{
+		BaseURL: f.URL,
+		APIKey:  "test-key",
+		Model:   "fake-model",
+	})
+}
+
+// Requests 收到的请求体
+func (f *FakeServer) Requests() []json.RawMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]json.RawMessage(nil), f.requests...)
+}
+
+// handle 处理 /chat/completions 请求
+func (f *FakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	var options struct {
+		Stream bool `json:"stream"`
+	}
+	_ = json.Unmarshal(body, &options)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, body)
+	reply := f.fallback
+	if len(f.replies) > 0 {
+		reply = f.replies[0]
+		if len(f.replies) > 1 {
+			f.replies = f.replies[1:]
+		} else {
+			f.fallback = reply
+			f.replies = nil
+		}
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if reply.StatusCode != 0 && reply.StatusCode != http.StatusOK {
+		if reply.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(reply.RetryAfter))
+		}
+		w.WriteHeader(reply.StatusCode)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]string{"message": reply.Error, "type": "fake_error"},
+		})
+		return
+	}
+
+	if options.Stream {
+		f.writeStream(w, r, reply)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"model": "fake-model",
+		"choices": []map[string]interface{}{
+			{"message": llm.Message{Role: llm.RoleAssistant, Content: reply.Content, ToolCalls: reply.ToolCalls}, "finish_reason": finishReason(reply)},
+		},
+		"usage": reply.Usage,
+	})
+}
+
+// writeStream 按 SSE 格式分片输出，客户端断开后停止
+func (f *FakeServer) writeStream(w http.ResponseWriter, r *http.Request, reply FakeReply) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	size := reply.ChunkRunes
+	if size <= 0 {
+		size = 1
+	}
+	runes := []rune(reply.Content)
+	for i := 0; i < len(runes); i += size {
+		end := i + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+		writeEvent(w, map[string]interface{}{
+			"model":   "fake-model",
+			"choices": []map[string]interface{}{{"delta": map[string]string{"content": string(runes[i:end])}}},
+		})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	for i, call := range reply.ToolCalls {
+		writeEvent(w, map[string]interface{}{
+			"model": "fake-model",
+			"choices": []map[string]interface{}{{"delta": map[string]interface{}{
+				"tool_calls": []map[string]interface{}{{"index": i, "id": call.ID, "type": "function", "function": call.Function}},
+			}}},
+		})
+	}
+	writeEvent(w, map[string]interface{}{
+		"model":   "fake-model",
+		"choices": []map[string]interface{}{{"delta": map[string]string{}, "finish_reason": finishReason(reply)}},
+	})
+	writeEvent(w, map[string]interface{}{"model": "fake-model", "choices": []interface{}{}, "usage": reply.Usage})
+	w.Write([]byte("data: [DONE]\n\n"))
+}
+
+// finishReason 有函数调用时为 tool_calls
+func finishReason(reply FakeReply) string {
+	if len(reply.ToolCalls) > 0 {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// writeEvent 输出一个 SSE 事件
+func writeEvent(w http.ResponseWriter, v interface{}) {
+	data, _ := json.Marshal(v)
+	w.Write([]byte("data: "))
+	w.Write(data)
+	w.Write([]byte("\n\n"))
+}
+
+// FakeClient 不走网络的客户端，按顺序返回预设内容
+type FakeClient struct {
+	mu       sync.Mutex
+	Replies  []string
+	Err      error
+	Requests []*llm.Request
+}
+
+// Stream 按字符逐个回调下一个预设内容
+func (f *FakeClient) Stream(ctx context.Context, req *llm.Request, handler llm.StreamHandler) (*llm.Response, error) {
+	resp, err := f.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resp.Content {
+		if err := ctx.Err(); err != nil {
+			return resp, err
+		}
+		if err := handler(string(r)); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// Complete 记录请求并返回下一个预设内容
+func (f *FakeClient) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Requests = append(f.Requests, req)
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	content := "ok"
+	if len(f.Replies) > 0 {
+		content = f.Replies[0]
+		if len(f.Replies) > 1 {
+			f.Replies = f.Replies[1:]
+		}
+	}
+	return &llm.Response{Content: content, Model: "fake-model", FinishReason: "stop"}, nil
+}
+
+// FakeEmbedder 不走网络的向量化服务：优先返回预设向量，否则使用本地哈希向量
+type FakeEmbedder struct {
+	mu      sync.Mutex
+	Vectors map[string][]float32
+	Err     error
+	Inputs  []string
+}
+
+// Model 模型标识
+func (f *FakeEmbedder) Model() string {
+	return "fake-embedding"
+}
+
+// Embed 记录输入并返回向量
+func (f *FakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Inputs = append(f.Inputs, texts...)
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	vectors, _ := llm.NewHashEmbedder(0).Embed(ctx, texts)
+	for i, text := range texts {
+		if vector, ok := f.Vectors[text]; ok {
+			vectors[i] = vector
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,362 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxToolCalls 一次回复中最多接受的函数调用数，流式分片的 index 超出范围视为无效响应
+const maxToolCalls = 32
+
+// openAIClient OpenAI 兼容接口的客户端
+type openAIClient struct {
+	cfg  Config
+	http *http.Client
+}
+
+// NewClient 创建 OpenAI 兼容接口的客户端
+func NewClient(cfg Config) Client {
+	return newOpenAIClient(cfg)
+}
+
+// newOpenAIClient 补全默认配置并创建客户端
+func newOpenAIClient(cfg Config) *openAIClient {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 500 * time.Millisecond
+	}
+	return &openAIClient{
+		cfg:  cfg,
+		http: &http.Client{},
+	}
+}
+
+// chatCompletionRequest 接口请求体
+type chatCompletionRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Temperature float64   `json:"temperature,omitempty"`
+	TopP        float64   `json:"top_p,omitempty"`
+	Tools       []Tool    `json:"tools,omitempty"`
+	Stream      bool      `json:"stream"`
+
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+// streamOptions 流式请求选项
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // 在最后一个分片中返回用量
+}
+
+// chatCompletionChunk 流式响应的分片
+type chatCompletionChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int              `json:"index"`
+				ID       string           `json:"id"`
+				Type     string           `json:"type"`
+				Function ToolCallFunction `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+// chatCompletionResponse 接口响应体
+type chatCompletionResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+// errorResponse 接口错误响应体
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// Complete 发送对话补全请求，失败时按配置重试
+func (c *openAIClient) Complete(ctx context.Context, req *Request) (*Response, error) {
+	payload, err := c.payload(req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *Response
+	err = c.withRetry(ctx, func() error {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+
+		httpResp, err := c.send(attemptCtx, "/chat/completions", payload)
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+		resp, err = decodeResponse(httpResp.Body)
+		return err
+	})
+	return resp, err
+}
+
+// Stream 发送流式对话补全请求
+// 只有在收到响应之前的失败才会重试；超时限制的是两次输出之间的最长间隔
+func (c *openAIClient) Stream(ctx context.Context, req *Request, handler StreamHandler) (*Response, error) {
+	payload, err := c.payload(req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	idle := time.AfterFunc(c.cfg.Timeout, cancel)
+	defer idle.Stop()
+
+	var httpResp *http.Response
+	err = c.withRetry(streamCtx, func() error {
+		idle.Reset(c.cfg.Timeout)
+		httpResp, err = c.send(streamCtx, "/chat/completions", payload)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	return readStream(streamCtx, httpResp.Body, func(delta string) error {
+		idle.Reset(c.cfg.Timeout)
+		return handler(delta)
+	})
+}
+
+// payload 构造请求体
+func (c *openAIClient) payload(req *Request, stream bool) ([]byte, error) {
+	if c.cfg.APIKey == "" {
+		return nil, ErrNotConfigured
+	}
+
+	body := chatCompletionRequest{
+		Model:       req.Model,
+		Messages:    req.Messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Tools:       req.Tools,
+		Stream:      stream,
+	}
+	if body.Model == "" {
+		body.Model = c.cfg.Model
+	}
+	if stream {
+		body.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	return json.Marshal(body)
+}
+
+// withRetry 执行 fn，遇到可重试的错误时按指数退避重试
+func (c *openAIClient) withRetry(ctx context.Context, fn func() error) error {
+	var lastErr error
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := c.cfg.RetryBackoff << (attempt - 1)
+			var apiErr *APIError
+			if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > wait {
+				wait = apiErr.RetryAfter
+			}
+			select {
+			case <-ctx.Done():
+				return lastErr
+			case <-time.After(wait):
+			}
+		}
+
+		lastErr = fn()
+		if lastErr == nil || ctx.Err() != nil || !retryable(lastErr) {
+			break
+		}
+	}
+	return lastErr
+}
+
+// send 向 path 发送一次请求，返回状态码为 200 的响应
+func (c *openAIClient) send(ctx context.Context, path string, payload []byte) (*http.Response, error) {
+	url := strings.TrimRight(c.cfg.BaseURL, "/") + path
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+
+	httpResp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, &networkError{msg: "请求 AI 服务失败", err: err}
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+		apiErr := &APIError{StatusCode: httpResp.StatusCode}
+		body, _ := io.ReadAll(io.LimitReader(httpResp.Body, 64<<10))
+		var errBody errorResponse
+		if json.Unmarshal(body, &errBody) == nil {
+			apiErr.Type = errBody.Error.Type
+			apiErr.Message = errBody.Error.Message
+		}
+		if seconds, err := strconv.Atoi(httpResp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, apiErr
+	}
+	return httpResp, nil
+}
+
+// decodeResponse 解析非流式响应
+func decodeResponse(r io.Reader) (*Response, error) {
+	body, err := io.ReadAll(io.LimitReader(r, 4<<20))
+	if err != nil {
+		return nil, &networkError{msg: "读取 AI 服务响应失败", err: err}
+	}
+
+	var parsed chatCompletionResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("解析 AI 服务响应失败：%w", err)
+	}
+	if len(parsed.Choices) == 0 {
+		return nil, ErrEmptyResponse
+	}
+	message := parsed.Choices[0].Message
+	if strings.TrimSpace(message.Content) == "" && len(message.ToolCalls) == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	return &Response{
+		Content:      strings.TrimSpace(message.Content),
+		ToolCalls:    message.ToolCalls,
+		Model:        parsed.Model,
+		FinishReason: parsed.Choices[0].FinishReason,
+		Usage:        parsed.Usage,
+	}, nil
+}
+
+// readStream 逐行读取 SSE 响应，把增量内容交给 handler
+func readStream(ctx context.Context, r io.Reader, handler StreamHandler) (*Response, error) {
+	resp := &Response{}
+	var content strings.Builder
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			resp.Content = content.String()
+			return resp, err
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("解析 AI 服务响应失败：%w", err)
+		}
+		if chunk.Model != "" {
+			resp.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			resp.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				resp.FinishReason = *choice.FinishReason
+			}
+			// 函数调用的名称和参数分散在多个分片中，按 index 拼接
+			for _, delta := range choice.Delta.ToolCalls {
+				if delta.Index < 0 || delta.Index >= maxToolCalls {
+					return nil, fmt.Errorf("AI 服务返回了无效的函数调用序号：%d", delta.Index)
+				}
+				for len(resp.ToolCalls) <= delta.Index {
+					resp.ToolCalls = append(resp.ToolCalls, ToolCall{Type: "function"})
+				}
+				call := &resp.ToolCalls[delta.Index]
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				call.Function.Name += delta.Function.Name
+				call.Function.Arguments += delta.Function.Arguments
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			if err := handler(choice.Delta.Content); err != nil {
+				resp.Content = content.String()
+				return resp, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		resp.Content = content.String()
+		return resp, fmt.Errorf("读取 AI 服务响应失败：%w", err)
+	}
+
+	resp.Content = strings.TrimSpace(content.String())
+	if resp.Content == "" && len(resp.ToolCalls) == 0 {
+		return nil, ErrEmptyResponse
+	}
+	return resp, nil
+}
+
+// networkError 连接失败、超时或读取响应中断等网络错误
+type networkError struct {
+	msg string
+	err error
+}
+
+func (e *networkError) Error() string {
+	return e.msg + "：" + e.err.Error()
+}
+
+func (e *networkError) Unwrap() error {
+	return e.err
+}
+
+// retryable 判断错误是否值得重试：只重试网络错误和限流、服务端错误
+// 响应无法解析或内容为空时重试通常得到同样的结果，直接返回
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var netErr *networkError
+	return errors.As(err, &netErr)
+}
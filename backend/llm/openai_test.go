@@ -0,0 +1,291 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tapspot/llm"
+	"tapspot/llm/llmtest"
+)
+
+// retryingClient 指向 url 的客户端，最多重试 2 次，退避时间很短
+func retryingClient(url string) llm.Client {
+	return llm.NewClient(llm.Config{
+		BaseURL:      url,
+		APIKey:       "test-key",
+		Model:        "fake-model",
+		Timeout:      2 * time.Second,
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+}
+
+func newFake(t *testing.T, replies ...llmtest.FakeReply) *llmtest.FakeServer {
+	f := llmtest.NewFakeServer(replies...)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func userRequest(text string) *llm.Request {
+	return &llm.Request{Messages: []llm.Message{{Role: llm.RoleUser, Content: text}}}
+}
+
+func TestCompleteRetriesServerErrors(t *testing.T) {
+	f := newFake(t,
+		llmtest.FakeReply{StatusCode: http.StatusServiceUnavailable, Error: "busy"},
+		llmtest.FakeReply{StatusCode: http.StatusTooManyRequests, Error: "slow down"},
+		llmtest.FakeReply{Content: "你好"},
+	)
+
+	resp, err := retryingClient(f.URL).Complete(context.Background(), userRequest("hi"))
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if resp.Content != "你好" {
+		t.Fatalf("unexpected content %q", resp.Content)
+	}
+	if n := len(f.Requests()); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestCompleteGivesUpAfterMaxRetries(t *testing.T) {
+	f := newFake(t, llmtest.FakeReply{StatusCode: http.StatusBadGateway, Error: "down"})
+
+	_, err := retryingClient(f.URL).Complete(context.Background(), userRequest("hi"))
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected 502 APIError, got %v", err)
+	}
+	if n := len(f.Requests()); n != 3 {
+		t.Fatalf("expected 1 request and 2 retries, got %d", n)
+	}
+}
+
+func TestCompleteDoesNotRetryClientErrors(t *testing.T) {
+	f := newFake(t, llmtest.FakeReply{StatusCode: http.StatusBadRequest, Error: "bad request"})
+
+	_, err := retryingClient(f.URL).Complete(context.Background(), userRequest("hi"))
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "bad request" {
+		t.Fatalf("expected 400 APIError, got %v", err)
+	}
+	if n := len(f.Requests()); n != 1 {
+		t.Fatalf("expected no retries, got %d requests", n)
+	}
+}
+
+func TestCompleteDoesNotRetryEmptyResponse(t *testing.T) {
+	f := newFake(t, llmtest.FakeReply{Content: "  "})
+
+	_, err := retryingClient(f.URL).Complete(context.Background(), userRequest("hi"))
+	if !errors.Is(err, llm.ErrEmptyResponse) {
+		t.Fatalf("expected ErrEmptyResponse, got %v", err)
+	}
+	if n := len(f.Requests()); n != 1 {
+		t.Fatalf("expected no retries, got %d requests", n)
+	}
+}
+
+func TestCompleteDoesNotRetryMalformedResponse(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte("<html>not json</html>"))
+	}))
+	defer server.Close()
+
+	if _, err := retryingClient(server.URL).Complete(context.Background(), userRequest("hi")); err == nil {
+		t.Fatal("expected decode error")
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("expected no retries, got %d requests", n)
+	}
+}
+
+func TestCompleteRetriesNetworkErrors(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// 第一次直接断开连接
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model":   "fake-model",
+			"choices": []map[string]interface{}{{"message": llm.Message{Role: llm.RoleAssistant, Content: "ok"}}},
+		})
+	}))
+	defer server.Close()
+
+	resp, err := retryingClient(server.URL).Complete(context.Background(), userRequest("hi"))
+	if err != nil || resp.Content != "ok" {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestNotConfiguredClient(t *testing.T) {
+	client := llm.NewClient(llm.Config{})
+	if _, err := client.Complete(context.Background(), userRequest("hi")); !errors.Is(err, llm.ErrNotConfigured) {
+		t.Fatalf("expected ErrNotConfigured, got %v", err)
+	}
+}
+
+// usageRecorder 收集用量记录
+type usageRecorder struct {
+	mu      sync.Mutex
+	records []llm.UsageRecord
+}
+
+func (r *usageRecorder) RecordUsage(record llm.UsageRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+func TestUsageRecorder(t *testing.T) {
+	usage := llm.Usage{PromptTokens: 12, CompletionTokens: 5, TotalTokens: 17}
+	f := newFake(t, llmtest.FakeReply{Content: "你好", Usage: usage})
+	recorder := &usageRecorder{}
+	client := llm.WithUsageRecorder(f.Client(), recorder)
+
+	req := userRequest("hi")
+	req.Purpose = "analyze"
+	req.UserID = 7
+	if _, err := client.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if _, err := client.Stream(context.Background(), req, func(string) error { return nil }); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+
+	if len(recorder.records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recorder.records))
+	}
+	for _, record := range recorder.records {
+		if record.Purpose != "analyze" || record.UserID != 7 || record.Model != "fake-model" ||
+			record.Usage != usage || record.Err != nil {
+			t.Fatalf("unexpected record %+v", record)
+		}
+	}
+
+	// 失败的调用也会记录，未配置的调用不记录
+	failing := llm.WithUsageRecorder(newFake(t, llmtest.FakeReply{StatusCode: http.StatusBadRequest}).Client(), recorder)
+	if _, err := failing.Complete(context.Background(), req); err == nil {
+		t.Fatal("expected error")
+	}
+	unconfigured := llm.WithUsageRecorder(llm.NewClient(llm.Config{}), recorder)
+	unconfigured.Complete(context.Background(), req)
+	if len(recorder.records) != 3 || recorder.records[2].Err == nil {
+		t.Fatalf("unexpected records %+v", recorder.records)
+	}
+}
+
+func TestStream(t *testing.T) {
+	usage := llm.Usage{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7}
+	f := newFake(t, llmtest.FakeReply{Content: "你好世界", ChunkRunes: 2, Usage: usage})
+
+	var deltas []string
+	resp, err := f.Client().Stream(context.Background(), userRequest("hi"), func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if strings.Join(deltas, "|") != "你好|世界" {
+		t.Fatalf("unexpected deltas %q", deltas)
+	}
+	if resp.Content != "你好世界" || resp.Usage != usage || resp.FinishReason != "stop" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	var body struct {
+		Stream        bool `json:"stream"`
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	if err := json.Unmarshal(f.Requests()[0], &body); err != nil || !body.Stream || !body.StreamOptions.IncludeUsage {
+		t.Fatalf("unexpected request %s", f.Requests()[0])
+	}
+}
+
+func TestStreamToolCalls(t *testing.T) {
+	call := llm.ToolCall{ID: "call-1", Type: "function", Function: llm.ToolCallFunction{Name: "search_posts", Arguments: `{"q":"咖啡"}`}}
+	f := newFake(t, llmtest.FakeReply{ToolCalls: []llm.ToolCall{call}})
+
+	resp, err := f.Client().Stream(context.Background(), userRequest("hi"), func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0] != call || resp.FinishReason != "tool_calls" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestStreamHandlerErrorStops(t *testing.T) {
+	f := newFake(t, llmtest.FakeReply{Content: "abcdef"})
+	stop := errors.New("stop")
+
+	var received int
+	resp, err := f.Client().Stream(context.Background(), userRequest("hi"), func(string) error {
+		received++
+		if received == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if received != 2 || resp == nil || resp.Content != "ab" {
+		t.Fatalf("expected partial content after 2 deltas, got %d %+v", received, resp)
+	}
+}
+
+func TestStreamRetriesBeforeResponse(t *testing.T) {
+	f := newFake(t,
+		llmtest.FakeReply{StatusCode: http.StatusServiceUnavailable},
+		llmtest.FakeReply{Content: "ok"},
+	)
+
+	resp, err := retryingClient(f.URL).Stream(context.Background(), userRequest("hi"), func(string) error { return nil })
+	if err != nil || resp.Content != "ok" {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+	if n := len(f.Requests()); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestStreamRejectsInvalidToolCallIndex(t *testing.T) {
+	for _, index := range []int{-1, 1 << 30} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":%d,\"function\":{\"name\":\"x\"}}]}}]}\n\n", index)
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		}))
+
+		_, err := retryingClient(server.URL).Stream(context.Background(), userRequest("hi"), func(string) error { return nil })
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), "函数调用序号") {
+			t.Fatalf("index %d: expected invalid index error, got %v", index, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxToolRounds 默认最多连续调用工具的轮数，防止模型陷入循环
+const DefaultMaxToolRounds = 4
+
+// ErrTooManyToolRounds 超过工具调用轮数上限
+var ErrTooManyToolRounds = errors.New("AI 调用工具次数过多")
+
+// ToolExecutor 执行模型发起的函数调用，返回交给模型的结果（通常是 JSON）
+// 参数错误等可恢复的问题应当作为结果返回给模型，而不是返回 error
+type ToolExecutor interface {
+	ExecuteTool(ctx context.Context, call ToolCall) (string, error)
+}
+
+// CompleteWithTools 调用模型，模型要求调用工具时执行工具并把结果发回，直到模型给出最终回复
+func CompleteWithTools(ctx context.Context, client Client, req *Request, executor ToolExecutor, maxRounds int) (*Response, error) {
+	return runTools(ctx, req, executor, maxRounds, func(r *Request) (*Response, error) {
+		return client.Complete(ctx, r)
+	})
+}
+
+// StreamWithTools 流式版本的 CompleteWithTools，每一轮的文本增量都会交给 handler
+func StreamWithTools(ctx context.Context, client Client, req *Request, executor ToolExecutor, maxRounds int, handler StreamHandler) (*Response, error) {
+	return runTools(ctx, req, executor, maxRounds, func(r *Request) (*Response, error) {
+		return client.Stream(ctx, r, handler)
+	})
+}
+
+// runTools 工具调用循环，返回的用量为各轮之和
+func runTools(ctx context.Context, req *Request, executor ToolExecutor, maxRounds int, call func(*Request) (*Response, error)) (*Response, error) {
+	if maxRounds <= 0 {
+		maxRounds = DefaultMaxToolRounds
+	}
+
+	round := *req
+	round.Messages = append([]Message(nil), req.Messages...)
+	var usage Usage
+
+	for i := 0; ; i++ {
+		// 最后一轮不再提供工具，强制模型给出回复
+		if i == maxRounds {
+			round.Tools = nil
+		}
+
+		resp, err := call(&round)
+		if resp != nil {
+			usage.add(resp.Usage)
+			resp.Usage = usage
+		}
+		if err != nil || len(resp.ToolCalls) == 0 {
+			return resp, err
+		}
+		if i == maxRounds {
+			return resp, ErrTooManyToolRounds
+		}
+
+		round.Messages = append(round.Messages, Message{
+			Role:      RoleAssistant,
+			Content:   resp.Content,
+			ToolCalls: resp.ToolCalls,
+		})
+		for _, toolCall := range resp.ToolCalls {
+			result, err := executor.ExecuteTool(ctx, toolCall)
+			if err != nil {
+				return nil, fmt.Errorf("执行工具 %s 失败：%w", toolCall.Function.Name, err)
+			}
+			round.Messages = append(round.Messages, Message{
+				Role:       RoleTool,
+				Content:    result,
+				ToolCallID: toolCall.ID,
+			})
+		}
+	}
+}
+
+// add 累加用量
+func (u *Usage) add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
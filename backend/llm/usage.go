@@ -0,0 +1,72 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// UsageRecord 一次调用的用量记录
+type UsageRecord struct {
+	Purpose string
+	UserID  uint
+	Model   string
+	Usage   Usage
+	Latency time.Duration
+	Err     error
+}
+
+// UsageRecorder 用量记录器
+type UsageRecorder interface {
+	RecordUsage(record UsageRecord)
+}
+
+// meteredClient 记录每次调用用量的客户端
+type meteredClient struct {
+	next     Client
+	recorder UsageRecorder
+}
+
+// WithUsageRecorder 包装客户端，每次调用后记录 token 用量和耗时
+// 未配置 API Key 的调用不会产生记录
+func WithUsageRecorder(client Client, recorder UsageRecorder) Client {
+	return &meteredClient{next: client, recorder: recorder}
+}
+
+// Complete 调用并记录用量
+func (m *meteredClient) Complete(ctx context.Context, req *Request) (*Response, error) {
+	start := time.Now()
+	resp, err := m.next.Complete(ctx, req)
+	m.record(req, resp, err, start)
+	return resp, err
+}
+
+// Stream 流式调用并记录用量（中途取消的调用也会记录已产生的用量）
+func (m *meteredClient) Stream(ctx context.Context, req *Request, handler StreamHandler) (*Response, error) {
+	start := time.Now()
+	resp, err := m.next.Stream(ctx, req, handler)
+	m.record(req, resp, err, start)
+	return resp, err
+}
+
+// record 生成用量记录
+func (m *meteredClient) record(req *Request, resp *Response, err error, start time.Time) {
+	if errors.Is(err, ErrNotConfigured) {
+		return
+	}
+
+	record := UsageRecord{
+		Purpose: req.Purpose,
+		UserID:  req.UserID,
+		Model:   req.Model,
+		Latency: time.Since(start),
+		Err:     err,
+	}
+	if resp != nil {
+		record.Usage = resp.Usage
+		if resp.Model != "" {
+			record.Model = resp.Model
+		}
+	}
+	m.recorder.RecordUsage(record)
+}
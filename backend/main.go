@@ -1,10 +1,12 @@
 package main
 
 import (
-	"fmt"
 	"log"
+	"os"
+	"time"
 	"tapspot/config"
-	"tapspot/controllers"
+	"tapspot/filter"
+	"tapspot/llm"
 	"tapspot/middleware"
 	"tapspot/models"
 	"tapspot/routes"
@@ -13,7 +15,6 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
 )
 
@@ -30,10 +31,27 @@ func main() {
 	// 自动迁移数据库表
 	migrateDB()
 
-	// 创建 WebSocket Hub 并设置为全局实例
-	websocket.GlobalHub = websocket.NewHub()
+	// 命令行管理，例如 ./tapspot set-role <用户名> admin 初始化管理员
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// 创建 WebSocket Hub 并设置为全局实例（WS_BACKPLANE=redis 时多个实例之间转发消息）
+	websocket.GlobalHub = websocket.NewHub(websocket.BackplaneFromEnv())
 	go websocket.GlobalHub.Run()
 
+	// 加载敏感词表（文件变化时自动重新加载）
+	filter.InitFilter()
+
+	// 初始化大模型客户端并记录每次调用的 token 用量
+	llm.Default = llm.WithUsageRecorder(llm.NewClient(llm.ConfigFromEnv()), services.NewLLMUsageRecorder())
+
+	// 初始化文本向量化服务（语义推荐）
+	llm.DefaultEmbedder = llm.EmbedderFromEnv()
+
+	// 按环境变量初始化 AI 地点分析缓存
+	services.DefaultAnalysisCache = services.NewAnalysisCache()
+
 	// 设置 token 验证函数（解决循环导入问题）
 	websocket.ValidateTokenFunc = func(tokenString string) (uint, error) {
 		return validateTokenAndGetUserID(tokenString)
@@ -42,12 +60,17 @@ func main() {
 	// 创建 Gin 引擎
 	r := gin.Default()
 
+	// 只采信可信代理转发的客户端 IP，否则伪造 X-Forwarded-For 即可绕过按 IP 限流
+	if err := r.SetTrustedProxies(middleware.TrustedProxiesFromEnv()); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// 配置 CORS
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
 	}))
 
@@ -57,8 +80,24 @@ func main() {
 	// 注册路由
 	routes.SetupRoutes(r)
 
-	// 创建测试用户 root/root
-	services.CreateTestUser()
+	// 开发模式下创建测试用户 root/root（不授予角色），否则收回仍使用默认密码的 root 的角色
+	if services.DevMode() {
+		services.CreateTestUser()
+	} else {
+		services.RevokeDefaultCredentialRoles()
+	}
+
+	// 定期匿名化注销宽限期已过的账号
+	services.NewAccountService(services.NewMailerFromEnv()).StartDeletionWorker(time.Hour)
+
+	// 定期清理 30 天未活跃的匿名聊天会话
+	services.NewChatSessionService().StartAnonymousCleanup(30*24*time.Hour, time.Hour)
+
+	// 加载文本向量索引，并定期为新增和修改过的帖子、打卡点计算向量
+	services.NewEmbeddingService().StartSyncWorker(time.Minute)
+
+	// 定期清理过期的 AI 地点分析
+	services.DefaultAnalysisCache.StartCleanup(time.Hour)
 
 	// 启动服务器
 	log.Println("🚀 TapSpot API running on http://localhost:8080")
@@ -79,33 +118,73 @@ func migrateDB() {
 		&models.CommentLike{},
 		&models.Conversation{},
 		&models.Message{},
+		&models.GroupChat{},   // 群聊
+		&models.GroupMember{}, // 群成员
 		&models.Visit{}, // 访客记录
-		&controllers.ChatMessage{}, // 阿尼亚聊天记录
+		&models.PasswordResetToken{}, // 密码重置令牌
+		&models.IdentifierVerification{}, // 邮箱/手机号验证码
+		&models.Identity{},           // 第三方登录身份
+		&models.OAuthState{},         // 第三方授权状态
+		&models.RecoveryCode{},       // 两步验证恢复码
+		&models.LoginChallenge{},     // 两步验证登录挑战
+		&models.Block{},              // 用户屏蔽关系
+		&models.Follow{},             // 关注关系
+		&models.Report{},             // 用户举报
+		&models.ModerationAction{},   // 审核操作记录
+		&models.LLMUsage{},           // 大模型调用用量
+		&models.ChatMessage{},      // 阿尼亚聊天记录
+		&models.ChatSession{},        // 阿尼亚聊天会话
+		&models.Embedding{},          // 帖子和打卡点的文本向量
+		&models.LocationAnalysis{},   // AI 地点分析缓存
 	)
+	migrateLocationPrecision()
+	migrateMessageReceiver()
 	log.Println("✅ 数据库迁移完成")
 }
 
-// validateTokenAndGetUserID 验证 token 并返回 userID
-func validateTokenAndGetUserID(tokenString string) (uint, error) {
-	// Bearer token 格式
-	if len(tokenString) > 7 && tokenString[:7] == "Bearer " {
-		tokenString = tokenString[7:]
+// migrateLocationPrecision 旧版本的 location_precision 列用 0 表示不模糊，
+// 迁移到 location_decimals 列（-1 表示不模糊）后删除旧列，使 0 位小数可以被选择
+func migrateLocationPrecision() {
+	migrator := config.DB.Migrator()
+	if !migrator.HasColumn(&models.User{}, "location_precision") {
+		return
 	}
-
-	// 使用 services 中的 JWT 密钥验证
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return services.GetJWTSecret(), nil
-	})
-
-	if err != nil || !token.Valid {
-		return 0, fmt.Errorf("invalid token")
+	if err := config.DB.Exec("UPDATE users SET location_decimals = location_precision WHERE location_precision > 0").Error; err != nil {
+		log.Printf("迁移位置精度设置失败: %v", err)
+		return
+	}
+	if err := migrator.DropColumn(&models.User{}, "location_precision"); err != nil {
+		log.Printf("删除旧的位置精度列失败: %v", err)
 	}
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if userID, ok := claims["user_id"].(float64); ok {
-			return uint(userID), nil
+// migrateMessageReceiver 旧版本的 messages.receiver_id 不允许为空，群消息保存为 0 时违反外键约束；
+// AutoMigrate 不会把 NOT NULL 列改为可空，这里单独修改，并把已有群消息的接收者置为 NULL
+func migrateMessageReceiver() {
+	migrator := config.DB.Migrator()
+	columnTypes, err := migrator.ColumnTypes(&models.Message{})
+	if err != nil {
+		log.Printf("读取消息表结构失败: %v", err)
+		return
+	}
+	for _, column := range columnTypes {
+		if column.Name() != "receiver_id" {
+			continue
+		}
+		if nullable, ok := column.Nullable(); ok && !nullable {
+			if err := migrator.AlterColumn(&models.Message{}, "ReceiverID"); err != nil {
+				log.Printf("修改消息接收者列失败: %v", err)
+				return
+			}
 		}
 	}
+	if err := config.DB.Exec("UPDATE messages SET receiver_id = NULL WHERE group_id IS NOT NULL AND receiver_id = 0").Error; err != nil {
+		log.Printf("迁移群消息接收者失败: %v", err)
+	}
+}
 
-	return 0, fmt.Errorf("invalid claims")
+// validateTokenAndGetUserID 验证 token 并返回 userID
+func validateTokenAndGetUserID(tokenString string) (uint, error) {
+	userID, _, err := services.ParseToken(tokenString)
+	return userID, err
 }
@@ -5,7 +5,6 @@ import (
 	"tapspot/services"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 // AuthMiddleware JWT 认证中间件
@@ -21,33 +20,18 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Bearer token 格式
-		tokenString := authHeader
-		if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-			tokenString = authHeader[7:]
-		}
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return services.GetJWTSecret(), nil
-		})
-
-		if err != nil || !token.Valid {
+		userID, username, err := services.ParseToken(authHeader)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
-				"message": "token 无效或已过期",
+				"message": err.Error(),
 			})
 			c.Abort()
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if userID, ok := claims["user_id"].(float64); ok {
-				c.Set("userID", uint(userID))
-			}
-			if username, ok := claims["username"].(string); ok {
-				c.Set("username", username)
-			}
-		}
+		c.Set("userID", userID)
+		c.Set("username", username)
 
 		c.Next()
 	}
@@ -62,24 +46,25 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := authHeader
-		if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-			tokenString = authHeader[7:]
+		if userID, username, err := services.ParseToken(authHeader); err == nil {
+			c.Set("userID", userID)
+			c.Set("username", username)
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return services.GetJWTSecret(), nil
-		})
+		c.Next()
+	}
+}
 
-		if err == nil && token.Valid {
-			if claims, ok := token.Claims.(jwt.MapClaims); ok {
-				if userID, ok := claims["user_id"].(float64); ok {
-					c.Set("userID", uint(userID))
-				}
-				if username, ok := claims["username"].(string); ok {
-					c.Set("username", username)
-				}
-			}
+// ModeratorMiddleware 审核员权限中间件（需放在 AuthMiddleware 之后）
+func ModeratorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !services.IsModerator(c.GetUint("userID")) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "没有审核权限",
+			})
+			c.Abort()
+			return
 		}
 
 		c.Next()
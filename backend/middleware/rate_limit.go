@@ -0,0 +1,221 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitPolicy 限流策略：窗口期内最多 Limit 次请求（令牌桶容量为 Limit，按 Limit/Window 匀速补充）
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// refillPerSecond 每秒补充的令牌数
+func (p RateLimitPolicy) refillPerSecond() float64 {
+	return float64(p.Limit) / p.Window.Seconds()
+}
+
+// RateLimitResult 一次取令牌的结果
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int           // 剩余令牌数
+	Reset      time.Duration // 令牌桶补满所需时间
+	RetryAfter time.Duration // 被拒绝时距离下一个令牌的时间
+}
+
+// RateLimitStore 令牌桶存储，多实例部署时可替换为 Redis 等共享存储
+type RateLimitStore interface {
+	Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+// MemoryRateLimitStore 进程内令牌桶存储
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket 令牌桶状态
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	idleTime time.Duration // 桶补满所需时间，超过后可以回收
+}
+
+// NewMemoryRateLimitStore 创建进程内存储，并定期回收已补满的桶
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.sweep(now)
+		}
+	}()
+	return s
+}
+
+// Take 从桶中取出一个令牌
+func (s *MemoryRateLimitStore) Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	rate := policy.refillPerSecond()
+	capacity := float64(policy.Limit)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, updated: now, idleTime: policy.Window}
+		s.buckets[key] = b
+	}
+
+	// 按流逝的时间补充令牌
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+		b.updated = now
+	}
+
+	result := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((capacity - b.tokens) / rate * float64(time.Second))
+	return result, nil
+}
+
+// sweep 回收长时间未使用（已经补满）的桶
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > b.idleTime {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// DefaultRateLimitStore 限流中间件使用的存储
+var DefaultRateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+
+// defaultRateLimitPolicies 各接口的默认限流策略
+var defaultRateLimitPolicies = map[string]RateLimitPolicy{
+	"create_post":       {Limit: 10, Window: time.Hour},
+	"create_comment":    {Limit: 30, Window: 10 * time.Minute},
+	"send_message":      {Limit: 60, Window: time.Minute},
+	"ai_analyze":        {Limit: 10, Window: time.Minute},
+	"chat":              {Limit: 20, Window: time.Minute},
+	"recommend":         {Limit: 30, Window: time.Minute},
+	"post_draft":        {Limit: 10, Window: time.Minute},
+	"verify_identifier": {Limit: 10, Window: 10 * time.Minute},
+	"login_2fa":         {Limit: 10, Window: 5 * time.Minute},
+	"login":             {Limit: 10, Window: 5 * time.Minute},
+	"password_forgot":   {Limit: 5, Window: time.Hour},
+	"password_reset":    {Limit: 10, Window: time.Hour},
+}
+
+// NamedRateLimitPolicy 获取指定名称的限流策略
+// 环境变量 RATE_LIMIT_<NAME> 可覆盖窗口期内的请求数，例如 RATE_LIMIT_AI_ANALYZE=20，设置为 0 表示不限流
+func NamedRateLimitPolicy(name string) RateLimitPolicy {
+	policy := defaultRateLimitPolicies[name]
+	policy.Name = name
+	if v, err := strconv.Atoi(os.Getenv("RATE_LIMIT_" + strings.ToUpper(name))); err == nil && v >= 0 {
+		policy.Limit = v
+	}
+	return policy
+}
+
+// AllowUser 非 HTTP 场景（如 WebSocket 消息）按用户 ID 限流，与同名 HTTP 接口共用令牌桶
+func AllowUser(policy RateLimitPolicy, userID uint) (RateLimitResult, bool) {
+	if policy.Limit <= 0 || policy.Window <= 0 {
+		return RateLimitResult{Allowed: true}, true
+	}
+	result, err := DefaultRateLimitStore.Take(rateLimitUserKey(policy, userID), policy, time.Now())
+	if err != nil {
+		return RateLimitResult{Allowed: true}, true
+	}
+	return result, result.Allowed
+}
+
+// rateLimitUserKey 按用户限流的 key
+func rateLimitUserKey(policy RateLimitPolicy, userID uint) string {
+	return policy.Name + ":user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// RateLimit 令牌桶限流中间件
+// 已登录用户按用户 ID 限流，未登录按客户端 IP 限流，需要放在认证中间件之后才能识别用户
+// 客户端 IP 只采信 TRUSTED_PROXIES 中的代理转发的 X-Forwarded-For，见 TrustedProxiesFromEnv
+func RateLimit(policy RateLimitPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if policy.Limit <= 0 || policy.Window <= 0 {
+			c.Next()
+			return
+		}
+
+		key := policy.Name + ":ip:" + c.ClientIP()
+		if userID := c.GetUint("userID"); userID != 0 {
+			key = rateLimitUserKey(policy, userID)
+		}
+
+		result, err := DefaultRateLimitStore.Take(key, policy, time.Now())
+		if err != nil {
+			// 存储不可用时放行，避免限流组件故障导致整站不可用
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window.Seconds())))
+		c.Header("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"success": false,
+				"message": "请求过于频繁，请稍后再试",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// TrustedProxiesFromEnv 可信反向代理列表（TRUSTED_PROXIES，逗号分隔的 IP 或 CIDR）
+// 默认只信任本机的代理（如同机部署的 Nginx）；设置为 none 表示不信任任何代理，直接使用连接的来源地址
+func TrustedProxiesFromEnv() []string {
+	value := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+	if value == "" {
+		return []string{"127.0.0.1", "::1"}
+	}
+	if strings.EqualFold(value, "none") {
+		return nil
+	}
+	var proxies []string
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// ceilSeconds 向上取整到秒
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newRateLimitedEngine 只有一个限流接口的路由，使用独立的令牌桶存储
+func newRateLimitedEngine(t *testing.T, proxies []string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	previous := DefaultRateLimitStore
+	DefaultRateLimitStore = NewMemoryRateLimitStore()
+	t.Cleanup(func() { DefaultRateLimitStore = previous })
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		t.Fatal(err)
+	}
+	r.POST("/login", RateLimit(RateLimitPolicy{Name: "login", Limit: 2, Window: time.Minute}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func postLogin(r *gin.Engine, remoteAddr, forwardedFor string) int {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRateLimitIgnoresForgedForwardedFor(t *testing.T) {
+	r := newRateLimitedEngine(t, TrustedProxiesFromEnv())
+
+	// 直连的客户端伪造 X-Forwarded-For，仍按连接地址计数
+	codes := []int{
+		postLogin(r, "203.0.113.7:5000", "10.0.0.1"),
+		postLogin(r, "203.0.113.7:5000", "10.0.0.2"),
+		postLogin(r, "203.0.113.7:5000", "10.0.0.3"),
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("expected third request to be limited, got %v", codes)
+	}
+}
+
+func TestRateLimitUsesForwardedForFromTrustedProxy(t *testing.T) {
+	r := newRateLimitedEngine(t, TrustedProxiesFromEnv())
+
+	// 本机代理转发的请求按真实客户端计数，客户端自己伪造的前缀被忽略
+	for i := 0; i < 2; i++ {
+		if code := postLogin(r, "127.0.0.1:40000", "10.9.9.9, 198.51.100.1"); code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+	if code := postLogin(r, "127.0.0.1:40000", "10.8.8.8, 198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected same client to be limited, got %d", code)
+	}
+	if code := postLogin(r, "127.0.0.1:40000", "198.51.100.2"); code != http.StatusOK {
+		t.Fatalf("expected other client to pass, got %d", code)
+	}
+}
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "none")
+	if proxies := TrustedProxiesFromEnv(); proxies != nil {
+		t.Fatalf("expected no trusted proxies, got %v", proxies)
+	}
+	t.Setenv("TRUSTED_PROXIES", " 10.0.0.0/8, 192.168.1.1 ")
+	if proxies := TrustedProxiesFromEnv(); len(proxies) != 2 || proxies[0] != "10.0.0.0/8" || proxies[1] != "192.168.1.1" {
+		t.Fatalf("unexpected proxies %v", proxies)
+	}
+}
@@ -19,7 +19,26 @@ type User struct {
 	Bio          string         `json:"bio" gorm:"size:500;default:''"`
 	Email        string         `json:"email" gorm:"size:100;index;default:''"`
 	Phone        string         `json:"phone" gorm:"size:20;index;default:''"`
+	EmailVerified bool          `json:"email_verified" gorm:"default:false"`      // 邮箱已验证，验证后才能用于登录和找回密码
+	PhoneVerified bool          `json:"phone_verified" gorm:"default:false"`      // 手机号已验证，验证后才能用于登录
 	RegistrationIP string       `json:"registration_ip" gorm:"size:45;default:''"` // 注册 IP 地址
+	TokenVersion int            `json:"-" gorm:"default:0"`                       // token 版本号，递增后旧 token 全部失效
+	TOTPSecret   string         `json:"-" gorm:"size:64;default:''"`              // 两步验证密钥（未启用时为待确认的密钥）
+	TOTPEnabled  bool           `json:"totp_enabled" gorm:"default:false"`         // 是否开启两步验证
+	TOTPLastStep int64          `json:"-" gorm:"default:0"`                       // 最近一次使用的验证码时间窗口，防止重放
+	DeletionRequestedAt *time.Time `json:"-" gorm:"index"`                         // 申请注销的时间，宽限期后匿名化
+	MessagePermission string    `json:"message_permission" gorm:"size:20;default:'everyone'"` // 谁可以私信我：everyone, followers（互相关注）, nobody
+	ProfileVisibility string    `json:"profile_visibility" gorm:"size:20;default:'public'"`   // 资料可见范围：public, followers（互相关注）, private
+	ShowEmail    bool           `json:"show_email" gorm:"default:false"`          // 是否向他人展示邮箱
+	ShowPhone    bool           `json:"show_phone" gorm:"default:false"`          // 是否向他人展示手机号
+	LocationPrecision int       `json:"location_precision" gorm:"column:location_decimals;default:-1"` // 帖子公开坐标保留的小数位数，-1 表示不模糊
+	Role         string         `json:"role" gorm:"size:20;default:'user'"`       // user, moderator, admin
+	WarningCount int            `json:"-" gorm:"default:0"`                       // 被审核警告的次数
+	SuspendedAt  *time.Time     `json:"-"`                                        // 被封禁的时间
+	SuspendedUntil *time.Time   `json:"-"`                                        // 封禁到期时间，为空表示永久封禁
+	SuspensionReason string     `json:"-" gorm:"size:255;default:''"`             // 封禁原因
+	ShadowBanned bool           `json:"-" gorm:"default:false;index"`             // 隐身封禁：发布的内容只有自己能看到
+	LastSeenAt   *time.Time     `json:"-"`                                        // 最后一个 WebSocket 连接断开的时间
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
@@ -33,10 +52,11 @@ type Post struct {
 	User         User           `json:"-" gorm:"foreignKey:UserID"`
 	Title        string         `json:"title" gorm:"size:255;not null"`
 	Content      string         `json:"content" gorm:"type:text;not null"`
-	Type         string         `json:"type" gorm:"size:20;default:'post'"` // post, food, hotel, shop
+	Type         string         `json:"type" gorm:"size:20;default:'post'"` // 取值见 services.PostCategories
 	LocationName string         `json:"location_name" gorm:"size:255"`
 	Latitude     float64        `json:"latitude" gorm:"not null;index"`
 	Longitude    float64        `json:"longitude" gorm:"not null;index"`
+	Hidden       bool           `json:"-" gorm:"default:false;index"` // 被审核隐藏
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
@@ -51,6 +71,7 @@ type Comment struct {
 	Content     string         `json:"content" gorm:"type:text;not null"`
 	ReplyToID   *uint          `json:"reply_to_id"`
 	ReplyToUser string         `json:"reply_to_user" gorm:"size:50"`
+	Hidden      bool           `json:"-" gorm:"default:false;index"` // 被审核隐藏
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
@@ -109,18 +130,47 @@ type Review struct {
 // Message 私信消息
 type Message struct {
 	ID         uint           `json:"id" gorm:"primaryKey"`
-	SenderID   uint           `json:"sender_id" gorm:"not null;index"`
+	SenderID   uint           `json:"sender_id" gorm:"not null;index;uniqueIndex:idx_sender_client_msg"`
 	Sender     User           `json:"sender,omitempty" gorm:"foreignKey:SenderID"`
-	ReceiverID uint           `json:"receiver_id" gorm:"not null;index"`
+	ReceiverID *uint          `json:"receiver_id" gorm:"index"` // 私信的接收者，群聊消息为空
 	Receiver   User           `json:"receiver,omitempty" gorm:"foreignKey:ReceiverID"`
 	Content    string         `json:"content" gorm:"type:text;not null"`
 	PostID     *uint          `json:"post_id,omitempty"` // 关联的帖子ID（可选）
 	IsRead     bool           `json:"is_read" gorm:"default:false"`
+	Shadowed   bool           `json:"-" gorm:"default:false"` // 发送者处于隐身封禁，接收者看不到
+	ClientMsgID *string       `json:"client_msg_id,omitempty" gorm:"size:64;uniqueIndex:idx_sender_client_msg"` // 客户端生成的消息 ID，重发时去重
+	GroupID    *uint          `json:"group_id,omitempty" gorm:"index"` // 群聊消息所属的群，私信为空
 	CreatedAt  time.Time      `json:"created_at"`
 	UpdatedAt  time.Time      `json:"updated_at"`
 	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// GroupChat 群聊
+type GroupChat struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"size:50;not null"`
+	OwnerID     uint           `json:"owner_id" gorm:"not null;index"`
+	LastMessage string         `json:"last_message" gorm:"type:text"`
+	LastMsgTime time.Time      `json:"last_msg_time"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// GroupMember 群成员，未读数和已读位置按成员保存
+type GroupMember struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	GroupID       uint      `json:"group_id" gorm:"not null;uniqueIndex:idx_group_member"`
+	UserID        uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_group_member;index"`
+	User          User      `json:"-" gorm:"foreignKey:UserID"`
+	Role          string    `json:"role" gorm:"size:20;not null;default:'member'"` // owner, admin, member
+	UnreadCount   int       `json:"unread_count" gorm:"default:0"`
+	LastReadID    uint      `json:"last_read_id" gorm:"default:0"`  // 已读到的最后一条群消息 ID
+	JoinedAfterID uint      `json:"-" gorm:"default:0"`              // 入群时最新的消息 ID，只能看到之后的消息
+	CreatedAt     time.Time `json:"joined_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
 // Conversation 会话（用于快速获取用户的会话列表）
 type Conversation struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
@@ -129,10 +179,83 @@ type Conversation struct {
 	LastMessage string    `json:"last_message" gorm:"type:text"`
 	LastMsgTime time.Time `json:"last_msg_time"`
 	UnreadCount int       `json:"unread_count" gorm:"default:0"`
+	LastReadID  uint      `json:"last_read_id" gorm:"default:0"` // 已读到的对方最后一条消息 ID
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// ChatMessage 与阿尼亚的聊天记录
+type ChatMessage struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	SessionID uint      `json:"session_id" gorm:"index;default:0"` // 0 表示会话功能上线前的旧记录
+	Role      string    `json:"role" gorm:"size:20;not null"` // user/assistant
+	Content   string    `json:"content" gorm:"type:text;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatSession 与阿尼亚的一段对话
+type ChatSession struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	UserID          uint      `json:"user_id" gorm:"not null;index"`           // 匿名访客为 0
+	AnonymousID     string    `json:"-" gorm:"size:64;index;default:''"` // 匿名访客的 cookie 标识
+	Title           string    `json:"title" gorm:"size:100;not null"`
+	Summary         string    `json:"-" gorm:"type:text"`       // 较早对话的摘要，随请求发送给模型
+	SummarizedUntil uint      `json:"-" gorm:"default:0"`       // 已并入摘要的最后一条消息 ID
+	MessageCount    int       `json:"message_count" gorm:"default:0"`
+	LastMessageAt   time.Time `json:"last_message_at" gorm:"index"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Block 用户屏蔽关系（屏蔽后双方无法私信、回复，且互相看不到对方的内容）
+type Block struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	BlockerID uint      `json:"blocker_id" gorm:"not null;uniqueIndex:idx_blocker_blocked"`
+	BlockedID uint      `json:"blocked_id" gorm:"not null;index;uniqueIndex:idx_blocker_blocked"`
+	Blocked   User      `json:"-" gorm:"foreignKey:BlockedID"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Follow 关注关系
+type Follow struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	FollowerID  uint      `json:"follower_id" gorm:"not null;uniqueIndex:idx_follower_following"`
+	FollowingID uint      `json:"following_id" gorm:"not null;index;uniqueIndex:idx_follower_following"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Report 用户举报
+type Report struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	ReporterID  uint       `json:"reporter_id" gorm:"not null;index"`
+	TargetType  string     `json:"target_type" gorm:"size:20;not null;index:idx_report_target"` // post, comment, message, user
+	TargetID    uint       `json:"target_id" gorm:"not null;index:idx_report_target"`
+	TargetUserID uint      `json:"target_user_id" gorm:"index"`                                // 被举报内容的作者
+	Reason      string     `json:"reason" gorm:"size:30;not null"`                             // spam, abuse, porn, illegal, other；filter 为内容过滤自动送审
+	Detail      string     `json:"detail" gorm:"size:500;default:''"`
+	Status      string     `json:"status" gorm:"size:20;not null;default:'pending';index"`     // pending, claimed, resolved, dismissed
+	ModeratorID *uint      `json:"moderator_id"`
+	ClaimedAt   *time.Time `json:"claimed_at"`
+	ResolvedAt  *time.Time `json:"resolved_at"`
+	Resolution  string     `json:"resolution" gorm:"size:20;default:''"` // 处理方式：hide, delete, warn, ban, dismiss
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ModerationAction 审核操作记录（审计日志，只增不改）
+type ModerationAction struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	ModeratorID uint      `json:"moderator_id" gorm:"not null;index"`
+	ReportID    *uint     `json:"report_id" gorm:"index"`
+	Action      string    `json:"action" gorm:"size:20;not null"` // hide, delete, warn, ban, dismiss, suspend, unsuspend, shadow_ban, unshadow_ban
+	TargetType  string    `json:"target_type" gorm:"size:20;not null"`
+	TargetID    uint      `json:"target_id" gorm:"not null"`
+	TargetUserID uint     `json:"target_user_id" gorm:"index"`
+	Note        string    `json:"note" gorm:"size:500;default:''"`
+	CreatedAt   time.Time `json:"created_at" gorm:"index"`
+}
+
 // Visit 访客记录（记录网站访问情况）
 type Visit struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
@@ -144,3 +267,112 @@ type Visit struct {
 	Referer   string    `json:"referer" gorm:"size:500"`
 	CreatedAt time.Time `json:"created_at" gorm:"index"`
 }
+
+// PasswordResetToken 密码重置令牌（只保存哈希，一次性使用）
+type PasswordResetToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex;not null"` // SHA-256 十六进制
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// IdentifierVerification 邮箱/手机号验证码（只保存哈希，一次性使用）
+type IdentifierVerification struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	Channel   string     `json:"channel" gorm:"size:10;not null"`  // email, phone
+	Target    string     `json:"target" gorm:"size:100;not null"`  // 发送验证码时的邮箱或手机号，修改后验证码失效
+	CodeHash  string     `json:"-" gorm:"size:64;not null"`        // SHA-256 十六进制
+	Attempts  int        `json:"-" gorm:"default:0"`               // 输错的次数
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Identity 第三方登录身份（OIDC），把提供方的 subject 关联到本站用户
+type Identity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	Provider  string    `json:"provider" gorm:"size:50;not null;uniqueIndex:idx_provider_subject"`
+	Subject   string    `json:"-" gorm:"size:255;not null;uniqueIndex:idx_provider_subject"`
+	Email     string    `json:"email" gorm:"size:100;default:''"` // 提供方返回的邮箱（仅展示）
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OAuthState 第三方授权请求的临时状态（一次性使用）
+type OAuthState struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	State        string    `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Provider     string    `json:"provider" gorm:"size:50;not null"`
+	CodeVerifier string    `json:"-" gorm:"size:128;not null"` // PKCE code_verifier
+	Nonce        string    `json:"-" gorm:"size:64;not null"`
+	LinkUserID   uint      `json:"link_user_id" gorm:"default:0"` // 不为 0 表示绑定到该用户
+	BindingHash  string    `json:"-" gorm:"size:64;not null;default:''"` // 发起授权的浏览器 cookie 的哈希，回调时必须一致
+	ExpiresAt    time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// RecoveryCode 两步验证恢复码（只保存哈希，一次性使用）
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"size:64;not null;index"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginChallenge 两步验证登录挑战（一次性使用，输错次数过多后作废）
+type LoginChallenge struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	TokenID   string     `json:"-" gorm:"size:64;not null;uniqueIndex"` // 挑战 token 中的 jti
+	Attempts  int        `json:"attempts" gorm:"default:0"`            // 已输错次数
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LLMUsage 大模型调用用量记录
+type LLMUsage struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	UserID           uint      `json:"user_id" gorm:"index;default:0"` // 未登录为 0
+	Purpose          string    `json:"purpose" gorm:"size:30;not null;index"` // analyze, chat ...
+	Model            string    `json:"model" gorm:"size:50;default:''"`
+	PromptTokens     int       `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int       `json:"completion_tokens" gorm:"default:0"`
+	TotalTokens      int       `json:"total_tokens" gorm:"default:0"`
+	LatencyMs        int64     `json:"latency_ms" gorm:"default:0"`
+	Success          bool      `json:"success" gorm:"default:true"`
+	Error            string    `json:"error" gorm:"size:255;default:''"`
+	CreatedAt        time.Time `json:"created_at" gorm:"index"`
+}
+
+// Embedding 帖子和打卡点文本的向量，用于语义推荐
+type Embedding struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	OwnerType   string    `json:"owner_type" gorm:"size:20;not null;uniqueIndex:idx_embedding_owner"` // post, spot
+	OwnerID     uint      `json:"owner_id" gorm:"not null;uniqueIndex:idx_embedding_owner"`
+	Model       string    `json:"model" gorm:"size:100;not null;index"` // 向量模型，换模型后需要重新计算
+	Dimensions  int       `json:"dimensions" gorm:"not null"`
+	Vector      []byte    `json:"-" gorm:"type:blob;not null"` // float32 小端序
+	ContentHash string    `json:"-" gorm:"size:64;not null"`   // 文本未变化时不重新计算
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// LocationAnalysis AI 地点分析缓存，按规范化后的地点名称和坐标去重
+type LocationAnalysis struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	CacheKey     string    `json:"-" gorm:"size:64;not null;uniqueIndex"` // 规范化键的 SHA-256
+	LocationName string    `json:"location_name" gorm:"type:text;not null"`
+	Latitude     float64   `json:"latitude" gorm:"default:0"` // 取整后的坐标，未提供时为 0
+	Longitude    float64   `json:"longitude" gorm:"default:0"`
+	Analysis     string    `json:"analysis" gorm:"type:text;not null"`
+	Model        string    `json:"model" gorm:"size:50;default:''"`
+	ExpiresAt    time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
@@ -13,10 +13,44 @@ func SetupRoutes(r *gin.Engine) {
 	{
 		// 认证控制器
 		authController := controllers.NewAuthController()
+		oauthController := controllers.NewOAuthController()
+		twoFactorController := controllers.NewTwoFactorController()
+		accountController := controllers.NewAccountController()
+		blockController := controllers.NewBlockController()
+		followController := controllers.NewFollowController()
+		privacyController := controllers.NewPrivacyController()
+		moderationController := controllers.NewModerationController()
+		chatController := controllers.NewChatController()
+		recommendController := controllers.NewRecommendController()
+		postDraftController := controllers.NewPostDraftController()
+		groupController := controllers.NewGroupController()
+		verificationController := controllers.NewVerificationController()
+
+		// 限流策略
+		postLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("create_post"))
+		commentLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("create_comment"))
+		messageLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("send_message"))
+		analyzeLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("ai_analyze"))
+		chatLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("chat"))
+		recommendLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("recommend"))
+		draftLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("post_draft"))
+		verifyLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("verify_identifier"))
+		twoFactorLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("login_2fa"))
+		loginLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("login"))
+		forgotLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("password_forgot"))
+		resetLimit := middleware.RateLimit(middleware.NamedRateLimitPolicy("password_reset"))
 
 		// 公开路由
 		api.POST("/register", authController.Register)
-		api.POST("/login", authController.Login)
+		api.POST("/login", loginLimit, authController.Login)
+		api.POST("/login/2fa", twoFactorLimit, twoFactorController.VerifyLogin)
+		api.POST("/password/forgot", forgotLimit, authController.ForgotPassword)
+		api.POST("/password/reset", resetLimit, authController.ResetPassword)
+
+		// 第三方登录（OIDC）
+		api.GET("/oauth/providers", oauthController.ListProviders)
+		api.GET("/oauth/:provider/login", oauthController.Login)
+		api.GET("/oauth/:provider/callback", oauthController.Callback)
 
 		// 需要认证的路由
 		auth := api.Group("")
@@ -25,20 +59,39 @@ func SetupRoutes(r *gin.Engine) {
 			// 用户相关
 			auth.GET("/me", authController.GetCurrentUser)
 			auth.PUT("/me", authController.UpdateProfile)
+			auth.POST("/me/verify/:channel/send", verifyLimit, verificationController.SendCode)
+			auth.POST("/me/verify/:channel/confirm", verifyLimit, verificationController.Confirm)
 			auth.POST("/change-password", authController.ChangePassword)
+			auth.GET("/me/export", accountController.ExportData)
+			auth.POST("/me/delete", accountController.DeleteAccount)
+			auth.GET("/me/identities", oauthController.ListIdentities)
+			auth.POST("/me/identities/:provider", oauthController.LinkIdentity)
+			auth.DELETE("/me/identities/:provider", oauthController.UnlinkIdentity)
+			auth.POST("/me/2fa/enroll", twoFactorController.Enroll)
+			auth.POST("/me/2fa/enable", twoFactorController.Enable)
+			auth.POST("/me/2fa/disable", twoFactorController.Disable)
+			auth.POST("/me/2fa/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+			auth.GET("/me/blocks", blockController.ListBlocked)
+			auth.GET("/me/privacy", privacyController.GetSettings)
+			auth.PUT("/me/privacy", privacyController.UpdateSettings)
 			auth.GET("/users/:id", authController.GetUserProfile)
+			auth.POST("/users/:id/block", blockController.BlockUser)
+			auth.DELETE("/users/:id/block", blockController.UnblockUser)
+			auth.POST("/users/:id/follow", followController.Follow)
+			auth.DELETE("/users/:id/follow", followController.Unfollow)
 		auth.GET("/users/:id/posts", controllers.GetUserPosts)
 		auth.GET("/users/stats", controllers.GetUserStats)
 
 			// 帖子路由
-			auth.POST("/posts", controllers.CreatePost)
+			auth.POST("/posts", postLimit, controllers.CreatePost)
+			auth.POST("/posts/suggest", draftLimit, postDraftController.Suggest)
 			auth.DELETE("/posts/:id", controllers.DeletePost)
 			auth.POST("/posts/:id/like", controllers.PostLike)
 			auth.GET("/likes/check", controllers.CheckPostLikes)
 			auth.GET("/likes/my", controllers.GetMyLikes)
 
 			// 评论路由
-			auth.POST("/posts/:id/comments", controllers.CreateComment)
+			auth.POST("/posts/:id/comments", commentLimit, controllers.CreateComment)
 			auth.DELETE("/comments/:id", controllers.DeleteComment)
 			auth.POST("/comments/:id/like", controllers.CommentLike)
 			auth.GET("/comments/likes/check", controllers.CheckCommentLikes)
@@ -48,17 +101,49 @@ func SetupRoutes(r *gin.Engine) {
 			auth.GET("/conversations/with", controllers.GetOrCreateConversation)
 			auth.POST("/conversations/:id/read", controllers.MarkConversationAsRead)
 			auth.GET("/conversations/:id/messages", controllers.GetMessages)
-			auth.POST("/messages", controllers.SendMessage)
+			auth.POST("/messages", messageLimit, controllers.SendMessage)
 			auth.GET("/messages/unread", controllers.GetUnreadCount)
+
+			// 群聊路由
+			auth.POST("/groups", groupController.CreateGroup)
+			auth.GET("/groups", groupController.ListGroups)
+			auth.GET("/groups/:id", groupController.GetGroup)
+			auth.POST("/groups/:id/members", groupController.InviteMembers)
+			auth.DELETE("/groups/:id/members/:userId", groupController.RemoveMember)
+			auth.PUT("/groups/:id/members/:userId/role", groupController.UpdateMemberRole)
+			auth.POST("/groups/:id/leave", groupController.LeaveGroup)
+			auth.GET("/groups/:id/messages", groupController.GetGroupMessages)
+			auth.POST("/groups/:id/messages", messageLimit, groupController.SendGroupMessage)
+			auth.POST("/groups/:id/read", groupController.MarkGroupRead)
+
+			// 举报
+			auth.POST("/reports", moderationController.CreateReport)
+
+			// 审核（需要审核员权限）
+			moderation := auth.Group("/moderation")
+			moderation.Use(middleware.ModeratorMiddleware())
+			{
+				moderation.GET("/reports", moderationController.ListReports)
+				moderation.POST("/reports/:id/claim", moderationController.ClaimReport)
+				moderation.POST("/reports/:id/resolve", moderationController.ResolveReport)
+				moderation.GET("/actions", moderationController.ListActions)
+				moderation.POST("/users/:id/suspend", moderationController.SuspendUser)
+				moderation.POST("/users/:id/unsuspend", moderationController.LiftSuspension)
+				moderation.POST("/users/:id/shadow-ban", moderationController.SetShadowBan)
+			}
 		}
 
 		// 公开路由
-		api.GET("/posts", controllers.GetPosts)
-		api.GET("/posts/:id", controllers.GetPost)
-		api.GET("/posts/:id/comments", controllers.GetComments)
-		api.GET("/posts/:id/best-comment", controllers.GetBestComment)
-		api.GET("/posts/comments/count", controllers.GetCommentCounts)
-		api.GET("/users/search", controllers.SearchUsers)
+		api.GET("/posts", middleware.OptionalAuthMiddleware(), controllers.GetPosts)
+		api.GET("/posts/categories", postDraftController.GetPostCategories)
+		api.GET("/posts/:id", middleware.OptionalAuthMiddleware(), controllers.GetPost)
+		api.GET("/posts/:id/comments", middleware.OptionalAuthMiddleware(), controllers.GetComments)
+		api.GET("/posts/:id/best-comment", middleware.OptionalAuthMiddleware(), controllers.GetBestComment)
+		api.GET("/posts/comments/count", middleware.OptionalAuthMiddleware(), controllers.GetCommentCounts)
+		api.GET("/users/search", middleware.OptionalAuthMiddleware(), controllers.SearchUsers)
+
+		// 推荐（登录后可按点赞过的帖子推荐）
+		api.GET("/recommend", middleware.OptionalAuthMiddleware(), recommendLimit, recommendController.Recommend)
 
 		// 地理服务
 		api.GET("/pois", controllers.GetPOIs)
@@ -70,12 +155,19 @@ func SetupRoutes(r *gin.Engine) {
 		// 统计 API（需要认证）
 		auth.GET("/stats/visits", controllers.GetVisitStats)
 		auth.GET("/stats/realtime", controllers.GetRealTimeVisitors)
+		auth.GET("/stats/ai-usage", middleware.ModeratorMiddleware(), controllers.GetAIUsageStats)
 
 		// AI 分析 API（公开）
-		api.POST("/ai/analyze", controllers.AnalyzeLocation)
+		api.POST("/ai/analyze", middleware.OptionalAuthMiddleware(), analyzeLimit, controllers.AnalyzeLocation)
+		api.POST("/ai/analyze/stream", middleware.OptionalAuthMiddleware(), analyzeLimit, controllers.AnalyzeLocationStream)
 
 		// 聊天 API
-		api.POST("/chat", controllers.ChatWithAnya)
-		auth.GET("/chat/history/:user_id", controllers.GetChatHistory)
+		api.POST("/chat", middleware.OptionalAuthMiddleware(), chatLimit, chatController.ChatWithAnya)
+		api.POST("/chat/stream", middleware.OptionalAuthMiddleware(), chatLimit, chatController.ChatWithAnyaStream)
+		auth.GET("/chat/history/:user_id", chatController.GetChatHistory)
+		api.GET("/chat/sessions", middleware.OptionalAuthMiddleware(), chatController.ListSessions)
+		api.GET("/chat/sessions/:id/messages", middleware.OptionalAuthMiddleware(), chatController.GetSessionMessages)
+		api.PUT("/chat/sessions/:id", middleware.OptionalAuthMiddleware(), chatController.RenameSession)
+		api.DELETE("/chat/sessions/:id", middleware.OptionalAuthMiddleware(), chatController.DeleteSession)
 	}
 }
@@ -0,0 +1,427 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"tapspot/dto"
+	"tapspot/models"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// deletedNickname 注销后展示的昵称
+const deletedNickname = "已注销用户"
+
+// ErrDeletionCodeSent 注销确认码已发送到邮箱，需要再次提交确认码
+var ErrDeletionCodeSent = errors.New("确认码已发送到你的邮箱，请提交确认码完成注销")
+
+// AccountService 账号数据导出与注销服务
+type AccountService struct {
+	gracePeriod time.Duration
+	mailer      Mailer
+}
+
+// NewAccountService 创建账号服务实例
+// 注销宽限期由 ACCOUNT_DELETION_GRACE_DAYS 配置（默认 14 天）
+func NewAccountService(mailer Mailer) *AccountService {
+	days := 14
+	if v, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS")); err == nil && v >= 0 {
+		days = v
+	}
+	return &AccountService{
+		gracePeriod: time.Duration(days) * 24 * time.Hour,
+		mailer:      mailer,
+	}
+}
+
+// accountExport 个人数据导出内容
+type accountExport struct {
+	ExportedAt    time.Time            `json:"exported_at"`
+	Profile       exportProfile        `json:"profile"`
+	Identities    []dto.IdentityInfo   `json:"identities"`
+	Posts         []models.Post        `json:"posts"`
+	Comments      []models.Comment     `json:"comments"`
+	PostLikes     []models.Like        `json:"post_likes"`
+	CommentLikes  []models.CommentLike `json:"comment_likes"`
+	Messages      []exportMessage      `json:"messages"`
+	Groups        []exportGroup        `json:"groups"`
+	GroupMessages []exportGroupMessage `json:"group_messages"`
+	AnyaChat      []models.ChatMessage `json:"anya_chat"`
+}
+
+// exportProfile 导出的个人资料（包含仅本人可见的字段）
+type exportProfile struct {
+	ID             uint      `json:"id"`
+	Username       string    `json:"username"`
+	Nickname       string    `json:"nickname"`
+	Avatar         string    `json:"avatar"`
+	Gender         string    `json:"gender"`
+	Bio            string    `json:"bio"`
+	Email          string    `json:"email"`
+	Phone          string    `json:"phone"`
+	RegistrationIP string    `json:"registration_ip"`
+	TOTPEnabled    bool      `json:"totp_enabled"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// exportMessage 导出的私信（不包含对方的个人资料）
+type exportMessage struct {
+	ID         uint      `json:"id"`
+	SenderID   uint      `json:"sender_id"`
+	ReceiverID uint      `json:"receiver_id"`
+	Content    string    `json:"content"`
+	PostID     *uint     `json:"post_id,omitempty"`
+	IsRead     bool      `json:"is_read"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// exportGroup 导出的群聊成员身份
+type exportGroup struct {
+	ID       uint      `json:"id"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// exportGroupMessage 导出的群消息：本人发送的，以及所在群中入群后本人能看到的
+type exportGroupMessage struct {
+	ID        uint      `json:"id"`
+	GroupID   uint      `json:"group_id"`
+	SenderID  uint      `json:"sender_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Export 汇总用户的个人数据
+func (s *AccountService) Export(userID uint) (*accountExport, error) {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+
+	export := &accountExport{
+		ExportedAt: time.Now(),
+		Profile: exportProfile{
+			ID:             user.ID,
+			Username:       user.Username,
+			Nickname:       user.Nickname,
+			Avatar:         user.Avatar,
+			Gender:         user.Gender,
+			Bio:            user.Bio,
+			Email:          user.Email,
+			Phone:          user.Phone,
+			RegistrationIP: user.RegistrationIP,
+			TOTPEnabled:    user.TOTPEnabled,
+			CreatedAt:      user.CreatedAt,
+			UpdatedAt:      user.UpdatedAt,
+		},
+		Identities:    []dto.IdentityInfo{},
+		Posts:         []models.Post{},
+		Comments:      []models.Comment{},
+		PostLikes:     []models.Like{},
+		CommentLikes:  []models.CommentLike{},
+		Messages:      []exportMessage{},
+		Groups:        []exportGroup{},
+		GroupMessages: []exportGroupMessage{},
+		AnyaChat:      []models.ChatMessage{},
+	}
+
+	var identities []models.Identity
+	models.DB.Where("user_id = ?", userID).Find(&identities)
+	for i := range identities {
+		export.Identities = append(export.Identities, toIdentityInfo(&identities[i]))
+	}
+
+	models.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&export.Posts)
+	models.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&export.Comments)
+	models.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&export.PostLikes)
+	models.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&export.CommentLikes)
+	models.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&export.AnyaChat)
+
+	// 隐身封禁用户发来的私信接收者从未看到，不导出
+	var messages []models.Message
+	models.DB.Where("group_id IS NULL AND (sender_id = ? OR receiver_id = ?)", userID, userID).
+		Where("(shadowed = ? OR sender_id = ?)", false, userID).
+		Order("created_at ASC").Find(&messages)
+	for _, msg := range messages {
+		export.Messages = append(export.Messages, exportMessage{
+			ID:         msg.ID,
+			SenderID:   msg.SenderID,
+			ReceiverID: *msg.ReceiverID,
+			Content:    msg.Content,
+			PostID:     msg.PostID,
+			IsRead:     msg.IsRead,
+			CreatedAt:  msg.CreatedAt,
+		})
+	}
+
+	exportGroups(userID, export)
+
+	return export, nil
+}
+
+// exportGroups 汇总群聊成员身份和群消息
+func exportGroups(userID uint, export *accountExport) {
+	var members []models.GroupMember
+	models.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&members)
+
+	query := models.DB.Where("group_id IS NOT NULL AND sender_id = ?", userID)
+	for _, member := range members {
+		var group models.GroupChat
+		if err := models.DB.First(&group, member.GroupID).Error; err != nil {
+			continue
+		}
+		export.Groups = append(export.Groups, exportGroup{
+			ID:       group.ID,
+			Name:     group.Name,
+			Role:     member.Role,
+			JoinedAt: member.CreatedAt,
+		})
+		query = query.Or("group_id = ? AND id > ? AND shadowed = ?", member.GroupID, member.JoinedAfterID, false)
+	}
+
+	var messages []models.Message
+	query.Order("id ASC").Find(&messages)
+	for _, msg := range messages {
+		export.GroupMessages = append(export.GroupMessages, exportGroupMessage{
+			ID:        msg.ID,
+			GroupID:   *msg.GroupID,
+			SenderID:  msg.SenderID,
+			Content:   msg.Content,
+			CreatedAt: msg.CreatedAt,
+		})
+	}
+}
+
+// WriteExportZip 把导出数据按类别写成 ZIP 包中的多个 JSON 文件
+func (s *AccountService) WriteExportZip(w io.Writer, export *accountExport) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profileFileContent(export.Profile, export.Identities, export.ExportedAt)},
+		{"posts.json", export.Posts},
+		{"comments.json", export.Comments},
+		{"likes.json", map[string]interface{}{"posts": export.PostLikes, "comments": export.CommentLikes}},
+		{"messages.json", export.Messages},
+		{"groups.json", map[string]interface{}{"groups": export.Groups, "messages": export.GroupMessages}},
+		{"anya_chat.json", export.AnyaChat},
+	}
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// RequestDeletion 申请注销账号，返回计划执行匿名化的时间
+// 申请后所有设备退出登录，宽限期内重新登录即撤销申请
+func (s *AccountService) RequestDeletion(userID uint, req *dto.DeleteAccountRequest) (time.Time, error) {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return time.Time{}, errors.New("用户不存在")
+	}
+
+	// 重新验证身份：有密码校验密码，无密码但开启两步验证时校验验证码，
+	// 两者都没有的第三方登录用户通过发送到已验证邮箱的确认码确认
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+			return time.Time{}, errors.New("密码错误")
+		}
+	} else if user.TOTPEnabled {
+		if err := verifyTwoFactorCode(&user, req.Code); err != nil {
+			return time.Time{}, err
+		}
+	} else if err := s.confirmDeletionByEmail(&user, req.Code); err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	if err := models.DB.Model(&user).Updates(map[string]interface{}{
+		"deletion_requested_at": now,
+		"token_version":         gorm.Expr("token_version + 1"),
+	}).Error; err != nil {
+		return time.Time{}, errors.New("申请注销失败")
+	}
+
+	return now.Add(s.gracePeriod), nil
+}
+
+// confirmDeletionByEmail 没有提交确认码时向已验证邮箱发送确认码，否则校验确认码
+func (s *AccountService) confirmDeletionByEmail(user *models.User, code string) error {
+	if user.Email == "" || !user.EmailVerified {
+		return errors.New("请先验证邮箱或开启两步验证后再注销账号")
+	}
+
+	if code == "" {
+		if !mailerCanDeliver(s.mailer) {
+			return errors.New("邮件服务暂不可用，请开启两步验证后再注销账号")
+		}
+		issued, err := issueVerificationCode(user.ID, verifyChannelDeletion, user.Email)
+		if err != nil {
+			return err
+		}
+		text := fmt.Sprintf("你正在申请注销 TapSpot 账号，确认码是 %s，%d 分钟内有效。如果不是你本人操作，请忽略并检查第三方账号的安全。",
+			issued, int(verificationCodeTTL.Minutes()))
+		if err := s.mailer.Send(user.Email, "TapSpot 注销账号确认", text); err != nil {
+			log.Printf("发送注销确认码失败: %v", err)
+			return errors.New("发送确认码失败，请稍后重试")
+		}
+		return ErrDeletionCodeSent
+	}
+
+	return consumeVerificationCode(models.DB, user.ID, verifyChannelDeletion, user.Email, code)
+}
+
+// StartDeletionWorker 启动后台任务，定期匿名化宽限期已过的账号
+func (s *AccountService) StartDeletionWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.purgeExpiredAccounts()
+			<-ticker.C
+		}
+	}()
+}
+
+// purgeExpiredAccounts 匿名化所有宽限期已过的账号
+func (s *AccountService) purgeExpiredAccounts() {
+	var users []models.User
+	models.DB.Select("id").
+		Where("deletion_requested_at IS NOT NULL AND deletion_requested_at <= ?", time.Now().Add(-s.gracePeriod)).
+		Find(&users)
+
+	for _, user := range users {
+		if err := anonymizeUser(user.ID); err != nil {
+			log.Printf("匿名化用户 %d 失败: %v", user.ID, err)
+			continue
+		}
+		log.Printf("🗑️ 用户 %d 已完成注销匿名化", user.ID)
+	}
+}
+
+// anonymizeUser 清除用户个人信息并匿名化其内容
+// 帖子、评论和私信保留（属于公共讨论和对方的聊天记录），但不再关联到可识别的个人
+func anonymizeUser(userID uint) error {
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		// 其他用户回复该用户评论时记录的昵称
+		if user.Nickname != "" {
+			if err := tx.Model(&models.Comment{}).
+				Where("reply_to_user = ? AND reply_to_id IN (?)", user.Nickname,
+					tx.Model(&models.Comment{}).Unscoped().Select("id").Where("user_id = ?", userID)).
+				Update("reply_to_user", deletedNickname).Error; err != nil {
+				return err
+			}
+		}
+
+		// 删除个人行为数据和凭据
+		for _, model := range []interface{}{
+			&models.Like{},
+			&models.CommentLike{},
+			&models.ChatMessage{},
+			&models.ChatSession{},
+			&models.Identity{},
+			&models.RecoveryCode{},
+			&models.PasswordResetToken{},
+			&models.IdentifierVerification{},
+			&models.LoginChallenge{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		// 双向的关系：会话列表、关注和屏蔽
+		if err := tx.Where("user_id = ? OR peer_id = ?", userID, userID).Delete(&models.Conversation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("follower_id = ? OR following_id = ?", userID, userID).Delete(&models.Follow{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("blocker_id = ? OR blocked_id = ?", userID, userID).Delete(&models.Block{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("link_user_id = ?", userID).Delete(&models.OAuthState{}).Error; err != nil {
+			return err
+		}
+		// 退出所有群聊，群主身份按主动退出的规则转让
+		var members []models.GroupMember
+		tx.Where("user_id = ?", userID).Find(&members)
+		for i := range members {
+			var group models.GroupChat
+			if err := tx.First(&group, members[i].GroupID).Error; err != nil {
+				if err := tx.Delete(&members[i]).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := leaveGroup(tx, &group, &members[i]); err != nil {
+				return err
+			}
+		}
+		// 访客记录只保留路径和时间用于统计
+		if err := tx.Model(&models.Visit{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"user_id":    nil,
+			"ip_address": "",
+			"user_agent": "",
+			"referer":    "",
+		}).Error; err != nil {
+			return err
+		}
+
+		// 清空个人资料，用户名改为占位以释放原用户名
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"username":              fmt.Sprintf("deleted_%d", user.ID),
+			"password":              "",
+			"nickname":              deletedNickname,
+			"avatar":                "",
+			"bio":                   "",
+			"email":                 "",
+			"phone":                 "",
+			"registration_ip":       "",
+			"gender":                "other",
+			"totp_secret":           "",
+			"totp_enabled":          false,
+			"deletion_requested_at": nil,
+			"token_version":         gorm.Expr("token_version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&user).Error
+	})
+}
+
+// profileFileContent 组装 profile.json 的内容
+func profileFileContent(profile exportProfile, identities []dto.IdentityInfo, exportedAt time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"exported_at": exportedAt,
+		"user":        profile,
+		"identities":  identities,
+	}
+}
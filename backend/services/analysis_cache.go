@@ -0,0 +1,253 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"tapspot/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// analysisCoordinatePrecision 缓存键中坐标保留的小数位数（约 11 公里），用于区分不同城市的同名地点
+const analysisCoordinatePrecision = 1
+
+// AnalysisKey AI 地点分析的缓存键：规范化的地点名称（或文字描述）和取整后的坐标
+type AnalysisKey struct {
+	Name      string  // 原始名称，用于生成提示词
+	Latitude  float64 // 取整后的坐标，未提供时为 0
+	Longitude float64
+
+	normalized string
+}
+
+// NewAnalysisKey 规范化地点名称和坐标
+func NewAnalysisKey(name string, latitude, longitude float64) AnalysisKey {
+	key := AnalysisKey{Name: strings.TrimSpace(name), normalized: normalizeLocationName(name)}
+	if latitude != 0 || longitude != 0 {
+		key.Latitude = roundCoordinate(latitude, analysisCoordinatePrecision)
+		key.Longitude = roundCoordinate(longitude, analysisCoordinatePrecision)
+	}
+	return key
+}
+
+// HasLocation 是否带有坐标
+func (k AnalysisKey) HasLocation() bool {
+	return k.Latitude != 0 || k.Longitude != 0
+}
+
+// hash 数据库和内存中使用的键
+func (k AnalysisKey) hash() string {
+	return contentHash(fmt.Sprintf("%s|%.1f,%.1f", k.normalized, k.Latitude, k.Longitude))
+}
+
+// normalizeLocationName 全角转半角、转小写、合并空白
+func normalizeLocationName(name string) string {
+	folded := strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '！' && r <= '～':
+			return unicode.ToLower(r - 0xFEE0)
+		}
+		return unicode.ToLower(r)
+	}, name)
+	return strings.Join(strings.Fields(folded), " ")
+}
+
+// AnalysisComputeFunc 生成分析，model 为空表示结果不是模型生成的（例如模拟文案），不写入缓存
+type AnalysisComputeFunc func(ctx context.Context) (analysis string, model string, err error)
+
+// AnalysisCache AI 地点分析缓存：内存 LRU 在前，数据库持久化，同一地点的并发请求只调用一次模型
+type AnalysisCache struct {
+	ttl      time.Duration
+	capacity int
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List // 最近使用的在前
+	inflight map[string]*analysisCall
+}
+
+// analysisEntry 内存中的缓存项
+type analysisEntry struct {
+	hash      string
+	analysis  string
+	expiresAt time.Time
+}
+
+// analysisCall 正在进行的分析，等待者在 done 关闭后读取结果
+type analysisCall struct {
+	done      chan struct{}
+	analysis  string
+	err       error
+	abandoned bool // 发起请求的客户端已断开，等待者需要自己重新生成
+}
+
+// DefaultAnalysisCache 全局分析缓存，启动时按环境变量替换
+var DefaultAnalysisCache = NewAnalysisCache()
+
+// NewAnalysisCache 创建分析缓存
+// ANALYSIS_CACHE_TTL_HOURS 为有效期（默认 168 小时），ANALYSIS_CACHE_SIZE 为内存中保留的条数（默认 1000）
+func NewAnalysisCache() *AnalysisCache {
+	a := &AnalysisCache{
+		ttl:      7 * 24 * time.Hour,
+		capacity: 1000,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*analysisCall),
+	}
+	if v, err := strconv.Atoi(os.Getenv("ANALYSIS_CACHE_TTL_HOURS")); err == nil && v > 0 {
+		a.ttl = time.Duration(v) * time.Hour
+	}
+	if v, err := strconv.Atoi(os.Getenv("ANALYSIS_CACHE_SIZE")); err == nil && v > 0 {
+		a.capacity = v
+	}
+	return a
+}
+
+// Get 查询未过期的分析：先查内存，再查数据库
+func (a *AnalysisCache) Get(key AnalysisKey) (string, bool) {
+	hash := key.hash()
+
+	a.mu.Lock()
+	analysis, ok := a.getMemory(hash)
+	a.mu.Unlock()
+	if ok {
+		return analysis, true
+	}
+
+	var row models.LocationAnalysis
+	if err := models.DB.Where("cache_key = ? AND expires_at > ?", hash, time.Now()).First(&row).Error; err != nil {
+		return "", false
+	}
+	a.mu.Lock()
+	a.putMemory(hash, row.Analysis, row.ExpiresAt)
+	a.mu.Unlock()
+	return row.Analysis, true
+}
+
+// GetOrCompute 返回缓存的分析，没有时调用 compute 生成并保存
+// 同一地点已有请求在生成时等待其结果；cached 表示结果不是本次调用生成的
+func (a *AnalysisCache) GetOrCompute(ctx context.Context, key AnalysisKey, compute AnalysisComputeFunc) (analysis string, cached bool, err error) {
+	hash := key.hash()
+	for {
+		if analysis, ok := a.Get(key); ok {
+			return analysis, true, nil
+		}
+
+		a.mu.Lock()
+		// 上一个请求可能刚刚写入缓存
+		if analysis, ok := a.getMemory(hash); ok {
+			a.mu.Unlock()
+			return analysis, true, nil
+		}
+		if call, ok := a.inflight[hash]; ok {
+			a.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return "", false, ctx.Err()
+			}
+			if call.abandoned {
+				continue
+			}
+			return call.analysis, true, call.err
+		}
+		call := &analysisCall{done: make(chan struct{})}
+		a.inflight[hash] = call
+		a.mu.Unlock()
+
+		analysis, model, err := compute(ctx)
+		if err == nil && model != "" {
+			a.store(key, hash, analysis, model)
+		}
+
+		call.analysis, call.err = analysis, err
+		call.abandoned = err != nil && ctx.Err() != nil
+		a.mu.Lock()
+		delete(a.inflight, hash)
+		a.mu.Unlock()
+		close(call.done)
+		return analysis, false, err
+	}
+}
+
+// StartCleanup 定期删除数据库中过期的分析
+func (a *AnalysisCache) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := models.DB.Where("expires_at <= ?", time.Now()).Delete(&models.LocationAnalysis{}).Error; err != nil {
+				log.Printf("⚠️ 清理过期的地点分析失败: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// store 写入内存和数据库
+func (a *AnalysisCache) store(key AnalysisKey, hash, analysis, model string) {
+	expiresAt := time.Now().Add(a.ttl)
+	a.mu.Lock()
+	a.putMemory(hash, analysis, expiresAt)
+	a.mu.Unlock()
+
+	row := models.LocationAnalysis{
+		CacheKey:     hash,
+		LocationName: key.Name,
+		Latitude:     key.Latitude,
+		Longitude:    key.Longitude,
+		Analysis:     analysis,
+		Model:        TruncateRunes(model, 50),
+		ExpiresAt:    expiresAt,
+	}
+	err := models.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cache_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"analysis", "model", "expires_at", "updated_at"}),
+	}).Create(&row).Error
+	if err != nil {
+		log.Printf("⚠️ 保存地点分析失败: %v", err)
+	}
+}
+
+// getMemory 查询内存缓存（调用方持有锁）
+func (a *AnalysisCache) getMemory(hash string) (string, bool) {
+	elem, ok := a.entries[hash]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*analysisEntry)
+	if time.Now().After(entry.expiresAt) {
+		a.order.Remove(elem)
+		delete(a.entries, hash)
+		return "", false
+	}
+	a.order.MoveToFront(elem)
+	return entry.analysis, true
+}
+
+// putMemory 写入内存缓存，超出容量时淘汰最久未使用的（调用方持有锁）
+func (a *AnalysisCache) putMemory(hash, analysis string, expiresAt time.Time) {
+	if elem, ok := a.entries[hash]; ok {
+		entry := elem.Value.(*analysisEntry)
+		entry.analysis, entry.expiresAt = analysis, expiresAt
+		a.order.MoveToFront(elem)
+		return
+	}
+	a.entries[hash] = a.order.PushFront(&analysisEntry{hash: hash, analysis: analysis, expiresAt: expiresAt})
+	for a.order.Len() > a.capacity {
+		oldest := a.order.Back()
+		a.order.Remove(oldest)
+		delete(a.entries, oldest.Value.(*analysisEntry).hash)
+	}
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// anonymousIDBytes 匿名标识的随机字节数
+const anonymousIDBytes = 16
+
+// NewAnonymousID 生成匿名访客标识，返回标识和带签名的 cookie 值
+func NewAnonymousID() (string, string, error) {
+	buf := make([]byte, anonymousIDBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	id := hex.EncodeToString(buf)
+	return id, id + "." + signAnonymousID(id), nil
+}
+
+// VerifyAnonymousID 校验 cookie 值的签名，返回其中的匿名标识
+func VerifyAnonymousID(value string) (string, bool) {
+	id, signature, found := strings.Cut(value, ".")
+	if !found || len(id) != anonymousIDBytes*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signature), []byte(signAnonymousID(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// signAnonymousID 用 JWT 密钥对匿名标识签名（加前缀与其它用途区分）
+func signAnonymousID(id string) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte("anonymous-chat:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,481 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"tapspot/dto"
+	"tapspot/llm"
+	"tapspot/models"
+)
+
+// 工具参数的默认值和上限
+const (
+	toolDefaultRadiusKm = 5.0
+	toolMaxRadiusKm     = 50.0
+	toolDefaultLimit    = 5
+	toolMaxLimit        = 10
+	toolSnippetRunes    = 80
+)
+
+// anyaToolDefinitions 提供给模型的工具定义
+var anyaToolDefinitions = []llm.Tool{
+	llm.NewTool("search_posts",
+		"搜索 TapSpot 用户发布的打卡帖子。可以按关键词、帖子类型搜索，给出 radius_km 时只返回该范围内的帖子。",
+		`{"type":"object","properties":{
+			"keyword":{"type":"string","description":"关键词，匹配标题、内容和地点名称"},
+			"type":{"type":"string","enum":["post","food","hotel","shop","scenic","transport","entertainment","work"],"description":"帖子类型：post 日常、food 美食、hotel 住宿、shop 购物、scenic 景点、transport 交通、entertainment 娱乐、work 工作"},
+			"latitude":{"type":"number","description":"搜索中心纬度，不填时使用用户当前位置"},
+			"longitude":{"type":"number","description":"搜索中心经度，不填时使用用户当前位置"},
+			"radius_km":{"type":"number","description":"搜索半径（公里），不填时不按距离筛选"},
+			"limit":{"type":"integer","description":"返回数量，默认 5，最多 10"}
+		}}`),
+	llm.NewTool("nearby_spots",
+		"查找某个位置附近的打卡点，按距离从近到远排序。",
+		`{"type":"object","properties":{
+			"latitude":{"type":"number","description":"中心纬度，不填时使用用户当前位置"},
+			"longitude":{"type":"number","description":"中心经度，不填时使用用户当前位置"},
+			"radius_km":{"type":"number","description":"搜索半径（公里），默认 5，最大 50"},
+			"category":{"type":"string","description":"打卡点分类"},
+			"limit":{"type":"integer","description":"返回数量，默认 5，最多 10"}
+		}}`),
+	llm.NewTool("get_place_details",
+		"获取打卡点详情，包括评分、最近的评价和附近的帖子。提供 spot_id 或 name 其中之一。",
+		`{"type":"object","properties":{
+			"spot_id":{"type":"integer","description":"打卡点 ID"},
+			"name":{"type":"string","description":"打卡点名称（模糊匹配）"}
+		}}`),
+	llm.NewTool("reverse_geocode",
+		"根据经纬度查询所在的国家、城市和附近的知名地点。",
+		`{"type":"object","properties":{
+			"latitude":{"type":"number","description":"纬度，不填时使用用户当前位置"},
+			"longitude":{"type":"number","description":"经度，不填时使用用户当前位置"}
+		}}`),
+}
+
+// AnyaToolbox 阿尼亚可调用的工具，基于 TapSpot 自己的数据
+// 每轮对话创建一个实例，工具返回的帖子和地点会整理成卡片
+type AnyaToolbox struct {
+	viewerID  uint
+	latitude  float64 // 用户当前位置，未提供时为 0
+	longitude float64
+
+	cards  []dto.ChatCard
+	seen   map[string]bool
+	OnCard func(card dto.ChatCard) // 生成新卡片时回调，可为空
+	OnCall func(call llm.ToolCall) // 开始执行工具时回调，可为空
+}
+
+// NewAnyaToolbox 创建工具箱，viewerID 用于过滤屏蔽和隐藏的内容
+func NewAnyaToolbox(viewerID uint, latitude, longitude float64) *AnyaToolbox {
+	return &AnyaToolbox{
+		viewerID:  viewerID,
+		latitude:  latitude,
+		longitude: longitude,
+		seen:      make(map[string]bool),
+	}
+}
+
+// Tools 工具定义
+func (t *AnyaToolbox) Tools() []llm.Tool {
+	return anyaToolDefinitions
+}
+
+// Cards 本轮对话中工具返回的卡片
+func (t *AnyaToolbox) Cards() []dto.ChatCard {
+	return t.cards
+}
+
+// toolArgs 各工具参数的并集
+type toolArgs struct {
+	Keyword   string   `json:"keyword"`
+	Type      string   `json:"type"`
+	Category  string   `json:"category"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	RadiusKm  float64  `json:"radius_km"`
+	Limit     int      `json:"limit"`
+	SpotID    uint     `json:"spot_id"`
+	Name      string   `json:"name"`
+}
+
+// ExecuteTool 实现 llm.ToolExecutor
+func (t *AnyaToolbox) ExecuteTool(ctx context.Context, call llm.ToolCall) (string, error) {
+	if t.OnCall != nil {
+		t.OnCall(call)
+	}
+
+	var args toolArgs
+	if strings.TrimSpace(call.Function.Arguments) != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			return toolError("参数不是合法的 JSON"), nil
+		}
+	}
+
+	var result interface{}
+	switch call.Function.Name {
+	case "search_posts":
+		result = t.searchPosts(&args)
+	case "nearby_spots":
+		result = t.nearbySpots(&args)
+	case "get_place_details":
+		result = t.placeDetails(&args)
+	case "reverse_geocode":
+		result = t.reverseGeocode(&args)
+	default:
+		return toolError("未知的工具：" + call.Function.Name), nil
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// NearbySpots 直接查询附近的打卡点（未配置模型时的本地推荐）
+func (t *AnyaToolbox) NearbySpots() []dto.ChatCard {
+	t.nearbySpots(&toolArgs{})
+	return t.cards
+}
+
+// postResult 帖子搜索结果
+type postResult struct {
+	ID           uint    `json:"id"`
+	Title        string  `json:"title"`
+	Snippet      string  `json:"snippet"`
+	Type         string  `json:"type"`
+	LocationName string  `json:"location_name"`
+	Author       string  `json:"author"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	DistanceKm   float64 `json:"distance_km,omitempty"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+// searchPosts 搜索帖子并生成卡片
+func (t *AnyaToolbox) searchPosts(args *toolArgs) interface{} {
+	results := t.findPosts(args)
+	for _, post := range results {
+		t.addCard(dto.ChatCard{
+			Type:        "post",
+			ID:          post.ID,
+			Title:       post.Title,
+			Description: post.Snippet,
+			Category:    post.Type,
+			Address:     post.LocationName,
+			Latitude:    post.Latitude,
+			Longitude:   post.Longitude,
+			Distance:    post.DistanceKm,
+		})
+	}
+	return map[string]interface{}{"posts": results, "count": len(results)}
+}
+
+// findPosts 查询帖子，遵守审核隐藏、隐身封禁、屏蔽和位置模糊规则
+func (t *AnyaToolbox) findPosts(args *toolArgs) []postResult {
+	limit := clampLimit(args.Limit)
+	query := models.DB.Preload("User").Where("hidden = ?", false).
+		Scopes(ShadowBanScope("user_id", t.viewerID))
+
+	if args.Type != "" {
+		query = query.Where("type = ?", args.Type)
+	}
+	if keyword := strings.TrimSpace(args.Keyword); keyword != "" {
+		like := "%" + keyword + "%"
+		query = query.Where("title LIKE ? OR content LIKE ? OR location_name LIKE ?", like, like, like)
+	}
+	if hidden := HiddenUserIDs(t.viewerID); len(hidden) > 0 {
+		query = query.Where("user_id NOT IN ?", hidden)
+	}
+
+	lat, lng, hasCenter := t.center(args)
+	radius := math.Min(args.RadiusKm, toolMaxRadiusKm)
+	filterByDistance := radius > 0 && hasCenter
+	if filterByDistance {
+		minLat, maxLat, minLng, maxLng := BoundingBox(lat, lng, radius)
+		query = query.Scopes(PublicBoxScope(t.viewerID, minLat, maxLat, minLng, maxLng)).
+			Limit(200)
+	} else {
+		query = query.Limit(limit)
+	}
+
+	var posts []models.Post
+	query.Order("created_at DESC").Find(&posts)
+
+	results := []postResult{}
+	for _, post := range posts {
+		// 距离按访问者可见的坐标计算，避免泄露精确位置
+		postLat, postLng := PublicCoordinates(&post, t.viewerID)
+		result := postResult{
+			ID:           post.ID,
+			Title:        post.Title,
+			Snippet:      TruncateRunes(post.Content, toolSnippetRunes),
+			Type:         post.Type,
+			LocationName: post.LocationName,
+			Author:       post.User.Nickname,
+			Latitude:     postLat,
+			Longitude:    postLng,
+			CreatedAt:    post.CreatedAt.Format("2006-01-02"),
+		}
+		if hasCenter {
+			result.DistanceKm = roundKm(DistanceKm(lat, lng, postLat, postLng))
+			if filterByDistance && result.DistanceKm > radius {
+				continue
+			}
+		}
+		results = append(results, result)
+	}
+	if filterByDistance {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].DistanceKm < results[j].DistanceKm })
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// spotResult 打卡点结果
+type spotResult struct {
+	ID          uint    `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Category    string  `json:"category"`
+	City        string  `json:"city"`
+	Address     string  `json:"address"`
+	Rating      float64 `json:"rating"`
+	ReviewCount int     `json:"review_count"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	DistanceKm  float64 `json:"distance_km,omitempty"`
+}
+
+// nearbySpots 查找附近的打卡点
+func (t *AnyaToolbox) nearbySpots(args *toolArgs) interface{} {
+	lat, lng, ok := t.center(args)
+	if !ok {
+		return toolErrorValue("缺少位置信息，请先询问用户所在的位置")
+	}
+
+	radius := args.RadiusKm
+	if radius <= 0 {
+		radius = toolDefaultRadiusKm
+	}
+	radius = math.Min(radius, toolMaxRadiusKm)
+
+	minLat, maxLat, minLng, maxLng := BoundingBox(lat, lng, radius)
+	query := models.DB.Where("latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", minLat, maxLat, minLng, maxLng)
+	if args.Category != "" {
+		query = query.Where("category = ?", args.Category)
+	}
+
+	var spots []models.Spot
+	query.Find(&spots)
+
+	results := []spotResult{}
+	for _, spot := range spots {
+		result := toSpotResult(&spot)
+		result.DistanceKm = roundKm(DistanceKm(lat, lng, spot.Latitude, spot.Longitude))
+		if result.DistanceKm <= radius {
+			results = append(results, result)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].DistanceKm < results[j].DistanceKm })
+	if limit := clampLimit(args.Limit); len(results) > limit {
+		results = results[:limit]
+	}
+
+	for _, spot := range results {
+		t.addSpotCard(spot)
+	}
+	return map[string]interface{}{"spots": results, "count": len(results), "radius_km": radius}
+}
+
+// placeDetails 打卡点详情
+func (t *AnyaToolbox) placeDetails(args *toolArgs) interface{} {
+	var spot models.Spot
+	switch {
+	case args.SpotID > 0:
+		if err := models.DB.First(&spot, args.SpotID).Error; err != nil {
+			return toolErrorValue("打卡点不存在")
+		}
+	case strings.TrimSpace(args.Name) != "":
+		if err := models.DB.Where("name LIKE ?", "%"+strings.TrimSpace(args.Name)+"%").
+			Order("review_count DESC").First(&spot).Error; err != nil {
+			return toolErrorValue("没有找到名为「" + args.Name + "」的打卡点")
+		}
+	default:
+		return toolErrorValue("需要提供 spot_id 或 name")
+	}
+
+	detail := toSpotResult(&spot)
+	if lat, lng, ok := t.center(&toolArgs{}); ok {
+		detail.DistanceKm = roundKm(DistanceKm(lat, lng, spot.Latitude, spot.Longitude))
+	}
+
+	var reviews []models.Review
+	models.DB.Where("spot_id = ?", spot.ID).Order("created_at DESC").Limit(3).Find(&reviews)
+	reviewResults := []map[string]interface{}{}
+	for _, review := range reviews {
+		reviewResults = append(reviewResults, map[string]interface{}{
+			"author":  review.Author,
+			"rating":  review.Rating,
+			"content": TruncateRunes(review.Content, toolSnippetRunes),
+		})
+	}
+
+	nearby := t.findPosts(&toolArgs{
+		Latitude:  &spot.Latitude,
+		Longitude: &spot.Longitude,
+		RadiusKm:  1,
+		Limit:     3,
+	})
+
+	t.addSpotCard(detail)
+	return map[string]interface{}{
+		"spot":         detail,
+		"reviews":      reviewResults,
+		"nearby_posts": nearby,
+	}
+}
+
+// reverseGeocode 用最近的打卡点推断所在城市，并列出附近帖子中的地点名称
+func (t *AnyaToolbox) reverseGeocode(args *toolArgs) interface{} {
+	lat, lng, ok := t.center(args)
+	if !ok {
+		return toolErrorValue("缺少经纬度")
+	}
+
+	minLat, maxLat, minLng, maxLng := BoundingBox(lat, lng, 20)
+	var spots []models.Spot
+	models.DB.Where("latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", minLat, maxLat, minLng, maxLng).Find(&spots)
+
+	var nearest *models.Spot
+	nearestKm := math.MaxFloat64
+	for i := range spots {
+		if d := DistanceKm(lat, lng, spots[i].Latitude, spots[i].Longitude); d < nearestKm {
+			nearest, nearestKm = &spots[i], d
+		}
+	}
+
+	result := map[string]interface{}{"latitude": lat, "longitude": lng}
+	card := dto.ChatCard{Type: "location", Latitude: lat, Longitude: lng}
+	if nearest != nil {
+		result["country"] = nearest.Country
+		result["city"] = nearest.City
+		result["nearest_spot"] = nearest.Name
+		result["nearest_spot_distance_km"] = roundKm(nearestKm)
+		card.Title = strings.TrimSpace(nearest.Country + " " + nearest.City)
+		card.Address = nearest.Address
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, post := range t.findPosts(&toolArgs{Latitude: &lat, Longitude: &lng, RadiusKm: 1, Limit: toolMaxLimit}) {
+		if post.LocationName != "" && !seen[post.LocationName] {
+			seen[post.LocationName] = true
+			names = append(names, post.LocationName)
+		}
+	}
+	result["nearby_places"] = names
+
+	if nearest == nil && len(names) == 0 {
+		result["note"] = "附近没有 TapSpot 收录的地点"
+		return result
+	}
+	if card.Title == "" && len(names) > 0 {
+		card.Title = names[0]
+	}
+	t.addCard(card)
+	return result
+}
+
+// center 工具调用使用的中心点：优先使用参数，其次使用用户当前位置
+func (t *AnyaToolbox) center(args *toolArgs) (float64, float64, bool) {
+	if args.Latitude != nil && args.Longitude != nil {
+		return *args.Latitude, *args.Longitude, true
+	}
+	if t.latitude != 0 || t.longitude != 0 {
+		return t.latitude, t.longitude, true
+	}
+	return 0, 0, false
+}
+
+// addSpotCard 把打卡点加入卡片
+func (t *AnyaToolbox) addSpotCard(spot spotResult) {
+	t.addCard(dto.ChatCard{
+		Type:        "spot",
+		ID:          spot.ID,
+		Title:       spot.Name,
+		Description: TruncateRunes(spot.Description, toolSnippetRunes),
+		Category:    spot.Category,
+		Address:     spot.Address,
+		Latitude:    spot.Latitude,
+		Longitude:   spot.Longitude,
+		Rating:      spot.Rating,
+		Distance:    spot.DistanceKm,
+	})
+}
+
+// addCard 加入卡片，同一对象只保留第一次出现的
+func (t *AnyaToolbox) addCard(card dto.ChatCard) {
+	key := fmt.Sprintf("%s:%d:%f:%f", card.Type, card.ID, card.Latitude, card.Longitude)
+	if card.ID > 0 {
+		key = fmt.Sprintf("%s:%d", card.Type, card.ID)
+	}
+	if t.seen[key] {
+		return
+	}
+	t.seen[key] = true
+	t.cards = append(t.cards, card)
+	if t.OnCard != nil {
+		t.OnCard(card)
+	}
+}
+
+// toSpotResult 转换打卡点
+func toSpotResult(spot *models.Spot) spotResult {
+	return spotResult{
+		ID:          spot.ID,
+		Name:        spot.Name,
+		Description: TruncateRunes(spot.Description, toolSnippetRunes*2),
+		Category:    spot.Category,
+		City:        spot.City,
+		Address:     spot.Address,
+		Rating:      spot.Rating,
+		ReviewCount: spot.ReviewCount,
+		Latitude:    spot.Latitude,
+		Longitude:   spot.Longitude,
+	}
+}
+
+// clampLimit 限制返回数量
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return toolDefaultLimit
+	}
+	if limit > toolMaxLimit {
+		return toolMaxLimit
+	}
+	return limit
+}
+
+// roundKm 距离保留两位小数
+func roundKm(km float64) float64 {
+	return math.Round(km*100) / 100
+}
+
+// toolErrorValue 返回给模型的错误说明
+func toolErrorValue(message string) map[string]string {
+	return map[string]string{"error": message}
+}
+
+// toolError 序列化后的错误说明
+func toolError(message string) string {
+	data, _ := json.Marshal(toolErrorValue(message))
+	return string(data)
+}
@@ -2,12 +2,15 @@ package services
 
 import (
 	"errors"
+	"log"
+	"strings"
 	"tapspot/dto"
 	"tapspot/models"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 var jwtSecret = []byte("tapspot-secret-key-2026-change-in-production")
@@ -22,22 +25,34 @@ func NewAuthService() *AuthService {
 
 // Register 用户注册
 func (s *AuthService) Register(req *dto.RegisterRequest, ip string) (*dto.RegisterResponse, error) {
+	// 用户名不能与邮箱/手机号格式混淆，否则会影响按账号类型登录
+	if idType, _ := DetectIdentifier(req.Username); idType != IdentifierUsername {
+		return nil, errors.New("用户名不能是邮箱或手机号格式")
+	}
+
+	// 规范化邮箱和手机号
+	email := NormalizeEmail(req.Email)
+	phone, err := normalizeOptionalPhone(req.Phone)
+	if err != nil {
+		return nil, err
+	}
+
 	// 检查用户名是否已存在
 	var existingUser models.User
 	if err := models.DB.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
 		return nil, errors.New("用户名已存在")
 	}
 
-	// 检查邮箱是否已存在（如果提供）
-	if req.Email != "" {
-		if err := models.DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	// 检查邮箱是否已被验证占用（如果提供）；未验证的邮箱不算占用，避免被他人抢先填写
+	if email != "" {
+		if err := models.DB.Where("email = ? AND email_verified = ?", email, true).First(&existingUser).Error; err == nil {
 			return nil, errors.New("邮箱已被注册")
 		}
 	}
 
-	// 检查手机号是否已存在（如果提供）
-	if req.Phone != "" {
-		if err := models.DB.Where("phone = ?", req.Phone).First(&existingUser).Error; err == nil {
+	// 检查手机号是否已被验证占用（如果提供）
+	if phone != "" {
+		if err := models.DB.Where("phone = ? AND phone_verified = ?", phone, true).First(&existingUser).Error; err == nil {
 			return nil, errors.New("手机号已被注册")
 		}
 	}
@@ -56,8 +71,8 @@ func (s *AuthService) Register(req *dto.RegisterRequest, ip string) (*dto.Regist
 		Gender:         req.Gender,
 		Bio:            req.Bio,
 		Avatar:         req.Avatar,
-		Email:          req.Email,
-		Phone:          req.Phone,
+		Email:          email,
+		Phone:          phone,
 		RegistrationIP: ip, // 记录注册 IP
 	}
 
@@ -80,41 +95,89 @@ func (s *AuthService) Register(req *dto.RegisterRequest, ip string) (*dto.Regist
 	}, nil
 }
 
-// Login 用户登录
+// Login 用户登录（账号可以是用户名、邮箱或手机号）
 func (s *AuthService) Login(req *dto.LoginRequest) (*dto.LoginResponse, error) {
+	account := req.Account
+	if account == "" {
+		account = req.Username // 兼容旧版客户端
+	}
+
 	// 查找用户
-	var user models.User
-	if err := models.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		return nil, errors.New("用户名或密码错误")
+	user, err := findUserByIdentifier(account)
+	if err != nil {
+		return nil, errors.New("账号或密码错误")
 	}
 
 	// 验证密码
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return nil, errors.New("用户名或密码错误")
+		return nil, errors.New("账号或密码错误")
+	}
+
+	return s.completeLogin(user)
+}
+
+// completeLogin 身份验证通过后签发登录结果
+// 开启两步验证的用户只拿到短期挑战 token，需再提交验证码
+func (s *AuthService) completeLogin(user *models.User) (*dto.LoginResponse, error) {
+	if err := suspensionError(user); err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		challenge, err := generateChallengeToken(user)
+		if err != nil {
+			return nil, errors.New("生成 token 失败")
+		}
+		return &dto.LoginResponse{
+			User:              toUserInfo(user),
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		}, nil
+	}
+
+	return s.issueSession(user)
+}
+
+// issueSession 签发正式登录 token
+// 注销宽限期内重新登录视为撤销注销申请
+func (s *AuthService) issueSession(user *models.User) (*dto.LoginResponse, error) {
+	if err := suspensionError(user); err != nil {
+		return nil, err
+	}
+	if user.DeletionRequestedAt != nil {
+		if err := models.DB.Model(user).Update("deletion_requested_at", nil).Error; err != nil {
+			return nil, errors.New("撤销注销申请失败")
+		}
 	}
 
 	// 生成 JWT
-	token, err := s.GenerateToken(user.ID, user.Username)
+	token, err := s.GenerateToken(user.ID, user.Username, user.TokenVersion)
 	if err != nil {
 		return nil, errors.New("生成 token 失败")
 	}
 
 	return &dto.LoginResponse{
-		User: dto.UserInfo{
-			ID:        user.ID,
-			Username:  user.Username,
-			Nickname:  user.Nickname,
-			Avatar:    user.Avatar,
-			Gender:    user.Gender,
-			Bio:       user.Bio,
-			Email:     user.Email,
-			Phone:     user.Phone,
-			CreatedAt: user.CreatedAt,
-		},
+		User:  toUserInfo(user),
 		Token: token,
 	}, nil
 }
 
+// toUserInfo 转换为用户基本信息响应
+func toUserInfo(user *models.User) dto.UserInfo {
+	return dto.UserInfo{
+		ID:            user.ID,
+		Username:      user.Username,
+		Nickname:      user.Nickname,
+		Avatar:        user.Avatar,
+		Gender:        user.Gender,
+		Bio:           user.Bio,
+		Email:         user.Email,
+		Phone:         user.Phone,
+		EmailVerified: user.EmailVerified,
+		PhoneVerified: user.PhoneVerified,
+		CreatedAt:     user.CreatedAt,
+	}
+}
+
 // GetUserByID 根据 ID 获取用户信息
 func (s *AuthService) GetUserByID(userID uint) (*dto.UserInfo, error) {
 	var user models.User
@@ -123,25 +186,39 @@ func (s *AuthService) GetUserByID(userID uint) (*dto.UserInfo, error) {
 	}
 
 	return &dto.UserInfo{
-		ID:        user.ID,
-		Username:  user.Username,
-		Nickname:  user.Nickname,
-		Avatar:    user.Avatar,
-		Gender:    user.Gender,
-		Bio:       user.Bio,
-		Email:     user.Email,
-		Phone:     user.Phone,
-		CreatedAt: user.CreatedAt,
+		ID:            user.ID,
+		Username:      user.Username,
+		Nickname:      user.Nickname,
+		Avatar:        user.Avatar,
+		Gender:        user.Gender,
+		Bio:           user.Bio,
+		Email:         user.Email,
+		Phone:         user.Phone,
+		EmailVerified: user.EmailVerified,
+		PhoneVerified: user.PhoneVerified,
+		CreatedAt:     user.CreatedAt,
 	}, nil
 }
 
 // GetUserProfile 获取用户完整资料（包含统计数据）
-func (s *AuthService) GetUserProfile(userID uint) (*dto.UserProfile, error) {
+// 按被查看用户的隐私设置裁剪：资料不公开时只返回基本信息，邮箱和手机号默认仅本人可见
+func (s *AuthService) GetUserProfile(viewerID, userID uint) (*dto.UserProfile, error) {
 	var user models.User
 	if err := models.DB.First(&user, userID).Error; err != nil {
 		return nil, errors.New("用户不存在")
 	}
 
+	if !CanViewProfile(viewerID, &user) {
+		return &dto.UserProfile{
+			ID:          user.ID,
+			Username:    user.Username,
+			Nickname:    user.Nickname,
+			Avatar:      user.Avatar,
+			IsFollowing: IsFollowing(viewerID, user.ID),
+			Restricted:  true,
+		}, nil
+	}
+
 	// 统计发帖数
 	var postCount int64
 	models.DB.Model(&models.Post{}).Where("user_id = ?", userID).Count(&postCount)
@@ -153,6 +230,8 @@ func (s *AuthService) GetUserProfile(userID uint) (*dto.UserProfile, error) {
 		Where("posts.user_id = ?", userID).
 		Count(&likeCount)
 
+	followerCount, followingCount := FollowCounts(userID)
+
 	return &dto.UserProfile{
 		ID:             user.ID,
 		Username:       user.Username,
@@ -160,13 +239,14 @@ func (s *AuthService) GetUserProfile(userID uint) (*dto.UserProfile, error) {
 		Avatar:         user.Avatar,
 		Gender:         user.Gender,
 		Bio:            user.Bio,
-		Email:          user.Email,
-		Phone:          user.Phone,
+		Email:          VisibleEmail(viewerID, &user),
+		Phone:          VisiblePhone(viewerID, &user),
 		CreatedAt:      user.CreatedAt,
 		PostCount:      postCount,
 		LikeCount:      likeCount,
-		FollowerCount:  0, // 预留
-		FollowingCount: 0, // 预留
+		FollowerCount:  followerCount,
+		FollowingCount: followingCount,
+		IsFollowing:    IsFollowing(viewerID, user.ID),
 	}, nil
 }
 
@@ -184,21 +264,37 @@ func (s *AuthService) UpdateProfile(userID uint, req *dto.UpdateProfileRequest)
 	if req.Avatar != "" {
 		updates["avatar"] = req.Avatar
 	}
+	var current models.User
+	if err := models.DB.Select("id", "email", "phone").First(&current, userID).Error; err != nil {
+		return errors.New("用户不存在")
+	}
 	if req.Email != "" {
-		// 检查邮箱是否被其他用户使用
+		email := NormalizeEmail(req.Email)
+		// 检查邮箱是否已被其他用户验证
 		var existingUser models.User
-		if err := models.DB.Where("email = ? AND id != ?", req.Email, userID).First(&existingUser).Error; err == nil {
+		if err := models.DB.Where("email = ? AND id != ? AND email_verified = ?", email, userID, true).First(&existingUser).Error; err == nil {
 			return errors.New("邮箱已被其他用户使用")
 		}
-		updates["email"] = req.Email
+		// 修改后需要重新验证
+		if email != current.Email {
+			updates["email"] = email
+			updates["email_verified"] = false
+		}
 	}
 	if req.Phone != "" {
-		// 检查手机号是否被其他用户使用
+		phone, err := normalizeOptionalPhone(req.Phone)
+		if err != nil {
+			return err
+		}
+		// 检查手机号是否已被其他用户验证
 		var existingUser models.User
-		if err := models.DB.Where("phone = ? AND id != ?", req.Phone, userID).First(&existingUser).Error; err == nil {
+		if err := models.DB.Where("phone = ? AND id != ? AND phone_verified = ?", phone, userID, true).First(&existingUser).Error; err == nil {
 			return errors.New("手机号已被其他用户使用")
 		}
-		updates["phone"] = req.Phone
+		if phone != current.Phone {
+			updates["phone"] = phone
+			updates["phone_verified"] = false
+		}
 	}
 
 	if len(updates) == 0 {
@@ -213,37 +309,83 @@ func (s *AuthService) UpdateProfile(userID uint, req *dto.UpdateProfileRequest)
 }
 
 // ChangePassword 修改密码
-func (s *AuthService) ChangePassword(userID uint, req *dto.ChangePasswordRequest) error {
+// 修改后其他设备的登录失效，返回当前设备使用的新 token
+func (s *AuthService) ChangePassword(userID uint, req *dto.ChangePasswordRequest) (string, error) {
 	// 获取用户
 	var user models.User
 	if err := models.DB.First(&user, userID).Error; err != nil {
-		return errors.New("用户不存在")
+		return "", errors.New("用户不存在")
 	}
 
 	// 验证旧密码
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldPassword)); err != nil {
-		return errors.New("原密码错误")
+		return "", errors.New("原密码错误")
 	}
 
 	// 加密新密码
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
-		return errors.New("密码加密失败")
+		return "", errors.New("密码加密失败")
 	}
 
-	// 更新密码
-	if err := models.DB.Model(&models.User{}).Where("id = ?", userID).Update("password", string(hashedPassword)).Error; err != nil {
-		return errors.New("修改密码失败")
+	// 更新密码，同时递增 token 版本让其他设备退出登录
+	if err := models.DB.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"password":      string(hashedPassword),
+		"token_version": gorm.Expr("token_version + 1"),
+	}).Error; err != nil {
+		return "", errors.New("修改密码失败")
 	}
 
-	return nil
+	token, err := s.GenerateToken(user.ID, user.Username, user.TokenVersion+1)
+	if err != nil {
+		return "", errors.New("生成 token 失败")
+	}
+	return token, nil
+}
+
+// findUserByIdentifier 按自动识别的账号类型查找用户，邮箱和手机号只有验证过才能用于登录
+func findUserByIdentifier(raw string) (*models.User, error) {
+	raw = strings.TrimSpace(raw)
+	idType, value := DetectIdentifier(raw)
+
+	var user models.User
+	switch idType {
+	case IdentifierEmail:
+		if err := models.DB.Where("email = ? AND email_verified = ?", value, true).First(&user).Error; err == nil {
+			return &user, nil
+		}
+	case IdentifierPhone:
+		// 历史数据中的手机号可能未规范化，原始输入也一并匹配
+		if err := models.DB.Where("phone IN ? AND phone_verified = ?", []string{value, raw}, true).First(&user).Error; err == nil {
+			return &user, nil
+		}
+	}
+
+	// 兜底按用户名查找（早期注册的用户名可能是纯数字）
+	if err := models.DB.Where("username = ?", raw).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// normalizeOptionalPhone 规范化可选的手机号，空值直接返回
+func normalizeOptionalPhone(phone string) (string, error) {
+	if strings.TrimSpace(phone) == "" {
+		return "", nil
+	}
+	normalized, ok := NormalizePhone(phone)
+	if !ok {
+		return "", errors.New("手机号格式不正确")
+	}
+	return normalized, nil
 }
 
 // GenerateToken 生成 JWT token
-func (s *AuthService) GenerateToken(userID uint, username string) (string, error) {
+func (s *AuthService) GenerateToken(userID uint, username string, version int) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id":  userID,
 		"username": username,
+		"ver":      version,                                   // 与 users.token_version 不一致时 token 失效
 		"exp":      time.Now().Add(7 * 24 * time.Hour).Unix(), // 7 天过期
 		"iat":      time.Now().Unix(),
 		"iss":      "tapspot",
@@ -258,18 +400,73 @@ func GetJWTSecret() []byte {
 	return jwtSecret
 }
 
-// CreateTestUser 创建测试用户（仅开发用）
+// ParseToken 校验 JWT 并返回用户 ID 和用户名
+// 除签名和过期时间外，还会比对 token 版本号，重置密码后旧 token 即失效
+func ParseToken(tokenString string) (uint, string, error) {
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return 0, "", errors.New("token 无效或已过期")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, "", errors.New("token 无效或已过期")
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, "", errors.New("token 无效或已过期")
+	}
+	if typ, _ := claims["typ"].(string); typ != "" {
+		// 两步验证挑战 token 等特殊用途 token 不能当作登录凭证
+		return 0, "", errors.New("token 无效或已过期")
+	}
+	username, _ := claims["username"].(string)
+	version, _ := claims["ver"].(float64) // 旧 token 没有 ver，视为 0
+
+	var user models.User
+	if err := models.DB.Select("id", "token_version", "suspended_at", "suspended_until", "suspension_reason").First(&user, uint(userID)).Error; err != nil {
+		return 0, "", errors.New("用户不存在")
+	}
+	if int(version) != user.TokenVersion {
+		return 0, "", errors.New("登录已失效，请重新登录")
+	}
+	if err := suspensionError(&user); err != nil {
+		return 0, "", err
+	}
+
+	return uint(userID), username, nil
+}
+
+// CreateTestUser 创建测试用户 root/root（仅开发模式），不授予任何角色
 func CreateTestUser() {
 	var user models.User
-	if err := models.DB.Where("username = ?", "root").First(&user).Error; err != nil {
-		// 用户不存在，创建
-		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("root"), bcrypt.DefaultCost)
-		testUser := models.User{
-			Username: "root",
-			Password: string(hashedPassword),
-			Nickname: "管理员",
-			Gender:   "other",
-		}
-		models.DB.Create(&testUser)
+	if err := models.DB.Where("username = ?", "root").First(&user).Error; err == nil {
+		return
+	}
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("root"), bcrypt.DefaultCost)
+	models.DB.Create(&models.User{
+		Username: "root",
+		Password: string(hashedPassword),
+		Nickname: "测试用户",
+		Gender:   "other",
+	})
+}
+
+// RevokeDefaultCredentialRoles 旧版本每次启动都会把 root/root 设为管理员，
+// 非开发模式下如果该账号仍使用默认密码，收回其角色
+func RevokeDefaultCredentialRoles() {
+	var user models.User
+	if err := models.DB.Where("username = ? AND role <> ?", "root", RoleUser).First(&user).Error; err != nil {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("root")) != nil {
+		return
+	}
+	if err := models.DB.Model(&user).Update("role", RoleUser).Error; err == nil {
+		log.Printf("⚠️ 用户 root 仍使用默认密码，已收回 %s 角色", user.Role)
 	}
 }
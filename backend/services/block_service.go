@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"tapspot/dto"
+	"tapspot/models"
+
+	"gorm.io/gorm"
+)
+
+// BlockService 用户屏蔽服务
+type BlockService struct{}
+
+// NewBlockService 创建屏蔽服务实例
+func NewBlockService() *BlockService {
+	return &BlockService{}
+}
+
+// Block 屏蔽用户（重复屏蔽视为成功）
+func (s *BlockService) Block(blockerID, blockedID uint) error {
+	if blockerID == blockedID {
+		return errors.New("不能屏蔽自己")
+	}
+
+	var target models.User
+	if err := models.DB.First(&target, blockedID).Error; err != nil {
+		return errors.New("用户不存在")
+	}
+
+	var existing models.Block
+	if err := models.DB.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).First(&existing).Error; err == nil {
+		return nil
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.Block{BlockerID: blockerID, BlockedID: blockedID}).Error; err != nil {
+			return err
+		}
+		// 屏蔽后双方互相取消关注
+		return tx.Where("(follower_id = ? AND following_id = ?) OR (follower_id = ? AND following_id = ?)",
+			blockerID, blockedID, blockedID, blockerID).Delete(&models.Follow{}).Error
+	})
+	if err != nil {
+		return errors.New("屏蔽失败，请稍后重试")
+	}
+	return nil
+}
+
+// Unblock 取消屏蔽
+func (s *BlockService) Unblock(blockerID, blockedID uint) error {
+	result := models.DB.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).Delete(&models.Block{})
+	if result.Error != nil {
+		return errors.New("取消屏蔽失败")
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("未屏蔽该用户")
+	}
+	return nil
+}
+
+// ListBlocked 获取我屏蔽的用户列表
+func (s *BlockService) ListBlocked(blockerID uint) ([]dto.BlockedUser, error) {
+	var blocks []models.Block
+	if err := models.DB.Preload("Blocked").Where("blocker_id = ?", blockerID).
+		Order("created_at DESC").Find(&blocks).Error; err != nil {
+		return nil, errors.New("获取屏蔽列表失败")
+	}
+
+	result := []dto.BlockedUser{}
+	for _, block := range blocks {
+		result = append(result, dto.BlockedUser{
+			ID:        block.BlockedID,
+			Username:  block.Blocked.Username,
+			Nickname:  block.Blocked.Nickname,
+			Avatar:    block.Blocked.Avatar,
+			BlockedAt: block.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+// IsBlocked 两个用户之间任意一方屏蔽了另一方即返回 true
+func IsBlocked(a, b uint) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	var count int64
+	models.DB.Model(&models.Block{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", a, b, b, a).
+		Count(&count)
+	return count > 0
+}
+
+// HiddenUserIDs 与该用户存在屏蔽关系（双向）的用户 ID，用于过滤列表
+func HiddenUserIDs(userID uint) []uint {
+	if userID == 0 {
+		return nil
+	}
+
+	var blocks []models.Block
+	models.DB.Where("blocker_id = ? OR blocked_id = ?", userID, userID).Find(&blocks)
+
+	ids := make([]uint, 0, len(blocks))
+	for _, block := range blocks {
+		if block.BlockerID == userID {
+			ids = append(ids, block.BlockedID)
+		} else {
+			ids = append(ids, block.BlockerID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"tapspot/llm"
+	"tapspot/models"
+
+	"gorm.io/gorm"
+)
+
+// chatTitleRunes 自动生成的会话标题长度
+const chatTitleRunes = 20
+
+// ChatOwner 聊天会话的归属：登录用户或持有匿名 cookie 的访客
+type ChatOwner struct {
+	UserID      uint
+	AnonymousID string
+}
+
+// IsZero 既没有登录也没有匿名标识
+func (o ChatOwner) IsZero() bool {
+	return o.UserID == 0 && o.AnonymousID == ""
+}
+
+// scope 只查询属于该归属的会话
+func (o ChatOwner) scope(db *gorm.DB) *gorm.DB {
+	return db.Where("user_id = ? AND anonymous_id = ?", o.UserID, o.AnonymousID)
+}
+
+// ChatSessionService 阿尼亚聊天会话服务
+type ChatSessionService struct {
+	historyTurns  int  // 每次请求最多携带的历史轮数
+	historyTokens int  // 历史消息的 token 预算（估算值）
+	summarize     bool // 是否把超出窗口的旧消息压缩成摘要
+
+	summarizing sync.Map // 正在生成摘要的会话，避免重复生成
+}
+
+// NewChatSessionService 创建会话服务实例
+// 由 CHAT_HISTORY_TURNS（默认 10）、CHAT_HISTORY_TOKENS（默认 2000）、CHAT_SUMMARY_ENABLED（默认 true）配置
+func NewChatSessionService() *ChatSessionService {
+	s := &ChatSessionService{
+		historyTurns:  10,
+		historyTokens: 2000,
+		summarize:     true,
+	}
+	if v, err := strconv.Atoi(os.Getenv("CHAT_HISTORY_TURNS")); err == nil && v >= 0 {
+		s.historyTurns = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CHAT_HISTORY_TOKENS")); err == nil && v >= 0 {
+		s.historyTokens = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("CHAT_SUMMARY_ENABLED")); err == nil {
+		s.summarize = v
+	}
+	return s
+}
+
+// Resolve 取得本次对话使用的会话
+// 指定 sessionID 时使用该会话；否则继续最近的会话，没有会话或 newSession 为 true 时以首条消息为标题新建
+func (s *ChatSessionService) Resolve(owner ChatOwner, sessionID uint, newSession bool, firstMessage string) (*models.ChatSession, error) {
+	if sessionID > 0 {
+		return s.Get(owner, sessionID)
+	}
+	if !newSession {
+		var latest models.ChatSession
+		if err := models.DB.Scopes(owner.scope).Order("last_message_at DESC").First(&latest).Error; err == nil {
+			return &latest, nil
+		}
+	}
+
+	session := models.ChatSession{
+		UserID:        owner.UserID,
+		AnonymousID:   owner.AnonymousID,
+		Title:         sessionTitle(firstMessage),
+		LastMessageAt: time.Now(),
+	}
+	if err := models.DB.Create(&session).Error; err != nil {
+		return nil, errors.New("创建会话失败")
+	}
+	return &session, nil
+}
+
+// Get 获取属于 owner 的会话
+func (s *ChatSessionService) Get(owner ChatOwner, sessionID uint) (*models.ChatSession, error) {
+	var session models.ChatSession
+	if err := models.DB.Scopes(owner.scope).Where("id = ?", sessionID).First(&session).Error; err != nil {
+		return nil, errors.New("会话不存在")
+	}
+	return &session, nil
+}
+
+// List 按最近活跃时间列出 owner 的会话
+func (s *ChatSessionService) List(owner ChatOwner, page, pageSize int) ([]models.ChatSession, int64, error) {
+	var total int64
+	query := models.DB.Model(&models.ChatSession{}).Scopes(owner.scope)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, errors.New("获取会话失败")
+	}
+
+	var sessions []models.ChatSession
+	if err := query.Order("last_message_at DESC").
+		Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&sessions).Error; err != nil {
+		return nil, 0, errors.New("获取会话失败")
+	}
+	return sessions, total, nil
+}
+
+// Rename 修改会话标题
+func (s *ChatSessionService) Rename(owner ChatOwner, sessionID uint, title string) (*models.ChatSession, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, errors.New("标题不能为空")
+	}
+
+	session, err := s.Get(owner, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := models.DB.Model(session).Update("title", title).Error; err != nil {
+		return nil, errors.New("修改标题失败")
+	}
+	return session, nil
+}
+
+// Delete 删除会话及其全部消息
+func (s *ChatSessionService) Delete(owner ChatOwner, sessionID uint) error {
+	session, err := s.Get(owner, sessionID)
+	if err != nil {
+		return err
+	}
+
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", session.ID).Delete(&models.ChatMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(session).Error
+	})
+}
+
+// Messages 分页获取会话消息，第 1 页为最新的消息，每页内按时间正序
+func (s *ChatSessionService) Messages(owner ChatOwner, sessionID uint, page, pageSize int) ([]models.ChatMessage, int64, error) {
+	if _, err := s.Get(owner, sessionID); err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	query := models.DB.Model(&models.ChatMessage{}).Where("session_id = ?", sessionID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, errors.New("获取聊天记录失败")
+	}
+
+	var messages []models.ChatMessage
+	if err := query.Order("id DESC").
+		Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&messages).Error; err != nil {
+		return nil, 0, errors.New("获取聊天记录失败")
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, total, nil
+}
+
+// ClaimAnonymous 匿名访客登录后，把匿名会话转到该用户名下
+func (s *ChatSessionService) ClaimAnonymous(anonymousID string, userID uint) error {
+	if anonymousID == "" || userID == 0 {
+		return nil
+	}
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		sessionIDs := tx.Model(&models.ChatSession{}).Select("id").
+			Where("user_id = ? AND anonymous_id = ?", 0, anonymousID)
+		if err := tx.Model(&models.ChatMessage{}).Where("session_id IN (?)", sessionIDs).
+			Update("user_id", userID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.ChatSession{}).
+			Where("user_id = ? AND anonymous_id = ?", 0, anonymousID).
+			Updates(map[string]interface{}{"user_id": userID, "anonymous_id": ""}).Error
+	})
+}
+
+// StartAnonymousCleanup 定期删除长时间不活跃的匿名会话（匿名 cookie 过期后已无人能访问）
+func (s *ChatSessionService) StartAnonymousCleanup(maxIdle, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.purgeAnonymous(time.Now().Add(-maxIdle))
+			<-ticker.C
+		}
+	}()
+}
+
+// purgeAnonymous 删除最后活跃时间早于 before 的匿名会话
+func (s *ChatSessionService) purgeAnonymous(before time.Time) {
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		stale := tx.Model(&models.ChatSession{}).Select("id").
+			Where("user_id = ? AND anonymous_id <> ? AND last_message_at < ?", 0, "", before)
+		if err := tx.Where("session_id IN (?)", stale).Delete(&models.ChatMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND anonymous_id <> ? AND last_message_at < ?", 0, "", before).
+			Delete(&models.ChatSession{}).Error
+	})
+	if err != nil {
+		log.Printf("清理匿名聊天会话失败: %v", err)
+	}
+}
+
+// Append 保存一轮对话并更新会话统计
+func (s *ChatSessionService) Append(session *models.ChatSession, userMessage, reply string) error {
+	now := time.Now()
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		messages := []models.ChatMessage{
+			{UserID: session.UserID, SessionID: session.ID, Role: llm.RoleUser, Content: userMessage},
+			{UserID: session.UserID, SessionID: session.ID, Role: llm.RoleAssistant, Content: reply},
+		}
+		if err := tx.Create(&messages).Error; err != nil {
+			return err
+		}
+		return tx.Model(session).Updates(map[string]interface{}{
+			"message_count":   gorm.Expr("message_count + ?", len(messages)),
+			"last_message_at": now,
+		}).Error
+	})
+}
+
+// ContextMessages 组装随请求发送的历史：摘要 + 预算内最近的若干轮
+func (s *ChatSessionService) ContextMessages(session *models.ChatSession) []llm.Message {
+	var history []llm.Message
+	if session.Summary != "" {
+		history = append(history, llm.Message{
+			Role:    llm.RoleSystem,
+			Content: "以下是你和用户之前对话的摘要，回答时可以参考：\n" + session.Summary,
+		})
+	}
+	if s.historyTurns == 0 {
+		return history
+	}
+
+	var recent []models.ChatMessage
+	models.DB.Where("session_id = ? AND id > ?", session.ID, session.SummarizedUntil).
+		Order("id DESC").
+		Limit(s.historyTurns * 2).
+		Find(&recent)
+
+	// 从最新的消息往前取，超出预算就停止
+	budget := s.historyTokens
+	start := len(recent)
+	for i, message := range recent {
+		budget -= EstimateTokens(message.Content)
+		if budget < 0 {
+			break
+		}
+		start = i + 1
+	}
+	// 不以半轮（只有助手回复）开头
+	if start > 0 && recent[start-1].Role == llm.RoleAssistant {
+		start--
+	}
+
+	for i := start - 1; i >= 0; i-- {
+		history = append(history, llm.Message{Role: recent[i].Role, Content: recent[i].Content})
+	}
+	return history
+}
+
+// SummarizeIfNeeded 未摘要的消息超出历史窗口较多时，把窗口之前的部分并入摘要
+// 在后台调用，失败只记录日志
+func (s *ChatSessionService) SummarizeIfNeeded(sessionID uint) {
+	if !s.summarize {
+		return
+	}
+	if _, running := s.summarizing.LoadOrStore(sessionID, true); running {
+		return
+	}
+	defer s.summarizing.Delete(sessionID)
+
+	var session models.ChatSession
+	if err := models.DB.First(&session, sessionID).Error; err != nil {
+		return
+	}
+
+	keep := s.historyTurns * 2
+	var pending []models.ChatMessage
+	models.DB.Where("session_id = ? AND id > ?", session.ID, session.SummarizedUntil).
+		Order("id ASC").
+		Find(&pending)
+	// 多攒几轮再摘要，避免每轮都调用一次模型
+	if len(pending) < keep+6 {
+		return
+	}
+	older := pending[:len(pending)-keep]
+
+	var transcript strings.Builder
+	for _, message := range older {
+		speaker := "用户"
+		if message.Role == llm.RoleAssistant {
+			speaker = "阿尼亚"
+		}
+		fmt.Fprintf(&transcript, "%s：%s\n", speaker, message.Content)
+	}
+
+	prompt := "请把下面的对话压缩成不超过 300 字的摘要，保留用户提到的偏好、计划、地点和未解决的问题，用第三人称陈述，不要添加对话中没有的信息。\n\n"
+	if session.Summary != "" {
+		prompt += "已有摘要：\n" + session.Summary + "\n\n"
+	}
+	prompt += "新的对话：\n" + transcript.String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := llm.Default.Complete(ctx, &llm.Request{
+		Model:       llm.EnvModel("LLM_SUMMARY_MODEL", "qwen-turbo"),
+		Messages:    []llm.Message{{Role: llm.RoleUser, Content: prompt}},
+		MaxTokens:   600,
+		Temperature: 0.3,
+		Purpose:     "chat_summary",
+		UserID:      session.UserID,
+	})
+	if err != nil {
+		if !errors.Is(err, llm.ErrNotConfigured) {
+			log.Printf("⚠️ 生成会话 %d 摘要失败: %v", session.ID, err)
+		}
+		return
+	}
+
+	// 条件更新，期间若已有其它摘要写入则放弃本次结果
+	models.DB.Model(&models.ChatSession{}).
+		Where("id = ? AND summarized_until = ?", session.ID, session.SummarizedUntil).
+		Updates(map[string]interface{}{
+			"summary":          resp.Content,
+			"summarized_until": older[len(older)-1].ID,
+		})
+}
+
+// EstimateTokens 粗略估算文本的 token 数：ASCII 约 4 个字符 1 个 token，其它字符各算 1 个
+func EstimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return other + (ascii+3)/4
+}
+
+// sessionTitle 用首条消息生成会话标题
+func sessionTitle(message string) string {
+	title := strings.Join(strings.Fields(message), " ")
+	if utf8.RuneCountInString(title) > chatTitleRunes {
+		title = TruncateRunes(title, chatTitleRunes) + "..."
+	}
+	if title == "" {
+		title = "新对话"
+	}
+	return title
+}
@@ -0,0 +1,13 @@
+package services
+
+import (
+	"os"
+	"strconv"
+)
+
+// DevMode 是否为本地开发模式（DEV_MODE=true）
+// 开发模式下才会创建测试账号、在日志中输出完整的邮件内容
+func DevMode() bool {
+	v, _ := strconv.ParseBool(os.Getenv("DEV_MODE"))
+	return v
+}
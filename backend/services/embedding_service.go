@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"tapspot/llm"
+	"tapspot/models"
+	"tapspot/vectorindex"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 向量对应的对象类型
+const (
+	EmbeddingPost = "post"
+	EmbeddingSpot = "spot"
+)
+
+// embeddingTextRunes 参与计算向量的最大字符数
+const embeddingTextRunes = 2000
+
+// embeddingIndexes 进程内的向量索引，按对象类型区分，启动时从数据库加载
+var embeddingIndexes = map[string]*vectorindex.HNSW{
+	EmbeddingPost: vectorindex.New(vectorindex.Config{}),
+	EmbeddingSpot: vectorindex.New(vectorindex.Config{}),
+}
+
+// EmbeddingService 计算并保存帖子和打卡点的文本向量
+type EmbeddingService struct {
+	embedder  llm.Embedder
+	batchSize int
+}
+
+// NewEmbeddingService 创建向量服务
+func NewEmbeddingService() *EmbeddingService {
+	return &EmbeddingService{
+		embedder:  llm.DefaultEmbedder,
+		batchSize: 32,
+	}
+}
+
+// embeddingDocument 待计算向量的文本
+type embeddingDocument struct {
+	ID   uint
+	Text string
+}
+
+// StartSyncWorker 先把已有向量加载到索引，再定期为新增和修改过的帖子、打卡点计算向量
+func (s *EmbeddingService) StartSyncWorker(interval time.Duration) {
+	go func() {
+		s.LoadIndexes()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.Sync(context.Background())
+			<-ticker.C
+		}
+	}()
+}
+
+// LoadIndexes 把当前模型的向量加载到内存索引，其他模型的向量会在同步时重新计算
+func (s *EmbeddingService) LoadIndexes() {
+	var rows []models.Embedding
+	loaded := 0
+	models.DB.Where("model = ?", s.embedder.Model()).
+		FindInBatches(&rows, 500, func(tx *gorm.DB, batch int) error {
+			for _, row := range rows {
+				if indexEmbedding(row.OwnerType, row.OwnerID, decodeVector(row.Vector)) {
+					loaded++
+				}
+			}
+			return nil
+		})
+	log.Printf("✅ 已加载 %d 条文本向量（%s）", loaded, s.embedder.Model())
+}
+
+// Sync 为缺少向量或内容有变化的帖子和打卡点计算向量，并清理已删除对象的向量
+func (s *EmbeddingService) Sync(ctx context.Context) {
+	for _, ownerType := range []string{EmbeddingPost, EmbeddingSpot} {
+		if err := s.syncType(ctx, ownerType); err != nil {
+			log.Printf("⚠️ 计算 %s 向量失败: %v", ownerType, err)
+		}
+	}
+	s.purgeOrphans()
+}
+
+// syncType 分批处理某类对象，每次最多处理 100 批，剩余的留到下一轮
+func (s *EmbeddingService) syncType(ctx context.Context, ownerType string) error {
+	for round := 0; round < 100; round++ {
+		docs := s.staleDocuments(ownerType)
+		if len(docs) == 0 {
+			return nil
+		}
+		if err := s.embedDocuments(ctx, ownerType, docs); err != nil {
+			return err
+		}
+		if len(docs) < s.batchSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// staleDocuments 查询没有当前模型向量、或在向量计算之后修改过的对象
+func (s *EmbeddingService) staleDocuments(ownerType string) []embeddingDocument {
+	model := s.embedder.Model()
+	var docs []embeddingDocument
+
+	switch ownerType {
+	case EmbeddingPost:
+		var posts []models.Post
+		models.DB.Joins("LEFT JOIN embeddings ON embeddings.owner_type = ? AND embeddings.owner_id = posts.id AND embeddings.model = ?", EmbeddingPost, model).
+			Where("embeddings.id IS NULL OR embeddings.updated_at < posts.updated_at").
+			Order("posts.id").Limit(s.batchSize).Find(&posts)
+		for _, post := range posts {
+			docs = append(docs, embeddingDocument{ID: post.ID, Text: postEmbeddingText(&post)})
+		}
+	case EmbeddingSpot:
+		var spots []models.Spot
+		models.DB.Joins("LEFT JOIN embeddings ON embeddings.owner_type = ? AND embeddings.owner_id = spots.id AND embeddings.model = ?", EmbeddingSpot, model).
+			Where("embeddings.id IS NULL OR embeddings.updated_at < spots.updated_at").
+			Order("spots.id").Limit(s.batchSize).Find(&spots)
+		for _, spot := range spots {
+			docs = append(docs, embeddingDocument{ID: spot.ID, Text: spotEmbeddingText(&spot)})
+		}
+	}
+	return docs
+}
+
+// embedDocuments 计算一批文本的向量并保存，文本未变化的只刷新时间
+func (s *EmbeddingService) embedDocuments(ctx context.Context, ownerType string, docs []embeddingDocument) error {
+	model := s.embedder.Model()
+	ids := make([]uint, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	var existing []models.Embedding
+	models.DB.Where("owner_type = ? AND owner_id IN ?", ownerType, ids).Find(&existing)
+	existingByOwner := make(map[uint]models.Embedding, len(existing))
+	for _, row := range existing {
+		existingByOwner[row.OwnerID] = row
+	}
+
+	var pending []embeddingDocument
+	var hashes []string
+	for _, doc := range docs {
+		hash := contentHash(doc.Text)
+		if row, ok := existingByOwner[doc.ID]; ok && row.Model == model && row.ContentHash == hash {
+			models.DB.Model(&models.Embedding{}).Where("id = ?", row.ID).Update("updated_at", time.Now())
+			continue
+		}
+		pending = append(pending, doc)
+		hashes = append(hashes, hash)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	texts := make([]string, len(pending))
+	for i, doc := range pending {
+		texts[i] = doc.Text
+	}
+	vectors, err := s.embedder.Embed(ctx, texts)
+	if err != nil {
+		return err
+	}
+	if len(vectors) != len(pending) {
+		return errors.New("向量数量与文本数量不一致")
+	}
+
+	rows := make([]models.Embedding, len(pending))
+	for i, doc := range pending {
+		rows[i] = models.Embedding{
+			OwnerType:   ownerType,
+			OwnerID:     doc.ID,
+			Model:       model,
+			Dimensions:  len(vectors[i]),
+			Vector:      encodeVector(vectors[i]),
+			ContentHash: hashes[i],
+		}
+	}
+	err = models.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "owner_type"}, {Name: "owner_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"model", "dimensions", "vector", "content_hash", "updated_at"}),
+	}).Create(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for i, doc := range pending {
+		if !indexEmbedding(ownerType, doc.ID, vectors[i]) {
+			embeddingIndexes[ownerType].Remove(doc.ID)
+		}
+	}
+	return nil
+}
+
+// purgeOrphans 删除已删除的帖子和打卡点的向量
+func (s *EmbeddingService) purgeOrphans() {
+	owners := map[string]*gorm.DB{
+		EmbeddingPost: models.DB.Model(&models.Post{}).Select("id"),
+		EmbeddingSpot: models.DB.Model(&models.Spot{}).Select("id"),
+	}
+	for ownerType, live := range owners {
+		var orphans []models.Embedding
+		models.DB.Select("id", "owner_id").Where("owner_type = ? AND owner_id NOT IN (?)", ownerType, live).Find(&orphans)
+		if len(orphans) == 0 {
+			continue
+		}
+		ids := make([]uint, len(orphans))
+		for i, orphan := range orphans {
+			ids[i] = orphan.ID
+			embeddingIndexes[ownerType].Remove(orphan.OwnerID)
+		}
+		models.DB.Where("id IN ?", ids).Delete(&models.Embedding{})
+	}
+}
+
+// indexEmbedding 把向量加入索引，零向量或维度不一致时返回 false
+func indexEmbedding(ownerType string, ownerID uint, vector []float32) bool {
+	index, ok := embeddingIndexes[ownerType]
+	if !ok {
+		return false
+	}
+	if err := index.Add(ownerID, vector); err != nil {
+		if !errors.Is(err, vectorindex.ErrZeroVector) {
+			log.Printf("⚠️ %s %d 的向量无法加入索引: %v", ownerType, ownerID, err)
+		}
+		return false
+	}
+	return true
+}
+
+// postEmbeddingText 帖子参与计算向量的文本
+func postEmbeddingText(post *models.Post) string {
+	return joinEmbeddingText(post.Title, post.LocationName, post.Content)
+}
+
+// spotEmbeddingText 打卡点参与计算向量的文本
+func spotEmbeddingText(spot *models.Spot) string {
+	return joinEmbeddingText(spot.Name, spot.Category, spot.City, spot.Address, spot.Description)
+}
+
+// joinEmbeddingText 拼接非空字段并限制长度
+func joinEmbeddingText(fields ...string) string {
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			parts = append(parts, field)
+		}
+	}
+	return TruncateRunes(strings.Join(parts, "\n"), embeddingTextRunes)
+}
+
+// contentHash 文本的 SHA-256
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeVector 把向量编码为 float32 小端序字节
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, len(vector)*4)
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	return data
+}
+
+// decodeVector 解码 encodeVector 生成的字节
+func decodeVector(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return vector
+}
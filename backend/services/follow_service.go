@@ -0,0 +1,73 @@
+package services
+
+import (
+	"errors"
+	"tapspot/models"
+)
+
+// FollowService 关注服务
+type FollowService struct{}
+
+// NewFollowService 创建关注服务实例
+func NewFollowService() *FollowService {
+	return &FollowService{}
+}
+
+// Follow 关注用户（重复关注视为成功）
+func (s *FollowService) Follow(followerID, followingID uint) error {
+	if followerID == followingID {
+		return errors.New("不能关注自己")
+	}
+
+	var target models.User
+	if err := models.DB.First(&target, followingID).Error; err != nil {
+		return errors.New("用户不存在")
+	}
+	if IsBlocked(followerID, followingID) {
+		return errors.New("对方已设置屏蔽，无法关注")
+	}
+
+	if IsFollowing(followerID, followingID) {
+		return nil
+	}
+	if err := models.DB.Create(&models.Follow{FollowerID: followerID, FollowingID: followingID}).Error; err != nil {
+		return errors.New("关注失败，请稍后重试")
+	}
+	return nil
+}
+
+// Unfollow 取消关注
+func (s *FollowService) Unfollow(followerID, followingID uint) error {
+	result := models.DB.Where("follower_id = ? AND following_id = ?", followerID, followingID).Delete(&models.Follow{})
+	if result.Error != nil {
+		return errors.New("取消关注失败")
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("未关注该用户")
+	}
+	return nil
+}
+
+// IsFollowing 判断 followerID 是否关注了 followingID
+func IsFollowing(followerID, followingID uint) bool {
+	if followerID == 0 || followingID == 0 {
+		return false
+	}
+	var count int64
+	models.DB.Model(&models.Follow{}).
+		Where("follower_id = ? AND following_id = ?", followerID, followingID).
+		Count(&count)
+	return count > 0
+}
+
+// IsMutualFollow 两个用户是否互相关注
+func IsMutualFollow(a, b uint) bool {
+	return IsFollowing(a, b) && IsFollowing(b, a)
+}
+
+// FollowCounts 统计粉丝数和关注数
+func FollowCounts(userID uint) (followers int64, following int64) {
+	models.DB.Model(&models.Follow{}).Where("following_id = ?", userID).Count(&followers)
+	models.DB.Model(&models.Follow{}).Where("follower_id = ?", userID).Count(&following)
+	return followers, following
+}
@@ -0,0 +1,35 @@
+package services
+
+import "math"
+
+// earthRadiusKm 地球平均半径（公里）
+const earthRadiusKm = 6371.0088
+
+// DistanceKm 计算两点间的大圆距离（Haversine 公式，单位公里）
+func DistanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	rad1 := lat1 * math.Pi / 180
+	rad2 := lat2 * math.Pi / 180
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad1)*math.Cos(rad2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// BoundingBox 返回包含以 (lat, lng) 为圆心、radiusKm 为半径的圆的经纬度范围，用于在数据库中预筛选
+func BoundingBox(lat, lng, radiusKm float64) (minLat, maxLat, minLng, maxLng float64) {
+	dLat := radiusKm / earthRadiusKm * 180 / math.Pi
+	minLat, maxLat = lat-dLat, lat+dLat
+
+	// 靠近两极时不再按经度筛选
+	cosLat := math.Cos(lat * math.Pi / 180)
+	if maxLat >= 90 || minLat <= -90 || cosLat < 1e-6 {
+		return math.Max(minLat, -90), math.Min(maxLat, 90), -180, 180
+	}
+	dLng := dLat / cosLat
+	// 跨越 180° 经线时不再按经度筛选
+	if lng-dLng < -180 || lng+dLng > 180 {
+		return minLat, maxLat, -180, 180
+	}
+	return minLat, maxLat, lng - dLng, lng + dLng
+}
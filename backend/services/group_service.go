@@ -0,0 +1,443 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tapspot/dto"
+	"tapspot/filter"
+	"tapspot/models"
+
+	"gorm.io/gorm"
+)
+
+// 群成员角色
+const (
+	GroupRoleOwner  = "owner"
+	GroupRoleAdmin  = "admin"
+	GroupRoleMember = "member"
+)
+
+// 群聊操作失败的类型，用 errors.Is 判断；无权限沿用 ErrMessageForbidden
+var (
+	ErrGroupNotFound = errors.New("群聊不存在")
+)
+
+// GroupService 群聊：创建、成员管理、群消息和已读位置
+type GroupService struct {
+	maxMembers int
+}
+
+// NewGroupService 创建群聊服务实例
+// 群成员上限由 GROUP_MAX_MEMBERS 配置（默认 200）
+func NewGroupService() *GroupService {
+	maxMembers := 200
+	if v, err := strconv.Atoi(os.Getenv("GROUP_MAX_MEMBERS")); err == nil && v > 1 {
+		maxMembers = v
+	}
+	return &GroupService{maxMembers: maxMembers}
+}
+
+// Create 创建群聊，创建者成为群主；无法邀请的用户（不存在、屏蔽、拒收私信）会被跳过
+// 返回群聊详情和实际加入的成员
+func (s *GroupService) Create(ownerID uint, name string, memberIDs []uint) (*dto.GroupDetail, []uint, error) {
+	name, err := s.checkName(ownerID, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	invitees := s.invitableUsers(ownerID, 0, memberIDs)
+	if len(invitees)+1 > s.maxMembers {
+		return nil, nil, &messageError{ErrInvalidMessage, fmt.Sprintf("群成员不能超过%d人", s.maxMembers)}
+	}
+
+	now := time.Now()
+	group := models.GroupChat{Name: name, OwnerID: ownerID, LastMsgTime: now}
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		members := []models.GroupMember{{GroupID: group.ID, UserID: ownerID, Role: GroupRoleOwner}}
+		for _, userID := range invitees {
+			members = append(members, models.GroupMember{GroupID: group.ID, UserID: userID, Role: GroupRoleMember})
+		}
+		return tx.Create(&members).Error
+	})
+	if err != nil {
+		return nil, nil, errors.New("创建群聊失败")
+	}
+
+	detail, err := s.Detail(ownerID, group.ID)
+	return detail, invitees, err
+}
+
+// List 用户加入的群聊，按最后消息时间倒序
+func (s *GroupService) List(userID uint) ([]dto.GroupSummary, error) {
+	var memberships []models.GroupMember
+	if err := models.DB.Where("user_id = ?", userID).Find(&memberships).Error; err != nil {
+		return nil, errors.New("获取群聊失败")
+	}
+	result := []dto.GroupSummary{}
+	if len(memberships) == 0 {
+		return result, nil
+	}
+
+	groupIDs := make([]uint, 0, len(memberships))
+	byGroup := make(map[uint]models.GroupMember, len(memberships))
+	for _, m := range memberships {
+		groupIDs = append(groupIDs, m.GroupID)
+		byGroup[m.GroupID] = m
+	}
+
+	var groups []models.GroupChat
+	models.DB.Where("id IN ?", groupIDs).Order("last_msg_time DESC").Find(&groups)
+	counts := memberCounts(groupIDs)
+	for _, group := range groups {
+		result = append(result, groupSummary(&group, byGroup[group.ID], counts[group.ID]))
+	}
+	return result, nil
+}
+
+// Detail 群聊详情和成员列表，只有成员可以查看
+func (s *GroupService) Detail(userID, groupID uint) (*dto.GroupDetail, error) {
+	group, member, err := s.membership(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []models.GroupMember
+	models.DB.Preload("User").Where("group_id = ?", groupID).Order("id ASC").Find(&members)
+	// 群主、管理员在前，其余按入群顺序
+	rank := map[string]int{GroupRoleOwner: 0, GroupRoleAdmin: 1, GroupRoleMember: 2}
+	sort.SliceStable(members, func(i, j int) bool { return rank[members[i].Role] < rank[members[j].Role] })
+
+	detail := &dto.GroupDetail{
+		GroupSummary: groupSummary(group, *member, len(members)),
+		Members:      make([]dto.GroupMemberInfo, 0, len(members)),
+	}
+	for _, m := range members {
+		nickname := m.User.Nickname
+		if nickname == "" {
+			nickname = m.User.Username
+		}
+		detail.Members = append(detail.Members, dto.GroupMemberInfo{
+			UserID:     m.UserID,
+			Nickname:   nickname,
+			Avatar:     m.User.Avatar,
+			Role:       m.Role,
+			LastReadID: m.LastReadID,
+			JoinedAt:   m.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return detail, nil
+}
+
+// Invite 群主或管理员邀请成员，已在群中或无法邀请的用户会被跳过
+// 新成员只能看到入群之后的消息，返回实际加入的成员
+func (s *GroupService) Invite(actorID, groupID uint, userIDs []uint) ([]uint, error) {
+	_, actor, err := s.membership(groupID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if actor.Role == GroupRoleMember {
+		return nil, &messageError{ErrMessageForbidden, "只有群主和管理员可以邀请成员"}
+	}
+
+	invitees := s.invitableUsers(actorID, groupID, userIDs)
+	if len(invitees) == 0 {
+		return nil, &messageError{ErrInvalidMessage, "没有可以邀请的用户"}
+	}
+	var count int64
+	models.DB.Model(&models.GroupMember{}).Where("group_id = ?", groupID).Count(&count)
+	if int(count)+len(invitees) > s.maxMembers {
+		return nil, &messageError{ErrInvalidMessage, fmt.Sprintf("群成员不能超过%d人", s.maxMembers)}
+	}
+
+	var lastID uint
+	models.DB.Model(&models.Message{}).Where("group_id = ?", groupID).Select("COALESCE(MAX(id), 0)").Scan(&lastID)
+	members := make([]models.GroupMember, 0, len(invitees))
+	for _, userID := range invitees {
+		members = append(members, models.GroupMember{
+			GroupID:       groupID,
+			UserID:        userID,
+			Role:          GroupRoleMember,
+			LastReadID:    lastID,
+			JoinedAfterID: lastID,
+		})
+	}
+	if err := models.DB.Create(&members).Error; err != nil {
+		return nil, errors.New("邀请失败，请稍后重试")
+	}
+	return invitees, nil
+}
+
+// Kick 移出成员：群主可以移出任何人，管理员只能移出普通成员
+func (s *GroupService) Kick(actorID, groupID, targetID uint) error {
+	_, actor, err := s.membership(groupID, actorID)
+	if err != nil {
+		return err
+	}
+	if actorID == targetID {
+		return &messageError{ErrInvalidMessage, "不能移出自己，请使用退出群聊"}
+	}
+	var target models.GroupMember
+	if err := models.DB.Where("group_id = ? AND user_id = ?", groupID, targetID).First(&target).Error; err != nil {
+		return &messageError{ErrGroupNotFound, "该用户不是群成员"}
+	}
+	if actor.Role == GroupRoleMember || (actor.Role == GroupRoleAdmin && target.Role != GroupRoleMember) {
+		return &messageError{ErrMessageForbidden, "没有权限移出该成员"}
+	}
+	if err := models.DB.Delete(&target).Error; err != nil {
+		return errors.New("移出失败，请稍后重试")
+	}
+	return nil
+}
+
+// Leave 退出群聊；群主退出时转让给最早的管理员，没有管理员时转让给最早入群的成员，最后一人退出时解散群聊
+// 返回新群主，没有转让时为 0
+func (s *GroupService) Leave(userID, groupID uint) (uint, error) {
+	group, member, err := s.membership(groupID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var newOwnerID uint
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		newOwnerID, err = leaveGroup(tx, group, member)
+		return err
+	})
+	if err != nil {
+		return 0, errors.New("退出失败，请稍后重试")
+	}
+	return newOwnerID, nil
+}
+
+// leaveGroup 在事务中删除成员；群主退出时转让给最早的管理员或成员，没有其他成员时解散群
+// 返回新群主的 ID，没有转让时为 0
+func leaveGroup(tx *gorm.DB, group *models.GroupChat, member *models.GroupMember) (uint, error) {
+	if err := tx.Delete(member).Error; err != nil {
+		return 0, err
+	}
+	if member.Role != GroupRoleOwner {
+		return 0, nil
+	}
+	var successor models.GroupMember
+	tx.Where("group_id = ?", group.ID).
+		Order("CASE WHEN role = '" + GroupRoleAdmin + "' THEN 0 ELSE 1 END, id ASC").
+		Limit(1).Find(&successor)
+	if successor.ID == 0 {
+		return 0, tx.Delete(group).Error
+	}
+	if err := tx.Model(&successor).Update("role", GroupRoleOwner).Error; err != nil {
+		return 0, err
+	}
+	return successor.UserID, tx.Model(group).Update("owner_id", successor.UserID).Error
+}
+
+// SetRole 群主设置成员为管理员或普通成员
+func (s *GroupService) SetRole(actorID, groupID, targetID uint, role string) error {
+	_, actor, err := s.membership(groupID, actorID)
+	if err != nil {
+		return err
+	}
+	if actor.Role != GroupRoleOwner {
+		return &messageError{ErrMessageForbidden, "只有群主可以设置管理员"}
+	}
+	if role != GroupRoleAdmin && role != GroupRoleMember {
+		return &messageError{ErrInvalidMessage, "角色只能是 admin 或 member"}
+	}
+	var target models.GroupMember
+	if err := models.DB.Where("group_id = ? AND user_id = ?", groupID, targetID).First(&target).Error; err != nil {
+		return &messageError{ErrGroupNotFound, "该用户不是群成员"}
+	}
+	if target.Role == GroupRoleOwner {
+		return &messageError{ErrInvalidMessage, "不能修改群主的角色"}
+	}
+	if err := models.DB.Model(&target).Update("role", role).Error; err != nil {
+		return errors.New("设置失败，请稍后重试")
+	}
+	return nil
+}
+
+// Messages 群消息历史（按时间正序），只包含入群之后的消息；隐身封禁用户的消息只有自己能看到
+// afterID 大于 0 时只返回更新的消息，用于轮询
+func (s *GroupService) Messages(userID, groupID uint, page, pageSize int, afterID uint) ([]dto.GroupMessage, error) {
+	_, member, err := s.membership(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := models.DB.Preload("Sender").
+		Where("group_id = ? AND id > ?", groupID, member.JoinedAfterID).
+		Where("(shadowed = ? OR sender_id = ?)", false, userID)
+	if afterID > 0 {
+		query = query.Where("id > ?", afterID)
+	}
+	var messages []models.Message
+	query.Order("id DESC").Limit(pageSize).Offset((page - 1) * pageSize).Find(&messages)
+
+	result := make([]dto.GroupMessage, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		senderName := msg.Sender.Nickname
+		if senderName == "" {
+			senderName = msg.Sender.Username
+		}
+		result = append(result, dto.GroupMessage{
+			ID:           msg.ID,
+			GroupID:      groupID,
+			SenderID:     msg.SenderID,
+			SenderName:   senderName,
+			SenderAvatar: msg.Sender.Avatar,
+			Content:      msg.Content,
+			IsMe:         msg.SenderID == userID,
+			CreatedAt:    msg.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result, nil
+}
+
+// MarkRead 把群消息全部标记为已读并清零成员的未读数
+// 返回已读到的最后一条消息 ID，advanced 表示已读位置是否前进
+func (s *GroupService) MarkRead(userID, groupID uint) (lastReadID uint, advanced bool, err error) {
+	_, member, err := s.membership(groupID, userID)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := models.DB.Model(&models.Message{}).
+		Where("group_id = ? AND (shadowed = ? OR sender_id = ?)", groupID, false, userID).
+		Select("COALESCE(MAX(id), 0)").Scan(&lastReadID).Error; err != nil {
+		return 0, false, err
+	}
+	if lastReadID < member.LastReadID {
+		lastReadID = member.LastReadID
+	}
+	err = models.DB.Model(&models.GroupMember{}).
+		Where("id = ?", member.ID).
+		Updates(map[string]interface{}{
+			"unread_count": 0,
+			"last_read_id": gorm.Expr("GREATEST(last_read_id, ?)", lastReadID),
+		}).Error
+	return lastReadID, lastReadID > member.LastReadID, err
+}
+
+// GroupMemberIDs 群内所有成员的用户 ID
+func GroupMemberIDs(groupID uint) []uint {
+	var ids []uint
+	models.DB.Model(&models.GroupMember{}).Where("group_id = ?", groupID).Pluck("user_id", &ids)
+	return ids
+}
+
+// IsGroupMember 判断用户是否在群中
+func IsGroupMember(groupID, userID uint) bool {
+	var count int64
+	models.DB.Model(&models.GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Count(&count)
+	return count > 0
+}
+
+// GroupUnreadCount 用户所有群聊的未读消息数
+func GroupUnreadCount(userID uint) int64 {
+	var total int64
+	models.DB.Model(&models.GroupMember{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(unread_count), 0)").Scan(&total)
+	return total
+}
+
+// membership 查找群聊和用户的成员记录，非成员视为群聊不存在
+func (s *GroupService) membership(groupID, userID uint) (*models.GroupChat, *models.GroupMember, error) {
+	var group models.GroupChat
+	if err := models.DB.First(&group, groupID).Error; err != nil {
+		return nil, nil, ErrGroupNotFound
+	}
+	var member models.GroupMember
+	if err := models.DB.Where("group_id = ? AND user_id = ?", groupID, userID).First(&member).Error; err != nil {
+		return nil, nil, ErrGroupNotFound
+	}
+	return &group, &member, nil
+}
+
+// checkName 校验群名称并做内容过滤
+func (s *GroupService) checkName(userID uint, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > 50 {
+		return "", &messageError{ErrInvalidMessage, "群名称长度必须在1-50字符之间"}
+	}
+	result := filter.Check(filter.SurfaceMessage, userID, name)
+	if result.Action == filter.ActionReject {
+		return "", &messageError{ErrInvalidMessage, "群名称未通过审核：" + result.Reason()}
+	}
+	return result.Text, nil
+}
+
+// invitableUsers 过滤出可以被邀请的用户：存在、不是自己、不在群中、没有屏蔽关系且允许邀请者发送私信
+func (s *GroupService) invitableUsers(actorID, groupID uint, userIDs []uint) []uint {
+	seen := map[uint]bool{actorID: true, 0: true}
+	var candidates []uint
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			candidates = append(candidates, id)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	existing := map[uint]bool{}
+	if groupID != 0 {
+		for _, id := range GroupMemberIDs(groupID) {
+			existing[id] = true
+		}
+	}
+	var users []models.User
+	models.DB.Where("id IN ?", candidates).Find(&users)
+	byID := make(map[uint]*models.User, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i]
+	}
+
+	var result []uint
+	for _, id := range candidates {
+		user, ok := byID[id]
+		if !ok || existing[id] || IsBlocked(actorID, id) || CanMessage(actorID, user) != nil {
+			continue
+		}
+		result = append(result, id)
+	}
+	return result
+}
+
+// memberCounts 各群的成员数
+func memberCounts(groupIDs []uint) map[uint]int {
+	var rows []struct {
+		GroupID uint
+		Count   int
+	}
+	models.DB.Model(&models.GroupMember{}).Select("group_id, COUNT(*) AS count").
+		Where("group_id IN ?", groupIDs).Group("group_id").Scan(&rows)
+	counts := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		counts[row.GroupID] = row.Count
+	}
+	return counts
+}
+
+// groupSummary 组装群聊列表项
+func groupSummary(group *models.GroupChat, member models.GroupMember, memberCount int) dto.GroupSummary {
+	return dto.GroupSummary{
+		ID:          group.ID,
+		Name:        group.Name,
+		OwnerID:     group.OwnerID,
+		Role:        member.Role,
+		MemberCount: memberCount,
+		UnreadCount: member.UnreadCount,
+		LastReadID:  member.LastReadID,
+		LastMessage: group.LastMessage,
+		LastMsgTime: group.LastMsgTime.Format("2006-01-02 15:04:05"),
+		CreatedAt:   group.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
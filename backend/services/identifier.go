@@ -0,0 +1,86 @@
+package services
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// IdentifierType 登录账号类型
+type IdentifierType string
+
+const (
+	IdentifierUsername IdentifierType = "username"
+	IdentifierEmail    IdentifierType = "email"
+	IdentifierPhone    IdentifierType = "phone"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	// 允许用户输入空格、横线、括号等分隔符
+	phoneInputPattern = regexp.MustCompile(`^\+?[0-9\s\-()]{6,20}$`)
+	e164Pattern       = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	cnMobilePattern   = regexp.MustCompile(`^1[3-9][0-9]{9}$`)
+)
+
+// DetectIdentifier 自动识别账号类型，并返回规范化后的值
+func DetectIdentifier(raw string) (IdentifierType, string) {
+	raw = strings.TrimSpace(raw)
+
+	if emailPattern.MatchString(raw) {
+		return IdentifierEmail, NormalizeEmail(raw)
+	}
+	if phoneInputPattern.MatchString(raw) {
+		if phone, ok := NormalizePhone(raw); ok {
+			return IdentifierPhone, phone
+		}
+	}
+	return IdentifierUsername, raw
+}
+
+// NormalizeEmail 规范化邮箱（去空格、转小写）
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhone 把手机号规范化为 E.164 格式（如 +8613800138000）
+// 未带国家码的号码使用 DEFAULT_PHONE_REGION 指定的国家码（默认 86）
+func NormalizePhone(phone string) (string, bool) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')':
+			// 忽略分隔符
+		default:
+			return "", false
+		}
+	}
+
+	digits := b.String()
+	switch {
+	case strings.HasPrefix(digits, "+"):
+	case strings.HasPrefix(digits, "00"):
+		digits = "+" + digits[2:]
+	case cnMobilePattern.MatchString(digits) || defaultPhoneRegion() != "86":
+		digits = "+" + defaultPhoneRegion() + strings.TrimPrefix(digits, "0")
+	default:
+		return "", false
+	}
+
+	if !e164Pattern.MatchString(digits) {
+		return "", false
+	}
+	return digits, true
+}
+
+// defaultPhoneRegion 默认国家码
+func defaultPhoneRegion() string {
+	if region := os.Getenv("DEFAULT_PHONE_REGION"); region != "" {
+		return strings.TrimPrefix(region, "+")
+	}
+	return "86"
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"tapspot/dto"
+	"tapspot/llm"
+	"tapspot/models"
+)
+
+// LLMUsageRecorder 把大模型调用用量异步写入数据库
+type LLMUsageRecorder struct {
+	records chan models.LLMUsage
+}
+
+// NewLLMUsageRecorder 创建用量记录器并启动写入协程
+func NewLLMUsageRecorder() *LLMUsageRecorder {
+	r := &LLMUsageRecorder{records: make(chan models.LLMUsage, 256)}
+	go r.run()
+	return r
+}
+
+// RecordUsage 实现 llm.UsageRecorder，队列已满时丢弃记录，不阻塞请求
+func (r *LLMUsageRecorder) RecordUsage(record llm.UsageRecord) {
+	usage := models.LLMUsage{
+		UserID:           record.UserID,
+		Purpose:          record.Purpose,
+		Model:            record.Model,
+		PromptTokens:     record.Usage.PromptTokens,
+		CompletionTokens: record.Usage.CompletionTokens,
+		TotalTokens:      record.Usage.TotalTokens,
+		LatencyMs:        record.Latency.Milliseconds(),
+		Success:          record.Err == nil,
+	}
+	if record.Err != nil {
+		usage.Error = TruncateRunes(record.Err.Error(), 255)
+	}
+
+	select {
+	case r.records <- usage:
+	default:
+		log.Printf("⚠️ 大模型用量记录队列已满，丢弃一条记录（%s）", record.Purpose)
+	}
+}
+
+// run 逐条写入用量记录
+func (r *LLMUsageRecorder) run() {
+	for usage := range r.records {
+		if err := models.DB.Create(&usage).Error; err != nil {
+			log.Printf("⚠️ 保存大模型用量失败: %v", err)
+		}
+	}
+}
+
+// LLMUsageSummary 统计指定时间之后的大模型用量
+func LLMUsageSummary(since time.Time) ([]dto.LLMUsageSummary, error) {
+	var summary []dto.LLMUsageSummary
+	err := models.DB.Model(&models.LLMUsage{}).
+		Select(`purpose, model, COUNT(*) AS calls,
+			SUM(CASE WHEN success THEN 0 ELSE 1 END) AS failures,
+			SUM(prompt_tokens) AS prompt_tokens,
+			SUM(completion_tokens) AS completion_tokens,
+			SUM(total_tokens) AS total_tokens,
+			CAST(AVG(latency_ms) AS SIGNED) AS avg_latency_ms`).
+		Where("created_at >= ?", since).
+		Group("purpose, model").
+		Order("total_tokens DESC").
+		Scan(&summary).Error
+	return summary, err
+}
+
+// TruncateRunes 按字符截断字符串，不会截断半个汉字
+func TruncateRunes(s string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	for i := range s {
+		if max == 0 {
+			return s[:i]
+		}
+		max--
+	}
+	return s
+}
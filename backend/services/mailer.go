@@ -0,0 +1,89 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Mailer 邮件发送接口（可替换为任意邮件服务实现）
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer 仅把邮件内容打印到日志（未配置 SMTP 时使用）
+// ShowSecrets 为 false 时隐藏链接中的令牌，避免有日志权限的人借此接管账号
+type LogMailer struct {
+	ShowSecrets bool
+}
+
+// secretParamPattern 邮件链接中的令牌参数
+var secretParamPattern = regexp.MustCompile(`(token=)[^\s&]+`)
+
+// Send 打印邮件内容
+func (m *LogMailer) Send(to, subject, body string) error {
+	if !m.ShowSecrets {
+		body = secretParamPattern.ReplaceAllString(body, "${1}[已隐藏]")
+	}
+	log.Printf("📧 [LogMailer] to=%s subject=%s\n%s", to, subject, body)
+	return nil
+}
+
+// SMTPMailer 通过 SMTP 发送邮件
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send 发送纯文本邮件
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	addr := fmt.Sprintf("%s:%s", m.Host, m.Port)
+	return smtp.SendMail(addr, auth, m.From, []string{to}, []byte(msg))
+}
+
+// NewMailerFromEnv 根据环境变量创建邮件发送器
+// 配置了 SMTP_HOST 时使用 SMTP，否则退化为日志输出（仅开发模式下输出令牌）
+func NewMailerFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &LogMailer{ShowSecrets: DevMode()}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_USERNAME")
+	}
+
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
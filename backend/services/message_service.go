@@ -0,0 +1,300 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"tapspot/filter"
+	"tapspot/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 私信的长度限制
+const (
+	MaxMessageRunes      = 1000
+	MaxClientMsgIDLength = 64
+)
+
+// 发送私信失败的类型，用 errors.Is 判断；错误信息可以直接展示给用户
+var (
+	ErrInvalidMessage    = errors.New("消息内容不正确")
+	ErrReceiverNotFound  = errors.New("接收者不存在")
+	ErrMessageForbidden  = errors.New("无法给对方发送消息")
+	ErrMessageSaveFailed = errors.New("发送失败")
+)
+
+// messageError 带有类型的发送错误，Error() 返回具体原因
+type messageError struct {
+	kind error
+	msg  string
+}
+
+func (e *messageError) Error() string        { return e.msg }
+func (e *messageError) Is(target error) bool { return target == e.kind }
+
+// SendMessageInput 发送私信的参数
+type SendMessageInput struct {
+	SenderID    uint
+	ReceiverID  uint
+	GroupID     uint // 发到群聊时不为 0，此时忽略 ReceiverID
+	Content     string
+	PostID      *uint
+	ClientMsgID string // 客户端生成的消息 ID，重发时去重，可为空
+}
+
+// SentMessage 已保存的私信
+type SentMessage struct {
+	Message            models.Message
+	SenderName         string
+	ConversationID     uint   // 发送者的会话 ID
+	PeerConversationID uint   // 接收者的会话 ID，消息被隐身时为 0
+	GroupID            uint   // 群消息所属的群，私信为 0
+	MemberIDs          []uint // 群消息发送时的群成员（包括发送者）
+	Duplicate          bool   // 相同 client_msg_id 的消息已经保存过，本次没有重复保存
+}
+
+// MessageService 私信和群消息发送：校验、保存消息和更新会话，HTTP 和 WebSocket 共用
+type MessageService struct{}
+
+// NewMessageService 创建私信服务
+func NewMessageService() *MessageService {
+	return &MessageService{}
+}
+
+// Send 校验并保存私信，在同一个事务中更新双方会话
+// 发送者处于隐身封禁时消息只有自己能看到，不更新接收者的会话；推送由调用方完成
+func (s *MessageService) Send(in SendMessageInput) (*SentMessage, error) {
+	if len(in.ClientMsgID) > MaxClientMsgIDLength {
+		return nil, &messageError{ErrInvalidMessage, "client_msg_id 过长"}
+	}
+	if in.ClientMsgID != "" {
+		if sent, err := s.findByClientMsgID(in); sent != nil || err != nil {
+			return sent, err
+		}
+	}
+
+	if strings.TrimSpace(in.Content) == "" || utf8.RuneCountInString(in.Content) > MaxMessageRunes {
+		return nil, &messageError{ErrInvalidMessage, "消息内容长度必须在1-1000字符之间"}
+	}
+	if in.GroupID != 0 {
+		return s.sendToGroup(in)
+	}
+	if in.ReceiverID == in.SenderID {
+		return nil, &messageError{ErrInvalidMessage, "不能给自己发送消息"}
+	}
+	var receiver models.User
+	if err := models.DB.First(&receiver, in.ReceiverID).Error; err != nil {
+		return nil, ErrReceiverNotFound
+	}
+	// 存在屏蔽关系时不能发送
+	if IsBlocked(in.SenderID, in.ReceiverID) {
+		return nil, &messageError{ErrMessageForbidden, "对方已设置屏蔽，无法发送消息"}
+	}
+	// 接收者的私信权限设置
+	if err := CanMessage(in.SenderID, &receiver); err != nil {
+		return nil, &messageError{ErrMessageForbidden, err.Error()}
+	}
+	// 内容过滤
+	result := filter.Check(filter.SurfaceMessage, in.SenderID, in.Content)
+	if result.Action == filter.ActionReject {
+		return nil, &messageError{ErrInvalidMessage, "内容未通过审核：" + result.Reason()}
+	}
+
+	sent := &SentMessage{
+		Message: models.Message{
+			SenderID:   in.SenderID,
+			ReceiverID: &in.ReceiverID,
+			Content:    result.Text,
+			PostID:     in.PostID,
+			IsRead:     false,
+			Shadowed:   IsShadowBanned(in.SenderID),
+		},
+	}
+	if in.ClientMsgID != "" {
+		sent.Message.ClientMsgID = &in.ClientMsgID
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sent.Message).Error; err != nil {
+			return err
+		}
+		var err error
+		sent.ConversationID, sent.PeerConversationID, err = updateConversations(tx, &sent.Message)
+		return err
+	})
+	if err != nil {
+		// 同一条消息并发重发时唯一索引冲突，返回已保存的消息
+		if in.ClientMsgID != "" {
+			if existing, err := s.findByClientMsgID(in); existing != nil || err != nil {
+				return existing, err
+			}
+		}
+		return nil, ErrMessageSaveFailed
+	}
+	result.Accept()
+
+	sent.SenderName = DisplayName(in.SenderID)
+	return sent, nil
+}
+
+// sendToGroup 校验并保存群消息，在同一个事务中更新群的最后消息和其他成员的未读数
+// 发送者处于隐身封禁时消息只有自己能看到，不更新群和其他成员
+func (s *MessageService) sendToGroup(in SendMessageInput) (*SentMessage, error) {
+	if !IsGroupMember(in.GroupID, in.SenderID) {
+		return nil, &messageError{ErrMessageForbidden, "你不是该群成员，无法发送消息"}
+	}
+	result := filter.Check(filter.SurfaceMessage, in.SenderID, in.Content)
+	if result.Action == filter.ActionReject {
+		return nil, &messageError{ErrInvalidMessage, "内容未通过审核：" + result.Reason()}
+	}
+
+	groupID := in.GroupID
+	sent := &SentMessage{
+		Message: models.Message{
+			SenderID: in.SenderID,
+			GroupID:  &groupID,
+			Content:  result.Text,
+			PostID:   in.PostID,
+			Shadowed: IsShadowBanned(in.SenderID),
+		},
+		GroupID: groupID,
+	}
+	if in.ClientMsgID != "" {
+		sent.Message.ClientMsgID = &in.ClientMsgID
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sent.Message).Error; err != nil {
+			return err
+		}
+		// 自己发的消息视为已读
+		if err := tx.Model(&models.GroupMember{}).
+			Where("group_id = ? AND user_id = ?", groupID, in.SenderID).
+			Update("last_read_id", sent.Message.ID).Error; err != nil {
+			return err
+		}
+		if sent.Message.Shadowed {
+			return nil
+		}
+		if err := tx.Model(&models.GroupChat{}).Where("id = ?", groupID).Updates(map[string]interface{}{
+			"last_message":  sent.Message.Content,
+			"last_msg_time": sent.Message.CreatedAt,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.GroupMember{}).
+			Where("group_id = ? AND user_id <> ?", groupID, in.SenderID).
+			Update("unread_count", gorm.Expr("unread_count + ?", 1)).Error
+	})
+	if err != nil {
+		if in.ClientMsgID != "" {
+			if existing, err := s.findByClientMsgID(in); existing != nil || err != nil {
+				return existing, err
+			}
+		}
+		return nil, ErrMessageSaveFailed
+	}
+	result.Accept()
+
+	sent.SenderName = DisplayName(in.SenderID)
+	sent.MemberIDs = GroupMemberIDs(groupID)
+	return sent, nil
+}
+
+// findByClientMsgID 按客户端消息 ID 查找已保存的消息，没有时返回 nil
+// 同一个 client_msg_id 已用于发给其他人或其他群的消息时返回错误，不把那条消息当作本次的重发
+func (s *MessageService) findByClientMsgID(in SendMessageInput) (*SentMessage, error) {
+	senderID := in.SenderID
+	var message models.Message
+	models.DB.Where("sender_id = ? AND client_msg_id = ?", senderID, in.ClientMsgID).Limit(1).Find(&message)
+	if message.ID == 0 {
+		return nil, nil
+	}
+	var sameTarget bool
+	if in.GroupID != 0 {
+		sameTarget = message.GroupID != nil && *message.GroupID == in.GroupID
+	} else {
+		sameTarget = message.ReceiverID != nil && *message.ReceiverID == in.ReceiverID
+	}
+	if !sameTarget {
+		return nil, &messageError{ErrInvalidMessage, "client_msg_id 已用于其他会话的消息"}
+	}
+	sent := &SentMessage{
+		Message:    message,
+		SenderName: DisplayName(senderID),
+		Duplicate:  true,
+	}
+	if message.GroupID != nil {
+		sent.GroupID = *message.GroupID
+		sent.MemberIDs = GroupMemberIDs(sent.GroupID)
+		return sent, nil
+	}
+	sent.ConversationID = ConversationID(senderID, *message.ReceiverID)
+	if !message.Shadowed {
+		sent.PeerConversationID = ConversationID(*message.ReceiverID, senderID)
+	}
+	return sent, nil
+}
+
+// updateConversations 更新发送者的会话，消息未被隐身时更新接收者的会话并增加未读数
+// 会话不存在时创建，返回双方的会话 ID
+func updateConversations(tx *gorm.DB, message *models.Message) (uint, uint, error) {
+	now := time.Now()
+	upsert := func(userID, peerID uint, unread int) (uint, error) {
+		updates := map[string]interface{}{
+			"last_message":  message.Content,
+			"last_msg_time": now,
+			"updated_at":    now,
+		}
+		if unread > 0 {
+			updates["unread_count"] = gorm.Expr("unread_count + ?", unread)
+		}
+		conv := models.Conversation{
+			UserID:      userID,
+			PeerID:      peerID,
+			LastMessage: message.Content,
+			LastMsgTime: now,
+			UnreadCount: unread,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "peer_id"}},
+			DoUpdates: clause.Assignments(updates),
+		}).Create(&conv).Error; err != nil {
+			return 0, err
+		}
+		// 更新已有会话时 MySQL 不返回会话 ID，重新查询
+		var saved models.Conversation
+		err := tx.Select("id").Where("user_id = ? AND peer_id = ?", userID, peerID).Limit(1).Find(&saved).Error
+		return saved.ID, err
+	}
+
+	convID, err := upsert(message.SenderID, *message.ReceiverID, 0)
+	if err != nil || message.Shadowed {
+		return convID, 0, err
+	}
+	peerConvID, err := upsert(*message.ReceiverID, message.SenderID, 1)
+	return convID, peerConvID, err
+}
+
+// ConversationID 用户与对方的会话 ID，不存在时为 0
+func ConversationID(userID, peerID uint) uint {
+	var conv models.Conversation
+	models.DB.Select("id").Where("user_id = ? AND peer_id = ?", userID, peerID).Limit(1).Find(&conv)
+	return conv.ID
+}
+
+// DisplayName 用户的昵称，未设置时为用户名
+func DisplayName(userID uint) string {
+	var user models.User
+	if err := models.DB.Select("id", "username", "nickname").First(&user, userID).Error; err != nil {
+		return ""
+	}
+	if user.Nickname != "" {
+		return user.Nickname
+	}
+	return user.Username
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"time"
+
+	"tapspot/models"
+
+	"gorm.io/gorm"
+)
+
+// MarkConversationRead 把对方发来的消息全部标记为已读并清零未读数
+// 返回已读到的最后一条消息 ID（没有消息时为 0），advanced 表示已读位置是否前进，前进时才需要向对方推送已读回执
+func MarkConversationRead(userID, peerID uint) (lastReadID uint, advanced bool, err error) {
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		var conv models.Conversation
+		tx.Select("id", "last_read_id").Where("user_id = ? AND peer_id = ?", userID, peerID).Limit(1).Find(&conv)
+		// 隐身封禁用户的消息接收者看不到，不计入已读位置
+		if err := tx.Model(&models.Message{}).
+			Where("sender_id = ? AND receiver_id = ? AND shadowed = ?", peerID, userID, false).
+			Select("COALESCE(MAX(id), 0)").Scan(&lastReadID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Message{}).
+			Where("sender_id = ? AND receiver_id = ? AND is_read = ? AND id <= ?", peerID, userID, false, lastReadID).
+			Update("is_read", true).Error; err != nil {
+			return err
+		}
+		advanced = lastReadID > conv.LastReadID
+		return tx.Model(&models.Conversation{}).
+			Where("user_id = ? AND peer_id = ?", userID, peerID).
+			Updates(map[string]interface{}{
+				"unread_count": 0,
+				"last_read_id": gorm.Expr("GREATEST(last_read_id, ?)", lastReadID),
+			}).Error
+	})
+	return lastReadID, advanced, err
+}
+
+// PresenceContacts 需要收到该用户上线/下线通知的用户：与其有会话且不存在屏蔽关系
+func PresenceContacts(userID uint) []uint {
+	query := models.DB.Model(&models.Conversation{}).Where("peer_id = ?", userID)
+	if hidden := HiddenUserIDs(userID); len(hidden) > 0 {
+		query = query.Where("user_id NOT IN ?", hidden)
+	}
+	var ids []uint
+	query.Distinct().Pluck("user_id", &ids)
+	return ids
+}
+
+// TouchLastSeen 记录用户最后在线的时间
+func TouchLastSeen(userID uint, at time.Time) {
+	models.DB.Model(&models.User{}).Where("id = ?", userID).Update("last_seen_at", at)
+}
@@ -0,0 +1,429 @@
+package services
+
+import (
+	"errors"
+	"tapspot/dto"
+	"tapspot/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 用户角色
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// ReportReasonFilter 内容过滤自动送审的举报原因
+const ReportReasonFilter = "filter"
+
+// 举报状态
+const (
+	ReportStatusPending   = "pending"
+	ReportStatusClaimed   = "claimed"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+)
+
+// ModerationService 举报与内容审核服务
+type ModerationService struct{}
+
+// NewModerationService 创建审核服务实例
+func NewModerationService() *ModerationService {
+	return &ModerationService{}
+}
+
+// IsModerator 判断用户是否有审核权限
+func IsModerator(userID uint) bool {
+	var user models.User
+	if err := models.DB.Select("id", "role").First(&user, userID).Error; err != nil {
+		return false
+	}
+	return user.Role == RoleModerator || user.Role == RoleAdmin
+}
+
+// SetUserRole 设置用户角色（由命令行执行，用于初始化管理员）
+func SetUserRole(username, role string) error {
+	switch role {
+	case RoleUser, RoleModerator, RoleAdmin:
+	default:
+		return errors.New("角色必须是 user、moderator 或 admin")
+	}
+	result := models.DB.Model(&models.User{}).Where("username = ?", username).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if models.DB.Model(&models.User{}).Where("username = ?", username).Count(&count); count == 0 {
+			return errors.New("用户不存在")
+		}
+	}
+	return nil
+}
+
+// CreateReport 提交举报
+func (s *ModerationService) CreateReport(reporterID uint, req *dto.CreateReportRequest) (*models.Report, error) {
+	targetUserID, err := resolveReportTarget(reporterID, req.TargetType, req.TargetID)
+	if err != nil {
+		return nil, err
+	}
+	if targetUserID == reporterID {
+		return nil, errors.New("不能举报自己")
+	}
+
+	// 同一用户对同一内容只保留一条未处理的举报
+	var count int64
+	models.DB.Model(&models.Report{}).
+		Where("reporter_id = ? AND target_type = ? AND target_id = ? AND status IN ?",
+			reporterID, req.TargetType, req.TargetID, []string{ReportStatusPending, ReportStatusClaimed}).
+		Count(&count)
+	if count > 0 {
+		return nil, errors.New("你已举报过该内容，请等待处理")
+	}
+
+	report := &models.Report{
+		ReporterID:   reporterID,
+		TargetType:   req.TargetType,
+		TargetID:     req.TargetID,
+		TargetUserID: targetUserID,
+		Reason:       req.Reason,
+		Detail:       req.Detail,
+		Status:       ReportStatusPending,
+	}
+	if err := models.DB.Create(report).Error; err != nil {
+		return nil, errors.New("举报失败，请稍后重试")
+	}
+	return report, nil
+}
+
+// FlagForReview 内容过滤命中"待审核"策略时，以系统身份提交举报进入审核队列
+// 审核员驳回（dismiss）此类举报即视为审核通过，内容恢复公开
+func (s *ModerationService) FlagForReview(targetType string, targetID, targetUserID uint, detail string) error {
+	report := &models.Report{
+		TargetType:   targetType,
+		TargetID:     targetID,
+		TargetUserID: targetUserID,
+		Reason:       ReportReasonFilter,
+		Detail:       detail,
+		Status:       ReportStatusPending,
+	}
+	if err := models.DB.Create(report).Error; err != nil {
+		return errors.New("提交审核失败")
+	}
+	return nil
+}
+
+// resolveReportTarget 校验举报对象并返回其作者
+func resolveReportTarget(reporterID uint, targetType string, targetID uint) (uint, error) {
+	switch targetType {
+	case "post":
+		var post models.Post
+		if err := models.DB.First(&post, targetID).Error; err != nil {
+			return 0, errors.New("帖子不存在")
+		}
+		return post.UserID, nil
+	case "comment":
+		var comment models.Comment
+		if err := models.DB.First(&comment, targetID).Error; err != nil {
+			return 0, errors.New("评论不存在")
+		}
+		return comment.UserID, nil
+	case "message":
+		// 只能举报自己收到的私信，或所在群里自己能看到的其他成员的消息
+		var message models.Message
+		if err := models.DB.First(&message, targetID).Error; err != nil {
+			return 0, errors.New("消息不存在")
+		}
+		if message.GroupID == nil {
+			if message.ReceiverID == nil || *message.ReceiverID != reporterID {
+				return 0, errors.New("消息不存在")
+			}
+			return message.SenderID, nil
+		}
+		if message.SenderID == reporterID || message.Shadowed {
+			return 0, errors.New("消息不存在")
+		}
+		var count int64
+		models.DB.Model(&models.GroupMember{}).
+			Where("group_id = ? AND user_id = ? AND joined_after_id < ?", *message.GroupID, reporterID, message.ID).
+			Count(&count)
+		if count == 0 {
+			return 0, errors.New("消息不存在")
+		}
+		return message.SenderID, nil
+	case "user":
+		var user models.User
+		if err := models.DB.First(&user, targetID).Error; err != nil {
+			return 0, errors.New("用户不存在")
+		}
+		return user.ID, nil
+	}
+	return 0, errors.New("不支持的举报类型")
+}
+
+// ListReports 获取审核队列，status 为空时返回待处理和已认领的举报
+func (s *ModerationService) ListReports(status string, page, pageSize int) ([]dto.ReportInfo, int64, error) {
+	query := models.DB.Model(&models.Report{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status IN ?", []string{ReportStatusPending, ReportStatusClaimed})
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var reports []models.Report
+	if err := query.Order("created_at ASC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&reports).Error; err != nil {
+		return nil, 0, errors.New("获取举报列表失败")
+	}
+
+	result := make([]dto.ReportInfo, 0, len(reports))
+	for _, report := range reports {
+		var reportCount int64
+		models.DB.Model(&models.Report{}).
+			Where("target_type = ? AND target_id = ? AND status IN ?",
+				report.TargetType, report.TargetID, []string{ReportStatusPending, ReportStatusClaimed}).
+			Count(&reportCount)
+
+		result = append(result, dto.ReportInfo{
+			ID:            report.ID,
+			ReporterID:    report.ReporterID,
+			TargetType:    report.TargetType,
+			TargetID:      report.TargetID,
+			TargetUserID:  report.TargetUserID,
+			TargetContent: reportTargetContent(report.TargetType, report.TargetID),
+			ReportCount:   reportCount,
+			Reason:        report.Reason,
+			Detail:        report.Detail,
+			Status:        report.Status,
+			ModeratorID:   report.ModeratorID,
+			Resolution:    report.Resolution,
+			CreatedAt:     report.CreatedAt,
+			ClaimedAt:     report.ClaimedAt,
+			ResolvedAt:    report.ResolvedAt,
+		})
+	}
+	return result, total, nil
+}
+
+// reportTargetContent 被举报内容的快照（包含已隐藏和已删除的内容，方便审核员判断）
+func reportTargetContent(targetType string, targetID uint) string {
+	switch targetType {
+	case "post":
+		var post models.Post
+		if err := models.DB.Unscoped().First(&post, targetID).Error; err == nil {
+			return post.Title + "\n" + post.Content
+		}
+	case "comment":
+		var comment models.Comment
+		if err := models.DB.Unscoped().First(&comment, targetID).Error; err == nil {
+			return comment.Content
+		}
+	case "message":
+		var message models.Message
+		if err := models.DB.Unscoped().First(&message, targetID).Error; err == nil {
+			return message.Content
+		}
+	case "user":
+		var user models.User
+		if err := models.DB.Unscoped().First(&user, targetID).Error; err == nil {
+			return user.Nickname + "\n" + user.Bio
+		}
+	}
+	return ""
+}
+
+// ClaimReport 认领举报，避免多个审核员重复处理
+func (s *ModerationService) ClaimReport(moderatorID, reportID uint) error {
+	now := time.Now()
+	result := models.DB.Model(&models.Report{}).
+		Where("id = ? AND status = ?", reportID, ReportStatusPending).
+		Updates(map[string]interface{}{
+			"status":       ReportStatusClaimed,
+			"moderator_id": moderatorID,
+			"claimed_at":   now,
+		})
+	if result.Error != nil {
+		return errors.New("认领失败")
+	}
+	if result.RowsAffected == 1 {
+		return nil
+	}
+
+	var report models.Report
+	if err := models.DB.First(&report, reportID).Error; err != nil {
+		return errors.New("举报不存在")
+	}
+	if report.Status == ReportStatusClaimed && report.ModeratorID != nil && *report.ModeratorID == moderatorID {
+		return nil
+	}
+	if report.Status == ReportStatusClaimed {
+		return errors.New("该举报已被其他审核员认领")
+	}
+	return errors.New("该举报已处理")
+}
+
+// ResolveReport 处理举报：对被举报内容执行处置，并记录审核日志
+// 同一内容的其他未处理举报（未被他人认领的）一并结案
+func (s *ModerationService) ResolveReport(moderatorID, reportID uint, req *dto.ResolveReportRequest) (*models.ModerationAction, error) {
+	var action *models.ModerationAction
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		var report models.Report
+		if err := tx.First(&report, reportID).Error; err != nil {
+			return errors.New("举报不存在")
+		}
+		if report.Status == ReportStatusResolved || report.Status == ReportStatusDismissed {
+			return errors.New("该举报已处理")
+		}
+		if report.Status == ReportStatusClaimed && report.ModeratorID != nil && *report.ModeratorID != moderatorID {
+			return errors.New("该举报已被其他审核员认领")
+		}
+
+		// 警告和封禁针对用户本人，与直接封禁一样检查能否处理目标用户
+		if req.Action == "warn" || req.Action == "ban" {
+			if err := checkModerationTarget(moderatorID, report.TargetUserID); err != nil {
+				return err
+			}
+		}
+		if err := applyModerationAction(tx, req.Action, report.TargetType, report.TargetID, report.TargetUserID, req.Note); err != nil {
+			return err
+		}
+		// 自动送审的内容审核通过后恢复公开
+		if req.Action == "dismiss" && report.Reason == ReportReasonFilter {
+			if err := unhideTarget(tx, report.TargetType, report.TargetID); err != nil {
+				return err
+			}
+		}
+
+		status := ReportStatusResolved
+		if req.Action == "dismiss" {
+			status = ReportStatusDismissed
+		}
+		now := time.Now()
+		if err := tx.Model(&models.Report{}).
+			Where("target_type = ? AND target_id = ?", report.TargetType, report.TargetID).
+			Where("id = ? OR status = ? OR (status = ? AND moderator_id = ?)",
+				report.ID, ReportStatusPending, ReportStatusClaimed, moderatorID).
+			Updates(map[string]interface{}{
+				"status":       status,
+				"moderator_id": moderatorID,
+				"resolution":   req.Action,
+				"resolved_at":  now,
+			}).Error; err != nil {
+			return err
+		}
+
+		action = &models.ModerationAction{
+			ModeratorID:  moderatorID,
+			ReportID:     &report.ID,
+			Action:       req.Action,
+			TargetType:   report.TargetType,
+			TargetID:     report.TargetID,
+			TargetUserID: report.TargetUserID,
+			Note:         req.Note,
+		}
+		return tx.Create(action).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// applyModerationAction 对被举报对象执行处置
+func applyModerationAction(tx *gorm.DB, action, targetType string, targetID, targetUserID uint, note string) error {
+	switch action {
+	case "dismiss":
+		return nil
+
+	case "hide":
+		switch targetType {
+		case "post":
+			return tx.Model(&models.Post{}).Where("id = ?", targetID).Update("hidden", true).Error
+		case "comment":
+			return tx.Model(&models.Comment{}).Where("id = ?", targetID).Update("hidden", true).Error
+		}
+		return errors.New("该类型的内容不支持隐藏")
+
+	case "delete":
+		switch targetType {
+		case "post":
+			return tx.Delete(&models.Post{}, targetID).Error
+		case "comment":
+			return tx.Delete(&models.Comment{}, targetID).Error
+		case "message":
+			return tx.Delete(&models.Message{}, targetID).Error
+		}
+		return errors.New("该类型的内容不支持删除")
+
+	case "warn":
+		return tx.Model(&models.User{}).Where("id = ?", targetUserID).
+			Update("warning_count", gorm.Expr("warning_count + 1")).Error
+
+	case "ban":
+		reason := note
+		if reason == "" {
+			reason = "违反社区规范"
+		}
+		// 永久封禁，递增 token 版本，已登录的设备立即失效
+		updates := suspensionUpdates(reason, nil)
+		updates["token_version"] = gorm.Expr("token_version + 1")
+		return tx.Model(&models.User{}).Where("id = ?", targetUserID).Updates(updates).Error
+	}
+	return errors.New("不支持的处理方式")
+}
+
+// unhideTarget 取消隐藏帖子或评论
+func unhideTarget(tx *gorm.DB, targetType string, targetID uint) error {
+	switch targetType {
+	case "post":
+		return tx.Model(&models.Post{}).Where("id = ?", targetID).Update("hidden", false).Error
+	case "comment":
+		return tx.Model(&models.Comment{}).Where("id = ?", targetID).Update("hidden", false).Error
+	}
+	return nil
+}
+
+// ListActions 获取审核操作日志，targetUserID 为 0 时返回全部
+func (s *ModerationService) ListActions(targetUserID uint, page, pageSize int) ([]models.ModerationAction, int64, error) {
+	query := models.DB.Model(&models.ModerationAction{})
+	if targetUserID != 0 {
+		query = query.Where("target_user_id = ?", targetUserID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	actions := []models.ModerationAction{}
+	if err := query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&actions).Error; err != nil {
+		return nil, 0, errors.New("获取审核记录失败")
+	}
+	return actions, total, nil
+}
+
+// checkModerationTarget 检查能否处理目标用户：不能处理自己，只有管理员能处理审核员，管理员不能被处理
+func checkModerationTarget(moderatorID, userID uint) error {
+	if moderatorID == userID {
+		return errors.New("不能处理自己的账号")
+	}
+
+	var target models.User
+	if err := models.DB.Select("id", "role").First(&target, userID).Error; err != nil {
+		return errors.New("用户不存在")
+	}
+	switch target.Role {
+	case RoleAdmin:
+		return errors.New("不能处理管理员账号")
+	case RoleModerator:
+		var moderator models.User
+		if err := models.DB.Select("id", "role").First(&moderator, moderatorID).Error; err != nil || moderator.Role != RoleAdmin {
+			return errors.New("只有管理员可以处理审核员账号")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,663 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"tapspot/dto"
+	"tapspot/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// OAuthStateTTL 授权请求状态有效期，也是浏览器绑定 cookie 的有效期
+	OAuthStateTTL = 10 * time.Minute
+	// jwksRefreshInterval 遇到未知 kid 时重新拉取 JWKS 的最短间隔，避免伪造的 kid 触发大量请求
+	jwksRefreshInterval = time.Minute
+)
+
+var (
+	errUnknownProvider   = errors.New("不支持的登录方式")
+	errInvalidOAuthState = errors.New("登录请求已失效，请重新发起")
+)
+
+// OIDCProviderConfig OIDC 身份提供方配置
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// LoadOIDCProviderConfigsFromEnv 从环境变量读取提供方配置
+// OIDC_PROVIDERS=google,gitlab 列出名称，每个名称读取 OIDC_<NAME>_ISSUER 等变量
+func LoadOIDCProviderConfigsFromEnv() []OIDCProviderConfig {
+	var configs []OIDCProviderConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		cfg := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+			log.Printf("⚠️ OIDC 提供方 %s 配置不完整，已忽略", name)
+			continue
+		}
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"openid", "email", "profile"}
+		}
+		configs = append(configs, cfg)
+	}
+	return configs
+}
+
+// oidcDiscovery 提供方的 /.well-known/openid-configuration 文档
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider 单个提供方（缓存发现文档和签名公钥）
+type oidcProvider struct {
+	config        OIDCProviderConfig
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{} // kid -> *rsa.PublicKey / *ecdsa.PublicKey
+	keysFetchedAt time.Time              // 最近一次拉取 JWKS 的时间
+}
+
+// oidcClaims ID Token 中用到的声明
+type oidcClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Picture           string
+}
+
+// OIDCService 第三方登录服务（授权码模式 + PKCE）
+type OIDCService struct {
+	providers   map[string]*oidcProvider
+	client      *http.Client
+	authService *AuthService
+}
+
+// NewOIDCService 创建第三方登录服务实例
+func NewOIDCService(configs []OIDCProviderConfig) *OIDCService {
+	providers := make(map[string]*oidcProvider)
+	for _, cfg := range configs {
+		providers[cfg.Name] = &oidcProvider{config: cfg}
+	}
+	return &OIDCService{
+		providers:   providers,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		authService: NewAuthService(),
+	}
+}
+
+// ProviderNames 已配置的提供方名称
+func (s *OIDCService) ProviderNames() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AuthCodeURL 生成跳转到提供方的授权地址
+// linkUserID 不为 0 时表示把第三方账号绑定到该用户，而不是登录
+// 返回的 binding 需要写入发起请求的浏览器的 HttpOnly cookie，回调时校验，防止把授权地址发给他人完成登录或绑定（CSRF）
+func (s *OIDCService) AuthCodeURL(providerName string, linkUserID uint) (authCodeURL, binding string, err error) {
+	p, ok := s.providers[providerName]
+	if !ok {
+		return "", "", errUnknownProvider
+	}
+	disc, err := p.getDiscovery(s.client)
+	if err != nil {
+		return "", "", err
+	}
+
+	binding = randomURLToken(24)
+	state := models.OAuthState{
+		State:        randomURLToken(24),
+		Provider:     p.config.Name,
+		CodeVerifier: randomURLToken(48),
+		Nonce:        randomURLToken(24),
+		LinkUserID:   linkUserID,
+		BindingHash:  hashResetToken(binding),
+		ExpiresAt:    time.Now().Add(OAuthStateTTL),
+	}
+
+	// 顺带清理过期的状态
+	models.DB.Where("expires_at < ?", time.Now()).Delete(&models.OAuthState{})
+	if err := models.DB.Create(&state).Error; err != nil {
+		return "", "", errors.New("发起登录失败，请稍后重试")
+	}
+
+	authURL, err := url.Parse(disc.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", errors.New("第三方登录配置错误")
+	}
+	challenge := sha256.Sum256([]byte(state.CodeVerifier))
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(p.config.Scopes, " "))
+	q.Set("state", state.State)
+	q.Set("nonce", state.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+
+	return authURL.String(), binding, nil
+}
+
+// HandleCallback 处理提供方回调：换取并校验 ID Token，然后登录或绑定账号
+// binding 为浏览器 cookie 中的值，必须与发起授权时签发的一致
+func (s *OIDCService) HandleCallback(providerName, state, code, binding string) (*dto.OAuthCallbackResponse, error) {
+	p, ok := s.providers[providerName]
+	if !ok {
+		return nil, errUnknownProvider
+	}
+	if state == "" || code == "" || binding == "" {
+		return nil, errInvalidOAuthState
+	}
+
+	// 状态只能使用一次
+	var st models.OAuthState
+	if err := models.DB.Where("state = ? AND provider = ?", state, p.config.Name).First(&st).Error; err != nil {
+		return nil, errInvalidOAuthState
+	}
+	if result := models.DB.Delete(&models.OAuthState{}, st.ID); result.Error != nil || result.RowsAffected != 1 {
+		return nil, errInvalidOAuthState
+	}
+	if time.Now().After(st.ExpiresAt) || !stateBoundTo(&st, binding) {
+		return nil, errInvalidOAuthState
+	}
+
+	rawIDToken, err := s.exchangeCode(p, code, st.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := s.verifyIDToken(p, rawIDToken, st.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if st.LinkUserID != 0 {
+		identity, err := s.linkIdentity(st.LinkUserID, p.config.Name, claims)
+		if err != nil {
+			return nil, err
+		}
+		return &dto.OAuthCallbackResponse{Action: "link", Identity: identity}, nil
+	}
+
+	login, err := s.loginWithIdentity(p.config.Name, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.OAuthCallbackResponse{Action: "login", Login: login}, nil
+}
+
+// stateBoundTo 授权状态是否由持有该 cookie 的浏览器发起
+func stateBoundTo(st *models.OAuthState, binding string) bool {
+	if st.BindingHash == "" || binding == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(st.BindingHash), []byte(hashResetToken(binding))) == 1
+}
+
+// ListIdentities 获取用户已绑定的第三方账号
+func (s *OIDCService) ListIdentities(userID uint) ([]dto.IdentityInfo, error) {
+	var identities []models.Identity
+	if err := models.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error; err != nil {
+		return nil, errors.New("获取绑定信息失败")
+	}
+
+	result := []dto.IdentityInfo{}
+	for _, identity := range identities {
+		result = append(result, toIdentityInfo(&identity))
+	}
+	return result, nil
+}
+
+// UnlinkIdentity 解绑第三方账号
+// 没有设置密码的用户必须保留至少一个第三方账号，否则将无法登录
+func (s *OIDCService) UnlinkIdentity(userID uint, providerName string) error {
+	var identity models.Identity
+	if err := models.DB.Where("user_id = ? AND provider = ?", userID, providerName).First(&identity).Error; err != nil {
+		return errors.New("未绑定该第三方账号")
+	}
+
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return errors.New("用户不存在")
+	}
+	if user.Password == "" {
+		var count int64
+		models.DB.Model(&models.Identity{}).Where("user_id = ?", userID).Count(&count)
+		if count <= 1 {
+			return errors.New("请先设置密码或绑定其他账号后再解绑")
+		}
+	}
+
+	if err := models.DB.Delete(&identity).Error; err != nil {
+		return errors.New("解绑失败")
+	}
+	return nil
+}
+
+// exchangeCode 用授权码和 code_verifier 换取 ID Token
+func (s *OIDCService) exchangeCode(p *oidcProvider, code, verifier string) (string, error) {
+	disc, err := p.getDiscovery(s.client)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", verifier)
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	resp, err := s.client.PostForm(disc.TokenEndpoint, form)
+	if err != nil {
+		return "", errors.New("连接第三方登录服务失败")
+	}
+	defer resp.Body.Close()
+
+	var tokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", errors.New("第三方登录服务响应异常")
+	}
+	if resp.StatusCode != http.StatusOK || tokenResp.IDToken == "" {
+		log.Printf("OIDC 换取 token 失败: provider=%s status=%d error=%s %s",
+			p.config.Name, resp.StatusCode, tokenResp.Error, tokenResp.ErrorDescription)
+		return "", errors.New("第三方登录授权失败")
+	}
+
+	return tokenResp.IDToken, nil
+}
+
+// verifyIDToken 校验 ID Token 的签名、issuer、audience、过期时间和 nonce
+func (s *OIDCService) verifyIDToken(p *oidcProvider, rawIDToken, nonce string) (*oidcClaims, error) {
+	disc, err := p.getDiscovery(s.client)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(rawIDToken, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(s.client, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(disc.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		log.Printf("OIDC ID Token 校验失败: provider=%s err=%v", p.config.Name, err)
+		return nil, errors.New("第三方登录凭证无效")
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("第三方登录凭证无效")
+	}
+	if tokenNonce, _ := mapClaims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("第三方登录凭证无效")
+	}
+
+	claims := &oidcClaims{}
+	claims.Subject, _ = mapClaims["sub"].(string)
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.Name, _ = mapClaims["name"].(string)
+	claims.PreferredUsername, _ = mapClaims["preferred_username"].(string)
+	claims.Picture, _ = mapClaims["picture"].(string)
+	switch v := mapClaims["email_verified"].(type) {
+	case bool:
+		claims.EmailVerified = v
+	case string:
+		claims.EmailVerified = v == "true"
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("第三方登录凭证无效")
+	}
+
+	return claims, nil
+}
+
+// loginWithIdentity 使用第三方身份登录，首次登录自动创建本站账号
+func (s *OIDCService) loginWithIdentity(providerName string, claims *oidcClaims) (*dto.LoginResponse, error) {
+	var user models.User
+	var identity models.Identity
+	err := models.DB.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error
+	if err == nil {
+		if err := models.DB.First(&user, identity.UserID).Error; err != nil {
+			return nil, errors.New("用户不存在")
+		}
+		if claims.Email != "" && claims.Email != identity.Email {
+			models.DB.Model(&identity).Update("email", claims.Email)
+		}
+	} else {
+		// 不按邮箱自动合并已有账号：本站邮箱未经验证，合并会带来账号被接管的风险
+		created, err := s.createUserFromClaims(providerName, claims)
+		if err != nil {
+			return nil, err
+		}
+		user = *created
+	}
+
+	return s.authService.completeLogin(&user)
+}
+
+// createUserFromClaims 根据第三方资料创建本站账号（无密码）
+func (s *OIDCService) createUserFromClaims(providerName string, claims *oidcClaims) (*models.User, error) {
+	nickname := claims.Name
+	if nickname == "" {
+		nickname = claims.PreferredUsername
+	}
+	if nickname == "" {
+		nickname = "TapSpot 用户"
+	}
+	if runes := []rune(nickname); len(runes) > 50 {
+		nickname = string(runes[:50])
+	}
+
+	// 只采用提供方已验证且未被占用的邮箱
+	email := ""
+	if claims.EmailVerified && claims.Email != "" {
+		normalized := NormalizeEmail(claims.Email)
+		var existing models.User
+		if err := models.DB.Where("email = ?", normalized).First(&existing).Error; err != nil {
+			email = normalized
+		}
+	}
+
+	var user models.User
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		username, err := generateOAuthUsername(tx, providerName)
+		if err != nil {
+			return err
+		}
+
+		user = models.User{
+			Username:      username,
+			Password:      "", // 空密码无法通过密码登录
+			Nickname:      nickname,
+			Avatar:        claims.Picture,
+			Gender:        "other",
+			Email:         email,
+			EmailVerified: email != "", // 只采用提供方已验证的邮箱
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.Identity{
+			UserID:   user.ID,
+			Provider: providerName,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("创建第三方登录用户失败: %v", err)
+		return nil, errors.New("注册失败，请稍后重试")
+	}
+
+	return &user, nil
+}
+
+// linkIdentity 把第三方身份绑定到已登录用户
+func (s *OIDCService) linkIdentity(userID uint, providerName string, claims *oidcClaims) (*dto.IdentityInfo, error) {
+	var identity models.Identity
+	if err := models.DB.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error; err == nil {
+		if identity.UserID != userID {
+			return nil, errors.New("该第三方账号已绑定其他用户")
+		}
+		info := toIdentityInfo(&identity)
+		return &info, nil
+	}
+
+	var existing models.Identity
+	if err := models.DB.Where("user_id = ? AND provider = ?", userID, providerName).First(&existing).Error; err == nil {
+		return nil, errors.New("已绑定该平台的其他账号，请先解绑")
+	}
+
+	identity = models.Identity{
+		UserID:   userID,
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := models.DB.Create(&identity).Error; err != nil {
+		return nil, errors.New("绑定失败，请稍后重试")
+	}
+
+	info := toIdentityInfo(&identity)
+	return &info, nil
+}
+
+// getDiscovery 获取（并缓存）提供方的发现文档
+func (p *oidcProvider) getDiscovery(client *http.Client) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := client.Get(wellKnown)
+	if err != nil {
+		return nil, errors.New("连接第三方登录服务失败")
+	}
+	defer resp.Body.Close()
+
+	var disc oidcDiscovery
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&disc) != nil {
+		return nil, errors.New("第三方登录服务配置获取失败")
+	}
+	if disc.Issuer != p.config.Issuer {
+		log.Printf("OIDC issuer 不匹配: 配置=%s 实际=%s", p.config.Issuer, disc.Issuer)
+		return nil, errors.New("第三方登录配置错误")
+	}
+
+	p.discovery = &disc
+	return p.discovery, nil
+}
+
+// publicKey 按 kid 查找签名公钥，找不到时重新拉取 JWKS（应对密钥轮换）
+// 两次拉取至少间隔 jwksRefreshInterval，期间未知的 kid 直接拒绝
+func (p *oidcProvider) publicKey(client *http.Client, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key := lookupKey(p.keys, kid)
+	refresh := key == nil && time.Since(p.keysFetchedAt) >= jwksRefreshInterval
+	if refresh {
+		p.keysFetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	if !refresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	disc, err := p.getDiscovery(client)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := fetchJWKS(client, disc.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	key = lookupKey(p.keys, kid)
+	p.mu.Unlock()
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// lookupKey 没有 kid 且只有一个密钥时直接使用该密钥
+func lookupKey(keys map[string]interface{}, kid string) interface{} {
+	if key, ok := keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// fetchJWKS 下载并解析 JWKS 公钥集合
+func fetchJWKS(client *http.Client, jwksURI string) (map[string]interface{}, error) {
+	resp, err := client.Get(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+	return keys, nil
+}
+
+// generateOAuthUsername 生成第三方登录用户的用户名（如 google_3f9a1c2e）
+func generateOAuthUsername(tx *gorm.DB, providerName string) (string, error) {
+	for i := 0; i < 5; i++ {
+		buf := make([]byte, 4)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		username := fmt.Sprintf("%s_%s", providerName, hex.EncodeToString(buf))
+		var count int64
+		tx.Model(&models.User{}).Unscoped().Where("username = ?", username).Count(&count)
+		if count == 0 {
+			return username, nil
+		}
+	}
+	return "", errors.New("生成用户名失败")
+}
+
+// randomURLToken 生成 URL 安全的随机字符串
+func randomURLToken(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic("crypto/rand unavailable: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// toIdentityInfo 转换为绑定信息响应
+func toIdentityInfo(identity *models.Identity) dto.IdentityInfo {
+	return dto.IdentityInfo{
+		Provider:  identity.Provider,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt,
+	}
+}
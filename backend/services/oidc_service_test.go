@@ -0,0 +1,325 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"tapspot/models"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeOIDCProvider 本地模拟的 OIDC 提供方：发现文档、授权、换取 token 和 JWKS
+type fakeOIDCProvider struct {
+	t        *testing.T
+	server   *httptest.Server
+	clientID string
+
+	mu       sync.Mutex
+	key      *rsa.PrivateKey
+	kid      string
+	codes    map[string]fakeAuthCode
+	claims   jwt.MapClaims // 覆盖签发的 ID Token 中的声明
+	jwksHits int32
+}
+
+type fakeAuthCode struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	f := &fakeOIDCProvider{
+		t:        t,
+		clientID: "tapspot-test",
+		codes:    make(map[string]fakeAuthCode),
+	}
+	f.rotateKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	mux.HandleFunc("/jwks", f.jwks)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// rotateKey 更换签名密钥
+func (f *fakeOIDCProvider) rotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		f.t.Fatalf("generate key: %v", err)
+	}
+	f.mu.Lock()
+	f.key, f.kid = key, kid
+	f.mu.Unlock()
+}
+
+func (f *fakeOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(oidcDiscovery{
+		Issuer:                f.server.URL,
+		AuthorizationEndpoint: f.server.URL + "/authorize",
+		TokenEndpoint:         f.server.URL + "/token",
+		JWKSURI:               f.server.URL + "/jwks",
+	})
+}
+
+// authorize 直接同意授权，带着 code 和 state 跳回 redirect_uri
+func (f *fakeOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != f.clientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	code := randomURLToken(16)
+	f.mu.Lock()
+	f.codes[code] = fakeAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	f.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token 校验 PKCE 后签发 ID Token，授权码只能使用一次
+func (f *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.mu.Lock()
+	auth, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	key, kid := f.key, f.kid
+	overrides := f.claims
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":            f.server.URL,
+		"aud":            f.clientID,
+		"sub":            "subject-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"nonce":          auth.nonce,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range overrides {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		f.t.Fatalf("sign id token: %v", err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+}
+
+func (f *fakeOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&f.jwksHits, 1)
+	f.mu.Lock()
+	pub, kid := f.key.PublicKey, f.kid
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// newTestOIDCService 创建只配置了模拟提供方的服务
+func newTestOIDCService(f *fakeOIDCProvider) (*OIDCService, *oidcProvider) {
+	s := NewOIDCService([]OIDCProviderConfig{{
+		Name:        "fake",
+		Issuer:      f.server.URL,
+		ClientID:    f.clientID,
+		RedirectURL: "http://localhost/api/oauth/fake/callback",
+		Scopes:      []string{"openid", "email"},
+	}})
+	return s, s.providers["fake"]
+}
+
+// authorizeCode 走一遍授权端点，返回跳转回来的授权码
+func authorizeCode(t *testing.T, f *fakeOIDCProvider, verifier, nonce string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("client_id", f.clientID)
+	q.Set("redirect_uri", "http://localhost/api/oauth/fake/callback")
+	q.Set("state", "state-1")
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	q.Set("code_challenge_method", "S256")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(f.server.URL + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Query().Get("state") != "state-1" {
+		t.Fatalf("unexpected redirect %q", resp.Header.Get("Location"))
+	}
+	return location.Query().Get("code")
+}
+
+func TestOIDCExchangeAndVerify(t *testing.T) {
+	f := newFakeOIDCProvider(t)
+	s, p := newTestOIDCService(f)
+
+	code := authorizeCode(t, f, "verifier-1", "nonce-1")
+	raw, err := s.exchangeCode(p, code, "verifier-1")
+	if err != nil {
+		t.Fatalf("exchangeCode: %v", err)
+	}
+	claims, err := s.verifyIDToken(p, raw, "nonce-1")
+	if err != nil {
+		t.Fatalf("verifyIDToken: %v", err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	// 授权码只能使用一次
+	if _, err := s.exchangeCode(p, code, "verifier-1"); err == nil {
+		t.Fatal("expected reused code to fail")
+	}
+}
+
+func TestOIDCExchangeRejectsWrongVerifier(t *testing.T) {
+	f := newFakeOIDCProvider(t)
+	s, p := newTestOIDCService(f)
+
+	code := authorizeCode(t, f, "verifier-1", "nonce-1")
+	if _, err := s.exchangeCode(p, code, "verifier-2"); err == nil {
+		t.Fatal("expected wrong code_verifier to fail")
+	}
+}
+
+func TestOIDCVerifyRejectsInvalidTokens(t *testing.T) {
+	cases := []struct {
+		name      string
+		overrides jwt.MapClaims
+		nonce     string
+	}{
+		{"wrong nonce", nil, "other-nonce"},
+		{"wrong audience", jwt.MapClaims{"aud": "someone-else"}, "nonce-1"},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example.com"}, "nonce-1"},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}, "nonce-1"},
+		{"missing subject", jwt.MapClaims{"sub": ""}, "nonce-1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeOIDCProvider(t)
+			f.claims = tc.overrides
+			s, p := newTestOIDCService(f)
+
+			raw, err := s.exchangeCode(p, authorizeCode(t, f, "verifier-1", "nonce-1"), "verifier-1")
+			if err != nil {
+				t.Fatalf("exchangeCode: %v", err)
+			}
+			if _, err := s.verifyIDToken(p, raw, tc.nonce); err == nil {
+				t.Fatal("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyRejectsForeignSignature(t *testing.T) {
+	f := newFakeOIDCProvider(t)
+	s, p := newTestOIDCService(f)
+
+	// 用不在 JWKS 中的密钥但相同的 kid 签名
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   f.server.URL,
+		"aud":   f.clientID,
+		"sub":   "subject-1",
+		"nonce": "nonce-1",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = f.kid
+	raw, err := token.SignedString(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.verifyIDToken(p, raw, "nonce-1"); err == nil {
+		t.Fatal("expected foreign signature to be rejected")
+	}
+}
+
+func TestOIDCJWKSRefetchThrottled(t *testing.T) {
+	f := newFakeOIDCProvider(t)
+	s, p := newTestOIDCService(f)
+
+	if _, err := p.publicKey(s.client, "key-1"); err != nil {
+		t.Fatalf("publicKey: %v", err)
+	}
+	// 未知 kid 在间隔内不会触发重新拉取
+	for i := 0; i < 10; i++ {
+		if _, err := p.publicKey(s.client, "forged-kid"); err == nil {
+			t.Fatal("expected unknown kid to fail")
+		}
+	}
+	if hits := atomic.LoadInt32(&f.jwksHits); hits != 1 {
+		t.Fatalf("expected 1 JWKS fetch, got %d", hits)
+	}
+
+	// 间隔过后，密钥轮换能被识别
+	f.rotateKey("key-2")
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-jwksRefreshInterval)
+	p.mu.Unlock()
+	raw, err := s.exchangeCode(p, authorizeCode(t, f, "verifier-1", "nonce-1"), "verifier-1")
+	if err != nil {
+		t.Fatalf("exchangeCode: %v", err)
+	}
+	if _, err := s.verifyIDToken(p, raw, "nonce-1"); err != nil {
+		t.Fatalf("verify after rotation: %v", err)
+	}
+	if hits := atomic.LoadInt32(&f.jwksHits); hits != 2 {
+		t.Fatalf("expected 2 JWKS fetches, got %d", hits)
+	}
+}
+
+func TestStateBoundTo(t *testing.T) {
+	st := &models.OAuthState{BindingHash: hashResetToken("browser-cookie")}
+	if !stateBoundTo(st, "browser-cookie") {
+		t.Fatal("expected matching cookie to pass")
+	}
+	if stateBoundTo(st, "other-cookie") || stateBoundTo(st, "") {
+		t.Fatal("expected mismatched cookie to fail")
+	}
+	// 升级前创建的状态没有绑定，一律拒绝
+	if stateBoundTo(&models.OAuthState{}, "browser-cookie") {
+		t.Fatal("expected unbound state to fail")
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"tapspot/dto"
+	"tapspot/models"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// resetTokenTTL 重置令牌有效期
+const resetTokenTTL = 30 * time.Minute
+
+var errInvalidResetToken = errors.New("重置链接无效或已过期")
+
+// PasswordService 找回密码服务
+type PasswordService struct {
+	mailer   Mailer
+	resetURL string
+}
+
+// NewPasswordService 创建找回密码服务实例
+func NewPasswordService(mailer Mailer) *PasswordService {
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		resetURL = "http://localhost:8081/reset-password"
+	}
+	return &PasswordService{
+		mailer:   mailer,
+		resetURL: resetURL,
+	}
+}
+
+// ForgotPassword 申请重置密码
+// 无论账号是否存在都立即返回 nil：查询账号、保存令牌和发送邮件都在后台完成，响应内容和耗时都不会泄露账号是否存在
+func (s *PasswordService) ForgotPassword(req *dto.ForgotPasswordRequest) error {
+	go s.issueResetToken(NormalizeEmail(req.Email))
+	return nil
+}
+
+// issueResetToken 为邮箱对应的账号生成重置令牌并发送邮件，账号不存在或邮箱未验证时什么也不做
+func (s *PasswordService) issueResetToken(email string) {
+	// 没有真实的邮件服务时令牌无法送达，非开发模式下不发放
+	if _, ok := s.mailer.(*LogMailer); ok && !DevMode() {
+		log.Printf("⚠️ 未配置 SMTP_HOST，非开发模式下不发放密码重置令牌")
+		return
+	}
+
+	// 只发送到验证过的邮箱，未验证的邮箱可能是他人填写的
+	var user models.User
+	if err := models.DB.Where("email = ? AND email_verified = ?", email, true).First(&user).Error; err != nil {
+		return
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		log.Printf("生成重置令牌失败: %v", err)
+		return
+	}
+
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		// 作废该用户之前未使用的令牌
+		now := time.Now()
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: hashResetToken(token),
+			ExpiresAt: now.Add(resetTokenTTL),
+		}).Error
+	})
+	if err != nil {
+		log.Printf("保存重置令牌失败: %v", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s?token=%s", s.resetURL, token)
+	body := fmt.Sprintf("你好 %s：\n\n请在 %d 分钟内点击以下链接重置 TapSpot 密码：\n%s\n\n如果不是你本人操作，请忽略此邮件。",
+		user.Nickname, int(resetTokenTTL.Minutes()), link)
+	if err := s.mailer.Send(user.Email, "TapSpot 密码重置", body); err != nil {
+		log.Printf("发送重置邮件失败: %v", err)
+	}
+}
+
+// ResetPassword 使用重置令牌设置新密码，并使该用户所有已登录的 token 失效
+func (s *PasswordService) ResetPassword(req *dto.ResetPasswordRequest) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("密码加密失败")
+	}
+
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		var resetToken models.PasswordResetToken
+		if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?",
+			hashResetToken(req.Token), time.Now()).First(&resetToken).Error; err != nil {
+			return errInvalidResetToken
+		}
+
+		// 条件更新保证令牌只能被使用一次
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil || result.RowsAffected != 1 {
+			return errInvalidResetToken
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", resetToken.UserID).Updates(map[string]interface{}{
+			"password":      string(hashedPassword),
+			"token_version": gorm.Expr("token_version + 1"),
+		}).Error; err != nil {
+			return errors.New("重置密码失败")
+		}
+
+		return nil
+	})
+}
+
+// generateResetToken 生成随机重置令牌（明文仅出现在邮件中）
+func generateResetToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashResetToken 计算令牌哈希，数据库中只保存哈希值
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package services
+
+import "strings"
+
+// PostCategory 帖子分类（Post.Type 的取值）
+type PostCategory struct {
+	Type     string   `json:"type"`
+	Label    string   `json:"label"`
+	Keywords []string `json:"-"` // 本地规则判断分类时使用的关键词
+}
+
+// DefaultPostType 未指定或无法判断分类时使用的分类
+const DefaultPostType = "post"
+
+// PostCategories 帖子分类表，与前端发布和筛选使用的分类一致
+var PostCategories = []PostCategory{
+	{Type: "post", Label: "日常"},
+	{Type: "food", Label: "美食", Keywords: []string{"美食", "好吃", "餐厅", "饭店", "小吃", "咖啡", "奶茶", "火锅", "烧烤", "甜品", "面包", "早餐", "午餐", "晚餐", "味道", "口味", "菜", "吃"}},
+	{Type: "hotel", Label: "住宿", Keywords: []string{"酒店", "民宿", "住宿", "入住", "客栈", "宾馆", "房间", "前台", "床"}},
+	{Type: "shop", Label: "购物", Keywords: []string{"购物", "商场", "逛街", "店铺", "买", "折扣", "打折", "超市", "专柜", "市集"}},
+	{Type: "scenic", Label: "景点", Keywords: []string{"景点", "景区", "公园", "风景", "山", "湖", "海边", "寺", "博物馆", "古镇", "日落", "日出", "拍照", "门票"}},
+	{Type: "transport", Label: "交通", Keywords: []string{"地铁", "公交", "高铁", "火车", "机场", "航班", "停车", "打车", "堵车", "车站", "换乘"}},
+	{Type: "entertainment", Label: "娱乐", Keywords: []string{"电影", "ktv", "酒吧", "游乐园", "演唱会", "展览", "剧本杀", "密室", "live", "游戏"}},
+	{Type: "work", Label: "工作", Keywords: []string{"工作", "上班", "加班", "办公", "会议", "出差", "公司", "同事", "面试"}},
+}
+
+// IsValidPostType 是否为分类表中的分类
+func IsValidPostType(postType string) bool {
+	_, ok := postCategory(postType)
+	return ok
+}
+
+// PostCategoryLabel 分类的中文名称
+func PostCategoryLabel(postType string) string {
+	if category, ok := postCategory(postType); ok {
+		return category.Label
+	}
+	return ""
+}
+
+// postCategory 按类型查找分类
+func postCategory(postType string) (PostCategory, bool) {
+	for _, category := range PostCategories {
+		if category.Type == postType {
+			return category, true
+		}
+	}
+	return PostCategory{}, false
+}
+
+// classifyPost 按关键词出现次数判断分类，返回分类和命中的关键词
+func classifyPost(text string) (string, []string) {
+	text = strings.ToLower(text)
+	bestType, bestScore := DefaultPostType, 0
+	var bestMatches []string
+	for _, category := range PostCategories {
+		score := 0
+		var matches []string
+		for _, keyword := range category.Keywords {
+			if count := strings.Count(text, keyword); count > 0 {
+				// 单字关键词容易误判，权重较低
+				if len([]rune(keyword)) > 1 {
+					count *= 2
+				}
+				score += count
+				matches = append(matches, keyword)
+			}
+		}
+		if score > bestScore {
+			bestType, bestScore, bestMatches = category.Type, score, matches
+		}
+	}
+	return bestType, bestMatches
+}
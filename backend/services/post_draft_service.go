@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"tapspot/dto"
+	"tapspot/llm"
+	"tapspot/models"
+)
+
+// 草稿建议的来源
+const (
+	DraftSourceAI    = "ai"
+	DraftSourceRules = "rules"
+)
+
+// 草稿建议的长度限制
+const (
+	draftTitleRunes    = 30
+	draftHashtagRunes  = 20
+	draftLocationRunes = 50
+	draftMaxHashtags   = 5
+	draftContentRunes  = 1500 // 发给模型的正文长度上限
+	draftNearbyKm      = 1.0  // 候选地点的范围
+	draftNearestKm     = 0.5  // 本地规则直接使用最近地点的范围
+	draftMaxPlaces     = 8
+)
+
+// PostDraftService 根据草稿内容和位置建议标题、分类、话题标签和地点名称
+type PostDraftService struct{}
+
+// NewPostDraftService 创建草稿建议服务
+func NewPostDraftService() *PostDraftService {
+	return &PostDraftService{}
+}
+
+// nearbyPlace 草稿位置附近的地点名称
+type nearbyPlace struct {
+	Name       string
+	DistanceKm float64
+}
+
+// Suggest 优先使用模型生成建议，未配置模型或调用失败时使用本地规则
+func (s *PostDraftService) Suggest(ctx context.Context, userID uint, req *dto.PostDraftRequest) *dto.PostDraftSuggestion {
+	places := s.nearbyPlaces(userID, req.Latitude, req.Longitude)
+	fallback := s.suggestByRules(req, places)
+
+	suggestion, err := s.suggestByAI(ctx, userID, req, places)
+	if err != nil {
+		if !errors.Is(err, llm.ErrNotConfigured) {
+			log.Printf("⚠️ AI 生成帖子建议失败: %v", err)
+		}
+		return fallback
+	}
+
+	// 模型没有给出的字段用本地规则补齐
+	if suggestion.Title == "" {
+		suggestion.Title = fallback.Title
+	}
+	if len(suggestion.Hashtags) == 0 {
+		suggestion.Hashtags = fallback.Hashtags
+	}
+	if suggestion.LocationName == "" {
+		suggestion.LocationName = fallback.LocationName
+	}
+	return suggestion
+}
+
+// suggestByRules 本地规则：按关键词判断分类，正文第一句作为标题，最近的地点作为地点名称
+func (s *PostDraftService) suggestByRules(req *dto.PostDraftRequest, places []nearbyPlace) *dto.PostDraftSuggestion {
+	postType, keywords := classifyPost(req.Title + "\n" + req.Content)
+
+	suggestion := &dto.PostDraftSuggestion{
+		Title:  draftTitle(req.Content),
+		Type:   postType,
+		Source: DraftSourceRules,
+	}
+	if len(places) > 0 && places[0].DistanceKm <= draftNearestKm {
+		suggestion.LocationName = places[0].Name
+	}
+
+	var tags []string
+	if postType != DefaultPostType {
+		tags = append(tags, PostCategoryLabel(postType))
+	}
+	if suggestion.LocationName != "" {
+		tags = append(tags, suggestion.LocationName)
+	}
+	for _, keyword := range keywords {
+		// 单字关键词不适合作为话题
+		if len([]rune(keyword)) > 1 {
+			tags = append(tags, keyword)
+		}
+	}
+	suggestion.Hashtags = cleanHashtags(tags)
+	suggestion.TypeLabel = PostCategoryLabel(suggestion.Type)
+	return suggestion
+}
+
+// draftSuggestionJSON 模型返回的 JSON
+type draftSuggestionJSON struct {
+	Title        string   `json:"title"`
+	Type         string   `json:"type"`
+	Hashtags     []string `json:"hashtags"`
+	LocationName string   `json:"location_name"`
+}
+
+// suggestByAI 让模型以 JSON 返回建议，分类不在分类表中时按本地规则判断
+func (s *PostDraftService) suggestByAI(ctx context.Context, userID uint, req *dto.PostDraftRequest, places []nearbyPlace) (*dto.PostDraftSuggestion, error) {
+	resp, err := llm.Default.Complete(ctx, draftRequest(userID, req, places))
+	if err != nil {
+		return nil, err
+	}
+
+	content := resp.Content
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("模型没有返回 JSON：%s", TruncateRunes(content, 100))
+	}
+	var parsed draftSuggestionJSON
+	if err := json.Unmarshal([]byte(content[start:end+1]), &parsed); err != nil {
+		return nil, fmt.Errorf("解析模型返回的 JSON 失败：%w", err)
+	}
+
+	suggestion := &dto.PostDraftSuggestion{
+		Title:        TruncateRunes(strings.Trim(strings.TrimSpace(parsed.Title), "\"“”#"), draftTitleRunes),
+		Type:         strings.ToLower(strings.TrimSpace(parsed.Type)),
+		Hashtags:     cleanHashtags(parsed.Hashtags),
+		LocationName: TruncateRunes(strings.TrimSpace(parsed.LocationName), draftLocationRunes),
+		Source:       DraftSourceAI,
+	}
+	if !IsValidPostType(suggestion.Type) {
+		suggestion.Type, _ = classifyPost(req.Title + "\n" + req.Content)
+	}
+	suggestion.TypeLabel = PostCategoryLabel(suggestion.Type)
+	return suggestion, nil
+}
+
+// draftRequest 构造草稿建议的请求
+func draftRequest(userID uint, req *dto.PostDraftRequest, places []nearbyPlace) *llm.Request {
+	var categories []string
+	for _, category := range PostCategories {
+		categories = append(categories, fmt.Sprintf("%s（%s）", category.Type, category.Label))
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("请根据下面的打卡帖子草稿给出发布建议。\n\n")
+	if title := strings.TrimSpace(req.Title); title != "" {
+		fmt.Fprintf(&prompt, "用户填写的标题：%s\n", title)
+	}
+	fmt.Fprintf(&prompt, "正文：\n%s\n\n", TruncateRunes(req.Content, draftContentRunes))
+	if len(places) > 0 {
+		prompt.WriteString("发帖位置附近的地点（按距离从近到远）：\n")
+		for _, place := range places {
+			fmt.Fprintf(&prompt, "- %s（%.2f 公里）\n", place.Name, place.DistanceKm)
+		}
+		prompt.WriteString("\n")
+	}
+	fmt.Fprintf(&prompt, `只输出一个 JSON 对象，不要输出其他内容：
+{"title": "不超过 20 个字的标题", "type": "分类", "hashtags": ["话题1", "话题2"], "location_name": "地点名称"}
+
+要求：
+- type 只能是以下之一：%s
+- hashtags 给出 1-5 个简短的话题，不带 # 号
+- location_name 优先从附近地点中选择与正文相符的，正文中明确提到地点时使用正文中的名称，无法判断时留空
+- 不要编造正文中没有的信息`, strings.Join(categories, "、"))
+
+	return &llm.Request{
+		Model:       llm.EnvModel("LLM_DRAFT_MODEL", "qwen-turbo"),
+		Messages:    []llm.Message{{Role: llm.RoleUser, Content: prompt.String()}},
+		MaxTokens:   300,
+		Temperature: 0.3,
+		Purpose:     "post_draft",
+		UserID:      userID,
+	}
+}
+
+// nearbyPlaces 附近的打卡点和帖子地点名称，按距离排序并去重
+func (s *PostDraftService) nearbyPlaces(userID uint, lat, lng float64) []nearbyPlace {
+	if lat == 0 && lng == 0 {
+		return nil
+	}
+
+	var places []nearbyPlace
+	minLat, maxLat, minLng, maxLng := BoundingBox(lat, lng, draftNearbyKm)
+	var spots []models.Spot
+	models.DB.Select("name", "latitude", "longitude").
+		Where("latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", minLat, maxLat, minLng, maxLng).
+		Limit(100).Find(&spots)
+	for _, spot := range spots {
+		if km := DistanceKm(lat, lng, spot.Latitude, spot.Longitude); km <= draftNearbyKm {
+			places = append(places, nearbyPlace{Name: spot.Name, DistanceKm: roundKm(km)})
+		}
+	}
+
+	// 其他用户帖子中的地点名称，遵守屏蔽、隐藏和位置模糊规则
+	posts := NewAnyaToolbox(userID, lat, lng).findPosts(&toolArgs{RadiusKm: draftNearbyKm, Limit: toolMaxLimit})
+	for _, post := range posts {
+		if post.LocationName != "" {
+			places = append(places, nearbyPlace{Name: post.LocationName, DistanceKm: post.DistanceKm})
+		}
+	}
+
+	sort.SliceStable(places, func(i, j int) bool { return places[i].DistanceKm < places[j].DistanceKm })
+	seen := make(map[string]bool, len(places))
+	unique := places[:0]
+	for _, place := range places {
+		name := strings.TrimSpace(place.Name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		place.Name = name
+		unique = append(unique, place)
+	}
+	if len(unique) > draftMaxPlaces {
+		unique = unique[:draftMaxPlaces]
+	}
+	return unique
+}
+
+// draftTitle 取正文第一句作为标题
+func draftTitle(content string) string {
+	content = strings.TrimSpace(content)
+	if i := strings.IndexAny(content, "。！？!?\n"); i > 0 {
+		content = content[:i]
+	}
+	return TruncateRunes(strings.TrimSpace(content), 20)
+}
+
+// cleanHashtags 去掉 # 号和空白，去重并限制数量和长度
+func cleanHashtags(tags []string) []string {
+	cleaned := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(strings.Trim(strings.TrimSpace(tag), "#＃")), "")
+		if tag == "" || seen[tag] || len([]rune(tag)) > draftHashtagRunes {
+			continue
+		}
+		seen[tag] = true
+		cleaned = append(cleaned, tag)
+		if len(cleaned) == draftMaxHashtags {
+			break
+		}
+	}
+	return cleaned
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"tapspot/dto"
+	"tapspot/models"
+
+	"gorm.io/gorm"
+)
+
+// 隐私设置取值
+// followers 指互相关注的用户：关注无需对方同意，只有对方回关后才放行
+const (
+	MessagePermissionEveryone  = "everyone"
+	MessagePermissionFollowers = "followers"
+	MessagePermissionNobody    = "nobody"
+
+	ProfileVisibilityPublic    = "public"
+	ProfileVisibilityFollowers = "followers"
+	ProfileVisibilityPrivate   = "private"
+
+	// LocationPrecisionExact 不模糊帖子坐标（0 表示取整到 1 度）
+	LocationPrecisionExact = -1
+	// maxLocationPrecision 最多保留 4 位小数（约 11 米），再精确就没有模糊的意义了
+	maxLocationPrecision = 4
+)
+
+// PrivacyService 隐私设置服务
+type PrivacyService struct{}
+
+// NewPrivacyService 创建隐私设置服务实例
+func NewPrivacyService() *PrivacyService {
+	return &PrivacyService{}
+}
+
+// GetSettings 获取隐私设置
+func (s *PrivacyService) GetSettings(userID uint) (*dto.PrivacySettings, error) {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	return toPrivacySettings(&user), nil
+}
+
+// UpdateSettings 更新隐私设置
+func (s *PrivacyService) UpdateSettings(userID uint, req *dto.UpdatePrivacyRequest) (*dto.PrivacySettings, error) {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+
+	updates := make(map[string]interface{})
+	if req.MessagePermission != "" {
+		updates["message_permission"] = req.MessagePermission
+	}
+	if req.ProfileVisibility != "" {
+		updates["profile_visibility"] = req.ProfileVisibility
+	}
+	if req.ShowEmail != nil {
+		updates["show_email"] = *req.ShowEmail
+	}
+	if req.ShowPhone != nil {
+		updates["show_phone"] = *req.ShowPhone
+	}
+	if req.LocationPrecision != nil {
+		if *req.LocationPrecision < LocationPrecisionExact || *req.LocationPrecision > maxLocationPrecision {
+			return nil, errors.New("位置精度必须在 0-4 之间，-1 表示不模糊")
+		}
+		updates["location_decimals"] = *req.LocationPrecision
+	}
+
+	if len(updates) > 0 {
+		if err := models.DB.Model(&user).Updates(updates).Error; err != nil {
+			return nil, errors.New("更新隐私设置失败")
+		}
+		models.DB.First(&user, userID)
+	}
+
+	return toPrivacySettings(&user), nil
+}
+
+// toPrivacySettings 转换为响应结构，兼容迁移前的空值
+func toPrivacySettings(user *models.User) *dto.PrivacySettings {
+	settings := &dto.PrivacySettings{
+		MessagePermission: user.MessagePermission,
+		ProfileVisibility: user.ProfileVisibility,
+		ShowEmail:         user.ShowEmail,
+		ShowPhone:         user.ShowPhone,
+		LocationPrecision: user.LocationPrecision,
+	}
+	if settings.MessagePermission == "" {
+		settings.MessagePermission = MessagePermissionEveryone
+	}
+	if settings.ProfileVisibility == "" {
+		settings.ProfileVisibility = ProfileVisibilityPublic
+	}
+	return settings
+}
+
+// CanMessage 检查接收者的私信设置是否允许发送者发消息
+func CanMessage(senderID uint, receiver *models.User) error {
+	switch receiver.MessagePermission {
+	case MessagePermissionNobody:
+		return errors.New("对方已关闭私信")
+	case MessagePermissionFollowers:
+		if !IsMutualFollow(senderID, receiver.ID) {
+			return errors.New("对方仅允许互相关注的用户发送私信")
+		}
+	}
+	return nil
+}
+
+// CanViewProfile 检查访问者是否可以查看用户的完整资料和帖子列表
+func CanViewProfile(viewerID uint, owner *models.User) bool {
+	if viewerID == owner.ID {
+		return true
+	}
+	if IsBlocked(viewerID, owner.ID) {
+		return false
+	}
+
+	switch owner.ProfileVisibility {
+	case ProfileVisibilityPrivate:
+		return false
+	case ProfileVisibilityFollowers:
+		return IsMutualFollow(viewerID, owner.ID)
+	}
+	return true
+}
+
+// VisibleEmail 返回访问者可见的邮箱，未公开时为空
+func VisibleEmail(viewerID uint, owner *models.User) string {
+	if viewerID == owner.ID || owner.ShowEmail {
+		return owner.Email
+	}
+	return ""
+}
+
+// VisiblePhone 返回访问者可见的手机号，未公开时为空
+func VisiblePhone(viewerID uint, owner *models.User) string {
+	if viewerID == owner.ID || owner.ShowPhone {
+		return owner.Phone
+	}
+	return ""
+}
+
+// PublicCoordinates 返回访问者可见的帖子坐标
+// 作者本人看到精确坐标，其他人看到按作者设置的精度取整后的坐标
+func PublicCoordinates(post *models.Post, viewerID uint) (float64, float64) {
+	author := post.User
+	if author.ID == 0 {
+		author.LocationPrecision = LocationPrecisionExact
+		models.DB.Select("id, location_decimals").First(&author, post.UserID)
+	}
+	if viewerID == post.UserID || author.LocationPrecision < 0 {
+		return post.Latitude, post.Longitude
+	}
+	return roundCoordinate(post.Latitude, author.LocationPrecision),
+		roundCoordinate(post.Longitude, author.LocationPrecision)
+}
+
+// locationRoundingMargin 按 precision 位小数取整后坐标最多偏离的度数
+func locationRoundingMargin(precision int) float64 {
+	if precision < 0 {
+		return 0
+	}
+	if precision > maxLocationPrecision {
+		precision = maxLocationPrecision
+	}
+	return 0.5 / math.Pow(10, float64(precision))
+}
+
+// PublicBoxScope 筛选访问者可见坐标可能落在矩形范围内的帖子
+// 精确坐标按作者的模糊精度放宽范围，结果是可见坐标在范围内的帖子的超集，
+// 调用方需再用 PublicCoordinates 过滤，这样帖子是否出现只取决于可见坐标，不会泄露精确位置
+func PublicBoxScope(viewerID uint, minLat, maxLat, minLng, maxLng float64) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		var cases strings.Builder
+		for p := 0; p <= maxLocationPrecision; p++ {
+			// 多放宽一点，避免浮点误差漏掉恰好在边界上的帖子
+			fmt.Fprintf(&cases, " WHEN %d THEN %g", p, locationRoundingMargin(p)+1e-9)
+		}
+		margin := "(CASE WHEN posts.user_id = ? THEN 0 ELSE COALESCE((SELECT CASE LEAST(u.location_decimals, " +
+			fmt.Sprint(maxLocationPrecision) + ")" + cases.String() + " ELSE 0 END FROM users u WHERE u.id = posts.user_id), 0) END)"
+
+		// 先按最大偏差粗筛以利用索引，再按每个作者的精度细筛
+		widest := locationRoundingMargin(0)
+		return db.Where("posts.latitude BETWEEN ? AND ? AND posts.longitude BETWEEN ? AND ?",
+			minLat-widest, maxLat+widest, minLng-widest, maxLng+widest).
+			Where("posts.latitude BETWEEN ? - "+margin+" AND ? + "+margin, minLat, viewerID, maxLat, viewerID).
+			Where("posts.longitude BETWEEN ? - "+margin+" AND ? + "+margin, minLng, viewerID, maxLng, viewerID)
+	}
+}
+
+// roundCoordinate 把坐标四舍五入到指定小数位数
+func roundCoordinate(value float64, precision int) float64 {
+	if precision > maxLocationPrecision {
+		precision = maxLocationPrecision
+	}
+	factor := math.Pow(10, float64(precision))
+	return math.Round(value*factor) / factor
+}
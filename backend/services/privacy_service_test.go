@@ -0,0 +1,58 @@
+package services
+
+import (
+	"math"
+	"math/rand"
+	"tapspot/models"
+	"testing"
+)
+
+func TestPublicCoordinates(t *testing.T) {
+	cases := []struct {
+		precision int
+		lat, lng  float64
+	}{
+		{LocationPrecisionExact, 31.230416, 121.473701},
+		{0, 31, 121},
+		{2, 31.23, 121.47},
+		{4, 31.2304, 121.4737},
+	}
+	for _, tc := range cases {
+		post := &models.Post{
+			UserID:    1,
+			Latitude:  31.230416,
+			Longitude: 121.473701,
+			User:      models.User{ID: 1, LocationPrecision: tc.precision},
+		}
+		lat, lng := PublicCoordinates(post, 2)
+		if lat != tc.lat || lng != tc.lng {
+			t.Errorf("precision %d: got (%v, %v), want (%v, %v)", tc.precision, lat, lng, tc.lat, tc.lng)
+		}
+
+		// 作者本人总是看到精确坐标
+		if lat, lng := PublicCoordinates(post, 1); lat != post.Latitude || lng != post.Longitude {
+			t.Errorf("precision %d: author got (%v, %v)", tc.precision, lat, lng)
+		}
+	}
+}
+
+func TestLocationRoundingMargin(t *testing.T) {
+	if m := locationRoundingMargin(LocationPrecisionExact); m != 0 {
+		t.Fatalf("exact precision: got margin %v", m)
+	}
+	if locationRoundingMargin(maxLocationPrecision+2) != locationRoundingMargin(maxLocationPrecision) {
+		t.Fatal("precision above the maximum should use the maximum")
+	}
+
+	// 取整后的坐标与精确坐标的偏差不超过 margin，PublicBoxScope 依赖这一点
+	rng := rand.New(rand.NewSource(1))
+	for precision := 0; precision <= maxLocationPrecision+1; precision++ {
+		margin := locationRoundingMargin(precision)
+		for i := 0; i < 1000; i++ {
+			value := rng.Float64()*360 - 180
+			if diff := math.Abs(roundCoordinate(value, precision) - value); diff > margin+1e-9 {
+				t.Fatalf("precision %d: %v rounded off by %v, margin %v", precision, value, diff, margin)
+			}
+		}
+	}
+}
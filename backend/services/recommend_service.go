@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tapspot/dto"
+	"tapspot/llm"
+	"tapspot/models"
+	"tapspot/vectorindex"
+
+	"gorm.io/gorm"
+)
+
+// 推荐依据
+const (
+	RecommendByQuery   = "query"   // 与搜索词的语义相似度
+	RecommendByLikes   = "likes"   // 与用户点赞过的帖子的语义相似度
+	RecommendByPopular = "popular" // 没有语义依据时按热度和距离
+)
+
+// 推荐参数的默认值和上限
+const (
+	recommendDefaultLimit   = 10
+	recommendMaxLimit       = 50
+	recommendCandidatePool  = 100  // 每类对象从向量索引和数据库中取出的候选数
+	recommendNearbyKm       = 10.0 // 未指定半径时额外加入的附近候选范围
+	recommendLikedPosts     = 50   // 计算兴趣向量使用的最近点赞数
+	recommendQualityWeight  = 0.1
+	recommendSnippetRunes   = 80
+	recommendMaxRadiusKm    = 200.0
+	recommendPopularLikeCap = 50 // 点赞数达到该值时热度得分为 1
+)
+
+// RecommendQuery 推荐条件
+type RecommendQuery struct {
+	ViewerID    uint
+	Query       string // 搜索词，为空时使用用户点赞过的帖子
+	Latitude    float64
+	Longitude   float64
+	HasLocation bool
+	RadiusKm    float64 // 大于 0 时只推荐该范围内的结果
+	Type        string  // post、spot，为空时两者都推荐
+	Category    string  // 帖子类型或打卡点分类
+	Limit       int
+}
+
+// RecommendService 结合语义相似度、距离和热度的推荐
+type RecommendService struct {
+	embedder        llm.Embedder
+	distanceWeight  float64
+	distanceScaleKm float64
+}
+
+// NewRecommendService 创建推荐服务
+func NewRecommendService() *RecommendService {
+	s := &RecommendService{
+		embedder:        llm.DefaultEmbedder,
+		distanceWeight:  0.3,
+		distanceScaleKm: 5,
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("RECOMMEND_DISTANCE_WEIGHT"), 64); err == nil && v >= 0 && v <= 0.9 {
+		s.distanceWeight = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("RECOMMEND_DISTANCE_SCALE_KM"), 64); err == nil && v > 0 {
+		s.distanceScaleKm = v
+	}
+	return s
+}
+
+// Recommend 返回推荐结果和推荐依据
+func (s *RecommendService) Recommend(ctx context.Context, q RecommendQuery) ([]dto.RecommendItem, string) {
+	if q.Limit <= 0 {
+		q.Limit = recommendDefaultLimit
+	}
+	if q.Limit > recommendMaxLimit {
+		q.Limit = recommendMaxLimit
+	}
+	q.RadiusKm = math.Min(q.RadiusKm, recommendMaxRadiusKm)
+	if !q.HasLocation {
+		q.RadiusKm = 0
+	}
+
+	target, basis, liked := s.target(ctx, q)
+
+	items := []dto.RecommendItem{}
+	if q.Type != EmbeddingSpot {
+		items = append(items, s.recommendPosts(q, target, liked)...)
+	}
+	if q.Type != EmbeddingPost {
+		items = append(items, s.recommendSpots(q, target)...)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Score > items[j].Score })
+	if len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+	return items, basis
+}
+
+// target 推荐的目标向量：优先使用搜索词，其次使用登录用户的兴趣向量
+// 同时返回用户点赞过的帖子，这些帖子不再推荐
+func (s *RecommendService) target(ctx context.Context, q RecommendQuery) ([]float32, string, map[uint]bool) {
+	if query := strings.TrimSpace(q.Query); query != "" {
+		vectors, err := s.embedder.Embed(ctx, []string{query})
+		if err != nil {
+			log.Printf("⚠️ 计算搜索词向量失败: %v", err)
+		} else if vector := vectorindex.Normalize(vectors[0]); vector != nil {
+			return vector, RecommendByQuery, nil
+		}
+	}
+	if q.ViewerID > 0 {
+		if vector, liked := s.interestVector(q.ViewerID); vector != nil {
+			return vector, RecommendByLikes, liked
+		}
+	}
+	return nil, RecommendByPopular, nil
+}
+
+// interestVector 用户最近点赞的帖子向量的平均值
+func (s *RecommendService) interestVector(userID uint) ([]float32, map[uint]bool) {
+	var postIDs []uint
+	models.DB.Model(&models.Like{}).Where("user_id = ?", userID).
+		Order("created_at DESC").Limit(recommendLikedPosts).Pluck("post_id", &postIDs)
+
+	liked := make(map[uint]bool, len(postIDs))
+	var sum []float32
+	for _, postID := range postIDs {
+		liked[postID] = true
+		vector, ok := embeddingIndexes[EmbeddingPost].Vector(postID)
+		if !ok {
+			continue
+		}
+		if sum == nil {
+			sum = make([]float32, len(vector))
+		}
+		for i := range vector {
+			sum[i] += vector[i]
+		}
+	}
+	return vectorindex.Normalize(sum), liked
+}
+
+// recommendPosts 推荐帖子，遵守审核隐藏、隐身封禁、屏蔽和位置模糊规则
+func (s *RecommendService) recommendPosts(q RecommendQuery, target []float32, liked map[uint]bool) []dto.RecommendItem {
+	var candidates []models.Post
+	if target != nil {
+		ids := searchIndex(EmbeddingPost, target)
+		if len(ids) > 0 {
+			s.visiblePosts(q).Where("posts.id IN ?", ids).Find(&candidates)
+		}
+	} else {
+		s.visiblePosts(q).Order("created_at DESC").Limit(recommendCandidatePool).Find(&candidates)
+	}
+	if q.HasLocation {
+		var nearby []models.Post
+		radius := s.nearbyRadius(q)
+		minLat, maxLat, minLng, maxLng := BoundingBox(q.Latitude, q.Longitude, radius)
+		s.visiblePosts(q).Scopes(PublicBoxScope(q.ViewerID, minLat, maxLat, minLng, maxLng)).
+			Order("created_at DESC").Limit(recommendCandidatePool * 2).Find(&nearby)
+		// 附近的候选只按可见坐标判断，避免通过是否出现推断精确位置
+		for _, post := range nearby {
+			lat, lng := PublicCoordinates(&post, q.ViewerID)
+			if DistanceKm(q.Latitude, q.Longitude, lat, lng) <= radius {
+				candidates = append(candidates, post)
+			}
+		}
+	}
+
+	seen := make(map[uint]bool, len(candidates))
+	var posts []models.Post
+	for _, post := range candidates {
+		if !seen[post.ID] && !liked[post.ID] {
+			seen[post.ID] = true
+			posts = append(posts, post)
+		}
+	}
+	likeCounts := postLikeCounts(posts)
+
+	items := []dto.RecommendItem{}
+	for _, post := range posts {
+		// 距离按访问者可见的坐标计算，避免泄露精确位置
+		lat, lng := PublicCoordinates(&post, q.ViewerID)
+		distance, ok := s.distance(q, lat, lng)
+		if !ok {
+			continue
+		}
+		quality := math.Min(1, math.Log1p(float64(likeCounts[post.ID]))/math.Log1p(recommendPopularLikeCap))
+		similarity := similarityTo(EmbeddingPost, post.ID, target)
+
+		items = append(items, dto.RecommendItem{
+			ChatCard: dto.ChatCard{
+				Type:        "post",
+				ID:          post.ID,
+				Title:       post.Title,
+				Description: TruncateRunes(post.Content, recommendSnippetRunes),
+				Category:    post.Type,
+				Address:     post.LocationName,
+				Latitude:    lat,
+				Longitude:   lng,
+				Distance:    distance,
+			},
+			LikeCount:  likeCounts[post.ID],
+			Similarity: roundScore(similarity),
+			Score:      roundScore(s.score(q, target, similarity, distance, quality)),
+		})
+	}
+	return items
+}
+
+// recommendSpots 推荐打卡点
+func (s *RecommendService) recommendSpots(q RecommendQuery, target []float32) []dto.RecommendItem {
+	var candidates []models.Spot
+	if target != nil {
+		ids := searchIndex(EmbeddingSpot, target)
+		if len(ids) > 0 {
+			s.spots(q).Where("id IN ?", ids).Find(&candidates)
+		}
+	} else {
+		s.spots(q).Order("rating DESC, review_count DESC").Limit(recommendCandidatePool).Find(&candidates)
+	}
+	if q.HasLocation {
+		var nearby []models.Spot
+		minLat, maxLat, minLng, maxLng := BoundingBox(q.Latitude, q.Longitude, s.nearbyRadius(q))
+		s.spots(q).Where("latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", minLat, maxLat, minLng, maxLng).
+			Order("rating DESC").Limit(recommendCandidatePool * 2).Find(&nearby)
+		candidates = append(candidates, nearby...)
+	}
+
+	seen := make(map[uint]bool, len(candidates))
+	items := []dto.RecommendItem{}
+	for _, spot := range candidates {
+		if seen[spot.ID] {
+			continue
+		}
+		seen[spot.ID] = true
+
+		distance, ok := s.distance(q, spot.Latitude, spot.Longitude)
+		if !ok {
+			continue
+		}
+		quality := math.Min(1, math.Max(0, spot.Rating/5))
+		similarity := similarityTo(EmbeddingSpot, spot.ID, target)
+
+		items = append(items, dto.RecommendItem{
+			ChatCard: dto.ChatCard{
+				Type:        "spot",
+				ID:          spot.ID,
+				Title:       spot.Name,
+				Description: TruncateRunes(spot.Description, recommendSnippetRunes),
+				Category:    spot.Category,
+				Address:     spot.Address,
+				Latitude:    spot.Latitude,
+				Longitude:   spot.Longitude,
+				Rating:      spot.Rating,
+				Distance:    distance,
+			},
+			ReviewCount: spot.ReviewCount,
+			Similarity:  roundScore(similarity),
+			Score:       roundScore(s.score(q, target, similarity, distance, quality)),
+		})
+	}
+	return items
+}
+
+// visiblePosts 访问者可以看到的帖子，不包含自己发布的
+func (s *RecommendService) visiblePosts(q RecommendQuery) *gorm.DB {
+	query := models.DB.Where("hidden = ?", false).Scopes(ShadowBanScope("user_id", q.ViewerID))
+	if q.ViewerID > 0 {
+		query = query.Where("user_id <> ?", q.ViewerID)
+	}
+	if hidden := HiddenUserIDs(q.ViewerID); len(hidden) > 0 {
+		query = query.Where("user_id NOT IN ?", hidden)
+	}
+	if q.Category != "" {
+		query = query.Where("type = ?", q.Category)
+	}
+	return query
+}
+
+// spots 符合分类的打卡点，排除没有坐标的
+func (s *RecommendService) spots(q RecommendQuery) *gorm.DB {
+	query := models.DB.Where("latitude != 0 OR longitude != 0")
+	if q.Category != "" {
+		query = query.Where("category = ?", q.Category)
+	}
+	return query
+}
+
+// nearbyRadius 附近候选的范围
+func (s *RecommendService) nearbyRadius(q RecommendQuery) float64 {
+	if q.RadiusKm > 0 {
+		return q.RadiusKm
+	}
+	return recommendNearbyKm
+}
+
+// distance 计算距离（公里，保留两位小数），超出半径时返回 false
+func (s *RecommendService) distance(q RecommendQuery, lat, lng float64) (float64, bool) {
+	if !q.HasLocation {
+		return 0, true
+	}
+	km := DistanceKm(q.Latitude, q.Longitude, lat, lng)
+	if q.RadiusKm > 0 && km > q.RadiusKm {
+		return 0, false
+	}
+	return roundKm(km), true
+}
+
+// score 综合得分：语义相似度、距离衰减和热度按权重加权平均，缺少的部分不参与计算
+func (s *RecommendService) score(q RecommendQuery, target []float32, similarity, distanceKm, quality float64) float64 {
+	total := recommendQualityWeight * quality
+	weight := recommendQualityWeight
+	if target != nil {
+		semanticWeight := 1 - s.distanceWeight - recommendQualityWeight
+		total += semanticWeight * similarity
+		weight += semanticWeight
+	}
+	if q.HasLocation {
+		total += s.distanceWeight * math.Exp(-distanceKm/s.distanceScaleKm)
+		weight += s.distanceWeight
+	}
+	return total / weight
+}
+
+// searchIndex 从向量索引中取出与 target 最相似的候选
+func searchIndex(ownerType string, target []float32) []uint {
+	results := embeddingIndexes[ownerType].Search(target, recommendCandidatePool)
+	ids := make([]uint, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	return ids
+}
+
+// similarityTo 对象与 target 的余弦相似度，没有向量时为 0，负相关按 0 计算
+func similarityTo(ownerType string, id uint, target []float32) float64 {
+	if target == nil {
+		return 0
+	}
+	vector, ok := embeddingIndexes[ownerType].Vector(id)
+	if !ok {
+		return 0
+	}
+	return math.Max(0, float64(vectorindex.Dot(target, vector)))
+}
+
+// postLikeCounts 批量统计帖子的点赞数
+func postLikeCounts(posts []models.Post) map[uint]int {
+	counts := make(map[uint]int, len(posts))
+	if len(posts) == 0 {
+		return counts
+	}
+	ids := make([]uint, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	var rows []struct {
+		PostID uint
+		Count  int
+	}
+	models.DB.Model(&models.Like{}).Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ?", ids).Group("post_id").Scan(&rows)
+	for _, row := range rows {
+		counts[row.PostID] = row.Count
+	}
+	return counts
+}
+
+// roundScore 得分保留三位小数
+func roundScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
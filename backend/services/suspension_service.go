@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"tapspot/dto"
+	"tapspot/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SuspendUser 封禁用户，DurationHours 为 0 表示永久封禁
+func (s *ModerationService) SuspendUser(moderatorID, userID uint, req *dto.SuspendUserRequest) (*models.ModerationAction, error) {
+	if err := checkModerationTarget(moderatorID, userID); err != nil {
+		return nil, err
+	}
+
+	var until *time.Time
+	if req.DurationHours > 0 {
+		t := time.Now().Add(time.Duration(req.DurationHours) * time.Hour)
+		until = &t
+	}
+
+	return recordUserAction(moderatorID, userID, "suspend", req.Reason, suspensionUpdates(req.Reason, until))
+}
+
+// LiftSuspension 解除封禁
+func (s *ModerationService) LiftSuspension(moderatorID, userID uint, note string) (*models.ModerationAction, error) {
+	if err := checkModerationTarget(moderatorID, userID); err != nil {
+		return nil, err
+	}
+
+	return recordUserAction(moderatorID, userID, "unsuspend", note, map[string]interface{}{
+		"suspended_at":      nil,
+		"suspended_until":   nil,
+		"suspension_reason": "",
+	})
+}
+
+// SetShadowBan 开启或关闭隐身封禁
+// 隐身封禁的用户可以正常使用，但发布的帖子、评论和私信只有自己能看到
+func (s *ModerationService) SetShadowBan(moderatorID, userID uint, enabled bool, note string) (*models.ModerationAction, error) {
+	if err := checkModerationTarget(moderatorID, userID); err != nil {
+		return nil, err
+	}
+
+	action := "shadow_ban"
+	if !enabled {
+		action = "unshadow_ban"
+	}
+	return recordUserAction(moderatorID, userID, action, note, map[string]interface{}{
+		"shadow_banned": enabled,
+	})
+}
+
+// recordUserAction 更新用户状态并写入审核日志
+func recordUserAction(moderatorID, userID uint, action, note string, updates map[string]interface{}) (*models.ModerationAction, error) {
+	record := &models.ModerationAction{
+		ModeratorID:  moderatorID,
+		Action:       action,
+		TargetType:   "user",
+		TargetID:     userID,
+		TargetUserID: userID,
+		Note:         note,
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Create(record).Error
+	})
+	if err != nil {
+		return nil, errors.New("操作失败")
+	}
+	return record, nil
+}
+
+// suspensionUpdates 封禁用户时更新的字段，until 为空表示永久封禁
+func suspensionUpdates(reason string, until *time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"suspended_at":      time.Now(),
+		"suspended_until":   until,
+		"suspension_reason": reason,
+	}
+}
+
+// IsSuspended 用户当前是否处于封禁期
+func IsSuspended(user *models.User) bool {
+	if user.SuspendedAt == nil {
+		return false
+	}
+	return user.SuspendedUntil == nil || user.SuspendedUntil.After(time.Now())
+}
+
+// suspensionError 账号处于封禁期时返回的错误，已过期的临时封禁视为未封禁
+func suspensionError(user *models.User) error {
+	if !IsSuspended(user) {
+		return nil
+	}
+
+	message := "账号已被永久封禁"
+	if user.SuspendedUntil != nil {
+		message = "账号已被封禁至 " + user.SuspendedUntil.Format("2006-01-02 15:04")
+	}
+	if user.SuspensionReason != "" {
+		message += "，原因：" + user.SuspensionReason
+	}
+	return errors.New(message)
+}
+
+// IsShadowBanned 用户是否处于隐身封禁
+func IsShadowBanned(userID uint) bool {
+	var user models.User
+	if err := models.DB.Select("id", "shadow_banned").First(&user, userID).Error; err != nil {
+		return false
+	}
+	return user.ShadowBanned
+}
+
+// ShadowBanScope 过滤隐身封禁用户发布的内容（访问者本人的内容除外）
+// column 为内容表中作者 ID 的列名
+func ShadowBanScope(column string, viewerID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		banned := models.DB.Model(&models.User{}).Select("id").Where("shadow_banned = ?", true)
+		return db.Where("("+column+" NOT IN (?) OR "+column+" = ?)", banned, viewerID)
+	}
+}
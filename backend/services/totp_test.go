@@ -0,0 +1,82 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret RFC 6238 附录 B 中 SHA-1 的测试密钥 "12345678901234567890"
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 给出的是 8 位验证码，6 位验证码取其后 6 位
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tc := range cases {
+		code, err := totpCode(rfc6238Secret, tc.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("totpCode(%d): %v", tc.unix, err)
+		}
+		if code != tc.code {
+			t.Errorf("time %d: got %s, want %s", tc.unix, code, tc.code)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	upper, _ := totpCode(rfc6238Secret, 1)
+	lower, err := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || lower != upper {
+		t.Fatalf("lowercase secret: got %q %v, want %q", lower, err, upper)
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Fatal("expected error for invalid secret")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code := func(step int64) string {
+		c, err := totpCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatalf("totpCode: %v", err)
+		}
+		return c
+	}
+
+	cases := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current window", code(current), 0, current, true},
+		{"previous window", code(current - 1), 0, current - 1, true},
+		{"next window", code(current + 1), 0, current + 1, true},
+		{"too old", code(current - 2), 0, 0, false},
+		{"too new", code(current + 2), 0, 0, false},
+		{"surrounding spaces", " " + code(current) + " ", 0, current, true},
+		{"wrong length", code(current)[:5], 0, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		{"replay of last step", code(current), current, 0, false},
+		{"replay of earlier step", code(current - 1), current, 0, false},
+		{"newer than last step", code(current + 1), current, current + 1, true},
+	}
+	for _, tc := range cases {
+		step, ok := validateTOTP(rfc6238Secret, tc.code, now, tc.lastStep)
+		if ok != tc.wantOK || step != tc.wantStep {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", tc.name, step, ok, tc.wantStep, tc.wantOK)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"tapspot/dto"
+	"tapspot/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// challengeTokenTTL 登录第二步的有效期
+	challengeTokenTTL = 5 * time.Minute
+	// recoveryCodeCount 每次生成的恢复码数量
+	recoveryCodeCount = 10
+	// challengeMaxAttempts 单个挑战 token 最多可以输错的次数，超过后需要重新输入密码登录
+	challengeMaxAttempts = 5
+	// twoFactorFailureWindow 和 twoFactorMaxFailures 限制同一用户在时间窗口内所有挑战的输错总数，
+	// 防止知道密码的攻击者反复登录换取新的挑战继续猜测
+	twoFactorFailureWindow = 15 * time.Minute
+	twoFactorMaxFailures   = 10
+)
+
+var (
+	errInvalidTwoFactorCode = errors.New("验证码错误")
+	errChallengeExpired     = errors.New("登录已超时，请重新登录")
+	errTooManyTwoFactor     = errors.New("验证码错误次数过多，请稍后重新登录")
+)
+
+// TwoFactorService 两步验证服务（TOTP）
+type TwoFactorService struct {
+	authService *AuthService
+}
+
+// NewTwoFactorService 创建两步验证服务实例
+func NewTwoFactorService() *TwoFactorService {
+	return &TwoFactorService{
+		authService: NewAuthService(),
+	}
+}
+
+// Enroll 生成新的 TOTP 密钥，需调用 Enable 确认后才生效
+func (s *TwoFactorService) Enroll(userID uint) (*dto.TwoFactorEnrollResponse, error) {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("已开启两步验证")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, errors.New("生成密钥失败")
+	}
+	if err := models.DB.Model(&user).Update("totp_secret", secret).Error; err != nil {
+		return nil, errors.New("生成密钥失败")
+	}
+
+	return &dto.TwoFactorEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: totpURI(secret, user.Username),
+	}, nil
+}
+
+// Enable 校验验证码后开启两步验证，返回恢复码
+func (s *TwoFactorService) Enable(userID uint, code string) (*dto.RecoveryCodesResponse, error) {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("已开启两步验证")
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.New("请先获取绑定二维码")
+	}
+
+	step, ok := validateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, errInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("开启两步验证失败")
+	}
+
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// Disable 关闭两步验证（需要重新验证密码）
+func (s *TwoFactorService) Disable(userID uint, req *dto.TwoFactorPasswordRequest) error {
+	user, err := s.reauthenticate(userID, req)
+	if err != nil {
+		return err
+	}
+
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return errors.New("关闭两步验证失败")
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码（旧恢复码全部作废）
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID uint, req *dto.TwoFactorPasswordRequest) (*dto.RecoveryCodesResponse, error) {
+	user, err := s.reauthenticate(userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("生成恢复码失败")
+	}
+
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// VerifyLogin 登录第二步：校验挑战 token 和验证码（或恢复码），签发正式 token
+// 挑战 token 只能成功使用一次，输错 challengeMaxAttempts 次后作废
+func (s *TwoFactorService) VerifyLogin(req *dto.TwoFactorLoginRequest) (*dto.LoginResponse, error) {
+	user, challenge, err := parseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if recentTwoFactorFailures(user.ID) >= twoFactorMaxFailures {
+		return nil, errTooManyTwoFactor
+	}
+
+	if err := verifyTwoFactorCode(user, req.Code); err != nil {
+		// 条件更新，并发请求也不会超过次数上限
+		result := models.DB.Model(&models.LoginChallenge{}).
+			Where("id = ? AND attempts < ?", challenge.ID, challengeMaxAttempts).
+			Update("attempts", gorm.Expr("attempts + 1"))
+		if result.Error != nil || result.RowsAffected != 1 || challenge.Attempts+1 >= challengeMaxAttempts {
+			return nil, errTooManyTwoFactor
+		}
+		return nil, err
+	}
+
+	result := models.DB.Model(&models.LoginChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, errChallengeExpired
+	}
+
+	return s.authService.issueSession(user)
+}
+
+// recentTwoFactorFailures 用户最近一段时间内两步验证输错的总次数
+func recentTwoFactorFailures(userID uint) int {
+	var total int
+	models.DB.Model(&models.LoginChallenge{}).
+		Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-twoFactorFailureWindow)).
+		Select("COALESCE(SUM(attempts), 0)").Scan(&total)
+	return total
+}
+
+// reauthenticate 敏感操作前重新验证身份
+// 有密码的用户校验密码，第三方登录且无密码的用户校验当前验证码
+func (s *TwoFactorService) reauthenticate(userID uint, req *dto.TwoFactorPasswordRequest) (*models.User, error) {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	if !user.TOTPEnabled {
+		return nil, errors.New("未开启两步验证")
+	}
+
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+			return nil, errors.New("密码错误")
+		}
+		return &user, nil
+	}
+
+	if err := verifyTwoFactorCode(&user, req.Code); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// verifyTwoFactorCode 校验 TOTP 验证码，失败时再尝试恢复码
+func verifyTwoFactorCode(user *models.User, code string) error {
+	if step, ok := validateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		// 条件更新，避免同一验证码被并发请求重复使用
+		result := models.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil || result.RowsAffected != 1 {
+			return errInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	result := models.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return errInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes 删除旧恢复码并生成新的一组，返回明文
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(buf)
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(code),
+		})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode 恢复码哈希（忽略大小写和分隔符）
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateChallengeToken 生成登录第二步使用的短期 token，并记录挑战用于统计输错次数
+func generateChallengeToken(user *models.User) (string, error) {
+	now := time.Now()
+	challenge := models.LoginChallenge{
+		UserID:    user.ID,
+		TokenID:   randomURLToken(24),
+		ExpiresAt: now.Add(challengeTokenTTL),
+	}
+	// 顺带清理统计窗口之外的挑战
+	models.DB.Where("created_at < ?", now.Add(-twoFactorFailureWindow)).Delete(&models.LoginChallenge{})
+	if err := models.DB.Create(&challenge).Error; err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"user_id": user.ID,
+		"ver":     user.TokenVersion,
+		"typ":     "2fa", // ParseToken 会拒绝带 typ 的 token
+		"jti":     challenge.TokenID,
+		"exp":     challenge.ExpiresAt.Unix(),
+		"iat":     now.Unix(),
+		"iss":     "tapspot",
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// parseChallengeToken 校验挑战 token，返回对应用户和仍然有效的挑战记录
+func parseChallengeToken(tokenString string) (*models.User, *models.LoginChallenge, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil || !token.Valid {
+		return nil, nil, errChallengeExpired
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, nil, errChallengeExpired
+	}
+	if typ, _ := claims["typ"].(string); typ != "2fa" {
+		return nil, nil, errChallengeExpired
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, nil, errChallengeExpired
+	}
+	version, _ := claims["ver"].(float64)
+	tokenID, _ := claims["jti"].(string)
+	if tokenID == "" {
+		return nil, nil, errChallengeExpired
+	}
+
+	var user models.User
+	if err := models.DB.First(&user, uint(userID)).Error; err != nil {
+		return nil, nil, errChallengeExpired
+	}
+	if int(version) != user.TokenVersion || !user.TOTPEnabled {
+		return nil, nil, errChallengeExpired
+	}
+
+	var challenge models.LoginChallenge
+	if err := models.DB.Where("token_id = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?",
+		tokenID, user.ID, time.Now()).First(&challenge).Error; err != nil {
+		return nil, nil, errChallengeExpired
+	}
+	if challenge.Attempts >= challengeMaxAttempts {
+		return nil, nil, errTooManyTwoFactor
+	}
+
+	return &user, &challenge, nil
+}
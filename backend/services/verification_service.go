@@ -0,0 +1,258 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"tapspot/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 验证渠道
+const (
+	VerifyChannelEmail = "email"
+	VerifyChannelPhone = "phone"
+	// verifyChannelDeletion 注销账号的邮件确认码，与邮箱验证码分开计数
+	verifyChannelDeletion = "deletion"
+)
+
+const (
+	// verificationCodeTTL 验证码有效期
+	verificationCodeTTL = 10 * time.Minute
+	// verificationResendInterval 同一渠道重新发送验证码的最短间隔
+	verificationResendInterval = time.Minute
+	// verificationMaxAttempts 验证码最多可以输错的次数，超过后需要重新发送
+	verificationMaxAttempts = 5
+)
+
+var errInvalidVerificationCode = errors.New("验证码错误或已过期，请重新获取")
+
+// SMSSender 短信发送接口，接入短信服务商时实现该接口
+type SMSSender interface {
+	Send(to, text string) error
+}
+
+// LogSMSSender 仅把短信内容打印到日志，ShowSecrets 为 false 时隐藏验证码
+type LogSMSSender struct {
+	ShowSecrets bool
+}
+
+// Send 打印短信内容
+func (s *LogSMSSender) Send(to, text string) error {
+	if !s.ShowSecrets {
+		text = "[已隐藏]"
+	}
+	log.Printf("📱 [LogSMSSender] to=%s\n%s", to, text)
+	return nil
+}
+
+// NewSMSSenderFromEnv 创建短信发送器，目前只有日志输出（仅开发模式下输出验证码）
+func NewSMSSenderFromEnv() SMSSender {
+	return &LogSMSSender{ShowSecrets: DevMode()}
+}
+
+// VerificationService 邮箱和手机号验证：验证后才能用于登录和找回密码
+type VerificationService struct {
+	mailer Mailer
+	sms    SMSSender
+}
+
+// NewVerificationService 创建验证服务实例
+func NewVerificationService(mailer Mailer, sms SMSSender) *VerificationService {
+	return &VerificationService{mailer: mailer, sms: sms}
+}
+
+// SendCode 向当前账号的邮箱或手机号发送 6 位验证码，之前未使用的验证码作废
+func (s *VerificationService) SendCode(userID uint, channel string) error {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return errors.New("用户不存在")
+	}
+	target, verified, err := verificationTarget(&user, channel)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return errors.New("请先在资料中填写" + channelName(channel))
+	}
+	if verified {
+		return errors.New(channelName(channel) + "已验证")
+	}
+	// 没有真实的发送渠道时验证码无法送达，非开发模式下不发放
+	if !s.canDeliver(channel) {
+		return errors.New(channelName(channel) + "验证暂不可用")
+	}
+
+	code, err := issueVerificationCode(userID, channel, target)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("你的 TapSpot 验证码是 %s，%d 分钟内有效。如果不是你本人操作，请忽略。",
+		code, int(verificationCodeTTL.Minutes()))
+	if channel == VerifyChannelEmail {
+		err = s.mailer.Send(target, "TapSpot 邮箱验证", text)
+	} else {
+		err = s.sms.Send(target, text)
+	}
+	if err != nil {
+		log.Printf("发送验证码失败: %v", err)
+		return errors.New("发送验证码失败")
+	}
+	return nil
+}
+
+// Confirm 校验验证码，通过后把邮箱或手机号标记为已验证
+// 其他账号中未验证的相同邮箱/手机号会被清除，避免同一个值对应多个账号
+func (s *VerificationService) Confirm(userID uint, channel, code string) error {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return errors.New("用户不存在")
+	}
+	target, verified, err := verificationTarget(&user, channel)
+	if err != nil {
+		return err
+	}
+	if verified {
+		return nil
+	}
+
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := consumeVerificationCode(tx, userID, channel, target, code); err != nil {
+			return err
+		}
+
+		column := channel
+		if err := tx.Model(&models.User{}).
+			Where(column+" = ? AND id != ? AND "+column+"_verified = ?", target, userID, false).
+			Update(column, "").Error; err != nil {
+			return errors.New("验证失败，请稍后重试")
+		}
+		if err := tx.Model(&models.User{}).Where("id = ? AND "+column+" = ?", userID, target).
+			Update(column+"_verified", true).Error; err != nil {
+			return errors.New("验证失败，请稍后重试")
+		}
+		return nil
+	})
+}
+
+// canDeliver 渠道是否可以真正送达验证码
+func (s *VerificationService) canDeliver(channel string) bool {
+	if channel == VerifyChannelEmail {
+		return mailerCanDeliver(s.mailer)
+	}
+	if DevMode() {
+		return true
+	}
+	_, logOnly := s.sms.(*LogSMSSender)
+	return !logOnly
+}
+
+// mailerCanDeliver 邮件是否可以真正送达（开发模式下日志输出也算）
+func mailerCanDeliver(mailer Mailer) bool {
+	if DevMode() {
+		return true
+	}
+	_, logOnly := mailer.(*LogMailer)
+	return !logOnly
+}
+
+// issueVerificationCode 生成并保存新的验证码，之前未使用的验证码作废，返回明文用于发送
+func issueVerificationCode(userID uint, channel, target string) (string, error) {
+	var last models.IdentifierVerification
+	models.DB.Where("user_id = ? AND channel = ?", userID, channel).Order("id DESC").Limit(1).Find(&last)
+	if last.ID != 0 && time.Since(last.CreatedAt) < verificationResendInterval {
+		return "", errors.New("验证码发送过于频繁，请稍后再试")
+	}
+
+	code, err := generateVerificationCode()
+	if err != nil {
+		return "", errors.New("生成验证码失败")
+	}
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.IdentifierVerification{}).
+			Where("user_id = ? AND channel = ? AND used_at IS NULL", userID, channel).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.IdentifierVerification{
+			UserID:    userID,
+			Channel:   channel,
+			Target:    target,
+			CodeHash:  hashResetToken(verificationSecret(userID, target, code)),
+			ExpiresAt: now.Add(verificationCodeTTL),
+		}).Error
+	})
+	if err != nil {
+		return "", errors.New("发送验证码失败")
+	}
+	return code, nil
+}
+
+// consumeVerificationCode 校验并使用验证码，输错次数过多后验证码作废
+func consumeVerificationCode(tx *gorm.DB, userID uint, channel, target, code string) error {
+	var record models.IdentifierVerification
+	if err := tx.Where("user_id = ? AND channel = ? AND target = ? AND used_at IS NULL AND expires_at > ?",
+		userID, channel, target, time.Now()).Order("id DESC").First(&record).Error; err != nil {
+		return errInvalidVerificationCode
+	}
+	if record.Attempts >= verificationMaxAttempts {
+		return errInvalidVerificationCode
+	}
+
+	expected := hashResetToken(verificationSecret(userID, target, code))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(record.CodeHash)) != 1 {
+		// 输错次数单独提交，不随事务回滚
+		models.DB.Model(&models.IdentifierVerification{}).Where("id = ?", record.ID).
+			Update("attempts", gorm.Expr("attempts + 1"))
+		return errInvalidVerificationCode
+	}
+
+	// 条件更新保证验证码只能被使用一次
+	result := tx.Model(&models.IdentifierVerification{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected != 1 {
+		return errInvalidVerificationCode
+	}
+	return nil
+}
+
+// verificationTarget 当前账号在该渠道的值及其验证状态
+func verificationTarget(user *models.User, channel string) (string, bool, error) {
+	switch channel {
+	case VerifyChannelEmail:
+		return user.Email, user.EmailVerified, nil
+	case VerifyChannelPhone:
+		return user.Phone, user.PhoneVerified, nil
+	}
+	return "", false, errors.New("不支持的验证方式")
+}
+
+// channelName 渠道的中文名称
+func channelName(channel string) string {
+	if channel == VerifyChannelPhone {
+		return "手机号"
+	}
+	return "邮箱"
+}
+
+// verificationSecret 参与哈希的内容：验证码与用户和目标绑定，换了邮箱或手机号后旧验证码不能通过
+func verificationSecret(userID uint, target, code string) string {
+	return fmt.Sprintf("%d:%s:%s", userID, target, code)
+}
+
+// generateVerificationCode 生成 6 位数字验证码
+func generateVerificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,416 @@
+// Package vectorindex 进程内的向量近似最近邻索引（HNSW）
+package vectorindex
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// ErrDimension 向量维度与索引不一致
+var ErrDimension = errors.New("向量维度不一致")
+
+// ErrZeroVector 零向量无法计算余弦相似度
+var ErrZeroVector = errors.New("向量不能全为 0")
+
+// Config 索引参数，零值使用默认值
+type Config struct {
+	M              int   // 每层每个节点的最大邻居数，第 0 层为 2M，默认 16
+	EfConstruction int   // 插入时的候选集大小，默认 200
+	EfSearch       int   // 查询时的候选集大小，默认 64
+	Seed           int64 // 随机层数的种子，默认 1
+}
+
+// Result 查询结果，Score 为余弦相似度
+type Result struct {
+	ID    uint
+	Score float32
+}
+
+// node 图中的节点，删除和更新只做标记，节点仍作为路径保留
+type node struct {
+	id      uint
+	vector  []float32 // 已归一化
+	friends [][]int32 // 每层的邻居
+	deleted bool
+}
+
+// HNSW 分层可导航小世界图，向量按余弦相似度检索，可并发使用
+type HNSW struct {
+	mu sync.RWMutex
+
+	dim            int
+	m              int
+	m0             int
+	efConstruction int
+	efSearch       int
+	levelMult      float64
+	rng            *rand.Rand
+
+	nodes    []*node
+	ids      map[uint]int32
+	entry    int32 // 入口节点，-1 表示空索引
+	maxLevel int
+	deleted  int
+}
+
+// New 创建索引，维度由第一次插入的向量决定
+func New(cfg Config) *HNSW {
+	if cfg.M <= 0 {
+		cfg.M = 16
+	}
+	if cfg.EfConstruction <= 0 {
+		cfg.EfConstruction = 200
+	}
+	if cfg.EfSearch <= 0 {
+		cfg.EfSearch = 64
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = 1
+	}
+	return &HNSW{
+		m:              cfg.M,
+		m0:             cfg.M * 2,
+		efConstruction: cfg.EfConstruction,
+		efSearch:       cfg.EfSearch,
+		levelMult:      1 / math.Log(float64(cfg.M)),
+		rng:            rand.New(rand.NewSource(cfg.Seed)),
+		ids:            make(map[uint]int32),
+		entry:          -1,
+	}
+}
+
+// Len 索引中有效的向量数
+func (h *HNSW) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.ids)
+}
+
+// Add 插入向量，id 已存在时替换原向量
+func (h *HNSW) Add(id uint, vector []float32) error {
+	normalized := Normalize(vector)
+	if normalized == nil {
+		return ErrZeroVector
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.dim == 0 {
+		h.dim = len(normalized)
+	}
+	if len(normalized) != h.dim {
+		return ErrDimension
+	}
+	if old, ok := h.ids[id]; ok {
+		h.nodes[old].deleted = true
+		h.deleted++
+	}
+	h.insert(id, normalized)
+
+	// 标记删除的节点过多时重建，避免查询时大量跳过
+	if h.deleted > 64 && h.deleted > len(h.ids) {
+		h.rebuild()
+	}
+	return nil
+}
+
+// Remove 删除向量
+func (h *HNSW) Remove(id uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	idx, ok := h.ids[id]
+	if !ok {
+		return
+	}
+	h.nodes[idx].deleted = true
+	delete(h.ids, id)
+	h.deleted++
+	if h.deleted > 64 && h.deleted > len(h.ids) {
+		h.rebuild()
+	}
+}
+
+// Vector 返回 id 对应的向量（归一化后的副本）
+func (h *HNSW) Vector(id uint) ([]float32, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	idx, ok := h.ids[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]float32(nil), h.nodes[idx].vector...), true
+}
+
+// Search 返回与 query 最相似的 k 个向量，按相似度从高到低排序
+func (h *HNSW) Search(query []float32, k int) []Result {
+	normalized := Normalize(query)
+	if normalized == nil || k <= 0 {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.entry < 0 || len(normalized) != h.dim {
+		return nil
+	}
+
+	ep := h.entry
+	for level := h.maxLevel; level > 0; level-- {
+		ep = h.greedy(normalized, ep, level)
+	}
+	ef := h.efSearch
+	if ef < k {
+		ef = k
+	}
+	candidates := h.searchLayer(normalized, []candidate{{idx: ep, dist: h.distance(normalized, ep)}}, ef, 0)
+
+	results := make([]Result, 0, k)
+	for _, c := range candidates {
+		n := h.nodes[c.idx]
+		if n.deleted {
+			continue
+		}
+		results = append(results, Result{ID: n.id, Score: 1 - c.dist})
+		if len(results) == k {
+			break
+		}
+	}
+	return results
+}
+
+// Normalize 返回归一化后的副本，零向量返回 nil
+func Normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return nil
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	normalized := make([]float32, len(vector))
+	for i, v := range vector {
+		normalized[i] = v * scale
+	}
+	return normalized
+}
+
+// Dot 两个向量的点积，对归一化后的向量即余弦相似度
+func Dot(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// insert 插入已归一化的向量（调用方持有写锁）
+func (h *HNSW) insert(id uint, vector []float32) {
+	level := h.randomLevel()
+	idx := int32(len(h.nodes))
+	n := &node{id: id, vector: vector, friends: make([][]int32, level+1)}
+	h.nodes = append(h.nodes, n)
+	h.ids[id] = idx
+
+	if h.entry < 0 {
+		h.entry, h.maxLevel = idx, level
+		return
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedy(vector, ep, l)
+	}
+	entryPoints := []candidate{{idx: ep, dist: h.distance(vector, ep)}}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(vector, entryPoints, h.efConstruction, l)
+		n.friends[l] = h.selectNeighbors(candidates, h.m)
+
+		maxFriends := h.m
+		if l == 0 {
+			maxFriends = h.m0
+		}
+		for _, friendIdx := range n.friends[l] {
+			friend := h.nodes[friendIdx]
+			friend.friends[l] = append(friend.friends[l], idx)
+			if len(friend.friends[l]) > maxFriends {
+				friend.friends[l] = h.shrink(friend, l, maxFriends)
+			}
+		}
+		entryPoints = candidates
+	}
+
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = idx, level
+	}
+}
+
+// rebuild 只用有效节点重建整个图
+func (h *HNSW) rebuild() {
+	live := make([]*node, 0, len(h.ids))
+	for _, n := range h.nodes {
+		if !n.deleted {
+			live = append(live, n)
+		}
+	}
+
+	h.nodes = make([]*node, 0, len(live))
+	h.ids = make(map[uint]int32, len(live))
+	h.entry, h.maxLevel, h.deleted = -1, 0, 0
+	for _, n := range live {
+		h.insert(n.id, n.vector)
+	}
+}
+
+// randomLevel 按指数分布随机选择节点的最高层
+func (h *HNSW) randomLevel() int {
+	return int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+}
+
+// distance 余弦距离
+func (h *HNSW) distance(vector []float32, idx int32) float32 {
+	return 1 - Dot(vector, h.nodes[idx].vector)
+}
+
+// greedy 在某一层上贪心地移动到离 vector 最近的节点
+func (h *HNSW) greedy(vector []float32, ep int32, level int) int32 {
+	best := h.distance(vector, ep)
+	for changed := true; changed; {
+		changed = false
+		for _, friendIdx := range h.nodes[ep].friends[level] {
+			if d := h.distance(vector, friendIdx); d < best {
+				ep, best, changed = friendIdx, d, true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer 在某一层上做有限宽度的最佳优先搜索，返回按距离升序排列的至多 ef 个节点
+func (h *HNSW) searchLayer(vector []float32, entryPoints []candidate, ef int, level int) []candidate {
+	visited := make(map[int32]bool, ef*4)
+	toVisit := &minHeap{}
+	found := &maxHeap{}
+	for _, ep := range entryPoints {
+		if visited[ep.idx] {
+			continue
+		}
+		visited[ep.idx] = true
+		heap.Push(toVisit, ep)
+		heap.Push(found, ep)
+		if found.Len() > ef {
+			heap.Pop(found)
+		}
+	}
+
+	for toVisit.Len() > 0 {
+		current := heap.Pop(toVisit).(candidate)
+		if found.Len() >= ef && current.dist > (*found)[0].dist {
+			break
+		}
+		for _, friendIdx := range h.nodes[current.idx].friends[level] {
+			if visited[friendIdx] {
+				continue
+			}
+			visited[friendIdx] = true
+			d := h.distance(vector, friendIdx)
+			if found.Len() < ef || d < (*found)[0].dist {
+				heap.Push(toVisit, candidate{idx: friendIdx, dist: d})
+				heap.Push(found, candidate{idx: friendIdx, dist: d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	results := []candidate(*found)
+	sort.Slice(results, func(i, j int) bool { return results[i].dist < results[j].dist })
+	return results
+}
+
+// selectNeighbors 启发式选择邻居：候选只有在离目标比离已选邻居更近时才入选，
+// 使邻居分布在不同方向上；不足 m 个时再按距离补齐
+func (h *HNSW) selectNeighbors(candidates []candidate, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var skipped []int32
+	for _, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		keep := true
+		for _, s := range selected {
+			if h.distance(h.nodes[c.idx].vector, s) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c.idx)
+		} else {
+			skipped = append(skipped, c.idx)
+		}
+	}
+	for _, idx := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, idx)
+	}
+	return selected
+}
+
+// shrink 邻居超过上限时重新选择
+func (h *HNSW) shrink(n *node, level int, m int) []int32 {
+	candidates := make([]candidate, len(n.friends[level]))
+	for i, friendIdx := range n.friends[level] {
+		candidates[i] = candidate{idx: friendIdx, dist: h.distance(n.vector, friendIdx)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	return h.selectNeighbors(candidates, m)
+}
+
+// candidate 搜索中的节点及其到目标的距离
+type candidate struct {
+	idx  int32
+	dist float32
+}
+
+// minHeap 距离最小的在堆顶
+type minHeap []candidate
+
+func (q minHeap) Len() int            { return len(q) }
+func (q minHeap) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q minHeap) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *minHeap) Push(x interface{}) { *q = append(*q, x.(candidate)) }
+func (q *minHeap) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// maxHeap 距离最大的在堆顶
+type maxHeap []candidate
+
+func (q maxHeap) Len() int            { return len(q) }
+func (q maxHeap) Less(i, j int) bool  { return q[i].dist > q[j].dist }
+func (q maxHeap) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *maxHeap) Push(x interface{}) { *q = append(*q, x.(candidate)) }
+func (q *maxHeap) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,174 @@
+package vectorindex
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+const (
+	testDim     = 16
+	testK       = 10
+	testQueries = 50
+	minRecall   = 0.9
+)
+
+func randomVector(rng *rand.Rand) []float32 {
+	v := make([]float32, testDim)
+	for i := range v {
+		v[i] = float32(rng.NormFloat64())
+	}
+	return v
+}
+
+// bruteForce 对所有向量逐个计算相似度，返回最相似的 k 个 id
+func bruteForce(vectors map[uint][]float32, query []float32, k int) []uint {
+	normalized := Normalize(query)
+	ids := make([]uint, 0, len(vectors))
+	for id := range vectors {
+		ids = append(ids, id)
+	}
+	scores := make(map[uint]float32, len(ids))
+	for _, id := range ids {
+		scores[id] = Dot(normalized, Normalize(vectors[id]))
+	}
+	sort.Slice(ids, func(i, j int) bool { return scores[ids[i]] > scores[ids[j]] })
+	if len(ids) > k {
+		ids = ids[:k]
+	}
+	return ids
+}
+
+// recall 用随机查询比较 Search 与暴力检索的结果，返回平均召回率
+func recall(t *testing.T, h *HNSW, vectors map[uint][]float32, rng *rand.Rand) float64 {
+	t.Helper()
+	var hits, total int
+	for q := 0; q < testQueries; q++ {
+		query := randomVector(rng)
+		expected := bruteForce(vectors, query, testK)
+		results := h.Search(query, testK)
+
+		found := make(map[uint]bool, len(results))
+		for i, r := range results {
+			if _, ok := vectors[r.ID]; !ok {
+				t.Fatalf("search returned removed id %d", r.ID)
+			}
+			if i > 0 && r.Score > results[i-1].Score {
+				t.Fatalf("results not sorted by score: %+v", results)
+			}
+			found[r.ID] = true
+		}
+		for _, id := range expected {
+			if found[id] {
+				hits++
+			}
+		}
+		total += len(expected)
+	}
+	return float64(hits) / float64(total)
+}
+
+func TestSearchRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	h := New(Config{})
+	vectors := make(map[uint][]float32)
+	for id := uint(1); id <= 2000; id++ {
+		vectors[id] = randomVector(rng)
+		if err := h.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	if r := recall(t, h, vectors, rng); r < minRecall {
+		t.Fatalf("recall %.3f below %.2f", r, minRecall)
+	}
+
+	// 删除一部分（不足以触发重建），已删除的 id 不能出现在结果中
+	for id := uint(1); id <= 40; id++ {
+		h.Remove(id)
+		delete(vectors, id)
+	}
+	if h.deleted == 0 {
+		t.Fatal("expected removals to be marked, not rebuilt")
+	}
+	if r := recall(t, h, vectors, rng); r < minRecall {
+		t.Fatalf("recall after remove %.3f below %.2f", r, minRecall)
+	}
+
+	// 替换向量后应按新向量检索
+	for id := uint(41); id <= 80; id++ {
+		vectors[id] = randomVector(rng)
+		if err := h.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if h.Len() != len(vectors) {
+		t.Fatalf("expected %d vectors, got %d", len(vectors), h.Len())
+	}
+	if r := recall(t, h, vectors, rng); r < minRecall {
+		t.Fatalf("recall after replace %.3f below %.2f", r, minRecall)
+	}
+}
+
+func TestSearchRecallAfterRebuild(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	h := New(Config{})
+	vectors := make(map[uint][]float32)
+	for id := uint(1); id <= 1000; id++ {
+		vectors[id] = randomVector(rng)
+		if err := h.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	// 删除超过一半，触发重建
+	for id := uint(1); id <= 600; id++ {
+		h.Remove(id)
+		delete(vectors, id)
+	}
+	if len(h.nodes) >= 1000 || len(h.nodes)-h.deleted != len(vectors) {
+		t.Fatalf("expected rebuild, got %d deleted and %d nodes", h.deleted, len(h.nodes))
+	}
+	if r := recall(t, h, vectors, rng); r < minRecall {
+		t.Fatalf("recall after rebuild %.3f below %.2f", r, minRecall)
+	}
+
+	// 重建后的图可以继续插入
+	for id := uint(1001); id <= 1200; id++ {
+		vectors[id] = randomVector(rng)
+		if err := h.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if r := recall(t, h, vectors, rng); r < minRecall {
+		t.Fatalf("recall after rebuild and add %.3f below %.2f", r, minRecall)
+	}
+}
+
+func TestSearchSmallIndex(t *testing.T) {
+	h := New(Config{})
+	if results := h.Search([]float32{1, 0}, 3); results != nil {
+		t.Fatalf("expected no results from empty index, got %+v", results)
+	}
+
+	h.Add(1, []float32{1, 0})
+	h.Add(2, []float32{0, 1})
+	h.Add(3, []float32{1, 1})
+	results := h.Search([]float32{2, 0}, 5)
+	if len(results) != 3 || results[0].ID != 1 || results[1].ID != 3 || results[2].ID != 2 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if results[0].Score < 0.999 {
+		t.Fatalf("expected exact match score near 1, got %f", results[0].Score)
+	}
+
+	if err := h.Add(4, []float32{0, 0}); err != ErrZeroVector {
+		t.Fatalf("expected ErrZeroVector, got %v", err)
+	}
+	if err := h.Add(4, []float32{1, 2, 3}); err != ErrDimension {
+		t.Fatalf("expected ErrDimension, got %v", err)
+	}
+	if results := h.Search([]float32{1, 2, 3}, 1); results != nil {
+		t.Fatalf("expected no results for wrong dimension, got %+v", results)
+	}
+}
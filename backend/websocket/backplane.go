@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 节点之间转发的消息类型
+const (
+	EnvelopeDeliver    = "deliver"    // 投递给用户的所有连接
+	EnvelopeDisconnect = "disconnect" // 断开用户的所有连接
+)
+
+// presenceTTL 节点上报的在线状态有效期，节点异常退出后在线状态在有效期后失效
+const presenceTTL = 60 * time.Second
+
+// Envelope 通过 Backplane 在节点之间转发的消息
+type Envelope struct {
+	Node   string          `json:"node"` // 发布消息的节点
+	Kind   string          `json:"kind"`
+	UserID uint            `json:"user_id"`
+	Data   json.RawMessage `json:"data,omitempty"` // 原样发送给客户端的消息
+}
+
+// Backplane 多实例部署时在节点之间转发消息并共享在线状态
+type Backplane interface {
+	// Publish 把消息发布给所有节点（包括自己）
+	Publish(env Envelope) error
+	// Subscribe 注册接收消息的回调，断线后由实现自动重连
+	Subscribe(handler func(Envelope)) error
+	// SetPresence 记录用户在某个节点上线或下线
+	SetPresence(node string, userID uint, online bool) error
+	// RefreshPresence 续期节点上在线用户的状态
+	RefreshPresence(node string, userIDs []uint) error
+	// IsOnline 用户是否在任意节点在线
+	IsOnline(userID uint) (bool, error)
+	Close() error
+}
+
+// BackplaneFromEnv 按 WS_BACKPLANE 创建 Backplane：memory（默认，单节点）或 redis
+// 连接 Redis 失败时退回单节点模式
+func BackplaneFromEnv() Backplane {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("WS_BACKPLANE"))) {
+	case "redis":
+		db, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+		channel := os.Getenv("WS_BACKPLANE_CHANNEL")
+		if channel == "" {
+			channel = "tapspot:ws"
+		}
+		b, err := NewRedisBackplane(RedisConfig{
+			Addr:     os.Getenv("REDIS_ADDR"),
+			Password: os.Getenv("REDIS_PASSWORD"),
+			DB:       db,
+			Channel:  channel,
+		})
+		if err != nil {
+			log.Printf("⚠️ 连接 Redis 失败，WebSocket 消息只在本节点内投递: %v", err)
+			return NewMemoryBackplane()
+		}
+		log.Printf("✅ WebSocket 使用 Redis 在节点间转发消息 (%s)", b.cfg.Addr)
+		return b
+	}
+	return NewMemoryBackplane()
+}
+
+// MemoryBackplane 进程内的 Backplane，用于单节点部署
+type MemoryBackplane struct {
+	mu       sync.RWMutex
+	handlers []func(Envelope)
+	presence map[uint]map[string]time.Time // userID -> 节点 -> 过期时间
+}
+
+// NewMemoryBackplane 创建进程内 Backplane
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{presence: make(map[uint]map[string]time.Time)}
+}
+
+// Publish 同步调用所有回调
+func (m *MemoryBackplane) Publish(env Envelope) error {
+	m.mu.RLock()
+	handlers := append([]func(Envelope){}, m.handlers...)
+	m.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(env)
+	}
+	return nil
+}
+
+// Subscribe 注册回调
+func (m *MemoryBackplane) Subscribe(handler func(Envelope)) error {
+	m.mu.Lock()
+	m.handlers = append(m.handlers, handler)
+	m.mu.Unlock()
+	return nil
+}
+
+// SetPresence 记录用户在节点上线或下线
+func (m *MemoryBackplane) SetPresence(node string, userID uint, online bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if online {
+		m.touch(node, userID)
+		return nil
+	}
+	if nodes, ok := m.presence[userID]; ok {
+		delete(nodes, node)
+		if len(nodes) == 0 {
+			delete(m.presence, userID)
+		}
+	}
+	return nil
+}
+
+// RefreshPresence 续期节点上在线用户的状态
+func (m *MemoryBackplane) RefreshPresence(node string, userIDs []uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, userID := range userIDs {
+		m.touch(node, userID)
+	}
+	return nil
+}
+
+// touch 更新在线状态的过期时间（调用方持有锁）
+func (m *MemoryBackplane) touch(node string, userID uint) {
+	nodes, ok := m.presence[userID]
+	if !ok {
+		nodes = make(map[string]time.Time)
+		m.presence[userID] = nodes
+	}
+	nodes[node] = time.Now().Add(presenceTTL)
+}
+
+// IsOnline 用户是否在任意节点在线
+func (m *MemoryBackplane) IsOnline(userID uint) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	for _, expiresAt := range m.presence[userID] {
+		if expiresAt.After(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Close 进程内 Backplane 无需释放资源
+func (m *MemoryBackplane) Close() error {
+	return nil
+}
@@ -1,14 +1,18 @@
 package websocket
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"tapspot/models"
+	"tapspot/middleware"
+	"tapspot/services"
 )
 
 var upgrader = websocket.Upgrader{
@@ -19,27 +23,39 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
-// Client 表示一个 WebSocket 客户端
+// Client 表示一个 WebSocket 连接
 type Client struct {
-	ID     uint
+	ID     uint   // 用户ID
+	ConnID string // 连接ID，区分同一用户的多个设备
 	Conn   *websocket.Conn
 	Send   chan []byte
 	Hub    *Hub
+
+	closeReason string // Hub 主动关闭连接的原因，发送关闭帧时告知客户端
+	since       uint   // 重连时需要补发的消息起点，为 0 时不补发
 }
 
-// Hub 管理所有 WebSocket 连接
+// Hub 管理本节点的 WebSocket 连接
+// 同一用户可以在多个设备（或标签页）同时在线，消息投递到该用户的所有连接
+// 多实例部署时通过 Backplane 把消息转发给其他节点上的连接
 type Hub struct {
-	Clients    map[uint]*Client // userID -> Client
+	Node       string                      // 节点ID
+	Clients    map[uint]map[string]*Client // userID -> 连接ID -> Client
 	Register   chan *Client
 	Unregister chan *Client
-	Broadcast  chan *Message
+	backplane  Backplane
 	mu         sync.RWMutex
+	presence   chan presenceEvent // 在线状态变化，由 presenceLoop 按顺序上报，避免 Redis 往返阻塞注册和注销
+
+	typing   map[typingKey]*typingEntry // 正在输入的状态
+	typingMu sync.Mutex
 }
 
 // Message WebSocket 消息格式
 type Message struct {
-	Type           string `json:"type"`           // "chat", "read", "typing"
+	Type           string `json:"type"`           // "chat", "read", "typing", "presence", "group", "error", "warning"
 	ConversationID uint   `json:"conversation_id"` // 会话ID
+	GroupID        uint   `json:"group_id,omitempty"` // 群聊ID，群消息和群已读时不为 0
 	SenderID       uint   `json:"sender_id"`
 	SenderName     string `json:"sender_name"`    // 发送者昵称
 	ReceiverID     uint   `json:"receiver_id"`
@@ -47,86 +63,185 @@ type Message struct {
 	PostID         *uint  `json:"post_id,omitempty"`
 	CreatedAt      string `json:"created_at"`
 	IsMe           bool   `json:"is_me"`          // 是否是自己发的
+	LastReadID     uint   `json:"last_read_id,omitempty"` // read：已读到的最后一条消息 ID
+	LastSeen       string `json:"last_seen,omitempty"`    // presence：离线时的最后在线时间
+	ExpiresIn      int    `json:"expires_in,omitempty"`   // typing：输入状态的有效秒数，期间没有续期视为停止输入
+	ClientMsgID    string `json:"client_msg_id,omitempty"` // chat/ack/error：客户端生成的消息 ID，重发时用于去重
+	MessageID      uint   `json:"message_id,omitempty"`    // chat/ack：服务端保存的消息 ID；synced：补发到的最后一条消息 ID
+}
+
+// presenceEvent 用户在本节点的第一个连接建立（online）或最后一个连接断开
+type presenceEvent struct {
+	userID uint
+	online bool
 }
 
-// NewHub 创建一个新的 Hub
-func NewHub() *Hub {
+// NewHub 创建一个新的 Hub，backplane 为 nil 时只在本节点内投递
+// 节点ID 取 WS_NODE_ID，未设置时由主机名和随机数生成
+func NewHub(backplane Backplane) *Hub {
+	if backplane == nil {
+		backplane = NewMemoryBackplane()
+	}
+	node := os.Getenv("WS_NODE_ID")
+	if node == "" {
+		hostname, _ := os.Hostname()
+		node = hostname + "-" + newConnectionID()[:8]
+	}
 	return &Hub{
-		Clients:    make(map[uint]*Client),
+		Node:       node,
+		Clients:    make(map[uint]map[string]*Client),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
-		Broadcast:  make(chan *Message),
+		backplane:  backplane,
+		presence:   make(chan presenceEvent, 1024),
+		typing:     make(map[typingKey]*typingEntry),
 	}
 }
 
 // Run 启动 Hub
 func (h *Hub) Run() {
+	if err := h.backplane.Subscribe(h.handleEnvelope); err != nil {
+		log.Printf("⚠️ 订阅其他节点的消息失败: %v", err)
+	}
+	go h.refreshPresence()
+	go h.presenceLoop()
+
 	for {
 		select {
 		case client := <-h.Register:
 			h.mu.Lock()
-			h.Clients[client.ID] = client
+			conns, ok := h.Clients[client.ID]
+			if !ok {
+				conns = make(map[string]*Client)
+				h.Clients[client.ID] = conns
+			}
+			conns[client.ConnID] = client
+			count := len(conns)
 			h.mu.Unlock()
-			log.Printf("👤 用户 %d 已连接 WebSocket", client.ID)
+			if count == 1 {
+				h.presence <- presenceEvent{userID: client.ID, online: true}
+			}
+			// 注册完成后再补发，保证补发的消息和 synced 不会因为连接尚未注册而被丢弃
+			if client.since > 0 {
+				go client.replay(client.since)
+			}
+			log.Printf("👤 用户 %d 已连接 WebSocket（连接 %s，当前 %d 个连接）", client.ID, client.ConnID, count)
 
 		case client := <-h.Unregister:
-			h.mu.Lock()
-			if _, ok := h.Clients[client.ID]; ok {
-				delete(h.Clients, client.ID)
-				close(client.Send)
-			}
-			h.mu.Unlock()
-			log.Printf("👤 用户 %d 已断开 WebSocket", client.ID)
-
-		case message := <-h.Broadcast:
-			log.Printf("广播消息: sender=%d, receiver=%d", message.SenderID, message.ReceiverID)
-			h.mu.RLock()
-			// 发送给接收者
-			if client, ok := h.Clients[message.ReceiverID]; ok {
-				log.Printf("接收者 %d 在线，发送消息", message.ReceiverID)
-				// 接收者收到的消息 is_me = false
-				msgCopy := *message
-				msgCopy.IsMe = false
-				// 查找接收者的会话 ID（接收者与发送者的会话）
-				var receiverConv models.Conversation
-				if err := models.DB.Where("user_id = ? AND peer_id = ?", message.ReceiverID, message.SenderID).First(&receiverConv).Error; err == nil {
-					msgCopy.ConversationID = receiverConv.ID
-					log.Printf("接收者的会话 ID: %d", receiverConv.ID)
-				} else {
-					log.Printf("未找到接收者会话: user_id=%d, peer_id=%d, err=%v", message.ReceiverID, message.SenderID, err)
-				}
-				// 同时添加接收者需要用来匹配的字段
-				msgBytes := h.serializeMessage(&msgCopy)
-				log.Printf("发送给接收者的消息: %s", string(msgBytes))
-				select {
-				case client.Send <- msgBytes:
-					log.Printf("消息已发送到接收者的 Send channel")
-				default:
-					close(client.Send)
-					delete(h.Clients, client.ID)
-					log.Printf("接收者 channel 满了，关闭连接")
+			if removed, remaining := h.removeClient(client, ""); removed {
+				log.Printf("👤 用户 %d 的连接 %s 已断开 WebSocket（剩余 %d 个连接）", client.ID, client.ConnID, remaining)
+				if remaining == 0 {
+					log.Printf("👤 用户 %d 已离线", client.ID)
 				}
-			} else {
-				log.Printf("接收者 %d 不在线", message.ReceiverID)
 			}
-			// 也发送给发送者（用于同步）
-			if client, ok := h.Clients[message.SenderID]; ok {
-				log.Printf("发送者 %d 在线，发送消息同步", message.SenderID)
-				// 发送者收到的消息 is_me = true
-				msgCopy := *message
-				msgCopy.IsMe = true
-				msgBytes := h.serializeMessage(&msgCopy)
-				log.Printf("发送给发送者的消息: %s", string(msgBytes))
-				select {
-				case client.Send <- msgBytes:
-					log.Printf("消息已发送到发送者的 Send channel")
-				default:
-					close(client.Send)
-					delete(h.Clients, client.ID)
-					log.Printf("发送者 channel 满了，关闭连接")
-				}
+
+		}
+	}
+}
+
+// deliver 把消息投递到用户在本节点的所有连接，返回成功投递的连接数
+// 发送缓冲区已满的连接带着 CloseSendBufferFull 关闭，客户端重连后按 since 补收消息
+func (h *Hub) deliver(userID uint, data []byte) int {
+	var slow []*Client
+	sent := 0
+	h.mu.RLock()
+	for _, client := range h.Clients[userID] {
+		select {
+		case client.Send <- data:
+			sent++
+		default:
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range slow {
+		h.closeSlowClient(client)
+	}
+	return sent
+}
+
+// sendTo 向单个连接发送消息，返回是否发送成功；连接已移除时忽略，缓冲区已满时关闭连接
+func (h *Hub) sendTo(client *Client, data []byte) bool {
+	h.mu.RLock()
+	if h.Clients[client.ID][client.ConnID] != client {
+		h.mu.RUnlock()
+		return false
+	}
+	select {
+	case client.Send <- data:
+		h.mu.RUnlock()
+		return true
+	default:
+	}
+	h.mu.RUnlock()
+	h.closeSlowClient(client)
+	return false
+}
+
+// closeSlowClient 关闭发送缓冲区已满的连接，并通过关闭帧告知客户端原因
+func (h *Hub) closeSlowClient(client *Client) {
+	if removed, _ := h.removeClient(client, "send buffer full, reconnect with since"); removed {
+		log.Printf("⚠️ 用户 %d 的连接 %s 发送缓冲区已满，已关闭连接等待客户端重连补收", client.ID, client.ConnID)
+	}
+}
+
+// isRegistered 连接是否仍在 Hub 中
+func (h *Hub) isRegistered(client *Client) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.Clients[client.ID][client.ConnID] == client
+}
+
+// removeClient 移除连接并关闭其发送通道，返回是否移除以及该用户在本节点剩余的连接数
+// 只移除同一个连接，避免误关同一用户其他设备的通道；最后一个连接移除后上报下线
+// reason 不为空时 writePump 在关闭帧中带上原因
+func (h *Hub) removeClient(client *Client, reason string) (bool, int) {
+	h.mu.Lock()
+	conns := h.Clients[client.ID]
+	if conns[client.ConnID] != client {
+		h.mu.Unlock()
+		return false, len(conns)
+	}
+	delete(conns, client.ConnID)
+	client.closeReason = reason
+	close(client.Send)
+	remaining := len(conns)
+	if remaining == 0 {
+		delete(h.Clients, client.ID)
+	}
+	h.mu.Unlock()
+
+	if remaining == 0 {
+		h.presence <- presenceEvent{userID: client.ID, online: false}
+	}
+	return true, remaining
+}
+
+// presenceLoop 按发生顺序处理在线状态变化：上报 Backplane 并通知联系人
+func (h *Hub) presenceLoop() {
+	for event := range h.presence {
+		h.mu.RLock()
+		local := len(h.Clients[event.userID]) > 0
+		h.mu.RUnlock()
+		// 处理前状态已经再次变化（例如断开后马上重连），以后面的事件为准
+		if local != event.online {
+			continue
+		}
+		if event.online {
+			// 在其他节点已经在线时不重复通知联系人
+			elsewhere, _ := h.backplane.IsOnline(event.userID)
+			h.setPresence(event.userID, true)
+			if !elsewhere {
+				go h.notifyPresence(event.userID, true)
 			}
-			h.mu.RUnlock()
+			continue
+		}
+		h.setPresence(event.userID, false)
+		// 在其他节点仍有连接时不算离线
+		if !h.IsUserOnline(event.userID) {
+			h.stopAllTyping(event.userID)
+			go h.notifyPresence(event.userID, false)
 		}
 	}
 }
@@ -137,26 +252,93 @@ func (h *Hub) serializeMessage(msg *Message) []byte {
 	return data
 }
 
-// SendToUser 发送消息给特定用户
+// SendToUser 发送消息给特定用户的所有设备，包括连接在其他节点上的设备
 func (h *Hub) SendToUser(userID uint, message []byte) {
+	h.deliver(userID, message)
+	h.publish(Envelope{Kind: EnvelopeDeliver, UserID: userID, Data: message})
+}
+
+// DisconnectUser 断开用户在所有节点上的 WebSocket 连接（例如账号被封禁时）
+func (h *Hub) DisconnectUser(userID uint) {
+	h.disconnect(userID)
+	h.publish(Envelope{Kind: EnvelopeDisconnect, UserID: userID})
+}
+
+// disconnect 断开用户在本节点的所有连接
+func (h *Hub) disconnect(userID uint) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	if client, ok := h.Clients[userID]; ok {
-		select {
-		case client.Send <- message:
-		default:
-			close(client.Send)
-			delete(h.Clients, userID)
-		}
+	for _, client := range h.Clients[userID] {
+		// 关闭底层连接后 readPump 退出并注销客户端
+		client.Conn.Close()
 	}
 }
 
-// IsUserOnline 检查用户是否在线
+// IsUserOnline 检查用户是否在线（在任意节点至少有一个设备连接）
 func (h *Hub) IsUserOnline(userID uint) bool {
 	h.mu.RLock()
-	defer h.mu.RUnlock()
-	_, ok := h.Clients[userID]
-	return ok
+	local := len(h.Clients[userID]) > 0
+	h.mu.RUnlock()
+	if local {
+		return true
+	}
+	online, err := h.backplane.IsOnline(userID)
+	if err != nil {
+		log.Printf("⚠️ 查询用户 %d 在其他节点的在线状态失败: %v", userID, err)
+	}
+	return online
+}
+
+// publish 把消息转发给其他节点
+func (h *Hub) publish(env Envelope) {
+	env.Node = h.Node
+	if err := h.backplane.Publish(env); err != nil {
+		log.Printf("⚠️ 转发消息到其他节点失败: %v", err)
+	}
+}
+
+// handleEnvelope 处理其他节点转发的消息，忽略本节点发布的消息
+func (h *Hub) handleEnvelope(env Envelope) {
+	if env.Node == h.Node {
+		return
+	}
+	switch env.Kind {
+	case EnvelopeDeliver:
+		h.deliver(env.UserID, env.Data)
+	case EnvelopeDisconnect:
+		h.disconnect(env.UserID)
+	}
+}
+
+// setPresence 上报用户在本节点上线或下线
+func (h *Hub) setPresence(userID uint, online bool) {
+	if err := h.backplane.SetPresence(h.Node, userID, online); err != nil {
+		log.Printf("⚠️ 上报用户 %d 的在线状态失败: %v", userID, err)
+	}
+}
+
+// refreshPresence 定期续期本节点在线用户的状态，节点退出后状态自动过期
+func (h *Hub) refreshPresence() {
+	ticker := time.NewTicker(presenceTTL / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.mu.RLock()
+		userIDs := make([]uint, 0, len(h.Clients))
+		for userID := range h.Clients {
+			userIDs = append(userIDs, userID)
+		}
+		h.mu.RUnlock()
+		if err := h.backplane.RefreshPresence(h.Node, userIDs); err != nil {
+			log.Printf("⚠️ 续期在线状态失败: %v", err)
+		}
+	}
+}
+
+// newConnectionID 生成随机的连接ID
+func newConnectionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // ValidateTokenFunc 外部传入的token验证函数
@@ -167,12 +349,13 @@ var GlobalHub *Hub
 
 // InitHub 初始化全局 Hub
 func InitHub() {
-	GlobalHub = NewHub()
+	GlobalHub = NewHub(BackplaneFromEnv())
 	go GlobalHub.Run()
 }
 
 // HandleWebSocket WebSocket 连接处理
-func HandleWebSocket(w http.ResponseWriter, r *http.Request, userID uint) {
+// since 为客户端已收到的最后一条消息 ID，大于 0 时连接建立后补发之后的消息
+func HandleWebSocket(w http.ResponseWriter, r *http.Request, userID uint, since uint) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket 升级失败: %v", err)
@@ -180,10 +363,12 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request, userID uint) {
 	}
 
 	client := &Client{
-		ID:   userID,
-		Conn: conn,
-		Send: make(chan []byte, 256),
-		Hub:  GlobalHub,
+		ID:     userID,
+		ConnID: newConnectionID(),
+		Conn:   conn,
+		Send:   make(chan []byte, 256),
+		Hub:    GlobalHub,
+		since:  since,
 	}
 
 	GlobalHub.Register <- client
@@ -216,17 +401,13 @@ func (c *Client) readPump() {
 			break
 		}
 
-		log.Printf("收到 WebSocket 消息: %s", string(message))
-
 		// 解析消息
 		var msg Message
 		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("消息解析错误: %v", err)
+			log.Printf("用户 %d 的 WebSocket 消息解析错误: %v", c.ID, err)
 			continue
 		}
 
-		log.Printf("解析后: type=%s, sender=%d, receiver=%d, content=%s", msg.Type, c.ID, msg.ReceiverID, msg.Content)
-
 		// 设置发送者
 		msg.SenderID = c.ID
 		msg.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
@@ -234,54 +415,64 @@ func (c *Client) readPump() {
 		// 处理不同类型的消息
 		switch msg.Type {
 		case "chat":
-			log.Printf("处理聊天消息: %+v", msg)
-			// 保存消息到数据库
-			dbMsg := models.Message{
-				SenderID:   msg.SenderID,
-				ReceiverID: msg.ReceiverID,
-				Content:    msg.Content,
-				PostID:     msg.PostID,
-				IsRead:     false,
+			// 与 HTTP 发送接口共用限流额度
+			if _, ok := middleware.AllowUser(middleware.NamedRateLimitPolicy("send_message"), msg.SenderID); !ok {
+				c.sendError(&msg, "发送过于频繁，请稍后再试")
+				continue
 			}
-			if err := models.DB.Create(&dbMsg).Error; err != nil {
-				log.Printf("保存消息失败: %v", err)
+			sent, err := services.NewMessageService().Send(services.SendMessageInput{
+				SenderID:    msg.SenderID,
+				ReceiverID:  msg.ReceiverID,
+				GroupID:     msg.GroupID,
+				Content:     msg.Content,
+				PostID:      msg.PostID,
+				ClientMsgID: msg.ClientMsgID,
+			})
+			if err != nil {
+				c.sendError(&msg, err.Error())
 				continue
 			}
-			log.Printf("消息已保存到数据库, ID=%d", dbMsg.ID)
-
-			// 更新或创建会话，并获取会话ID
-			convID := updateConversation(msg.SenderID, msg.ReceiverID, msg.Content)
-			msg.ConversationID = convID
-
-			// 获取发送者昵称
-			var sender models.User
-			if err := models.DB.First(&sender, msg.SenderID).Error; err == nil {
-				if sender.Nickname != "" {
-					msg.SenderName = sender.Nickname
-				} else {
-					msg.SenderName = sender.Username
-				}
+			c.ack(msg.ClientMsgID, sent)
+			// 重发的消息已经推送过，只重新确认
+			if !sent.Duplicate {
+				c.Hub.DeliverMessage(sent, msg.ClientMsgID)
 			}
 
-			// 广播消息
-			log.Printf("准备广播消息: sender=%d, receiver=%d, convID=%d", msg.SenderID, msg.ReceiverID, msg.ConversationID)
-			c.Hub.Broadcast <- &msg
-			log.Printf("消息已加入广播队列")
-
 		case "read":
-			// 标记消息为已读
-			models.DB.Model(&models.Message{}).
-				Where("sender_id = ? AND receiver_id = ? AND is_read = ?", msg.ReceiverID, c.ID, false).
-				Update("is_read", true)
-
-			// 更新会话未读数
-			models.DB.Model(&models.Conversation{}).
-				Where("user_id = ? AND peer_id = ?", c.ID, msg.ReceiverID).
-				Update("unread_count", 0)
+			// 标记消息为已读，已读位置前进时推送已读回执
+			if msg.GroupID != 0 {
+				c.Hub.markGroupRead(c.ID, msg.GroupID)
+				continue
+			}
+			lastReadID, advanced, err := services.MarkConversationRead(c.ID, msg.ReceiverID)
+			if err != nil {
+				log.Printf("标记已读失败: %v", err)
+				continue
+			}
+			if advanced {
+				c.Hub.NotifyRead(c.ID, msg.ReceiverID, lastReadID)
+			}
+
+		case "typing":
+			c.handleTyping(&msg)
 		}
 	}
 }
 
+// sendError 向当前连接发送错误提示，带上原消息的 client_msg_id 便于客户端对应
+func (c *Client) sendError(original *Message, content string) {
+	msg := &Message{
+		Type:        "error",
+		SenderID:    c.ID,
+		ReceiverID:  original.ReceiverID,
+		GroupID:     original.GroupID,
+		Content:     content,
+		CreatedAt:   time.Now().Format("2006-01-02 15:04:05"),
+		ClientMsgID: original.ClientMsgID,
+	}
+	c.Hub.sendTo(c, c.Hub.serializeMessage(msg))
+}
+
 // writePump 向客户端发送消息
 func (c *Client) writePump() {
 	ticker := time.NewTicker(54 * time.Second)
@@ -295,10 +486,16 @@ func (c *Client) writePump() {
 		case message, ok := <-c.Send:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMessage := []byte{}
+				if c.closeReason != "" {
+					closeMessage = websocket.FormatCloseMessage(CloseSendBufferFull, c.closeReason)
+				}
+				c.Conn.WriteMessage(websocket.CloseMessage, closeMessage)
+				return
+			}
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
-			c.Conn.WriteMessage(websocket.TextMessage, message)
 
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
@@ -308,48 +505,3 @@ func (c *Client) writePump() {
 		}
 	}
 }
-
-// updateConversation 更新会话，返回会话ID
-func updateConversation(userID, peerID uint, lastMessage string) uint {
-	now := time.Now()
-
-	// 更新发送者的会话
-	var conv models.Conversation
-	result := models.DB.Where("user_id = ? AND peer_id = ?", userID, peerID).First(&conv)
-	if result.Error != nil {
-		// 创建新会话
-		conv = models.Conversation{
-			UserID:      userID,
-			PeerID:      peerID,
-			LastMessage: lastMessage,
-			LastMsgTime: now,
-			UnreadCount: 0,
-		}
-		models.DB.Create(&conv)
-	} else {
-		conv.LastMessage = lastMessage
-		conv.LastMsgTime = now
-		models.DB.Save(&conv)
-	}
-
-	// 更新接收者的会话（增加未读数）
-	var peerConv models.Conversation
-	result = models.DB.Where("user_id = ? AND peer_id = ?", peerID, userID).First(&peerConv)
-	if result.Error != nil {
-		peerConv = models.Conversation{
-			UserID:      peerID,
-			PeerID:      userID,
-			LastMessage: lastMessage,
-			LastMsgTime: now,
-			UnreadCount: 1,
-		}
-		models.DB.Create(&peerConv)
-	} else {
-		peerConv.LastMessage = lastMessage
-		peerConv.LastMsgTime = now
-		peerConv.UnreadCount++
-		models.DB.Save(&peerConv)
-	}
-
-	return conv.ID
-}
@@ -0,0 +1,193 @@
+package websocket
+
+import (
+	"time"
+
+	"tapspot/models"
+	"tapspot/services"
+)
+
+// CloseSendBufferFull 发送缓冲区积压时关闭连接使用的状态码，客户端应带上 since 重新连接补收消息
+const CloseSendBufferFull = 4008
+
+// 补发离线消息的参数
+const (
+	replayBatchSize = 50
+	replayLimit     = 1000            // 单次连接最多补发的消息数，更早的消息通过 HTTP 接口获取
+	replayWait      = 5 * time.Second // 等待客户端消费缓冲区的最长时间
+)
+
+// ack 向发送消息的连接确认消息已保存，message_id 为服务端保存的消息 ID
+func (c *Client) ack(clientMsgID string, sent *services.SentMessage) {
+	stored := &sent.Message
+	msg := &Message{
+		Type:           "ack",
+		ConversationID: sent.ConversationID,
+		GroupID:        sent.GroupID,
+		SenderID:       stored.SenderID,
+		SenderName:     sent.SenderName,
+		Content:        stored.Content,
+		PostID:         stored.PostID,
+		CreatedAt:      stored.CreatedAt.Format("2006-01-02 15:04:05"),
+		IsMe:           true,
+		ClientMsgID:    clientMsgID,
+		MessageID:      stored.ID,
+	}
+	if stored.ReceiverID != nil {
+		msg.ReceiverID = *stored.ReceiverID
+	}
+	c.Hub.sendTo(c, c.Hub.serializeMessage(msg))
+}
+
+// DeliverMessage 把已保存的私信推送给接收者和发送者的所有设备，群消息推送给所有成员
+// 隐身封禁用户的消息只推送给发送者自己；clientMsgID 让发送者的其他设备与本地消息对应
+func (h *Hub) DeliverMessage(sent *services.SentMessage, clientMsgID string) {
+	if sent.GroupID != 0 {
+		h.deliverGroupMessage(sent, clientMsgID)
+		return
+	}
+	stored := &sent.Message
+	msg := &Message{
+		Type:           "chat",
+		ConversationID: sent.PeerConversationID,
+		SenderID:       stored.SenderID,
+		SenderName:     sent.SenderName,
+		ReceiverID:     *stored.ReceiverID,
+		Content:        stored.Content,
+		PostID:         stored.PostID,
+		CreatedAt:      stored.CreatedAt.Format("2006-01-02 15:04:05"),
+		MessageID:      stored.ID,
+	}
+	if !stored.Shadowed {
+		h.SendToUser(*stored.ReceiverID, h.serializeMessage(msg))
+	}
+
+	msg.ConversationID = sent.ConversationID
+	msg.IsMe = true
+	msg.ClientMsgID = clientMsgID
+	h.SendToUser(stored.SenderID, h.serializeMessage(msg))
+}
+
+// deliverGroupMessage 把群消息推送给在线的群成员，发送者的消息带上 is_me 和 client_msg_id
+func (h *Hub) deliverGroupMessage(sent *services.SentMessage, clientMsgID string) {
+	stored := &sent.Message
+	msg := &Message{
+		Type:       "chat",
+		GroupID:    sent.GroupID,
+		SenderID:   stored.SenderID,
+		SenderName: sent.SenderName,
+		Content:    stored.Content,
+		PostID:     stored.PostID,
+		CreatedAt:  stored.CreatedAt.Format("2006-01-02 15:04:05"),
+		MessageID:  stored.ID,
+	}
+	if !stored.Shadowed {
+		data := h.serializeMessage(msg)
+		for _, memberID := range sent.MemberIDs {
+			if memberID != stored.SenderID {
+				h.SendToUser(memberID, data)
+			}
+		}
+	}
+
+	msg.IsMe = true
+	msg.ClientMsgID = clientMsgID
+	h.SendToUser(stored.SenderID, h.serializeMessage(msg))
+}
+
+// replay 补发 ID 大于 since 的消息（包括自己在其他设备发出的消息和入群后的群消息），完成后发送 synced
+// 客户端按 message_id 去重：补发期间实时推送的消息可能与补发的消息重复
+func (c *Client) replay(since uint) {
+	cursor := since
+	senderNames := make(map[uint]string)
+	conversations := make(map[uint]uint)
+	for sent := 0; sent < replayLimit; {
+		var messages []models.Message
+		models.DB.Where("id > ?", cursor).
+			Where("(receiver_id = ? AND shadowed = ?) OR sender_id = ? OR (shadowed = ? AND EXISTS ("+
+				"SELECT 1 FROM group_members gm WHERE gm.group_id = messages.group_id AND gm.user_id = ? AND messages.id > gm.joined_after_id))",
+				c.ID, false, c.ID, false, c.ID).
+			Order("id ASC").Limit(replayBatchSize).Find(&messages)
+
+		if !c.waitForBuffer(len(messages)) {
+			return
+		}
+		for _, message := range messages {
+			if message.GroupID != nil {
+				if !c.replayGroupMessage(&message, senderNames) {
+					return
+				}
+				cursor = message.ID
+				sent++
+				continue
+			}
+			peerID := message.SenderID
+			if peerID == c.ID {
+				peerID = *message.ReceiverID
+			}
+			if _, ok := conversations[peerID]; !ok {
+				conversations[peerID] = services.ConversationID(c.ID, peerID)
+			}
+			if _, ok := senderNames[message.SenderID]; !ok {
+				senderNames[message.SenderID] = services.DisplayName(message.SenderID)
+			}
+			msg := &Message{
+				Type:           "chat",
+				ConversationID: conversations[peerID],
+				SenderID:       message.SenderID,
+				SenderName:     senderNames[message.SenderID],
+				ReceiverID:     *message.ReceiverID,
+				Content:        message.Content,
+				PostID:         message.PostID,
+				CreatedAt:      message.CreatedAt.Format("2006-01-02 15:04:05"),
+				IsMe:           message.SenderID == c.ID,
+				MessageID:      message.ID,
+			}
+			if !c.Hub.sendTo(c, c.Hub.serializeMessage(msg)) {
+				return
+			}
+			cursor = message.ID
+			sent++
+		}
+		if len(messages) < replayBatchSize {
+			break
+		}
+	}
+
+	c.Hub.sendTo(c, c.Hub.serializeMessage(&Message{
+		Type:      "synced",
+		MessageID: cursor,
+		CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
+	}))
+}
+
+// replayGroupMessage 补发一条群消息
+func (c *Client) replayGroupMessage(message *models.Message, senderNames map[uint]string) bool {
+	if _, ok := senderNames[message.SenderID]; !ok {
+		senderNames[message.SenderID] = services.DisplayName(message.SenderID)
+	}
+	msg := &Message{
+		Type:       "chat",
+		GroupID:    *message.GroupID,
+		SenderID:   message.SenderID,
+		SenderName: senderNames[message.SenderID],
+		Content:    message.Content,
+		PostID:     message.PostID,
+		CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
+		IsMe:       message.SenderID == c.ID,
+		MessageID:  message.ID,
+	}
+	return c.Hub.sendTo(c, c.Hub.serializeMessage(msg))
+}
+
+// waitForBuffer 等待发送缓冲区腾出 n 条消息的空间，连接已断开或等待超时返回 false
+func (c *Client) waitForBuffer(n int) bool {
+	deadline := time.Now().Add(replayWait)
+	for cap(c.Send)-len(c.Send) < n {
+		if !c.Hub.isRegistered(c) || time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return c.Hub.isRegistered(c)
+}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"tapspot/services"
+)
+
+// 群成员变化（group 消息的 content）
+const (
+	GroupJoined  = "joined"  // 被邀请加入群聊，推送给新成员
+	GroupRemoved = "removed" // 被移出群聊，推送给被移出的用户
+	GroupUpdated = "updated" // 成员或角色有变化，推送给其他成员，客户端应重新获取群详情
+)
+
+// markGroupRead 处理客户端上报的群已读
+func (h *Hub) markGroupRead(userID, groupID uint) {
+	lastReadID, advanced, err := services.NewGroupService().MarkRead(userID, groupID)
+	if err != nil {
+		log.Printf("标记群消息已读失败: %v", err)
+		return
+	}
+	if advanced {
+		h.NotifyGroupRead(userID, groupID, lastReadID)
+	}
+}
+
+// NotifyGroupRead 向群成员推送已读位置，读者自己的其他设备收到 is_me 为 true 的消息
+func (h *Hub) NotifyGroupRead(readerID, groupID, lastReadID uint) {
+	if lastReadID == 0 {
+		return
+	}
+	msg := &Message{
+		Type:       "read",
+		GroupID:    groupID,
+		SenderID:   readerID,
+		LastReadID: lastReadID,
+		CreatedAt:  time.Now().Format("2006-01-02 15:04:05"),
+	}
+	// 隐身封禁用户的已读位置不推送给其他成员
+	if !services.IsShadowBanned(readerID) {
+		data := h.serializeMessage(msg)
+		for _, memberID := range services.GroupMemberIDs(groupID) {
+			if memberID != readerID {
+				h.SendToUser(memberID, data)
+			}
+		}
+	}
+
+	msg.IsMe = true
+	h.SendToUser(readerID, h.serializeMessage(msg))
+}
+
+// NotifyGroup 向指定用户推送群成员变化，actorID 为执行操作的用户
+func (h *Hub) NotifyGroup(userIDs []uint, groupID, actorID uint, event string) {
+	data := h.serializeMessage(&Message{
+		Type:      "group",
+		GroupID:   groupID,
+		SenderID:  actorID,
+		Content:   event,
+		CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
+	})
+	for _, userID := range userIDs {
+		h.SendToUser(userID, data)
+	}
+}
@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"tapspot/services"
+)
+
+// 输入状态（typing 消息的 content）
+const (
+	TypingStart = "start"
+	TypingStop  = "stop"
+)
+
+// 在线状态（presence 消息的 content）
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// typingTTL 输入状态的有效期，期间没有收到新的 typing 消息视为停止输入
+const typingTTL = 6 * time.Second
+
+// typingKey 谁正在给谁输入
+type typingKey struct {
+	senderID   uint
+	receiverID uint
+}
+
+// typingEntry 输入状态，到期后自动发送 stop
+type typingEntry struct {
+	timer *time.Timer
+}
+
+// handleTyping 处理客户端上报的输入状态，content 为 start 或 stop
+// 客户端在持续输入时应每隔几秒重发 start，服务端只在状态变化时转发给对方
+func (c *Client) handleTyping(msg *Message) {
+	if msg.ReceiverID == 0 || msg.ReceiverID == c.ID {
+		return
+	}
+	if msg.Content == TypingStop {
+		c.Hub.stopTyping(c.ID, msg.ReceiverID)
+		return
+	}
+	c.Hub.startTyping(c.ID, msg.ReceiverID)
+}
+
+// startTyping 开始输入或续期输入状态
+func (h *Hub) startTyping(senderID, receiverID uint) {
+	key := typingKey{senderID: senderID, receiverID: receiverID}
+	h.typingMu.Lock()
+	if entry, ok := h.typing[key]; ok {
+		entry.timer.Reset(typingTTL)
+		h.typingMu.Unlock()
+		return
+	}
+	h.typingMu.Unlock()
+
+	// 存在屏蔽关系或发送者处于隐身封禁时不转发
+	if services.IsBlocked(senderID, receiverID) || services.IsShadowBanned(senderID) {
+		return
+	}
+
+	h.typingMu.Lock()
+	if _, ok := h.typing[key]; ok {
+		h.typingMu.Unlock()
+		return
+	}
+	entry := &typingEntry{}
+	entry.timer = time.AfterFunc(typingTTL, func() { h.expireTyping(key, entry) })
+	h.typing[key] = entry
+	h.typingMu.Unlock()
+
+	h.sendTyping(key, TypingStart)
+}
+
+// stopTyping 客户端主动停止输入
+func (h *Hub) stopTyping(senderID, receiverID uint) {
+	key := typingKey{senderID: senderID, receiverID: receiverID}
+	h.typingMu.Lock()
+	entry, ok := h.typing[key]
+	if ok {
+		entry.timer.Stop()
+		delete(h.typing, key)
+	}
+	h.typingMu.Unlock()
+	if ok {
+		h.sendTyping(key, TypingStop)
+	}
+}
+
+// stopAllTyping 用户离线时结束其所有输入状态
+func (h *Hub) stopAllTyping(senderID uint) {
+	var stopped []typingKey
+	h.typingMu.Lock()
+	for key, entry := range h.typing {
+		if key.senderID == senderID {
+			entry.timer.Stop()
+			delete(h.typing, key)
+			stopped = append(stopped, key)
+		}
+	}
+	h.typingMu.Unlock()
+	for _, key := range stopped {
+		h.sendTyping(key, TypingStop)
+	}
+}
+
+// expireTyping 输入状态到期，已被新的输入状态替换时忽略
+func (h *Hub) expireTyping(key typingKey, entry *typingEntry) {
+	h.typingMu.Lock()
+	current, ok := h.typing[key]
+	if ok && current == entry {
+		delete(h.typing, key)
+	}
+	h.typingMu.Unlock()
+	if ok && current == entry {
+		h.sendTyping(key, TypingStop)
+	}
+}
+
+// sendTyping 把输入状态转发给对方，conversation_id 为对方与输入者的会话
+func (h *Hub) sendTyping(key typingKey, state string) {
+	msg := &Message{
+		Type:       "typing",
+		SenderID:   key.senderID,
+		ReceiverID: key.receiverID,
+		Content:    state,
+		CreatedAt:  time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if state == TypingStart {
+		msg.ExpiresIn = int(typingTTL / time.Second)
+	}
+	msg.ConversationID = services.ConversationID(key.receiverID, key.senderID)
+	h.SendToUser(key.receiverID, h.serializeMessage(msg))
+}
+
+// NotifyRead 向对方推送已读回执，并同步给读者的其他设备
+// lastReadID 为读者已读到的对方最后一条消息 ID
+func (h *Hub) NotifyRead(readerID, peerID, lastReadID uint) {
+	if lastReadID == 0 {
+		return
+	}
+	msg := &Message{
+		Type:           "read",
+		ConversationID: services.ConversationID(peerID, readerID),
+		SenderID:       readerID,
+		ReceiverID:     peerID,
+		LastReadID:     lastReadID,
+		CreatedAt:      time.Now().Format("2006-01-02 15:04:05"),
+	}
+	// 存在屏蔽关系或读者处于隐身封禁时不告知对方，只同步读者自己的设备
+	if !services.IsBlocked(readerID, peerID) && !services.IsShadowBanned(readerID) {
+		h.SendToUser(peerID, h.serializeMessage(msg))
+	}
+
+	msg.ConversationID = services.ConversationID(readerID, peerID)
+	msg.IsMe = true
+	h.SendToUser(readerID, h.serializeMessage(msg))
+}
+
+// notifyPresence 用户在所有节点上线或离线时通知联系人，离线时记录最后在线时间
+func (h *Hub) notifyPresence(userID uint, online bool) {
+	now := time.Now()
+	msg := &Message{
+		Type:      "presence",
+		SenderID:  userID,
+		Content:   PresenceOnline,
+		CreatedAt: now.Format("2006-01-02 15:04:05"),
+	}
+	if !online {
+		services.TouchLastSeen(userID, now)
+		msg.Content = PresenceOffline
+		msg.LastSeen = msg.CreatedAt
+	}
+
+	contacts := services.PresenceContacts(userID)
+	data := h.serializeMessage(msg)
+	for _, contactID := range contacts {
+		h.SendToUser(contactID, data)
+	}
+	log.Printf("👤 用户 %d %s，已通知 %d 个联系人", userID, msg.Content, len(contacts))
+}
@@ -0,0 +1,401 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisConfig Redis 连接配置
+type RedisConfig struct {
+	Addr     string // host:port，默认 127.0.0.1:6379
+	Password string
+	DB       int
+	Channel  string // 发布消息使用的频道
+}
+
+// redisTimeout 连接和普通命令的超时时间
+const redisTimeout = 5 * time.Second
+
+// redisSubscribePing 订阅连接上发送 PING 的间隔，两个间隔内没有收到任何回复视为连接已断开
+// 防止半开的 TCP 连接（Redis 主从切换、NAT 超时）让订阅永远阻塞
+const redisSubscribePing = 30 * time.Second
+
+// idempotentCommands 重复执行结果不变的命令，写出后连接出错可以安全重试
+// PUBLISH 重试会让订阅方收到两次消息，不在其中
+var idempotentCommands = map[string]bool{
+	"PING":    true,
+	"HSET":    true,
+	"HDEL":    true,
+	"HGETALL": true,
+	"PEXPIRE": true,
+}
+
+// RedisBackplane 通过 Redis 发布/订阅在节点之间转发消息，在线状态保存在 Redis 哈希中
+// 每个用户一个哈希 <频道>:presence:<userID>，字段为节点，值为过期时间（Unix 毫秒）
+type RedisBackplane struct {
+	cfg          RedisConfig
+	pingInterval time.Duration // 订阅连接的心跳间隔
+
+	mu   sync.Mutex // 保护命令连接
+	conn *redisConn
+
+	subMu   sync.Mutex
+	subConn *redisConn
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewRedisBackplane 连接 Redis 并确认可用
+func NewRedisBackplane(cfg RedisConfig) (*RedisBackplane, error) {
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:6379"
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = "tapspot:ws"
+	}
+	r := &RedisBackplane{cfg: cfg, pingInterval: redisSubscribePing, closed: make(chan struct{})}
+	if _, err := r.do("PING"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Publish 发布到频道
+func (r *RedisBackplane) Publish(env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("PUBLISH", r.cfg.Channel, string(data))
+	return err
+}
+
+// Subscribe 在独立连接上订阅频道，断线后自动重连
+func (r *RedisBackplane) Subscribe(handler func(Envelope)) error {
+	go func() {
+		backoff := time.Second
+		for {
+			err := r.subscribe(handler)
+			select {
+			case <-r.closed:
+				return
+			default:
+			}
+			log.Printf("⚠️ Redis 订阅断开，%v 后重连: %v", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-r.closed:
+				return
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}()
+	return nil
+}
+
+// subscribe 订阅并持续读取消息，直到连接出错或心跳超时
+func (r *RedisBackplane) subscribe(handler func(Envelope)) error {
+	conn, err := r.dial()
+	if err != nil {
+		return err
+	}
+	r.subMu.Lock()
+	r.subConn = conn
+	r.subMu.Unlock()
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(redisTimeout))
+	if err := conn.write("SUBSCRIBE", r.cfg.Channel); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go r.keepalive(conn, done)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * r.pingInterval))
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 || parts[0] != "message" {
+			continue
+		}
+		payload, _ := parts[2].(string)
+		var env Envelope
+		if err := json.Unmarshal([]byte(payload), &env); err != nil {
+			log.Printf("⚠️ 无法解析 Redis 消息: %v", err)
+			continue
+		}
+		handler(env)
+	}
+}
+
+// keepalive 定期在订阅连接上发送 PING，订阅模式下的回复由 subscribe 读取并忽略
+func (r *RedisBackplane) keepalive(conn *redisConn, done chan struct{}) {
+	ticker := time.NewTicker(r.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(redisTimeout))
+			if err := conn.write("PING"); err != nil {
+				conn.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// SetPresence 上线时写入节点的过期时间，下线时删除节点
+func (r *RedisBackplane) SetPresence(node string, userID uint, online bool) error {
+	if online {
+		return r.RefreshPresence(node, []uint{userID})
+	}
+	_, err := r.do("HDEL", r.presenceKey(userID), node)
+	return err
+}
+
+// RefreshPresence 批量续期节点上在线用户的状态
+func (r *RedisBackplane) RefreshPresence(node string, userIDs []uint) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	expiresAt := strconv.FormatInt(time.Now().Add(presenceTTL).UnixMilli(), 10)
+	ttl := strconv.FormatInt(presenceTTL.Milliseconds(), 10)
+	var cmds [][]string
+	for _, userID := range userIDs {
+		key := r.presenceKey(userID)
+		cmds = append(cmds, []string{"HSET", key, node, expiresAt}, []string{"PEXPIRE", key, ttl})
+	}
+	return r.pipeline(cmds)
+}
+
+// IsOnline 任意节点的状态未过期即视为在线
+func (r *RedisBackplane) IsOnline(userID uint) (bool, error) {
+	reply, err := r.do("HGETALL", r.presenceKey(userID))
+	if err != nil {
+		return false, err
+	}
+	fields, _ := reply.([]interface{})
+	now := time.Now().UnixMilli()
+	for i := 1; i < len(fields); i += 2 {
+		value, _ := fields[i].(string)
+		if expiresAt, err := strconv.ParseInt(value, 10, 64); err == nil && expiresAt > now {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Close 关闭所有连接并停止订阅
+func (r *RedisBackplane) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	r.subMu.Lock()
+	if r.subConn != nil {
+		r.subConn.Close()
+	}
+	r.subMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return nil
+}
+
+// presenceKey 用户在线状态的哈希键
+func (r *RedisBackplane) presenceKey(userID uint) string {
+	return fmt.Sprintf("%s:presence:%d", r.cfg.Channel, userID)
+}
+
+// do 执行单条命令
+func (r *RedisBackplane) do(args ...string) (interface{}, error) {
+	var reply interface{}
+	err := r.withConn(idempotentCommands[args[0]], func(conn *redisConn) error {
+		if err := conn.write(args...); err != nil {
+			return err
+		}
+		var err error
+		reply, err = conn.read()
+		return err
+	})
+	return reply, err
+}
+
+// pipeline 一次发送多条命令后依次读取结果，返回第一个错误
+func (r *RedisBackplane) pipeline(cmds [][]string) error {
+	idempotent := true
+	for _, cmd := range cmds {
+		idempotent = idempotent && idempotentCommands[cmd[0]]
+	}
+	return r.withConn(idempotent, func(conn *redisConn) error {
+		for _, cmd := range cmds {
+			if err := conn.write(cmd...); err != nil {
+				return err
+			}
+		}
+		var firstErr error
+		for range cmds {
+			if _, err := conn.read(); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return err
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+		return firstErr
+	})
+}
+
+// withConn 在命令连接上执行 fn，网络错误时丢弃连接
+// 命令还没有写出，或者命令是幂等的，才会重连并重试一次
+func (r *RedisBackplane) withConn(idempotent bool, fn func(conn *redisConn) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if r.conn == nil {
+			if r.conn, err = r.dial(); err != nil {
+				return err
+			}
+		}
+		r.conn.SetDeadline(time.Now().Add(redisTimeout))
+		r.conn.written = false
+		err = fn(r.conn)
+		var replyErr redisError
+		if err == nil || errors.As(err, &replyErr) {
+			return err
+		}
+		written := r.conn.written
+		r.conn.Close()
+		r.conn = nil
+		if written && !idempotent {
+			return err
+		}
+	}
+	return err
+}
+
+// dial 建立连接并完成认证和选库
+func (r *RedisBackplane) dial() (*redisConn, error) {
+	c, err := net.DialTimeout("tcp", r.cfg.Addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, reader: bufio.NewReader(c)}
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+	if r.cfg.Password != "" {
+		if err := conn.write("AUTH", r.cfg.Password); err == nil {
+			_, err = conn.read()
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis 认证失败: %w", err)
+		}
+	}
+	if r.cfg.DB != 0 {
+		if err := conn.write("SELECT", strconv.Itoa(r.cfg.DB)); err == nil {
+			_, err = conn.read()
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis 选择数据库失败: %w", err)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// redisError Redis 返回的错误回复，连接仍然可用
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn RESP 协议连接
+type redisConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	written bool // 本次 withConn 中是否已经（可能部分）写出过命令
+}
+
+// write 以 RESP 数组发送命令
+func (c *redisConn) write(args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	c.written = true
+	_, err := c.Write(buf)
+	return err
+}
+
+// read 读取一个回复：简单字符串和批量字符串为 string，整数为 int64，数组为 []interface{}，空值为 nil
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: 无效的回复 %q", line)
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: 无效的回复 %q", line)
+}
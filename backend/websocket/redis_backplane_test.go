@@ -0,0 +1,382 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis 只实现 RedisBackplane 用到的命令的本地 RESP 服务
+type fakeRedis struct {
+	ln net.Listener
+
+	mu          sync.Mutex
+	commands    [][]string
+	conns       int
+	hashes      map[string]map[string]string
+	subscribers map[string][]net.Conn
+	drop        map[string]int  // 读到该命令后直接断开连接的剩余次数
+	mute        map[string]bool // 不回复但保持连接的命令，模拟半开连接
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{
+		ln:          ln,
+		hashes:      make(map[string]map[string]string),
+		subscribers: make(map[string][]net.Conn),
+		drop:        make(map[string]int),
+		mute:        make(map[string]bool),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns++
+			f.mu.Unlock()
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+// dropAfter 下 n 次收到 cmd 时不回复并断开连接
+func (f *fakeRedis) dropAfter(cmd string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.drop[cmd] = n
+}
+
+// silence 之后收到 cmd 时不回复也不断开连接
+func (f *fakeRedis) silence(cmd string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mute[cmd] = true
+}
+
+// count 收到 cmd 的次数
+func (f *fakeRedis) count(cmd string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, args := range f.commands {
+		if args[0] == cmd {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRedis) connCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.conns
+}
+
+func (f *fakeRedis) subscriberCount(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers[channel])
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	conn := &redisConn{Conn: c, reader: bufio.NewReader(c)}
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		args[0] = strings.ToUpper(args[0])
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		if f.drop[args[0]] > 0 {
+			f.drop[args[0]]--
+			f.mu.Unlock()
+			return
+		}
+		if f.mute[args[0]] {
+			f.mu.Unlock()
+			continue
+		}
+		out := f.exec(c, args)
+		f.mu.Unlock()
+		if _, err := c.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+// exec 执行命令并返回 RESP 回复（调用方持有锁）
+func (f *fakeRedis) exec(c net.Conn, args []string) string {
+	switch args[0] {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "HSET":
+		hash := f.hashes[args[1]]
+		if hash == nil {
+			hash = make(map[string]string)
+			f.hashes[args[1]] = hash
+		}
+		hash[args[2]] = args[3]
+		return ":1\r\n"
+	case "HDEL":
+		delete(f.hashes[args[1]], args[2])
+		return ":1\r\n"
+	case "PEXPIRE":
+		return ":1\r\n"
+	case "HGETALL":
+		hash := f.hashes[args[1]]
+		out := "*" + strconv.Itoa(len(hash)*2) + "\r\n"
+		for field, value := range hash {
+			out += bulk(field) + bulk(value)
+		}
+		return out
+	case "SUBSCRIBE":
+		f.subscribers[args[1]] = append(f.subscribers[args[1]], c)
+		return "*3\r\n" + bulk("subscribe") + bulk(args[1]) + ":1\r\n"
+	case "PUBLISH":
+		message := "*3\r\n" + bulk("message") + bulk(args[1]) + bulk(args[2])
+		for _, sub := range f.subscribers[args[1]] {
+			sub.Write([]byte(message))
+		}
+		return ":" + strconv.Itoa(len(f.subscribers[args[1]])) + "\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func newTestBackplane(t *testing.T, cfg RedisConfig) *RedisBackplane {
+	t.Helper()
+	r, err := NewRedisBackplane(cfg)
+	if err != nil {
+		t.Fatalf("NewRedisBackplane: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// waitFor 轮询直到 cond 成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRedisBackplaneAuthAndSelect(t *testing.T) {
+	f := newFakeRedis(t)
+	newTestBackplane(t, RedisConfig{Addr: f.addr(), Password: "secret", DB: 2})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	got := fmt.Sprint(f.commands)
+	if got != "[[AUTH secret] [SELECT 2] [PING]]" {
+		t.Fatalf("unexpected commands %s", got)
+	}
+}
+
+func TestRedisBackplanePresence(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestBackplane(t, RedisConfig{Addr: f.addr(), Channel: "test"})
+
+	if err := r.SetPresence("node-a", 7, true); err != nil {
+		t.Fatalf("SetPresence: %v", err)
+	}
+	if online, err := r.IsOnline(7); err != nil || !online {
+		t.Fatalf("expected user online, got %v %v", online, err)
+	}
+	if f.count("PEXPIRE") != 1 {
+		t.Fatal("expected presence key to get a TTL")
+	}
+
+	if err := r.SetPresence("node-a", 7, false); err != nil {
+		t.Fatalf("SetPresence: %v", err)
+	}
+	if online, err := r.IsOnline(7); err != nil || online {
+		t.Fatalf("expected user offline, got %v %v", online, err)
+	}
+
+	// 节点异常退出后留下的过期状态不算在线
+	f.mu.Lock()
+	f.hashes["test:presence:8"] = map[string]string{
+		"node-b": strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10),
+	}
+	f.mu.Unlock()
+	if online, err := r.IsOnline(8); err != nil || online {
+		t.Fatalf("expected expired presence to be offline, got %v %v", online, err)
+	}
+}
+
+func TestRedisBackplanePublishSubscribe(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestBackplane(t, RedisConfig{Addr: f.addr(), Channel: "test"})
+
+	received := make(chan Envelope, 1)
+	r.Subscribe(func(env Envelope) { received <- env })
+	waitFor(t, "subscription", func() bool { return f.subscriberCount("test") == 1 })
+
+	sent := Envelope{Node: "node-a", Kind: EnvelopeDeliver, UserID: 7, Data: json.RawMessage(`{"type":"ping"}`)}
+	if err := r.Publish(sent); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case env := <-received:
+		if env.Node != sent.Node || env.Kind != sent.Kind || env.UserID != sent.UserID || string(env.Data) != string(sent.Data) {
+			t.Fatalf("unexpected envelope %+v", env)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestRedisBackplaneSubscribeKeepalive(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestBackplane(t, RedisConfig{Addr: f.addr(), Channel: "test"})
+	r.pingInterval = 20 * time.Millisecond
+
+	r.Subscribe(func(Envelope) {})
+	waitFor(t, "subscription", func() bool { return f.subscriberCount("test") == 1 })
+	waitFor(t, "keepalive ping", func() bool { return f.count("PING") >= 2 })
+
+	// PING 不再有回复时视为连接已断开，重新订阅
+	f.silence("PING")
+	waitFor(t, "resubscription", func() bool { return f.count("SUBSCRIBE") == 2 })
+}
+
+func TestRedisBackplaneRetriesIdempotentCommands(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestBackplane(t, RedisConfig{Addr: f.addr(), Channel: "test"})
+	r.SetPresence("node-a", 7, true)
+
+	f.dropAfter("HGETALL", 1)
+	if online, err := r.IsOnline(7); err != nil || !online {
+		t.Fatalf("expected retry to succeed, got %v %v", online, err)
+	}
+	if n := f.count("HGETALL"); n != 2 {
+		t.Fatalf("expected HGETALL to be sent twice, got %d", n)
+	}
+
+	f.dropAfter("HSET", 1)
+	if err := r.RefreshPresence("node-a", []uint{7, 8}); err != nil {
+		t.Fatalf("expected pipeline retry to succeed, got %v", err)
+	}
+}
+
+func TestRedisBackplaneDoesNotRetryPublish(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestBackplane(t, RedisConfig{Addr: f.addr(), Channel: "test"})
+
+	f.dropAfter("PUBLISH", 1)
+	if err := r.Publish(Envelope{Kind: EnvelopeDeliver, UserID: 7}); err == nil {
+		t.Fatal("expected publish to fail")
+	}
+	if n := f.count("PUBLISH"); n != 1 {
+		t.Fatalf("expected PUBLISH to be sent once, got %d", n)
+	}
+
+	// 断开的连接已丢弃，下一次发布使用新连接
+	if err := r.Publish(Envelope{Kind: EnvelopeDeliver, UserID: 7}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if n := f.connCount(); n != 2 {
+		t.Fatalf("expected a new connection, got %d connections", n)
+	}
+}
+
+func TestRedisBackplaneReplyErrorKeepsConnection(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestBackplane(t, RedisConfig{Addr: f.addr()})
+
+	_, err := r.do("BOGUS")
+	var replyErr redisError
+	if !errors.As(err, &replyErr) {
+		t.Fatalf("expected redis error reply, got %v", err)
+	}
+	if _, err := r.do("PING"); err != nil {
+		t.Fatalf("PING: %v", err)
+	}
+	if n := f.connCount(); n != 1 {
+		t.Fatalf("expected connection to be reused, got %d connections", n)
+	}
+}
+
+// TestRedisBackplaneWithRedis 设置 REDIS_ADDR 时对真实的 Redis 运行
+func TestRedisBackplaneWithRedis(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	db, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+	channel := fmt.Sprintf("tapspot:test:%d", time.Now().UnixNano())
+	r := newTestBackplane(t, RedisConfig{Addr: addr, Password: os.Getenv("REDIS_PASSWORD"), DB: db, Channel: channel})
+
+	received := make(chan Envelope, 1)
+	r.Subscribe(func(env Envelope) { received <- env })
+	// 订阅在后台建立，重复发布直到收到
+	deadline := time.After(5 * time.Second)
+	for done := false; !done; {
+		if err := r.Publish(Envelope{Node: "test", Kind: EnvelopeDeliver, UserID: 7}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		select {
+		case env := <-received:
+			if env.UserID != 7 {
+				t.Fatalf("unexpected envelope %+v", env)
+			}
+			done = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("timed out waiting for message")
+		}
+	}
+
+	if err := r.SetPresence("test", 7, true); err != nil {
+		t.Fatalf("SetPresence: %v", err)
+	}
+	if online, err := r.IsOnline(7); err != nil || !online {
+		t.Fatalf("expected user online, got %v %v", online, err)
+	}
+	if err := r.SetPresence("test", 7, false); err != nil {
+		t.Fatalf("SetPresence: %v", err)
+	}
+	if online, err := r.IsOnline(7); err != nil || online {
+		t.Fatalf("expected user offline, got %v %v", online, err)
+	}
+}
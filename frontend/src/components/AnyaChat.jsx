@@ -26,7 +26,7 @@ export default function AnyaChat({ isOpen, onClose, userId, userLocation, anyaAv
 
   const loadChatHistory = async () => {
     try {
-      const token = localStorage.getItem('token')
+      const token = localStorage.getItem('tapspot_token')
       const res = await fetch(`/api/chat/history/${userId}`, {
         headers: {
           'Authorization': `Bearer ${token}`